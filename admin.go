@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// activeIngests counts /ingest requests currently running the pipeline.
+var activeIngests atomic.Int64
+
+type recentError struct {
+	Source  string    `json:"source"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// recentErrors keeps the last few failures from upstreams and ingests.
+var recentErrors = struct {
+	sync.Mutex
+	list []recentError
+}{}
+
+const maxRecentErrors = 20
+
+func recordError(source string, err error) {
+	recentErrors.Lock()
+	defer recentErrors.Unlock()
+	recentErrors.list = append(recentErrors.list, recentError{Source: source, Message: err.Error(), At: time.Now()})
+	if n := len(recentErrors.list); n > maxRecentErrors {
+		recentErrors.list = recentErrors.list[n-maxRecentErrors:]
+	}
+}
+
+// adminAuth protects admin routes with a bearer ADMIN_TOKEN. Without one
+// set they are closed to everyone.
+func adminAuth(c *gin.Context) {
+	token := os.Getenv("ADMIN_TOKEN")
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, errorReply(c, codeForbidden, "Admin access is disabled: ADMIN_TOKEN is not set"))
+		return
+	}
+	got := c.GetHeader("Authorization")
+	if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, errorReply(c, codeUnauthorized, "Unauthorized"))
+	}
+}
+
+// handleAdminStatus reports the operational state an operator needs during an
+// incident: queues, running ingests, collection sizes, cache hit rates,
+// upstream latency and health, Qdrant connections, chat stage timings, and the most recent
+// errors.
+func handleAdminStatus(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	inflight.Lock()
+	idempotentInflight := len(inflight.m)
+	inflight.Unlock()
+
+	upstreams := gin.H{}
+	for name, b := range breakers() {
+		upstreams[name] = b.snapshot()
+	}
+
+	lookups, hits := idempotencyLookups.Load(), idempotencyHits.Load()
+	var hitRate float64
+	if lookups > 0 {
+		hitRate = float64(hits) / float64(lookups)
+	}
+
+	recentErrors.Lock()
+	errs := make([]recentError, len(recentErrors.list))
+	copy(errs, recentErrors.list)
+	recentErrors.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"queues": gin.H{
+			"llm": gin.H{
+				"active":   llmQueue().active(),
+				"queued":   llmQueue().queued.Load(),
+				"capacity": cap(llmQueue().slots),
+				"rejected": llmQueue().rejected.Load(),
+				"timeouts": llmQueue().timeouts.Load(),
+			},
+			"idempotent_inflight": idempotentInflight,
+		},
+		"active_ingests": activeIngests.Load(),
+		"leader":         gin.H{"holder": scheduler.holder, "is_leader": scheduler.isLeader.Load()},
+		"collections":    collectionSizes(ctx),
+		"caches": gin.H{
+			"idempotency": gin.H{"lookups": lookups, "hits": hits, "hit_rate": hitRate},
+		},
+		"upstreams":     upstreams,
+		"qdrant":        qdrantConns.status(),
+		"stage_latency": stageLatencies(),
+		"recent_errors": errs,
+	})
+}
+
+// collectionSizes returns the approximate point count of every collection,
+// or the error in its place if Qdrant can't be reached.
+func collectionSizes(ctx context.Context) gin.H {
+	list, err := collectionsClient.List(ctx, &pb.ListCollectionsRequest{})
+	if err != nil {
+		return gin.H{"error": newAPIError(ctx, codeUpstream, upstreamQdrant, "Qdrant Error: "+err.Error())}
+	}
+	sizes := gin.H{}
+	for _, col := range list.GetCollections() {
+		info, err := collectionsClient.Get(ctx, &pb.GetCollectionInfoRequest{CollectionName: col.GetName()})
+		if err != nil {
+			sizes[col.GetName()] = gin.H{"error": newAPIError(ctx, codeUpstream, upstreamQdrant, "Qdrant Error: "+err.Error())}
+			continue
+		}
+		sizes[col.GetName()] = gin.H{"points": info.GetResult().GetPointsCount(), "status": info.GetResult().GetStatus().String()}
+	}
+	return sizes
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai"
+)
+
+const agentPrompt = "Answer the question using the search_documents tool to look things up in the uploaded documents. You may search several times with different queries, for example once per section or entity the question mentions. Stop searching once you have enough context, then answer."
+
+// agentStep records one tool call made while answering.
+type agentStep struct {
+	Step      int        `json:"step"`
+	Tool      string     `json:"tool"`
+	Query     string     `json:"query,omitempty"`
+	Arguments string     `json:"arguments,omitempty"`
+	Results   []citation `json:"results"`
+	Output    string     `json:"output,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+var searchTool = openai.Tool{
+	Type: openai.ToolTypeFunction,
+	Function: &openai.FunctionDefinition{
+		Name:        "search_documents",
+		Description: "Semantic search over the uploaded documents. Returns the most relevant excerpts.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {"query": {"type": "string", "description": "What to look for"}},
+			"required": ["query"]
+		}`),
+	},
+}
+
+// searchScope keeps the agent's searches to what the chat itself may see:
+// filter is the one its vector search uses, and skip drops hits by payload,
+// such as chunks of other users' documents.
+type searchScope struct {
+	filter *pb.Filter
+	skip   func(map[string]*pb.Value) bool
+}
+
+// agentMaxSteps caps how many tool calls a single agent run may make.
+func agentMaxSteps() int {
+	return envInt("AGENT_MAX_STEPS", 5)
+}
+
+// runAgent lets the model drive retrieval: it issues search tool calls until
+// it decides it has enough context, then answers, searching within scope.
+// The returned trace lists every tool call it made.
+func runAgent(ctx context.Context, question, lang, workspace string, tools []toolPlugin, prompts promptVersions, scope searchScope) (string, []agentStep, error) {
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: personaFor(workspace, prompts) + outOfScopeInstruction(workspace) + "\n\n" + prompts.text("agent") + languageInstruction(lang)},
+		{Role: openai.ChatMessageRoleUser, Content: question},
+	}
+	return runToolLoop(ctx, messages, tools, &scope)
+}
+
+// runToolLoop sends the conversation and executes the tool calls the model
+// makes until it replies with a plain answer or runs out of steps. The search
+// tool is only offered with a scope to search in.
+func runToolLoop(ctx context.Context, messages []openai.ChatCompletionMessage, tools []toolPlugin, scope *searchScope) (string, []agentStep, error) {
+	defs := toolDefinitions(tools)
+	if scope != nil {
+		defs = append(defs, searchTool)
+	}
+	trace := []agentStep{}
+	maxSteps := agentMaxSteps()
+
+	for {
+		req := openai.ChatCompletionRequest{Model: chatModel, Messages: messages}
+		if len(trace) < maxSteps {
+			req.Tools = defs
+		}
+		resp, err := aiClient.CreateChatCompletion(ctx, req)
+		if err != nil {
+			return "", trace, err
+		}
+		if len(resp.Choices) == 0 {
+			return "", trace, errors.New("the model returned no answer")
+		}
+		msg := resp.Choices[0].Message
+		if len(msg.ToolCalls) == 0 {
+			return msg.Content, trace, nil
+		}
+
+		messages = append(messages, msg)
+		for _, call := range msg.ToolCalls {
+			step := agentStep{Step: len(trace) + 1, Tool: call.Function.Name, Results: []citation{}}
+			var output string
+			if scope != nil && call.Function.Name == searchTool.Function.Name {
+				output = runSearchTool(ctx, call, *scope, &step)
+			} else {
+				output = runPluginTool(ctx, call, tools, &step)
+			}
+			trace = append(trace, step)
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				ToolCallID: call.ID,
+				Content:    output,
+			})
+		}
+	}
+}
+
+// runPluginTool dispatches a call to one of the enabled plugin tools.
+func runPluginTool(ctx context.Context, call openai.ToolCall, tools []toolPlugin, step *agentStep) string {
+	step.Arguments = call.Function.Arguments
+	for _, t := range tools {
+		if t.Name() != call.Function.Name {
+			continue
+		}
+		out, err := t.Call(ctx, json.RawMessage(call.Function.Arguments))
+		if err != nil {
+			step.Error = err.Error()
+			return "Error: " + err.Error()
+		}
+		step.Output = out
+		return out
+	}
+	step.Error = "unknown tool"
+	return "Error: unknown tool " + call.Function.Name
+}
+
+// runSearchTool executes a search_documents call within scope, filling in
+// the trace step and returning the text handed back to the model.
+func runSearchTool(ctx context.Context, call openai.ToolCall, scope searchScope, step *agentStep) string {
+	var args struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal([]byte(call.Function.Arguments), &args); err != nil || args.Query == "" {
+		step.Error = "invalid arguments"
+		return "Error: expected {\"query\": string}"
+	}
+	step.Query = args.Query
+
+	vector, err := embedText(ctx, args.Query)
+	if err != nil {
+		step.Error = err.Error()
+		return "Error: " + err.Error()
+	}
+	// twice the hits, leaving room for those skip drops
+	hits, err := searchChunks(ctx, vector, 6, scope.filter)
+	if err != nil {
+		step.Error = err.Error()
+		return "Error: " + err.Error()
+	}
+	hits = slices.DeleteFunc(hits, func(hit *pb.ScoredPoint) bool { return scope.skip(hit.Payload) })
+	hits = hits[:min(len(hits), 3)]
+	if len(hits) == 0 {
+		return "No results."
+	}
+
+	var out strings.Builder
+	for i, hit := range hits {
+		step.Results = append(step.Results, newCitation(hit))
+		fmt.Fprintf(&out, "[%d] (%s) %s\n\n", i+1, payloadString(hit.Payload, "filename"), payloadString(hit.Payload, "text"))
+	}
+	return out.String()
+}
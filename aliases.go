@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// aliasTarget returns the collection an alias points at, or the name itself
+// if it is not an alias.
+func aliasTarget(ctx context.Context, name string) (string, error) {
+	res, err := collectionsClient.ListAliases(ctx, &pb.ListAliasesRequest{})
+	if err != nil {
+		return "", err
+	}
+	for _, a := range res.GetAliases() {
+		if a.GetAliasName() == name {
+			return a.GetCollectionName(), nil
+		}
+	}
+	return name, nil
+}
+
+// switchAlias points alias at collection. Qdrant applies the delete and
+// create in one operation, so searches never see the alias missing.
+func switchAlias(ctx context.Context, alias, collection string, exists bool) error {
+	var actions []*pb.AliasOperations
+	if exists {
+		actions = append(actions, &pb.AliasOperations{Action: &pb.AliasOperations_DeleteAlias{
+			DeleteAlias: &pb.DeleteAlias{AliasName: alias},
+		}})
+	}
+	actions = append(actions, &pb.AliasOperations{Action: &pb.AliasOperations_CreateAlias{
+		CreateAlias: &pb.CreateAlias{CollectionName: collection, AliasName: alias},
+	}})
+	_, err := collectionsClient.UpdateAliases(ctx, &pb.ChangeAliases{Actions: actions})
+	return err
+}
+
+// handleListAliases lists Qdrant aliases and the collections behind them,
+// with the embedding model each collection was built with where known:
+// GET /admin/aliases.
+func handleListAliases(c *gin.Context) {
+	res, err := collectionsClient.ListAliases(c.Request.Context(), &pb.ListAliasesRequest{})
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	aliases := []gin.H{}
+	for _, a := range res.GetAliases() {
+		entry := gin.H{"alias": a.GetAliasName(), "collection": a.GetCollectionName(), "serving": a.GetAliasName() == collectionName}
+		var model embeddingConfig
+		if found, _ := metaStore.Get("collection_models", a.GetCollectionName(), &model); found {
+			entry["embedding"] = model
+		}
+		aliases = append(aliases, entry)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "aliases": aliases})
+}
+
+// handlePutAlias points an alias at a collection, creating it if needed:
+// PUT /admin/aliases/:name {"collection", "drop_previous"}. Switching the
+// serving alias is a blue/green cut-over: the target is synced from the live
+// collection first, and queries move to the target's embedding model with
+// it. The previous collection is kept for switching back unless
+// drop_previous is set, and then only dropped once the switch has succeeded.
+func handlePutAlias(c *gin.Context) {
+	var body struct {
+		Collection   string `json:"collection"`
+		DropPrevious bool   `json:"drop_previous"`
+	}
+	if err := c.BindJSON(&body); err != nil || body.Collection == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "collection is required"))
+		return
+	}
+	ctx := c.Request.Context()
+	name := c.Param("name")
+	current, err := aliasTarget(ctx, name)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	if current == body.Collection {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Alias already points there", "alias": name, "collection": current})
+		return
+	}
+
+	if name == collectionName {
+		if !reindexRunning.CompareAndSwap(false, true) {
+			c.JSON(http.StatusOK, errorReply(c, codeConflict, "A reindex is already running"))
+			return
+		}
+		defer reindexRunning.Store(false)
+		synced := 0
+		if err := promoteCollection(ctx, current, body.Collection, func(n int) { synced += n }); err != nil {
+			if errorCode(err) != "" {
+				c.JSON(http.StatusOK, errReply(c, codeConflict, err))
+				return
+			}
+			c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Switch Error: "+err.Error()))
+			return
+		}
+		audit(auditEntry{Action: "alias_switch", Detail: fmt.Sprintf("%s: %s -> %s (%d chunks synced)", name, current, body.Collection, synced)})
+		reply := gin.H{"status": "success", "alias": name, "collection": body.Collection, "previous": current, "synced": synced}
+		if body.DropPrevious {
+			reply["dropped"] = dropCollection(ctx, current)
+		}
+		c.JSON(http.StatusOK, reply)
+		return
+	}
+
+	if err := switchAlias(ctx, name, body.Collection, current != name); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "alias_switch", Detail: fmt.Sprintf("%s: %s -> %s", name, current, body.Collection)})
+	reply := gin.H{"status": "success", "alias": name, "collection": body.Collection}
+	if body.DropPrevious && current != name {
+		reply["previous"], reply["dropped"] = current, dropCollection(ctx, current)
+	}
+	c.JSON(http.StatusOK, reply)
+}
+
+// dropCollection deletes a collection an alias no longer points at, unless
+// another alias still does, and reports whether it did.
+func dropCollection(ctx context.Context, collection string) bool {
+	res, err := collectionsClient.ListAliases(ctx, &pb.ListAliasesRequest{})
+	if err != nil {
+		log.Printf("❌ Qdrant Error: %v", err)
+		return false
+	}
+	for _, a := range res.GetAliases() {
+		if a.GetCollectionName() == collection {
+			return false
+		}
+	}
+	if _, err := collectionsClient.Delete(ctx, &pb.DeleteCollection{CollectionName: collection}); err != nil {
+		log.Printf("❌ Qdrant Error: %v", err)
+		return false
+	}
+	metaStore.Delete("collection_models", collection)
+	audit(auditEntry{Action: "collection_delete", Detail: collection})
+	return true
+}
+
+// handleDeleteAlias removes an alias, leaving its collection in place:
+// DELETE /admin/aliases/:name. The serving alias can't be removed.
+func handleDeleteAlias(c *gin.Context) {
+	name := c.Param("name")
+	if name == collectionName {
+		c.JSON(http.StatusOK, errorReply(c, codeConflict, "The serving alias can't be deleted; point it elsewhere instead"))
+		return
+	}
+	_, err := collectionsClient.UpdateAliases(c.Request.Context(), &pb.ChangeAliases{Actions: []*pb.AliasOperations{
+		{Action: &pb.AliasOperations_DeleteAlias{DeleteAlias: &pb.DeleteAlias{AliasName: name}}},
+	}})
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "alias_delete", Detail: name})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Alias deleted"})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// retrievedChunk identifies a chunk that was put into a chat's context.
+type retrievedChunk = api.Source
+
+func chunkRef(id *pb.PointId, payload map[string]*pb.Value, score float32, source string) retrievedChunk {
+	ref := retrievedChunk{
+		ChunkID:    id.GetUuid(),
+		DocumentID: payloadString(payload, "document_id"),
+		ChunkIndex: payload["chunk_index"].GetIntegerValue(),
+		Score:      score,
+		Source:     source,
+		Collection: payload["collection"].GetStringValue(),
+	}
+	// documents that came from a URL are cited by it, transcripts at the
+	// moment the passage starts
+	ref.Start = payload["start"].GetDoubleValue()
+	if u := payload["url"].GetStringValue(); u != "" {
+		ref.URL, ref.Title = timestampURL(u, ref.Start), payloadString(payload, "title")
+	}
+	return ref
+}
+
+// documentStats is how a document has been used in chats.
+type documentStats struct {
+	Retrievals       int            `json:"retrievals"`
+	ChunkHits        map[string]int `json:"chunk_hits"` // retrievals by chunk index
+	LowConfidence    int            `json:"low_confidence"`
+	NegativeFeedback int            `json:"negative_feedback"`
+	LastRetrieved    time.Time      `json:"last_retrieved"`
+}
+
+// analyticsMu serialises read-modify-write updates of document stats.
+var analyticsMu sync.Mutex
+
+// lowConfidenceScore is the LOW_CONFIDENCE_SCORE below which a chat's best
+// vector match counts as a low-confidence answer.
+func lowConfidenceScore() float32 {
+	if f, err := strconv.ParseFloat(os.Getenv("LOW_CONFIDENCE_SCORE"), 32); err == nil {
+		return float32(f)
+	}
+	return 0.35
+}
+
+// lowConfidence reports whether the best vector match of a chat was weak.
+// Exact and graph matches carry no score and count as confident.
+func lowConfidence(sources []retrievedChunk) bool {
+	best, scored := float32(0), false
+	for _, s := range sources {
+		if s.Source != "vector" {
+			return false
+		}
+		best, scored = max(best, s.Score), true
+	}
+	return scored && best < lowConfidenceScore()
+}
+
+// recordRetrieval counts the chunks a chat used against their documents.
+func recordRetrieval(sources []retrievedChunk) {
+	if len(sources) == 0 {
+		return
+	}
+	low := lowConfidence(sources)
+	updateStats(sources, func(st *documentStats, chunks []retrievedChunk) {
+		st.Retrievals++
+		st.LastRetrieved = time.Now()
+		for _, c := range chunks {
+			st.ChunkHits[strconv.FormatInt(c.ChunkIndex, 10)]++
+		}
+		if low {
+			st.LowConfidence++
+		}
+	})
+}
+
+// recordNegativeFeedback counts a thumbs-down against the documents whose
+// chunks went into the answer.
+func recordNegativeFeedback(sources []retrievedChunk) {
+	updateStats(sources, func(st *documentStats, _ []retrievedChunk) { st.NegativeFeedback++ })
+}
+
+func updateStats(sources []retrievedChunk, update func(*documentStats, []retrievedChunk)) {
+	byDoc := map[string][]retrievedChunk{}
+	for _, s := range sources {
+		if s.DocumentID != "" {
+			byDoc[s.DocumentID] = append(byDoc[s.DocumentID], s)
+		}
+	}
+	analyticsMu.Lock()
+	defer analyticsMu.Unlock()
+	batch := make(map[string]any, len(byDoc))
+	for id, chunks := range byDoc {
+		st := documentStats{}
+		metaStore.Get("analytics", id, &st)
+		if st.ChunkHits == nil {
+			st.ChunkHits = map[string]int{}
+		}
+		update(&st, chunks)
+		batch[id] = st
+	}
+	if err := metaStore.PutBatch("analytics", batch); err != nil {
+		log.Printf("❌ Analytics Error: %v", err)
+	}
+}
+
+// handleDocumentAnalytics reports per-document usage, most retrieved first,
+// including documents that were never retrieved:
+// GET /analytics/documents?workspace=.
+func handleDocumentAnalytics(c *gin.Context) {
+	docs, err := metaStore.List("documents")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	ws := c.Query("workspace")
+	type row struct {
+		documentRecord
+		documentStats
+		NeverRetrieved bool     `json:"never_retrieved"`
+		UnusedChunks   int      `json:"unused_chunks"`
+		TopChunks      []string `json:"top_chunks"` // chunk indexes, most retrieved first
+	}
+	rows := []row{}
+	for _, raw := range docs {
+		var doc documentRecord
+		if json.Unmarshal(raw, &doc) != nil || (ws != "" && doc.Workspace != ws) {
+			continue
+		}
+		r := row{documentRecord: doc}
+		metaStore.Get("analytics", doc.ID, &r.documentStats)
+		if r.ChunkHits == nil {
+			r.ChunkHits = map[string]int{}
+		}
+		r.NeverRetrieved = r.Retrievals == 0
+		r.UnusedChunks = max(doc.Chunks-len(r.ChunkHits), 0)
+		for idx := range r.ChunkHits {
+			r.TopChunks = append(r.TopChunks, idx)
+		}
+		sort.Slice(r.TopChunks, func(i, j int) bool { return r.ChunkHits[r.TopChunks[i]] > r.ChunkHits[r.TopChunks[j]] })
+		r.TopChunks = r.TopChunks[:min(len(r.TopChunks), 10)]
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Retrievals > rows[j].Retrievals })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "documents": rows})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+)
+
+// Answer length and reading level are set per request rather than left to
+// the question. Each maps to a prompt library template, so the wording can
+// be tuned without a redeploy; length also caps the reply's tokens:
+// ANSWER_BRIEF_TOKENS (default 250), ANSWER_NORMAL_TOKENS (default 0, no
+// cap) and ANSWER_DETAILED_TOKENS (default 2000). Tool calls aren't capped,
+// only the reply.
+
+var (
+	answerLengths = []string{"brief", "normal", "detailed"}
+	readingLevels = []string{"beginner", "general", "expert"}
+)
+
+const (
+	lengthBriefPrompt     = "\n\nAnswer briefly: one to three sentences, or a short list, with only what the question needs."
+	lengthDetailedPrompt  = "\n\nAnswer in detail: cover every relevant point in the context, explaining each and giving examples where they help, organised with headings or lists."
+	readingBeginnerPrompt = "\n\nThe reader is new to the topic. Use plain, everyday words, define each technical term or acronym the first time it comes up, and give a simple example where it helps."
+	readingExpertPrompt   = "\n\nThe reader knows the field. Use its terminology without defining it and skip the basics."
+)
+
+// styleInstruction is the prompt for an answer length and reading level;
+// normal length and general reading add nothing.
+func styleInstruction(p promptVersions, length, level string) string {
+	var b strings.Builder
+	if length == "brief" || length == "detailed" {
+		b.WriteString(p.text("length_" + length))
+	}
+	if level == "beginner" || level == "expert" {
+		b.WriteString(p.text("reading_" + level))
+	}
+	return b.String()
+}
+
+// answerTokenCap is the most tokens a reply of a length may have, 0 for no
+// cap.
+func answerTokenCap(length string) int {
+	switch length {
+	case "brief":
+		return envInt("ANSWER_BRIEF_TOKENS", 250)
+	case "detailed":
+		return envInt("ANSWER_DETAILED_TOKENS", 2000)
+	}
+	return envInt("ANSWER_NORMAL_TOKENS", 0)
+}
+
+// trimToSentence drops the unfinished sentence a reply cut off at its token
+// cap ends with, unless that would leave nothing.
+func trimToSentence(answer string) string {
+	cut := -1
+	for _, sep := range []string{". ", "! ", "? ", ".\n", "!\n", "?\n"} {
+		if i := strings.LastIndex(answer, sep); i >= 0 {
+			cut = max(cut, i+1)
+		}
+	}
+	if cut <= 0 {
+		return answer
+	}
+	return answer[:cut]
+}
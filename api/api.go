@@ -0,0 +1,339 @@
+// Package api holds the request and response types of the docuchat HTTP
+// API, shared by the server and by Go clients of it.
+//
+// Every reply is HTTP 200. Document and admin endpoints report failure with
+// status "error" and a message; chat endpoints put the error in the answer,
+// prefixed with ❌. Either way the reply also carries an Error under
+// "error", which clients can branch on without parsing the message.
+package api
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ChatRequest is the body of POST /chat.
+type ChatRequest struct {
+	Question       string            `json:"question"`
+	ResponseSchema json.RawMessage   `json:"response_schema,omitempty"`
+	Mode           string            `json:"mode,omitempty"` // "agent" for multi-step retrieval, "graph" for graph-augmented, "scan" to read whole documents
+	Workspace      string            `json:"workspace,omitempty"`
+	Language       string            `json:"language,omitempty"` // only search documents in this language
+	Stream         bool              `json:"stream,omitempty"`   // answer as server-sent events
+	SessionID      string            `json:"session_id,omitempty"`
+	DocumentIDs    []string          `json:"document_ids,omitempty"` // limit a new session to these documents
+	Explain        bool              `json:"explain,omitempty"`      // return the assembled prompt instead of answering
+	After          string            `json:"after,omitempty"`        // only documents dated after this year, month or day
+	Before         string            `json:"before,omitempty"`       // only documents dated before this year, month or day
+	ExcludeDocs    []string          `json:"exclude_document_ids,omitempty"`
+	ExcludeTags    []string          `json:"exclude_tags,omitempty"`
+	AsOf           string            `json:"as_of,omitempty"`         // search the document versions in force on this date instead of today's
+	Collections    []string          `json:"collections,omitempty"`   // search these collections too, merging the results
+	Workspaces     []string          `json:"workspaces,omitempty"`    // only search documents from these workspaces
+	Fields         map[string]string `json:"fields,omitempty"`        // only search chunks whose record or document metadata fields have these values
+	Format         string            `json:"format,omitempty"`        // markdown (default), plain or html
+	Audio          bool              `json:"audio,omitempty"`         // also return a URL the answer can be fetched from as speech
+	Attachment     string            `json:"attachment,omitempty"`    // how a file sent with the question is kept: persistent (default) or ephemeral
+	Length         string            `json:"length,omitempty"`        // brief, normal (default) or detailed; capped in tokens by the server
+	ReadingLevel   string            `json:"reading_level,omitempty"` // beginner (new to the topic), general (default) or expert
+	Deterministic  bool              `json:"deterministic,omitempty"` // answer at temperature 0 with a fixed seed, so it can be reproduced
+	Seed           *int              `json:"seed,omitempty"`          // the provider's sampling seed; the server's default when deterministic and unset
+	Debug          bool              `json:"debug,omitempty"`         // add debug.timings, what each stage took, to the reply
+	// skip optional stages that would run past this many ms
+	LatencyBudget int `json:"latency_budget_ms,omitempty"`
+}
+
+// Error is what a failed request reports under "error". Code is stable:
+// invalid_request, unauthorized, forbidden, not_found, conflict, too_large,
+// rate_limited, interrupted, no_text, upstream_error, storage_error,
+// ingest_failed or internal_error, or a more specific one such as
+// pdf_password_required. Retryable says whether the same request may
+// succeed later, and Upstream names the dependency that failed, such as
+// openai, qdrant or metadata_store. RequestID is also sent as the
+// X-Request-ID header, and finds the request in the server's logs.
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+	Upstream  string `json:"upstream,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ChatResponse is the reply to a POST /chat that isn't streamed.
+type ChatResponse struct {
+	Answer       string          `json:"answer"`
+	Format       string          `json:"format,omitempty"`
+	Language     string          `json:"language,omitempty"`
+	ChatID       string          `json:"chat_id,omitempty"`
+	SessionID    string          `json:"session_id,omitempty"`
+	AnswerSource string          `json:"answer_source,omitempty"` // documents, web, faq, override...
+	Data         json.RawMessage `json:"data,omitempty"`          // the structured answer, for a response_schema
+	AudioURL     string          `json:"audio_url,omitempty"`
+	Transcript   string          `json:"transcript,omitempty"` // a spoken question, as transcribed
+	Degraded     []string        `json:"degraded,omitempty"`   // stages skipped to meet the latency budget
+	Attachment   *Attachment     `json:"attachment,omitempty"` // the file sent with the question
+	Sources      []Source        `json:"sources,omitempty"`    // the chunks put into the context
+	Seed         *int            `json:"seed,omitempty"`       // the sampling seed the answer was generated with
+	// SystemFingerprint identifies the provider's backend configuration; a
+	// seeded answer reproduces while it stays the same.
+	SystemFingerprint string        `json:"system_fingerprint,omitempty"`
+	Attributions      []Attribution `json:"attributions,omitempty"` // answer sentences mapped to the sources supporting them
+	Debug             *Debug        `json:"debug,omitempty"`
+	Error             *Error        `json:"error,omitempty"` // set with a ❌ answer
+}
+
+// Debug is what a chat asked with debug=true reports about itself.
+type Debug struct {
+	// Timings are the ms each stage took, such as embed, search, rerank
+	// and completion, and the total. Stages that didn't run are left out.
+	Timings map[string]float64 `json:"timings"`
+}
+
+// Attachment is a file sent with a chat question and added to its session.
+// A persistent attachment is ingested as a document of the session's
+// workspace; an ephemeral one is held in the server's memory until the
+// session has gone unused for a while.
+type Attachment struct {
+	Filename   string `json:"filename"`
+	Mode       string `json:"mode"`                  // persistent or ephemeral
+	DocumentID string `json:"document_id,omitempty"` // set for a persistent attachment
+	Chunks     int    `json:"chunks"`
+}
+
+// Source identifies a chunk that was put into a chat's context.
+type Source struct {
+	ChunkID    string  `json:"chunk_id"`
+	DocumentID string  `json:"document_id"`
+	ChunkIndex int64   `json:"chunk_index"`
+	Score      float32 `json:"score,omitempty"`
+	Source     string  `json:"source"`               // exact, vector, graph, attachment or external:<retriever>
+	Collection string  `json:"collection,omitempty"` // set for hits from a federated collection
+	URL        string  `json:"url,omitempty"`        // set for hits from an external retriever or a document with a URL
+	Title      string  `json:"title,omitempty"`
+	Start      float64 `json:"start,omitempty"` // seconds into the recording, for a transcript
+}
+
+// StartEvent opens a streamed chat.
+type StartEvent struct {
+	ChatID       string      `json:"chat_id"`
+	SessionID    string      `json:"session_id"`
+	Language     string      `json:"language"`
+	AnswerSource string      `json:"answer_source"`
+	Transcript   string      `json:"transcript,omitempty"`
+	Attachment   *Attachment `json:"attachment,omitempty"`
+}
+
+// TokenEvent is a piece of a streamed answer.
+type TokenEvent struct {
+	Text string `json:"text"`
+}
+
+// CitationsEvent lists the sources of a streamed chat as soon as they're
+// retrieved, before the answer is generated.
+type CitationsEvent struct {
+	Sources []Source `json:"sources"`
+}
+
+// Attribution maps a sentence of an answer to the sources that support it.
+// Start and End are offsets in characters (Unicode code points) into the
+// answer as Markdown, the way it streams, before any other format is
+// applied.
+type Attribution struct {
+	Sentence int    `json:"sentence"` // counting from 0
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+	Text     string `json:"text"`
+	Sources  []int  `json:"sources"` // indexes into the chat's sources
+	// Highlights are the passages of those sources that best support the
+	// sentence, one per source. They come with the done event and with
+	// replies that aren't streamed.
+	Highlights []Highlight `json:"highlights,omitempty"`
+}
+
+// Highlight is the passage of a source that best supports a sentence of an
+// answer. Start and End are offsets in characters into the source's text.
+type Highlight struct {
+	Source int     `json:"source"` // index into the chat's sources
+	Start  int     `json:"start"`
+	End    int     `json:"end"`
+	Text   string  `json:"text"`
+	Score  float32 `json:"score"` // similarity to the sentence
+}
+
+// DoneEvent closes a streamed chat with the whole answer.
+type DoneEvent struct {
+	ChatResponse
+	Stopped bool `json:"stopped"` // cancelled before the model finished
+}
+
+// StreamEvent is one server-sent event of a streamed chat: Type is start,
+// citations, token, attribution, done or error, and the matching field is
+// set. Error carries the answer of an error event, and Failure its error.
+type StreamEvent struct {
+	Type        string
+	Start       *StartEvent
+	Citations   *CitationsEvent
+	Token       *TokenEvent
+	Attribution *Attribution
+	Done        *DoneEvent
+	Error       string
+	Failure     *Error
+}
+
+// PageError is a page whose text couldn't be extracted.
+type PageError struct {
+	Page  int    `json:"page"`
+	Error string `json:"error"`
+}
+
+// IngestReport describes how much of an ingested document made it in.
+type IngestReport struct {
+	Pages           int         `json:"pages"`
+	FailedPages     []PageError `json:"failed_pages"`
+	DuplicateChunks int         `json:"duplicate_chunks,omitempty"`
+}
+
+// IngestResponse is the reply to POST /ingest. A queued ingest has a JobID
+// and no chunks yet.
+type IngestResponse struct {
+	Status     string        `json:"status"`
+	Message    string        `json:"message"`
+	Code       string        `json:"code,omitempty"`
+	DocumentID string        `json:"document_id,omitempty"`
+	JobID      string        `json:"job_id,omitempty"`
+	Chunks     int           `json:"chunks,omitempty"`
+	Language   string        `json:"language,omitempty"`
+	Report     *IngestReport `json:"report,omitempty"`
+	Paper      *Paper        `json:"paper,omitempty"` // from POST /ingest/paper
+	Video      *Video        `json:"video,omitempty"` // from POST /ingest/video
+}
+
+// IngestTextRequest is the body of POST /ingest/text.
+type IngestTextRequest struct {
+	Title      string         `json:"title,omitempty"`
+	Text       string         `json:"text"` // form feeds break pages
+	Metadata   map[string]any `json:"metadata,omitempty"`
+	Workspace  string         `json:"workspace,omitempty"`
+	Tags       []string       `json:"tags,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	URL        string         `json:"url,omitempty"` // cited as where the text came from
+	Supersedes string         `json:"supersedes,omitempty"`
+}
+
+// IngestPaperRequest is the body of POST /ingest/paper.
+type IngestPaperRequest struct {
+	ID        string   `json:"id"` // an arXiv ID or a DOI, bare, prefixed ("arXiv:", "doi:") or as a URL
+	Workspace string   `json:"workspace,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Paper is what POST /ingest/paper found out about a paper.
+type Paper struct {
+	ArXivID   string    `json:"arxiv_id,omitempty"`
+	DOI       string    `json:"doi,omitempty"`
+	Title     string    `json:"title"`
+	Authors   []string  `json:"authors,omitempty"`
+	Abstract  string    `json:"abstract,omitempty"`
+	Journal   string    `json:"journal,omitempty"`
+	Published time.Time `json:"published,omitzero"`
+	URL       string    `json:"url"`     // the paper's landing page, cited
+	PDFURL    string    `json:"pdf_url"` // where the PDF was fetched from
+}
+
+// IngestVideoRequest is the body of POST /ingest/video.
+type IngestVideoRequest struct {
+	URL       string   `json:"url"`                // a YouTube or Vimeo link
+	Language  string   `json:"language,omitempty"` // captions to prefer, e.g. "en"
+	Workspace string   `json:"workspace,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Video is what POST /ingest/video found out about a video.
+type Video struct {
+	Provider   string  `json:"provider"` // youtube or vimeo
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Channel    string  `json:"channel,omitempty"`
+	URL        string  `json:"url"`
+	Duration   float64 `json:"duration,omitempty"` // seconds
+	Transcript string  `json:"transcript"`         // captions, auto_captions or transcribed
+	Language   string  `json:"language,omitempty"` // of the captions used
+}
+
+// Document is an entry of GET /documents.
+type Document struct {
+	ID          string            `json:"id"`
+	Filename    string            `json:"filename"`
+	Workspace   string            `json:"workspace"`
+	Owner       string            `json:"owner,omitempty"`
+	ParentID    string            `json:"parent_id,omitempty"`
+	Language    string            `json:"language"`
+	Tags        []string          `json:"tags,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"` // supplied with pushed text or by a connector; filterable as fields
+	Chunks      int               `json:"chunks"`
+	Pages       int               `json:"pages,omitempty"`
+	FailedPages []PageError       `json:"failed_pages,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Version     int               `json:"version,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+}
+
+// Job is a queued ingest, from GET /jobs/:id.
+type Job struct {
+	ID          string      `json:"id"`
+	DocumentID  string      `json:"document_id"`
+	Filename    string      `json:"filename"`
+	Workspace   string      `json:"workspace"`
+	State       string      `json:"state"` // queued, running, done, failed
+	Chunks      int         `json:"chunks"`
+	Pages       int         `json:"pages,omitempty"`
+	FailedPages []PageError `json:"failed_pages,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	ErrorCode   string      `json:"error_code,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// RetrieveRequest is the body of POST /v1/retrieve.
+type RetrieveRequest struct {
+	Query          string          `json:"query"`
+	TopK           int             `json:"top_k,omitempty"`     // 4 when unset, at most 50
+	Workspace      string          `json:"workspace,omitempty"` // "default" when empty
+	ScoreThreshold float32         `json:"score_threshold,omitempty"`
+	Filters        *RetrieveFilter `json:"filters,omitempty"`
+}
+
+// RetrieveFilter narrows a retrieval. Empty fields don't filter.
+type RetrieveFilter struct {
+	DocumentIDs []string          `json:"document_ids,omitempty"`
+	Tags        []string          `json:"tags,omitempty"` // chunks carrying any of these tags
+	Language    string            `json:"language,omitempty"`
+	After       string            `json:"after,omitempty"`  // only documents dated after this year, month or day
+	Before      string            `json:"before,omitempty"` // only documents dated before this year, month or day
+	Fields      map[string]string `json:"fields,omitempty"` // only chunks whose record or document metadata fields have these values
+}
+
+// RetrievedDocument is a chunk returned by POST /v1/retrieve, in the shape
+// of a LangChain Document.
+type RetrievedDocument struct {
+	ID          string         `json:"id"`
+	PageContent string         `json:"page_content"`
+	Metadata    map[string]any `json:"metadata"` // document_id, filename, page, score...
+	Score       float32        `json:"score"`
+}
+
+// RetrieveResponse is the reply to POST /v1/retrieve.
+type RetrieveResponse struct {
+	Status    string              `json:"status"`
+	Documents []RetrievedDocument `json:"documents"`
+}
+
+// RecordMapping says how the records of a JSON or JSONL upload are indexed.
+// Fields are named by key, or by dotted path into nested objects.
+type RecordMapping struct {
+	Text     []string `json:"text,omitempty"`     // fields that make up the searchable text; all but metadata when empty
+	Metadata []string `json:"metadata,omitempty"` // fields kept as filterable attributes
+	Title    string   `json:"title,omitempty"`    // field whose value heads the record's text
+}
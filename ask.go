@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// POST /v1/ask is a single call for no-code tools that can't chain an
+// upload, an ingest and a chat: it fetches the document at a URL, ingests it
+// into the ASK_WORKSPACE workspace (default "ask"), answers the question
+// from it alone, and deletes it again before replying.
+
+// askClient fetches documents for /v1/ask, and pages and files at URLs
+// found in fetched content. Unless ASK_ALLOW_PRIVATE_URLS is true it refuses
+// to connect to loopback, private, shared (carrier-grade NAT) and
+// link-local addresses, so none of them can be used to reach services behind
+// the firewall. It never goes through a proxy, which would make the
+// connection the check sees the proxy's.
+var askClient = &http.Client{
+	Timeout: 2 * time.Minute,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				if os.Getenv("ASK_ALLOW_PRIVATE_URLS") == "true" {
+					return nil
+				}
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				ip := net.ParseIP(host)
+				if ip == nil || ip.IsLoopback() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+					return fmt.Errorf("address %s is not allowed", host)
+				}
+				return nil
+			},
+		}).DialContext,
+	},
+}
+
+// sharedAddressSpace is 100.64.0.0/10, carrier-grade NAT space (RFC 6598)
+// that IsPrivate doesn't cover.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// downloadDocument saves the document at rawURL into the upload directory,
+// at most ASK_MAX_DOWNLOAD_MB (default 50), and returns its path and a
+// filename for it: the server's, the URL's, or one with an extension for
+// its content type.
+func downloadDocument(ctx context.Context, rawURL, id string) (string, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", "", errors.New("document_url must be an http or https URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := askClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("fetching the document returned %s", resp.Status)
+	}
+
+	filename := path.Base(u.Path)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		filename = filepath.Base(params["filename"])
+	}
+	if filename == "." || filename == "/" {
+		filename = "document"
+	}
+	if filepath.Ext(filename) == "" {
+		if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+			if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+				filename += exts[0]
+			}
+		}
+	}
+
+	limit := int64(envInt("ASK_MAX_DOWNLOAD_MB", 50)) << 20
+	dest := filepath.Join(uploadDir(), "ask-"+id+filepath.Ext(filename))
+	if err := os.MkdirAll(uploadDir(), 0o755); err != nil {
+		return "", "", err
+	}
+	out, err := os.Create(dest)
+	if err != nil {
+		return "", "", err
+	}
+	n, err := io.Copy(out, io.LimitReader(resp.Body, limit+1))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("document is larger than %d MB", limit>>20)
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", "", err
+	}
+	return dest, filename, nil
+}
+
+// handleAsk answers a question about a document at a URL in one call:
+// POST /v1/ask {"document_url", "question"}.
+func handleAsk(c *gin.Context) {
+	var body struct {
+		DocumentURL string `json:"document_url"`
+		Question    string `json:"question"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	if strings.TrimSpace(body.DocumentURL) == "" || strings.TrimSpace(body.Question) == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "document_url and question are required"))
+		return
+	}
+	ctx := c.Request.Context()
+	id := uuid.New().String()
+	filePath, filename, err := downloadDocument(ctx, body.DocumentURL, id)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamWeb, "Download Error: "+err.Error()))
+		return
+	}
+	defer os.Remove(filePath)
+
+	job := ingestJob{
+		ID:         id,
+		DocumentID: uuid.New().String(),
+		Filename:   filename,
+		Path:       filePath,
+		Workspace:  cmp.Or(os.Getenv("ASK_WORKSPACE"), "ask"),
+		Owner:      requestUser(c),
+		Source:     body.DocumentURL,
+	}
+	// the document goes whatever happens, even if the caller hangs up
+	defer func() {
+		doc := documentRecord{ID: job.DocumentID, Workspace: job.Workspace}
+		metaStore.Get("documents", job.DocumentID, &doc)
+		if _, err := deleteDocument(context.Background(), doc); err != nil {
+			log.Printf("❌ Ask Cleanup Error: %s: %v", job.DocumentID, err)
+		}
+	}()
+	res, err := executeIngest(ctx, job)
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeIngestFailed, err))
+		return
+	}
+	if res.Chunks == 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeNoText, "No text found in the document"))
+		return
+	}
+
+	reply := answerChat(c, api.ChatRequest{Question: body.Question, Workspace: job.Workspace, DocumentIDs: []string{job.DocumentID}, Format: "plain"}, "", false)
+	answer, _ := reply["answer"].(string)
+	if e, failed := reply["error"].(apiError); failed {
+		// the chat's own error, as answerChat reports it
+		c.JSON(http.StatusOK, failureReply(e))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "answer": answer, "filename": filename, "pages": res.Pages, "chat_id": reply["chat_id"]})
+}
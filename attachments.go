@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/ledongthuc/pdf"
+)
+
+// maxAttachmentDepth stops attachments of attachments from nesting forever.
+const maxAttachmentDepth = 3
+
+// attachmentsEnabled reports whether files embedded in PDFs are ingested as
+// child documents; EXTRACT_ATTACHMENTS=false turns it off.
+func attachmentsEnabled() bool {
+	return os.Getenv("EXTRACT_ATTACHMENTS") != "false"
+}
+
+// embeddedFile is a file found inside a PDF, written out to Path.
+type embeddedFile struct {
+	Name string
+	Path string
+}
+
+// attachmentResult says how ingesting one embedded file went.
+type attachmentResult struct {
+	Filename   string `json:"filename"`
+	DocumentID string `json:"document_id,omitempty"`
+	Chunks     int    `json:"chunks"`
+	Error      string `json:"error,omitempty"`
+}
+
+// extractEmbeddedFiles writes out the files embedded in a PDF: the document's
+// EmbeddedFiles name tree (which also holds a portfolio's members) and file
+// attachment annotations. The caller removes the files.
+func extractEmbeddedFiles(path, password string) (files []embeddedFile, err error) {
+	f, r, err := openPdf(path, password)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%v", p)
+		}
+	}()
+
+	seen := map[string]bool{}
+	save := func(spec pdf.Value) {
+		stream := spec.Key("EF").Key("F")
+		if stream.IsNull() {
+			return
+		}
+		name := spec.Key("UF").Text()
+		if name == "" {
+			name = spec.Key("F").Text()
+		}
+		name = filepath.Base(name)
+		if name == "." || name == "/" || name == "" {
+			name = "attachment"
+		}
+		if seen[name] {
+			return
+		}
+		seen[name] = true
+		if file, err := writeEmbedded(stream, name); err != nil {
+			log.Printf("❌ Attachment Error (%s): %v", name, err)
+		} else {
+			files = append(files, file)
+		}
+	}
+
+	var walk func(node pdf.Value)
+	walk = func(node pdf.Value) {
+		names := node.Key("Names")
+		for i := 1; i < names.Len(); i += 2 {
+			save(names.Index(i))
+		}
+		kids := node.Key("Kids")
+		for i := 0; i < kids.Len(); i++ {
+			walk(kids.Index(i))
+		}
+	}
+	walk(r.Trailer().Key("Root").Key("Names").Key("EmbeddedFiles"))
+
+	for i := 1; i <= r.NumPage(); i++ {
+		annots := r.Page(i).V.Key("Annots")
+		for j := 0; j < annots.Len(); j++ {
+			if a := annots.Index(j); a.Key("Subtype").Name() == "FileAttachment" {
+				save(a.Key("FS"))
+			}
+		}
+	}
+	return files, nil
+}
+
+func writeEmbedded(stream pdf.Value, name string) (embeddedFile, error) {
+	tmp, err := os.CreateTemp("", "docuchat-attachment-*"+filepath.Ext(name))
+	if err != nil {
+		return embeddedFile{}, err
+	}
+	rc := stream.Reader()
+	defer rc.Close()
+	_, err = io.Copy(tmp, rc)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return embeddedFile{}, err
+	}
+	return embeddedFile{Name: name, Path: tmp.Name()}, nil
+}
+
+// ingestAttachments ingests the files embedded in a job's PDF as child
+// documents of it, recursing into their own attachments.
+func ingestAttachments(ctx context.Context, job ingestJob, path string) []attachmentResult {
+	if !attachmentsEnabled() || job.Depth >= maxAttachmentDepth {
+		return nil
+	}
+	files, err := extractEmbeddedFiles(path, openText(job.Password))
+	if err != nil || len(files) == 0 {
+		return nil
+	}
+	var results []attachmentResult
+	for _, file := range files {
+		child := ingestJob{
+			ID:         uuid.New().String(),
+			DocumentID: uuid.New().String(),
+			ParentID:   job.DocumentID,
+			Depth:      job.Depth + 1,
+			Filename:   file.Name,
+			Path:       file.Path,
+			Workspace:  job.Workspace,
+			Owner:      job.Owner,
+			Graph:      job.Graph,
+			Tags:       job.Tags,
+			Source:     job.Source,
+		}
+		res, err := executeIngest(ctx, child)
+		os.Remove(file.Path)
+		result := attachmentResult{Filename: file.Name, Chunks: res.Chunks}
+		switch {
+		case err != nil:
+			result.Error = err.Error()
+		case res.Chunks == 0:
+			result.Error = "No text found"
+		default:
+			result.DocumentID = child.DocumentID
+		}
+		results = append(results, result)
+	}
+	return results
+}
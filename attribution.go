@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gebt2000/go-docuchat/api"
+)
+
+// attribution maps a sentence of an answer to the sources that support it.
+type attribution = api.Attribution
+
+// attributor maps a streamed answer to its sources a sentence at a time, as
+// each sentence ends. A sentence is supported by a source when at least
+// ATTRIBUTION_MIN_OVERLAP (default 0.5) of its content words are in the
+// source's text; sentences of fewer than three content words, such as
+// "Sure!", aren't attributed. Words are compared as written, so a source in
+// another language than the answer doesn't support it.
+type attributor struct {
+	sources  []map[string]bool // the content words of each source
+	minimum  float64
+	pending  strings.Builder
+	offset   int // characters of the answer before pending
+	sentence int
+	found    []attribution
+}
+
+func newAttributor(contexts []string) *attributor {
+	a := &attributor{minimum: envFloat("ATTRIBUTION_MIN_OVERLAP", 0.5)}
+	for _, text := range contexts {
+		words := map[string]bool{}
+		for _, w := range contentWords(text) {
+			words[w] = true
+		}
+		a.sources = append(a.sources, words)
+	}
+	return a
+}
+
+// Add takes the next piece of the answer and returns the attributions of
+// the sentences it completes.
+func (a *attributor) Add(text string) []attribution {
+	a.pending.WriteString(text)
+	buf := a.pending.String()
+	var out []attribution
+	for {
+		cut := sentenceEnd(buf)
+		if cut < 0 {
+			break
+		}
+		if at, ok := a.attribute(buf[:cut]); ok {
+			out = append(out, at)
+		}
+		buf = buf[cut:]
+	}
+	a.pending.Reset()
+	a.pending.WriteString(buf)
+	return out
+}
+
+// Flush attributes the last sentence, which ended with the answer.
+func (a *attributor) Flush() []attribution {
+	text := a.pending.String()
+	a.pending.Reset()
+	if at, ok := a.attribute(text); ok {
+		return []attribution{at}
+	}
+	return nil
+}
+
+// All is every attribution made so far.
+func (a *attributor) All() []attribution {
+	return append([]attribution{}, a.found...)
+}
+
+func (a *attributor) attribute(text string) (attribution, bool) {
+	start := a.offset
+	a.offset += utf8.RuneCountInString(text)
+	if strings.TrimSpace(text) == "" {
+		return attribution{}, false
+	}
+	n := a.sentence
+	a.sentence++
+	words := contentWords(text)
+	if len(words) < 3 || len(a.sources) == 0 {
+		return attribution{}, false
+	}
+	at := attribution{Sentence: n, Text: strings.TrimSpace(text), Sources: []int{}}
+	// offsets exclude the whitespace around the sentence
+	lead := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+	at.Start = start + utf8.RuneCountInString(text[:lead])
+	at.End = at.Start + utf8.RuneCountInString(at.Text)
+	for i, src := range a.sources {
+		shared := 0
+		for _, w := range words {
+			if src[w] {
+				shared++
+			}
+		}
+		if float64(shared)/float64(len(words)) >= a.minimum {
+			at.Sources = append(at.Sources, i)
+		}
+	}
+	if len(at.Sources) == 0 {
+		return attribution{}, false
+	}
+	a.found = append(a.found, at)
+	return at, true
+}
+
+// sentenceEnd is the byte offset just past the first sentence of text that
+// is known to have ended, or -1.
+func sentenceEnd(text string) int {
+	cut := -1
+	for _, sep := range []string{". ", "! ", "? ", "\n"} {
+		if i := strings.Index(text, sep); i >= 0 && (cut < 0 || i+len(sep) < cut) {
+			cut = i + len(sep)
+		}
+	}
+	return cut
+}
+
+// contentWords lowercases text and splits it into words of letters and
+// digits, dropping the stopwords of every language and words shorter than
+// three letters unless they hold a digit.
+func contentWords(text string) []string {
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if stopwordSet[w] || (utf8.RuneCountInString(w) < 3 && !strings.ContainsFunc(w, unicode.IsDigit)) {
+			continue
+		}
+		out = append(out, w)
+	}
+	return out
+}
+
+// stopwordSet holds the stopwords of every language.
+var stopwordSet = func() map[string]bool {
+	set := map[string]bool{}
+	for _, list := range stopwords {
+		for _, w := range list {
+			set[w] = true
+		}
+	}
+	return set
+}()
+
+// highlight is the passage of a source that best supports a sentence.
+type highlight = api.Highlight
+
+// textSpan is a sentence of a source, by character offsets.
+type textSpan struct {
+	start, end int
+	text       string
+}
+
+// sentenceSpans splits text into its sentences, trimmed of surrounding
+// whitespace, skipping those with no content words.
+func sentenceSpans(text string) []textSpan {
+	var spans []textSpan
+	offset := 0
+	for text != "" {
+		cut := sentenceEnd(text)
+		if cut < 0 {
+			cut = len(text)
+		}
+		part := text[:cut]
+		trimmed := strings.TrimSpace(part)
+		lead := utf8.RuneCountInString(part[:len(part)-len(strings.TrimLeftFunc(part, unicode.IsSpace))])
+		if len(contentWords(trimmed)) > 0 {
+			start := offset + lead
+			spans = append(spans, textSpan{start, start + utf8.RuneCountInString(trimmed), trimmed})
+		}
+		offset += utf8.RuneCountInString(part)
+		text = text[cut:]
+	}
+	return spans
+}
+
+// highlightAttributions finds, for each source of each attribution, the
+// sentence of the source closest in meaning to the attributed one, by
+// embedding both, so a UI can highlight it when the citation is clicked.
+// Offsets count characters into the source's text as put into the context:
+// a chunk's text, led by a "Source:" line for web and attachment hits.
+// Everything is embedded together, in requests of up to 100 texts; if one
+// fails the attributions are returned without highlights.
+func highlightAttributions(ctx context.Context, contexts []string, ats []attribution) []attribution {
+	if len(ats) == 0 {
+		return ats
+	}
+	spans := map[int][]textSpan{}
+	var texts []string
+	for _, at := range ats {
+		texts = append(texts, at.Text)
+	}
+	first := map[int]int{} // where each source's spans start among the texts
+	for _, at := range ats {
+		for _, i := range at.Sources {
+			if _, ok := spans[i]; ok || i >= len(contexts) {
+				continue
+			}
+			spans[i] = sentenceSpans(contexts[i])
+			first[i] = len(texts)
+			for _, sp := range spans[i] {
+				texts = append(texts, sp.text)
+			}
+		}
+	}
+	vectors, err := embedTexts(ctx, texts)
+	if err != nil {
+		log.Printf("⚠️ Highlight Error: %v", err)
+		return ats
+	}
+	out := make([]attribution, len(ats))
+	for n, at := range ats {
+		at.Highlights = []highlight{}
+		for _, i := range at.Sources {
+			best, score := -1, 0.0
+			for k := range spans[i] {
+				if sim := cosine(vectors[n], vectors[first[i]+k]); best < 0 || sim > score {
+					best, score = k, sim
+				}
+			}
+			if best >= 0 {
+				sp := spans[i][best]
+				at.Highlights = append(at.Highlights, highlight{Source: i, Start: sp.start, End: sp.end, Text: sp.text, Score: float32(score)})
+			}
+		}
+		out[n] = at
+	}
+	return out
+}
+
+// answerAttributions attributes a whole answer at once, for replies that
+// aren't streamed, highlighting the supporting passages when asked to.
+func answerAttributions(rec chatRecord) []attribution {
+	a := newAttributor(rec.contexts)
+	ats := append(a.Add(rec.Answer), a.Flush()...)
+	if rec.highlight {
+		ats = highlightAttributions(context.Background(), rec.contexts, ats)
+	}
+	if ats == nil {
+		return []attribution{}
+	}
+	return ats
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+)
+
+// handleChatBatch answers several questions at once: POST /chat/batch with
+// {"questions": [...]} plus any /chat options, which apply to every question.
+// A question is a string or an object of /chat fields overriding them. Up to
+// CHAT_BATCH_MAX (default 20) questions run CHAT_BATCH_CONCURRENCY (default
+// 4) at a time, each answered as /chat would in a session of its own.
+// Results come back in order, or with "stream": true as a "result" event
+// each as it finishes, then "done".
+func handleChatBatch(c *gin.Context) {
+	var body map[string]json.RawMessage
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	var questions []json.RawMessage
+	if err := json.Unmarshal(body["questions"], &questions); err != nil || len(questions) == 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "questions must be a non-empty array"))
+		return
+	}
+	if limit := envInt("CHAT_BATCH_MAX", 20); len(questions) > limit {
+		c.JSON(http.StatusOK, errorReply(c, codeTooLarge, fmt.Sprintf("At most %d questions per batch", limit)))
+		return
+	}
+	var stream bool
+	json.Unmarshal(body["stream"], &stream)
+	// concurrent turns can't share a session
+	for _, k := range []string{"questions", "stream", "session_id"} {
+		delete(body, k)
+	}
+
+	requests := make([][]byte, len(questions))
+	for i, q := range questions {
+		fields := map[string]json.RawMessage{}
+		for k, v := range body {
+			fields[k] = v
+		}
+		var question string
+		if json.Unmarshal(q, &question) == nil {
+			fields["question"] = q
+		} else {
+			var own map[string]json.RawMessage
+			if err := json.Unmarshal(q, &own); err != nil {
+				c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, fmt.Sprintf("questions[%d] must be a string or an object", i)))
+				return
+			}
+			for k, v := range own {
+				fields[k] = v
+			}
+			delete(fields, "stream")
+			delete(fields, "session_id")
+		}
+		requests[i], _ = json.Marshal(fields)
+	}
+
+	results := make([]gin.H, len(questions))
+	finished := make(chan int)
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, envInt("CHAT_BATCH_CONCURRENCY", 4))
+	for i := range requests {
+		wg.Go(func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = batchAnswer(c, requests[i])
+			results[i]["index"] = i
+			finished <- i
+		})
+	}
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	if !stream {
+		for range finished {
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "results": results})
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	for i := range finished {
+		c.SSEvent("result", results[i])
+		c.Writer.Flush()
+	}
+	c.SSEvent("done", gin.H{"count": len(results)})
+	c.Writer.Flush()
+}
+
+// batchAnswer answers one question of a batch as the same user and returns
+// its reply.
+func batchAnswer(c *gin.Context, body []byte) gin.H {
+	var req api.ChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return chatErrorReply(c, codeInvalidRequest, "❌ Error: Invalid JSON format.")
+	}
+	req.Stream, req.SessionID = false, ""
+	return answerChat(c, req, "", false)
+}
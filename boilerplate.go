@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// boilerplateSample is how many pages are read before deciding which
+	// lines repeat.
+	boilerplateSample = 12
+	// boilerplateEdge is how many lines at the top and bottom of a page can
+	// be a header or footer.
+	boilerplateEdge = 3
+)
+
+var (
+	digitsRe     = regexp.MustCompile(`\d+`)
+	pageNumberRe = regexp.MustCompile(`^[\s\-–—|]*(?i:page|p\.|seite|página|pagina)?\s*(?:\d+|x{0,3}(?:ix|iv|v?i{1,3}|v)|x{1,3})(?:\s*(?i:of|/|von|de|di)\s*\d+)?[\s\-–—|]*$`)
+)
+
+// boilerplateEnabled reports whether headers, footers and page numbers are
+// stripped; STRIP_BOILERPLATE=false turns it off.
+func boilerplateEnabled() bool {
+	return os.Getenv("STRIP_BOILERPLATE") != "false"
+}
+
+// boilerplateKey normalises a line so its page-to-page variants compare
+// equal: "Confidential — Page 3 of 10" and "... Page 4 of 10" share a key.
+// Code fences and lines of bare punctuation, like a closing brace, get no
+// key: they repeat at page edges in code listings without being boilerplate.
+func boilerplateKey(line string) string {
+	if fenceRe.MatchString(line) || !strings.ContainsFunc(line, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+		return ""
+	}
+	return strings.ToLower(strings.Join(strings.Fields(digitsRe.ReplaceAllString(line, "#")), " "))
+}
+
+// edgeLines returns the indexes of the non-blank lines at the top and bottom
+// of a page: up to boilerplateEdge each, fewer on short pages so their body
+// isn't mistaken for a header.
+func edgeLines(lines []string) []int {
+	nonBlank := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			nonBlank++
+		}
+	}
+	edge := min(boilerplateEdge, max(1, nonBlank/4))
+	var top, bottom []int
+	for i := 0; i < len(lines) && len(top) < edge; i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			top = append(top, i)
+		}
+	}
+	for i := len(lines) - 1; i >= 0 && len(bottom) < edge; i-- {
+		if strings.TrimSpace(lines[i]) != "" && (len(top) == 0 || i > top[len(top)-1]) {
+			bottom = append(bottom, i)
+		}
+	}
+	return append(top, bottom...)
+}
+
+// stripBoilerplate removes repeated headers and footers and bare page
+// numbers from a page stream. The first pages are held back until it has
+// learnt which edge lines recur on at least half of them; a document needs
+// three pages before anything but page numbers is stripped.
+func stripBoilerplate(ctx context.Context, pages <-chan pdfPage) <-chan pdfPage {
+	if !boilerplateEnabled() {
+		return pages
+	}
+	out := make(chan pdfPage)
+	go func() {
+		defer close(out)
+		var sample []pdfPage
+		for p := range pages {
+			if sample = append(sample, p); len(sample) == boilerplateSample {
+				break
+			}
+		}
+		repeated := repeatedEdgeLines(sample)
+		send := func(p pdfPage) bool {
+			p.Text = stripPage(p.Text, repeated)
+			select {
+			case out <- p:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, p := range sample {
+			if !send(p) {
+				return
+			}
+		}
+		for p := range pages {
+			if !send(p) {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// repeatedEdgeLines finds the keys of edge lines shared by at least half of
+// the sampled pages.
+func repeatedEdgeLines(sample []pdfPage) map[string]bool {
+	repeated := map[string]bool{}
+	if len(sample) < 3 {
+		return repeated
+	}
+	counts := map[string]int{}
+	for _, p := range sample {
+		lines := strings.Split(p.Text, "\n")
+		seen := map[string]bool{}
+		for _, i := range edgeLines(lines) {
+			if key := boilerplateKey(lines[i]); key != "" && !seen[key] {
+				seen[key] = true
+				counts[key]++
+			}
+		}
+	}
+	for key, n := range counts {
+		if n*2 >= len(sample) {
+			repeated[key] = true
+		}
+	}
+	return repeated
+}
+
+// stripPage drops repeated headers and footers and page numbers from the
+// edges of one page's text.
+func stripPage(text string, repeated map[string]bool) string {
+	lines := strings.Split(text, "\n")
+	drop := map[int]bool{}
+	for _, i := range edgeLines(lines) {
+		if repeated[boilerplateKey(lines[i])] || pageNumberRe.MatchString(lines[i]) {
+			drop[i] = true
+		}
+	}
+	if len(drop) == 0 {
+		return text
+	}
+	kept := lines[:0]
+	for i, line := range lines {
+		if !drop[i] {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	"github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	// the BPE tables are embedded, so counting never needs the network
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// contextLimits are the context windows of the models we know about.
+var contextLimits = map[string]int{
+	"gpt-4o":        128000,
+	"gpt-4o-mini":   128000,
+	"gpt-4.1":       1047576,
+	"gpt-4.1-mini":  1047576,
+	"gpt-4-turbo":   128000,
+	"gpt-4":         8192,
+	"gpt-3.5-turbo": 16385,
+}
+
+// messageOverhead is what the chat format adds per message, roughly.
+const messageOverhead = 4
+
+var encodings sync.Map // model -> *tiktoken.Tiktoken, or nil if unknown
+
+func encodingFor(model string) *tiktoken.Tiktoken {
+	if enc, ok := encodings.Load(model); ok {
+		return enc.(*tiktoken.Tiktoken)
+	}
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		enc, err = tiktoken.GetEncoding("o200k_base")
+	}
+	if err != nil {
+		enc = nil
+	}
+	encodings.Store(model, enc)
+	return enc
+}
+
+// countTokens counts text's tokens the way model's tokenizer does, falling
+// back to four characters a token.
+func countTokens(model, text string) int {
+	if enc := encodingFor(model); enc != nil {
+		return len(enc.EncodeOrdinary(text))
+	}
+	return (len(text) + 3) / 4
+}
+
+// truncateTokens cuts text to at most n tokens.
+func truncateTokens(model, text string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if enc := encodingFor(model); enc != nil {
+		if tokens := enc.EncodeOrdinary(text); len(tokens) > n {
+			return enc.Decode(tokens[:n])
+		}
+		return text
+	}
+	if r := []rune(text); len(r) > n*4 {
+		return string(r[:n*4])
+	}
+	return text
+}
+
+// promptBudget is how many prompt tokens a model can take: its context
+// window (CONTEXT_LIMIT_TOKENS overrides it) less CONTEXT_RESERVE_TOKENS
+// (default 1024) kept for the reply.
+type promptBudget struct {
+	model string
+	limit int
+}
+
+func newPromptBudget(model string) promptBudget {
+	limit := envInt("CONTEXT_LIMIT_TOKENS", 0)
+	if limit <= 0 {
+		limit = contextLimits[model]
+	}
+	if limit <= 0 {
+		limit = 8192
+	}
+	return promptBudget{model: model, limit: limit - envInt("CONTEXT_RESERVE_TOKENS", 1024)}
+}
+
+// budgetPriority lists the trimmable prompt components in the order they
+// claim the budget, from BUDGET_PRIORITY (default "chunks,history"). The
+// first gets all it needs; later ones share what is left.
+func budgetPriority() []string {
+	p := os.Getenv("BUDGET_PRIORITY")
+	if p == "" {
+		p = "chunks,history"
+	}
+	var order []string
+	for _, c := range strings.Split(p, ",") {
+		if c = strings.TrimSpace(c); c != "" && !slices.Contains(order, c) {
+			order = append(order, c)
+		}
+	}
+	// anything left out comes last rather than getting nothing
+	for _, c := range []string{"chunks", "history"} {
+		if !slices.Contains(order, c) {
+			order = append(order, c)
+		}
+	}
+	return order
+}
+
+// fit trims history and retrieved texts so that they, plus the fixed part of
+// the prompt (instructions and question, never trimmed), fit the budget.
+// Texts are kept in rank order and history newest first; the first item that
+// doesn't fit is truncated and the rest are dropped.
+func (b promptBudget) fit(fixed string, history []openai.ChatCompletionMessage, texts []string) ([]openai.ChatCompletionMessage, []string) {
+	left := b.limit - countTokens(b.model, fixed) - messageOverhead
+	// a leading system message (the session summary) is kept like the question
+	var lead []openai.ChatCompletionMessage
+	if len(history) > 0 && history[0].Role == openai.ChatMessageRoleSystem {
+		lead, history = history[:1], history[1:]
+		left -= countTokens(b.model, lead[0].Content) + messageOverhead
+	}
+	var keptTexts []string
+	keepHistory := len(history)
+
+	for _, component := range budgetPriority() {
+		switch component {
+		case "chunks":
+			for _, t := range texts {
+				n := countTokens(b.model, t) + 2 // separator
+				if n > left {
+					if t = truncateTokens(b.model, t, left-2); t != "" {
+						keptTexts = append(keptTexts, t)
+					}
+					left = 0
+					break
+				}
+				keptTexts = append(keptTexts, t)
+				left -= n
+			}
+		case "history":
+			keepHistory = 0
+			// whole turns only, newest first
+			for i := len(history) - 2; i >= 0; i -= 2 {
+				n := countTokens(b.model, history[i].Content) + countTokens(b.model, history[i+1].Content) + 2*messageOverhead
+				if n > left {
+					break
+				}
+				left -= n
+				keepHistory = len(history) - i
+			}
+		}
+	}
+	return append(lead, history[len(history)-keepHistory:]...), keptTexts
+}
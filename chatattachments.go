@@ -0,0 +1,241 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// A /chat question can bring a file along, sent as the multipart "file"
+// field (or an "upload_id" of a finished resumable upload) next to the
+// "request" JSON. Its attachment field says how the file is kept:
+//
+//   - persistent (the default) ingests it inline, as if POSTed to /ingest,
+//     into the session's workspace. A session limited to documents gains it
+//     in its scope; an unscoped session searches it with everything else.
+//   - ephemeral indexes it in memory, like an ephemeral session's file, for
+//     the chat session alone; its passages compete with the documents' for
+//     the context of every later turn. It is forgotten after
+//     EPHEMERAL_TTL_MINUTES (default 60) without a question, and with the
+//     process. The file isn't stored, though the session's turns are
+//     recorded as usual.
+//
+// Either way the file can be asked about in the same request.
+
+type chatAttachment = api.Attachment
+
+// attachedFile is a file held in memory for a chat session.
+type attachedFile struct {
+	id       string
+	filename string
+	chunks   []textChunk
+	vectors  [][]float32
+}
+
+// sessionFiles are the ephemeral attachments of a chat session.
+type sessionFiles struct {
+	mu      sync.Mutex
+	files   []attachedFile
+	expires time.Time
+}
+
+// chatAttachments maps chat session IDs to their *sessionFiles.
+var chatAttachments sync.Map
+
+// sweepChatAttachments forgets the files of sessions unused for longer than
+// the TTL.
+func sweepChatAttachments() {
+	chatAttachments.Range(func(key, value any) bool {
+		s := value.(*sessionFiles)
+		s.mu.Lock()
+		expired := time.Now().After(s.expires)
+		s.mu.Unlock()
+		if expired {
+			chatAttachments.Delete(key)
+		}
+		return true
+	})
+}
+
+// hasAttachment reports whether a multipart /chat request carries a file.
+func hasAttachment(c *gin.Context) bool {
+	if c.ContentType() != "multipart/form-data" {
+		return false
+	}
+	if c.PostForm("upload_id") != "" {
+		return true
+	}
+	_, err := c.FormFile("file")
+	return err == nil
+}
+
+// attachToSession keeps the file sent with a chat question as mode asks,
+// adding it to the session, and returns what became of it.
+func attachToSession(c *gin.Context, sess *session, mode string) (*chatAttachment, error) {
+	switch mode {
+	case "", "persistent":
+		return attachPersistent(c, sess)
+	case "ephemeral":
+		return attachEphemeral(c, sess)
+	}
+	return nil, fmt.Errorf("attachment must be persistent or ephemeral")
+}
+
+func attachPersistent(c *gin.Context, sess *session) (*chatAttachment, error) {
+	src, err := ingestSource(c, sess.Workspace)
+	if err != nil {
+		return nil, err
+	}
+	succeeded := false
+	defer func() { src.Done(succeeded) }()
+	if src.Path == "" {
+		return nil, errors.New("Upload was already ingested")
+	}
+	job := ingestJob{
+		ID:         uuid.New().String(),
+		DocumentID: uuid.New().String(),
+		Filename:   src.Filename,
+		Path:       src.Path,
+		UploadID:   src.UploadID,
+		Workspace:  sess.Workspace,
+		Owner:      requestUser(c),
+		Graph:      graphEnabled(""),
+		Source:     "chat",
+	}
+	if pw := c.PostForm("password"); pw != "" {
+		job.Password = sealText(pw)
+	}
+	// inline even when ingests are queued, so the question can be answered
+	// from the file
+	res, err := executeIngest(context.Background(), job)
+	if err != nil {
+		return nil, err
+	}
+	if res.Chunks == 0 {
+		return nil, errors.New("No text found in the attachment")
+	}
+	succeeded = true
+	if len(sess.Documents) > 0 {
+		if err := scopeSession(sess, job.DocumentID); err != nil {
+			return nil, err
+		}
+	}
+	return &chatAttachment{Filename: job.Filename, Mode: "persistent", DocumentID: job.DocumentID, Chunks: res.Chunks}, nil
+}
+
+// scopeSession adds a document to a session's scope, in the stored record
+// too when the session has one already.
+func scopeSession(s *session, documentID string) error {
+	s.Documents = append(s.Documents, documentID)
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	var stored session
+	found, err := metaStore.Get("sessions", s.ID, &stored)
+	if err != nil || !found {
+		return err
+	}
+	stored.Documents = append(stored.Documents, documentID)
+	return metaStore.Put("sessions", s.ID, stored)
+}
+
+func attachEphemeral(c *gin.Context, sess *session) (*chatAttachment, error) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.New("an ephemeral attachment must be sent as a file")
+	}
+	tmp, err := os.CreateTemp("", "docuchat-ephemeral-*"+filepath.Ext(file.Filename))
+	if err != nil {
+		return nil, errors.New("Upload Storage Error: " + err.Error())
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+		return nil, errors.New("Upload Storage Error: " + err.Error())
+	}
+
+	ctx := c.Request.Context()
+	chunks, _, err := chunkFile(ctx, tmp.Name(), file.Filename, c.PostForm("password"))
+	os.Remove(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, errors.New("No text found in the attachment")
+	}
+	if limit := envInt("EPHEMERAL_MAX_CHUNKS", 2000); len(chunks) > limit {
+		return nil, fmt.Errorf("the attachment is too long to keep in memory (%d chunks, at most %d)", len(chunks), limit)
+	}
+	vectors, err := embedChunks(ctx, chunks)
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI Embedding Error: %v", err)
+	}
+
+	sweepChatAttachments()
+	v, _ := chatAttachments.LoadOrStore(sess.ID, &sessionFiles{})
+	s := v.(*sessionFiles)
+	s.mu.Lock()
+	s.files = append(s.files, attachedFile{id: uuid.New().String(), filename: filepath.Base(file.Filename), chunks: chunks, vectors: vectors})
+	s.expires = time.Now().Add(ephemeralTTL())
+	s.mu.Unlock()
+	return &chatAttachment{Filename: filepath.Base(file.Filename), Mode: "ephemeral", Chunks: len(chunks)}, nil
+}
+
+// sessionAttachments returns the ephemeral attachments of a chat session,
+// keeping them for another TTL.
+func sessionAttachments(sessionID string) (*sessionFiles, bool) {
+	sweepChatAttachments()
+	v, ok := chatAttachments.Load(sessionID)
+	if !ok {
+		return nil, false
+	}
+	s := v.(*sessionFiles)
+	s.mu.Lock()
+	s.expires = time.Now().Add(ephemeralTTL())
+	s.mu.Unlock()
+	return s, true
+}
+
+// search ranks the passages of the session's files against the question,
+// best first, citing each by its file. They have no document or point, so
+// they aren't counted in document analytics.
+func (s *sessionFiles) search(vector []float32, limit int) []blendedItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type hit struct {
+		file  int
+		chunk rankedVector
+	}
+	var hits []hit
+	for i, f := range s.files {
+		for _, r := range rankVectors(vector, f.vectors, limit) {
+			hits = append(hits, hit{i, r})
+		}
+	}
+	slices.SortStableFunc(hits, func(a, b hit) int { return cmp.Compare(b.chunk.score, a.chunk.score) })
+	items := make([]blendedItem, 0, min(len(hits), limit))
+	for _, h := range hits[:min(len(hits), limit)] {
+		items = append(items, attachmentItem(s.files[h.file], h.chunk))
+	}
+	return items
+}
+
+func attachmentItem(f attachedFile, r rankedVector) blendedItem {
+	return blendedItem{text: "Source: " + f.filename + "\n" + f.chunks[r.index].Text, ref: retrievedChunk{
+		ChunkID:    f.id + ":" + strconv.Itoa(r.index),
+		ChunkIndex: int64(r.index),
+		Score:      float32(r.score),
+		Source:     "attachment",
+		Title:      f.filename,
+	}}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gebt2000/go-docuchat/client"
+)
+
+// chatREPL is the state of a `docuchat chat` terminal session.
+type chatREPL struct {
+	server    string
+	client    *client.Client
+	workspace string
+	sessionID string
+	docs      []string          // scope for the next new session
+	filenames map[string]string // document ID -> filename, for citations
+	color     bool
+	out       io.Writer
+}
+
+const (
+	ansiReset = "\033[0m"
+	ansiDim   = "\033[2m"
+	ansiCyan  = "\033[36m"
+	ansiBold  = "\033[1m"
+	ansiRed   = "\033[31m"
+)
+
+const replHelp = `Commands:
+  /doc <id>...   start a new session limited to these documents (/doc clear for all)
+  /docs          list the workspace's documents
+  /new           start a new session
+  /save <name>   remember the current session under a name
+  /resume <name> continue a saved session (or a session ID)
+  /sessions      list saved sessions
+  /quit          leave`
+
+// runChatREPL is `docuchat chat [-server url] [-workspace ws] [-user id]
+// [-session id]`: an interactive client for the API that streams answers
+// and lists their citations. Named sessions are kept in
+// ~/.docuchat/sessions.json, or DOCUCHAT_SESSIONS.
+func runChatREPL(args []string) error {
+	fset := flag.NewFlagSet("chat", flag.ContinueOnError)
+	server := fset.String("server", cmp.Or(os.Getenv("DOCUCHAT_URL"), "http://localhost:8080"), "API base URL")
+	workspace := fset.String("workspace", "default", "workspace to chat in")
+	user := fset.String("user", os.Getenv("DOCUCHAT_USER"), "user ID sent as X-User-ID")
+	sessionID := fset.String("session", "", "session ID or saved name to resume")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	r := &chatREPL{
+		server:    strings.TrimRight(*server, "/"),
+		client:    client.New(*server, client.WithUser(*user)),
+		workspace: *workspace,
+		filenames: map[string]string{},
+		color:     ansiSupported(),
+		out:       os.Stdout,
+	}
+	if *sessionID != "" {
+		r.resume(*sessionID)
+	}
+	r.loadFilenames()
+	fmt.Fprintf(r.out, "%s docuchat — workspace %s at %s. /help for commands.\n", r.paint(ansiBold, "💬"), r.workspace, r.server)
+
+	in := bufio.NewScanner(os.Stdin)
+	in.Buffer(make([]byte, 64*1024), 1<<20)
+	for {
+		fmt.Fprint(r.out, r.paint(ansiBold, "\n> "))
+		if !in.Scan() {
+			fmt.Fprintln(r.out)
+			return in.Err()
+		}
+		line := strings.TrimSpace(in.Text())
+		switch {
+		case line == "":
+		case line == "/quit" || line == "/exit":
+			return nil
+		case strings.HasPrefix(line, "/"):
+			r.command(line)
+		default:
+			if err := r.ask(line); err != nil {
+				fmt.Fprintln(r.out, r.paint(ansiRed, "❌ "+err.Error()))
+			}
+		}
+	}
+}
+
+func (r *chatREPL) command(line string) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "/help":
+		fmt.Fprintln(r.out, replHelp)
+	case "/doc":
+		if len(fields) == 2 && fields[1] == "clear" {
+			r.docs = nil
+			fmt.Fprintln(r.out, "New session, searching all documents.")
+		} else if len(fields) > 1 {
+			r.docs = fields[1:]
+			fmt.Fprintf(r.out, "New session, limited to %s.\n", strings.Join(r.docNames(r.docs), ", "))
+		} else {
+			fmt.Fprintln(r.out, "Usage: /doc <id>... or /doc clear")
+			return
+		}
+		r.sessionID = ""
+	case "/docs":
+		r.loadFilenames()
+		ids := slices.Sorted(func(yield func(string) bool) {
+			for id := range r.filenames {
+				if !yield(id) {
+					return
+				}
+			}
+		})
+		for _, id := range ids {
+			fmt.Fprintf(r.out, "%s  %s\n", r.paint(ansiDim, id), r.filenames[id])
+		}
+	case "/new":
+		r.sessionID = ""
+		fmt.Fprintln(r.out, "New session.")
+	case "/save":
+		if len(fields) != 2 || r.sessionID == "" {
+			fmt.Fprintln(r.out, "Usage: /save <name>, once the session has a message")
+			return
+		}
+		saved := loadSavedSessions()
+		saved[fields[1]] = savedSession{ID: r.sessionID, Workspace: r.workspace, Server: r.server}
+		if err := storeSavedSessions(saved); err != nil {
+			fmt.Fprintln(r.out, r.paint(ansiRed, "❌ "+err.Error()))
+			return
+		}
+		fmt.Fprintf(r.out, "Saved as %s.\n", fields[1])
+	case "/resume":
+		if len(fields) != 2 {
+			fmt.Fprintln(r.out, "Usage: /resume <name or session ID>")
+			return
+		}
+		r.resume(fields[1])
+	case "/sessions":
+		saved := loadSavedSessions()
+		for _, name := range slices.Sorted(func(yield func(string) bool) {
+			for n := range saved {
+				if !yield(n) {
+					return
+				}
+			}
+		}) {
+			fmt.Fprintf(r.out, "%s  %s %s\n", name, r.paint(ansiDim, saved[name].ID), saved[name].Workspace)
+		}
+	default:
+		fmt.Fprintln(r.out, "Unknown command. /help for commands.")
+	}
+}
+
+func (r *chatREPL) resume(name string) {
+	if s, ok := loadSavedSessions()[name]; ok {
+		r.sessionID, r.workspace = s.ID, s.Workspace
+	} else {
+		r.sessionID = name
+	}
+	r.docs = nil
+	fmt.Fprintf(r.out, "Resuming session %s.\n", r.sessionID)
+}
+
+// ask streams one answer, then lists its citations.
+func (r *chatREPL) ask(question string) error {
+	req := api.ChatRequest{Question: question, Workspace: r.workspace, SessionID: r.sessionID}
+	if r.sessionID == "" {
+		req.DocumentIDs = r.docs
+	}
+	done, err := r.client.ChatStream(context.Background(), req, func(ev api.StreamEvent) error {
+		switch {
+		case ev.Start != nil:
+			r.sessionID = ev.Start.SessionID
+			if ev.Start.AnswerSource != "" && ev.Start.AnswerSource != "documents" {
+				fmt.Fprintln(r.out, r.paint(ansiDim, "("+ev.Start.AnswerSource+")"))
+			}
+		case ev.Token != nil:
+			fmt.Fprint(r.out, ev.Token.Text)
+		}
+		return nil
+	})
+	fmt.Fprintln(r.out)
+	if err != nil {
+		return err
+	}
+	r.printCitations(done.Sources)
+	return nil
+}
+
+func (r *chatREPL) printCitations(sources []api.Source) {
+	if len(sources) == 0 {
+		return
+	}
+	fmt.Fprintln(r.out)
+	for i, s := range sources {
+		label := s.Title
+		switch {
+		case s.URL != "":
+			label = strings.TrimSpace(s.Title + " " + s.URL)
+		case s.DocumentID != "":
+			label = fmt.Sprintf("%s, chunk %d", cmp.Or(r.filenames[s.DocumentID], s.DocumentID), s.ChunkIndex)
+		}
+		score := ""
+		if s.Score != 0 {
+			score = fmt.Sprintf(" %.2f", s.Score)
+		}
+		fmt.Fprintf(r.out, "%s %s%s\n", r.paint(ansiCyan, fmt.Sprintf("[%d]", i+1)), r.paint(ansiCyan, label), r.paint(ansiDim, score+" "+s.Source))
+	}
+}
+
+// loadFilenames fetches the workspace's document list for citations.
+func (r *chatREPL) loadFilenames() {
+	docs, err := r.client.Documents(context.Background(), r.workspace)
+	if err != nil {
+		return
+	}
+	for _, d := range docs {
+		r.filenames[d.ID] = d.Filename
+	}
+}
+
+func (r *chatREPL) docNames(ids []string) []string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = cmp.Or(r.filenames[id], id)
+	}
+	return names
+}
+
+func (r *chatREPL) paint(code, s string) string {
+	if !r.color {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// ansiSupported reports whether stdout is a terminal that wants colour.
+func ansiSupported() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// savedSession is a session remembered by name.
+type savedSession struct {
+	ID        string `json:"id"`
+	Workspace string `json:"workspace"`
+	Server    string `json:"server"`
+}
+
+func savedSessionsPath() string {
+	if p := os.Getenv("DOCUCHAT_SESSIONS"); p != "" {
+		return p
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docuchat", "sessions.json")
+}
+
+func loadSavedSessions() map[string]savedSession {
+	saved := map[string]savedSession{}
+	if data, err := os.ReadFile(savedSessionsPath()); err == nil {
+		json.Unmarshal(data, &saved)
+	}
+	return saved
+}
+
+func storeSavedSessions(saved map[string]savedSession) error {
+	path := savedSessionsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, _ := json.MarshalIndent(saved, "", "  ")
+	return os.WriteFile(path, data, 0o600)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+)
+
+// chatRecord is one answered question, kept in the "chats" bucket. It is a
+// message of its session.
+type chatRecord struct {
+	ID        string `json:"id"`
+	SessionID string `json:"session_id"`
+	Workspace string `json:"workspace"`
+	Owner     string `json:"owner,omitempty"`
+	Question  string `json:"question"`
+	Answer    string `json:"answer"`
+	Language  string `json:"language,omitempty"`
+	Stopped   bool   `json:"stopped,omitempty"` // generation was cancelled part way
+	Model     string `json:"model,omitempty"`
+	// Seed and Temperature are the sampling asked for, nil for the
+	// provider's defaults; SystemFingerprint is the backend that answered.
+	Seed              *int             `json:"seed,omitempty"`
+	Temperature       *float32         `json:"temperature,omitempty"`
+	SystemFingerprint string           `json:"system_fingerprint,omitempty"`
+	Sources           []retrievedChunk `json:"sources,omitempty"` // chunks put into the context
+	// AnswerSource is "web" when the documents had nothing and the answer
+	// came from the web search fallback.
+	AnswerSource string `json:"answer_source,omitempty"`
+	Format       string `json:"format,omitempty"` // how the answer was returned; Answer itself stays Markdown
+	Length       string `json:"length,omitempty"` // brief or detailed, when asked for
+	ReadingLevel string `json:"reading_level,omitempty"`
+	Audio        bool   `json:"audio,omitempty"`       // the answer was also offered as speech
+	Transcribed  bool   `json:"transcribed,omitempty"` // the question was spoken
+	// Flags are the feature flags checked for this turn and whether each was on.
+	Flags map[string]bool `json:"flags,omitempty"`
+	// Prompts are the library templates used for this turn, by version.
+	Prompts promptVersions `json:"prompt_versions,omitempty"`
+	// Degraded lists the optional stages skipped to stay in the latency budget.
+	Degraded []string `json:"degraded,omitempty"`
+	// Attachment is the file the question brought, added to the session.
+	Attachment *chatAttachment `json:"attachment,omitempty"`
+	// Prompt is the final user message sent for a plain chat reply, sealed
+	// at rest, so the turn can be regenerated. Empty for agent, tool and
+	// structured answers.
+	Prompt    string          `json:"prompt,omitempty"`
+	Variants  []answerVariant `json:"variants,omitempty"`
+	Selected  int             `json:"selected,omitempty"`
+	Feedback  *chatFeedback   `json:"feedback,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	shadow    *shadowInput    // set when the turn is sampled for shadow mode
+	contexts  []string        // the text of each source, as put into the prompt
+	highlight bool            // attributions come with highlighted passages; the opt-in highlights flag
+	timing    *latencyBudget  // set when debug.timings were asked for
+}
+
+// finishChat saves a new turn and adds it to its session.
+func finishChat(s session, rec chatRecord) {
+	saveChat(rec)
+	if rec.shadow != nil && !rec.Stopped {
+		startShadow(rec)
+	}
+	if err := addToSession(s, rec.ID); err != nil {
+		log.Printf("❌ Metadata Store Error: %v", err)
+	}
+	if len(s.Messages) == 0 {
+		go titleSession(s.ID, rec)
+	} else {
+		go summarizeSession(s.ID)
+	}
+}
+
+func saveChat(rec chatRecord) {
+	if rec.CreatedAt.IsZero() {
+		rec.CreatedAt = time.Now()
+	}
+	if err := metaStore.Put("chats", rec.ID, rec); err != nil {
+		log.Printf("❌ Metadata Store Error: %v", err)
+	}
+}
+
+// activeStreams maps the ID of each chat still generating to its
+// activeStream.
+var activeStreams sync.Map
+
+// activeStream is a chat still generating: who asked, and the function that
+// stops it.
+type activeStream struct {
+	owner  string
+	cancel context.CancelFunc
+}
+
+// streamChat sends the answer as server-sent events while it is generated:
+// "start" with the chat_id, "citations" with the sources retrieved, a
+// "token" per delta, then "done" with the full answer. As each sentence
+// ends, an "attribution" maps it to the sources supporting it, if any.
+// POST /chat/:id/cancel or a client disconnect stops generation; the
+// partial answer is kept and marked stopped.
+func streamChat(c *gin.Context, req openai.ChatCompletionRequest, s session, rec chatRecord) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	activeStreams.Store(rec.ID, activeStream{owner: rec.Owner, cancel: cancel})
+	defer activeStreams.Delete(rec.ID)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	send := func(event string, data any) {
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+	}
+	send("start", startEvent(rec))
+	send("citations", gin.H{"sources": citedSources(rec)})
+
+	var answer strings.Builder
+	attr := newAttributor(rec.contexts)
+	sendText := func(text string) {
+		send("token", gin.H{"text": text})
+		for _, at := range attr.Add(text) {
+			send("attribution", at)
+		}
+	}
+	filter := newAnswerFilter(rec.Workspace)
+	if label := labelAnswer(rec, ""); label != "" {
+		answer.WriteString(label)
+		if text := filter.Add(label); text != "" {
+			sendText(text)
+		}
+	}
+	generating := time.Now()
+	stream, err := aiClient.CreateChatCompletionStream(ctx, req)
+	if err == nil {
+		defer stream.Close()
+		for {
+			var resp openai.ChatCompletionStreamResponse
+			resp, err = stream.Recv()
+			if err != nil {
+				break
+			}
+			rec.SystemFingerprint = cmp.Or(resp.SystemFingerprint, rec.SystemFingerprint)
+			if len(resp.Choices) > 0 && resp.Choices[0].Delta.Content != "" {
+				answer.WriteString(resp.Choices[0].Delta.Content)
+				if text := filter.Add(resp.Choices[0].Delta.Content); text != "" {
+					sendText(text)
+				}
+			}
+		}
+	}
+	if text := filter.Flush(); text != "" {
+		sendText(text)
+	}
+	if rec.timing != nil {
+		rec.timing.took[stageGenerate] += time.Since(generating)
+	}
+	for _, at := range attr.Flush() {
+		send("attribution", at)
+	}
+
+	rec.Answer = filterAnswer(rec.Workspace, answer.String())
+	rec.Stopped = ctx.Err() != nil
+	if err != nil && !errors.Is(err, io.EOF) && !rec.Stopped {
+		send("error", chatUpstreamReply(c, upstreamOpenAI, "❌ OpenAI Chat Error: "+err.Error()))
+		return
+	}
+	finishChat(s, rec)
+	if c.Request.Context().Err() == nil {
+		attributions := attr.All()
+		if rec.highlight {
+			attributions = highlightAttributions(context.Background(), rec.contexts, attributions)
+		}
+		send("done", withReplyExtras(gin.H{"chat_id": rec.ID, "answer": formatAnswer(rec.Answer, rec.Format), "format": cmp.Or(rec.Format, "markdown"), "stopped": rec.Stopped, "sources": citedSources(rec), "attributions": attributions}, rec))
+	}
+}
+
+// streamAnswer sends an answer that needed no generation, such as an FAQ
+// hit, as the events streamChat would, in a single token.
+func streamAnswer(c *gin.Context, s session, rec chatRecord) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent("start", startEvent(rec))
+	c.SSEvent("citations", gin.H{"sources": citedSources(rec)})
+	c.SSEvent("token", gin.H{"text": rec.Answer})
+	finishChat(s, rec)
+	c.SSEvent("done", withReplyExtras(gin.H{"chat_id": rec.ID, "answer": formatAnswer(rec.Answer, rec.Format), "format": cmp.Or(rec.Format, "markdown"), "stopped": false, "sources": citedSources(rec)}, rec))
+	c.Writer.Flush()
+}
+
+// citedSources is a turn's sources for a reply, never null, so attribution
+// indexes always have a list to point into.
+func citedSources(rec chatRecord) []retrievedChunk {
+	if rec.Sources == nil {
+		return []retrievedChunk{}
+	}
+	return rec.Sources
+}
+
+// withReplyExtras adds the audio URL to a chat reply when audio=true was
+// asked for, the transcript when the question was spoken, and the stages a
+// latency budget dropped.
+func withReplyExtras(h gin.H, rec chatRecord) gin.H {
+	if rec.Audio {
+		h["audio_url"] = audioURL(rec)
+	}
+	if rec.Transcribed {
+		h["transcript"] = rec.Question
+	}
+	if len(rec.Degraded) > 0 {
+		h["degraded"] = rec.Degraded
+	}
+	if rec.Attachment != nil {
+		h["attachment"] = rec.Attachment
+	}
+	if rec.Seed != nil {
+		h["seed"] = *rec.Seed
+	}
+	if rec.SystemFingerprint != "" {
+		h["system_fingerprint"] = rec.SystemFingerprint
+	}
+	if rec.timing != nil {
+		h["debug"] = gin.H{"timings": rec.timing.timings()}
+	}
+	return h
+}
+
+func startEvent(rec chatRecord) gin.H {
+	start := gin.H{"chat_id": rec.ID, "session_id": rec.SessionID, "language": rec.Language, "answer_source": cmp.Or(rec.AnswerSource, "documents")}
+	if rec.Transcribed {
+		start["transcript"] = rec.Question
+	}
+	if rec.Attachment != nil {
+		start["attachment"] = rec.Attachment
+	}
+	return start
+}
+
+// handleCancelChat stops a streaming chat: POST /chat/:id/cancel. Only the
+// user who asked can stop it.
+func handleCancelChat(c *gin.Context) {
+	v, found := activeStreams.Load(c.Param("id"))
+	stream, _ := v.(activeStream)
+	if !found || (stream.owner != "" && stream.owner != requestUser(c)) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No chat is generating with that id"))
+		return
+	}
+	stream.cancel()
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Generation stopped"})
+}
+
+func newChatRecord(c *gin.Context, s session, question, lang string) chatRecord {
+	return chatRecord{ID: uuid.New().String(), SessionID: s.ID, Workspace: s.Workspace, Owner: requestUser(c), Question: question, Language: lang, Model: chatModel, Prompts: promptVersions{}}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// envInt reads an integer setting, falling back to def when unset or invalid.
+func envInt(key string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(key)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+func envFloat(key string, def float64) float64 {
+	if f, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil && f > 0 {
+		return f
+	}
+	return def
+}
+
+// sentenceEnds lists the sentence-final punctuation per language; anything
+// not listed uses the Latin set. CJK and Devanagari sentences end without a
+// following space, which chunkBoundary accounts for.
+var sentenceEnds = map[string]string{
+	"zh": "。！？；", "ja": "。！？", "ko": ".!?。",
+	"ar": ".!?؟۔", "fa": ".!?؟", "he": ".!?",
+	"hi": "।॥!?", "th": " ",
+}
+
+// spacelessSentences are languages whose sentence punctuation is not followed by whitespace.
+var spacelessSentences = map[string]bool{"zh": true, "ja": true, "hi": true, "th": true}
+
+// textChunk is a piece of a document ready to embed. Page is the page the
+// chunk starts on (1 for sources without pages). Type is "" for body text,
+// or what else the chunk holds, such as "form_field" or "annotation".
+// Fields are the metadata of the record a chunk was cut from.
+type textChunk struct {
+	Text   string            `json:"text"`
+	Page   int               `json:"page"`
+	Type   string            `json:"type,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+	Start  float64           `json:"start,omitempty"` // seconds into the recording, for a transcript
+}
+
+// chunker incrementally splits text into pieces of roughly CHUNK_SIZE runes
+// that overlap by CHUNK_OVERLAP, preferring to cut at paragraph or sentence
+// boundaries for the document's language and never inside a code block or
+// formula that fits in a chunk. Text is fed in page by page so the
+// whole document never has to be held at once.
+type chunker struct {
+	lang          string
+	size, overlap int
+	buf           []rune
+	marks         []pageMark // where each page starts in buf, ascending
+	spans         []span     // protectedSpans of buf, found once per page
+}
+
+type pageMark struct{ offset, page int }
+
+// languageSample is how much text the chunker buffers to detect the language
+// when none is given.
+const languageSample = 4000
+
+// newChunker returns a chunker for lang, or one that detects the language
+// from the first few pages when lang is "".
+func newChunker(lang string) *chunker {
+	size := envInt("CHUNK_SIZE", 1000)
+	return &chunker{lang: lang, size: size, overlap: min(envInt("CHUNK_OVERLAP", 200), size/2)}
+}
+
+// Lang is the chunker's language, once known.
+func (c *chunker) Lang() string { return c.lang }
+
+// Add appends a page of text and returns any chunks that are now complete.
+func (c *chunker) Add(page int, text string) []textChunk {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if len(c.buf) > 0 {
+		c.buf = append(c.buf, '\n', '\n')
+	}
+	c.marks = append(c.marks, pageMark{offset: len(c.buf), page: page})
+	c.buf = append(c.buf, []rune(text)...)
+	c.spans = protectedSpans(c.buf)
+
+	if c.lang == "" {
+		if len(c.buf) < languageSample {
+			return nil
+		}
+		c.lang = detectLanguage(string(c.buf))
+	}
+	var out []textChunk
+	for len(c.buf) > c.size {
+		out = c.cut(out)
+	}
+	return out
+}
+
+// Flush returns the remaining buffered text as final chunks.
+func (c *chunker) Flush() []textChunk {
+	if c.lang == "" {
+		c.lang = detectLanguage(string(c.buf))
+	}
+	var out []textChunk
+	for len(c.buf) > c.size {
+		out = c.cut(out)
+	}
+	if piece := strings.TrimSpace(string(c.buf)); piece != "" {
+		out = append(out, textChunk{Text: piece, Page: c.pageAt(0)})
+	}
+	c.buf, c.marks, c.spans = nil, nil, nil
+	return out
+}
+
+// cut removes one chunk from the front of a buffer longer than size, keeping
+// the overlap for the next chunk.
+func (c *chunker) cut(out []textChunk) []textChunk {
+	spans := c.spans
+	end := chunkBoundary(c.buf, c.size/2, c.size, c.lang, spans)
+	if piece := strings.TrimSpace(string(c.buf[:end])); piece != "" {
+		out = append(out, textChunk{Text: piece, Page: c.pageAt(0)})
+	}
+
+	// Step back for the overlap, but start on a word boundary (any
+	// character will do in scripts written without spaces).
+	next := max(end-c.overlap, 1)
+	for next < end && !spacelessSentences[c.lang] && !unicode.IsSpace(c.buf[next-1]) {
+		next++
+	}
+	// and not part way into a block this chunk already holds whole
+	if s, ok := spanAt(spans, next); ok && s.end <= end {
+		next = s.end
+	}
+	current := c.pageAt(next)
+	c.buf = c.buf[next:]
+	marks := []pageMark{{offset: 0, page: current}}
+	for _, m := range c.marks {
+		if m.offset > next {
+			marks = append(marks, pageMark{offset: m.offset - next, page: m.page})
+		}
+	}
+	c.marks = marks
+	// the spans move along with the text rather than being searched for again
+	var kept []span
+	for _, s := range spans {
+		if s.end > next {
+			kept = append(kept, span{max(s.start-next, 0), s.end - next})
+		}
+	}
+	c.spans = kept
+	return out
+}
+
+// pageAt is the page containing buffer offset i.
+func (c *chunker) pageAt(i int) int {
+	page := 1
+	for _, m := range c.marks {
+		if m.offset > i {
+			break
+		}
+		page = m.page
+	}
+	return page
+}
+
+// chunkText splits a complete text in one go.
+func chunkText(text, lang string) []textChunk {
+	c := newChunker(lang)
+	return append(c.Add(1, text), c.Flush()...)
+}
+
+// chunkBoundary finds the best place to cut within r[lo:hi]: the last
+// paragraph break, else the last sentence end, else the last space, skipping
+// places inside protected spans. When a protected block fills the window it
+// is kept whole by cutting before it, or after it if it's small enough to
+// let the chunk run up to twice hi; only a block bigger than that is split,
+// at a line break.
+func chunkBoundary(r []rune, lo, hi int, lang string, spans []span) int {
+	ends, ok := sentenceEnds[lang]
+	if !ok {
+		ends = ".!?"
+	}
+	spaceless := spacelessSentences[lang]
+	sentence, space := -1, -1
+	for i := hi - 1; i > lo; i-- {
+		if s, ok := spanAt(spans, i); ok {
+			i = s.start + 1 // the loop steps on to s.start, a fine place to cut
+			continue
+		}
+		switch {
+		case r[i] == '\n' && r[i-1] == '\n':
+			return i + 1
+		case sentence < 0 && spaceless && strings.ContainsRune(ends, r[i-1]):
+			sentence = i
+		case sentence < 0 && unicode.IsSpace(r[i]) && strings.ContainsRune(ends, r[i-1]):
+			sentence = i + 1
+		case space < 0 && unicode.IsSpace(r[i]):
+			space = i + 1
+		}
+	}
+	if sentence > 0 {
+		return sentence
+	}
+	if space > 0 {
+		return space
+	}
+	if s, ok := spanAt(spans, hi); ok {
+		switch {
+		case s.start > lo/2:
+			return s.start
+		case s.end <= 2*hi:
+			return s.end
+		}
+		for i := hi - 1; i > s.start; i-- {
+			if r[i-1] == '\n' {
+				return i
+			}
+		}
+	}
+	return hi
+}
+
+// embedTexts embeds many texts with the serving model, batching requests to
+// stay within API limits.
+func embedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	return embedTextsWith(ctx, currentEmbedding(), texts)
+}
+
+func embedTextsWith(ctx context.Context, model embeddingConfig, texts []string) ([][]float32, error) {
+	const batch = 100
+	vectors := make([][]float32, 0, len(texts))
+	for i := 0; i < len(texts); i += batch {
+		resp, err := aiClient.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+			Input:      texts[i:min(i+batch, len(texts))],
+			Model:      openai.EmbeddingModel(model.Model),
+			Dimensions: model.Dimensions,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range resp.Data {
+			vectors = append(vectors, d.Embedding)
+		}
+	}
+	return vectors, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// exportedChunk is a chunk as stored in Qdrant, for auditing what was indexed.
+type exportedChunk struct {
+	ID         string         `json:"id"`
+	ChunkIndex int64          `json:"chunk_index"`
+	Page       int64          `json:"page"`
+	Text       string         `json:"text"`
+	Metadata   map[string]any `json:"metadata"`
+	Vector     []float32      `json:"vector,omitempty"`
+}
+
+// payloadValue converts a payload value to plain Go for JSON output.
+func payloadValue(v *pb.Value) any {
+	switch k := v.GetKind().(type) {
+	case *pb.Value_StringValue:
+		return openText(k.StringValue)
+	case *pb.Value_IntegerValue:
+		return k.IntegerValue
+	case *pb.Value_DoubleValue:
+		return k.DoubleValue
+	case *pb.Value_BoolValue:
+		return k.BoolValue
+	case *pb.Value_ListValue:
+		out := make([]any, len(k.ListValue.GetValues()))
+		for i, item := range k.ListValue.GetValues() {
+			out[i] = payloadValue(item)
+		}
+		return out
+	case *pb.Value_StructValue:
+		out := make(map[string]any, len(k.StructValue.GetFields()))
+		for key, item := range k.StructValue.GetFields() {
+			out[key] = payloadValue(item)
+		}
+		return out
+	}
+	return nil
+}
+
+func exportChunk(p *pb.RetrievedPoint, withVectors bool) exportedChunk {
+	ch := exportedChunk{
+		ID:         p.GetId().GetUuid(),
+		ChunkIndex: p.Payload["chunk_index"].GetIntegerValue(),
+		Page:       p.Payload["page"].GetIntegerValue(),
+		Text:       payloadString(p.Payload, "text"),
+		Metadata:   map[string]any{},
+	}
+	for key, v := range p.Payload {
+		if key != "text" {
+			ch.Metadata[key] = payloadValue(v)
+		}
+	}
+	if withVectors {
+		v := p.GetVectors().GetVector()
+		if ch.Vector = v.GetDense().GetData(); ch.Vector == nil {
+			ch.Vector = v.GetData() // servers before 1.13
+		}
+	}
+	return ch
+}
+
+// handleDocumentChunks pages through a document's chunks:
+// GET /documents/:id/chunks?limit=&offset=&with_vectors=true. Pass the
+// returned next_offset as offset to get the next page; it is empty on the
+// last one.
+func handleDocumentChunks(c *gin.Context) {
+	documentID := c.Param("id")
+	var doc documentRecord
+	if found, err := metaStore.Get("documents", documentID, &doc); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	} else if !found || (doc.Owner != "" && doc.Owner != requestUser(c)) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document not found"))
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	limit32 := uint32(min(limit, 1000))
+	withVectors := c.Query("with_vectors") == "true"
+	req := &pb.ScrollPoints{
+		CollectionName: collectionName,
+		Filter:         documentFilter(documentID),
+		Limit:          &limit32,
+		WithPayload:    pb.NewWithPayload(true),
+		WithVectors:    pb.NewWithVectors(withVectors),
+	}
+	if offset := c.Query("offset"); offset != "" {
+		req.Offset = pb.NewIDUUID(offset)
+	}
+	res, err := qdrantClient.Scroll(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+
+	chunks := make([]exportedChunk, 0, len(res.GetResult()))
+	for _, p := range res.GetResult() {
+		chunks = append(chunks, exportChunk(p, withVectors))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"document_id": documentID,
+		"chunks":      chunks,
+		"next_offset": res.GetNextPageOffset().GetUuid(),
+	})
+}
+
+// handlePatchChunk edits one chunk: PATCH /chunks/:id {"text", "disabled"}.
+// New text is re-embedded and re-indexed for exact matches and the glossary;
+// a disabled chunk stays stored but is never retrieved. Re-chunking the
+// document from its stored text discards edits. Curating the index is an
+// admin task, so it takes the ADMIN_TOKEN.
+func handlePatchChunk(c *gin.Context) {
+	var body struct {
+		Text     *string `json:"text"`
+		Disabled *bool   `json:"disabled"`
+	}
+	if err := c.BindJSON(&body); err != nil || (body.Text == nil && body.Disabled == nil) {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Provide text or disabled"))
+		return
+	}
+	if body.Text != nil && strings.TrimSpace(*body.Text) == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Text can't be empty; disable the chunk instead"))
+		return
+	}
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	res, err := qdrantClient.Get(ctx, &pb.GetPoints{
+		CollectionName: collectionName,
+		Ids:            []*pb.PointId{pb.NewIDUUID(id)},
+		WithPayload:    pb.NewWithPayload(true),
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	if len(res.GetResult()) == 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Chunk not found"))
+		return
+	}
+	point := res.GetResult()[0]
+	documentID := payloadString(point.Payload, "document_id")
+
+	if body.Disabled != nil {
+		point.Payload["disabled"] = pb.NewValueBool(*body.Disabled)
+	}
+	if body.Text == nil {
+		_, err = qdrantClient.SetPayload(ctx, &pb.SetPayloadPoints{
+			CollectionName: collectionName,
+			Payload:        map[string]*pb.Value{"disabled": point.Payload["disabled"]},
+			PointsSelector: pb.NewPointsSelector(point.Id),
+		})
+	} else {
+		var vector []float32
+		if vector, err = embedText(ctx, *body.Text); err != nil {
+			c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, "OpenAI Embedding Error: "+err.Error()))
+			return
+		}
+		point.Payload["text"] = pb.NewValueString(sealText(*body.Text))
+		point.Payload["edited"] = pb.NewValueBool(true)
+		edited := &pb.PointStruct{Id: point.Id, Vectors: pb.NewVectorsDense(vector), Payload: point.Payload}
+		if _, err = qdrantClient.Upsert(ctx, &pb.UpsertPoints{CollectionName: collectionName, Points: []*pb.PointStruct{edited}}); err == nil {
+			if err := forgetTermChunks(map[string]bool{id: true}); err != nil {
+				log.Printf("❌ Term Index Error: %v", err)
+			}
+			indexBatch(ctx, ingestDoc{DocumentID: documentID, Workspace: payloadString(point.Payload, "workspace")}, []*pb.PointStruct{edited}, &ingestResult{})
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+
+	audit(auditEntry{Action: "chunk_edit", Workspace: payloadString(point.Payload, "workspace"), DocumentID: documentID, Detail: chunkEditDetail(id, body.Text != nil, body.Disabled)})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "chunk": exportChunk(point, false)})
+}
+
+func chunkEditDetail(id string, textChanged bool, disabled *bool) string {
+	var parts []string
+	if textChanged {
+		parts = append(parts, "text edited")
+	}
+	if disabled != nil {
+		parts = append(parts, "disabled="+strconv.FormatBool(*disabled))
+	}
+	return id + ": " + strings.Join(parts, ", ")
+}
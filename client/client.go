@@ -0,0 +1,457 @@
+// Package client is a typed Go client for the docuchat HTTP API.
+//
+//	c := client.New("http://localhost:8080", client.WithUser("alice"))
+//	doc, err := c.Ingest(ctx, client.IngestRequest{Filename: "handbook.pdf", File: f})
+//	reply, err := c.Chat(ctx, api.ChatRequest{Question: "How many leave days?", DocumentIDs: []string{doc.DocumentID}})
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gebt2000/go-docuchat/api"
+)
+
+// Client calls a docuchat server. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	user    string
+	apiKey  string
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sends requests through hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option { return func(c *Client) { c.http = hc } }
+
+// WithUser sends requests on behalf of a user, as X-User-ID.
+func WithUser(id string) Option { return func(c *Client) { c.user = id } }
+
+// WithAPIKey sends key as a bearer token, as the admin endpoints require.
+func WithAPIKey(key string) Option { return func(c *Client) { c.apiKey = key } }
+
+// New returns a client for the server at baseURL.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), http: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is a failure the server reported: status "error" from a document
+// endpoint, or a ❌ answer from a chat.
+type Error struct {
+	Message   string
+	Code      string // machine-readable reason, when the server gives one
+	Retryable bool   // the same request may succeed later
+	Upstream  string // the dependency that failed, for upstream_error
+	RequestID string // the server's ID for the request, to find it in its logs
+}
+
+// apiError converts the error object of a reply, falling back to message
+// for servers that don't send one.
+func apiError(e *api.Error, message string) *Error {
+	if e == nil {
+		return &Error{Message: message}
+	}
+	return &Error{Message: cmp.Or(e.Message, message), Code: e.Code, Retryable: e.Retryable, Upstream: e.Upstream, RequestID: e.RequestID}
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("docuchat: %s (%s)", e.Message, e.Code)
+	}
+	return "docuchat: " + e.Message
+}
+
+func (c *Client) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.user != "" {
+		req.Header.Set("X-User-ID", c.user)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		resp.Body.Close()
+		return nil, &Error{Message: resp.Status, RequestID: resp.Header.Get("X-Request-ID")}
+	}
+	return resp, nil
+}
+
+// getJSON decodes a reply with a status field into out, returning an
+// *Error for status "error".
+func (c *Client) getJSON(ctx context.Context, method, path, contentType string, body io.Reader, out any) error {
+	resp, err := c.do(ctx, method, path, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		Status  string     `json:"status"`
+		Message string     `json:"message"`
+		Code    string     `json:"code"`
+		Error   *api.Error `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return err
+	}
+	if status.Status == "error" {
+		e := apiError(status.Error, status.Message)
+		e.Code = cmp.Or(e.Code, status.Code)
+		return e
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// IngestRequest is a file to ingest.
+type IngestRequest struct {
+	Filename   string
+	File       io.Reader
+	Workspace  string             // "default" when empty
+	Tags       []string           // labels to filter and run extraction templates by
+	Source     string             // where the document came from
+	Supersedes string             // ID of the document this is a new version of
+	Graph      *bool              // extract entities and relations; the server's default when nil
+	Password   string             // for an encrypted PDF
+	Mapping    *api.RecordMapping // for JSON and JSONL records; the workspace's default when nil
+}
+
+// Ingest uploads and ingests a file. When the server queues ingests the
+// reply has a JobID to follow with Job.
+func (c *Client) Ingest(ctx context.Context, in IngestRequest) (*api.IngestResponse, error) {
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		fields := map[string]string{
+			"workspace":  in.Workspace,
+			"tags":       strings.Join(in.Tags, ","),
+			"source":     in.Source,
+			"supersedes": in.Supersedes,
+			"password":   in.Password,
+		}
+		if in.Graph != nil {
+			fields["graph"] = fmt.Sprint(*in.Graph)
+		}
+		if in.Mapping != nil {
+			raw, _ := json.Marshal(in.Mapping)
+			fields["mapping"] = string(raw)
+		}
+		for name, value := range fields {
+			if value != "" {
+				form.WriteField(name, value)
+			}
+		}
+		part, err := form.CreateFormFile("file", cmp.Or(in.Filename, "document.pdf"))
+		if err == nil {
+			_, err = io.Copy(part, in.File)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	var out api.IngestResponse
+	if err := c.getJSON(ctx, http.MethodPost, "/ingest", form.FormDataContentType(), pr, &out); err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	return &out, nil
+}
+
+// IngestText ingests text directly, such as a ticket or a CRM note.
+func (c *Client) IngestText(ctx context.Context, req api.IngestTextRequest) (*api.IngestResponse, error) {
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var out api.IngestResponse
+	if err := c.getJSON(ctx, http.MethodPost, "/ingest/text", "application/json", bytes.NewReader(raw), &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// IngestPaper fetches and ingests a paper by its arXiv ID or DOI. The
+// reply's Paper has what the server found out about it.
+func (c *Client) IngestPaper(ctx context.Context, req api.IngestPaperRequest) (*api.IngestResponse, error) {
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var out api.IngestResponse
+	if err := c.getJSON(ctx, http.MethodPost, "/ingest/paper", "application/json", bytes.NewReader(raw), &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// IngestVideo ingests a YouTube or Vimeo video's transcript. Its chunks
+// are cited by links to the moment they start.
+func (c *Client) IngestVideo(ctx context.Context, req api.IngestVideoRequest) (*api.IngestResponse, error) {
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var out api.IngestResponse
+	if err := c.getJSON(ctx, http.MethodPost, "/ingest/video", "application/json", bytes.NewReader(raw), &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Job reports a queued ingest.
+func (c *Client) Job(ctx context.Context, id string) (*api.Job, error) {
+	var out struct {
+		Job api.Job `json:"job"`
+	}
+	if err := c.getJSON(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), "", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.Job, nil
+}
+
+// Documents lists the caller's documents, those of one workspace when it
+// isn't empty.
+func (c *Client) Documents(ctx context.Context, workspace string) ([]api.Document, error) {
+	path := "/documents"
+	if workspace != "" {
+		path += "?workspace=" + url.QueryEscape(workspace)
+	}
+	var out struct {
+		Documents []api.Document `json:"documents"`
+	}
+	if err := c.getJSON(ctx, http.MethodGet, path, "", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Documents, nil
+}
+
+// Chat asks a question and waits for the whole answer. req.Stream is
+// ignored; use ChatStream to stream.
+func (c *Client) Chat(ctx context.Context, req api.ChatRequest) (*api.ChatResponse, error) {
+	req.Stream = false
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/chat", "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out api.ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if isError(out.Answer) {
+		return nil, chatError(out.Answer, out.Error)
+	}
+	return &out, nil
+}
+
+// ChatWithFile asks a question about a file sent along with it, which is
+// added to the session as req.Attachment says: persistent (the default) or
+// ephemeral. The reply's Attachment reports what became of it, and its
+// SessionID continues the conversation with the file in scope.
+func (c *Client) ChatWithFile(ctx context.Context, req api.ChatRequest, filename string, file io.Reader) (*api.ChatResponse, error) {
+	req.Stream = false
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		err := form.WriteField("request", string(raw))
+		var part io.Writer
+		if err == nil {
+			part, err = form.CreateFormFile("file", cmp.Or(filename, "document.pdf"))
+		}
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	resp, err := c.do(ctx, http.MethodPost, "/chat", form.FormDataContentType(), pr)
+	if err != nil {
+		pr.CloseWithError(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out api.ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if isError(out.Answer) {
+		return nil, chatError(out.Answer, out.Error)
+	}
+	return &out, nil
+}
+
+// ChatStream asks a question and calls fn for each event as the answer is
+// generated: a start, the citations, tokens with an attribution after each
+// sentence sources support, then done. It returns the done event. An
+// error event, or an error from fn, ends the stream with that error.
+func (c *Client) ChatStream(ctx context.Context, req api.ChatRequest, fn func(api.StreamEvent) error) (*api.DoneEvent, error) {
+	req.Stream = true
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/chat", "application/json", bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		// answered before streaming began: an error, or a reply that isn't streamed
+		var out struct {
+			api.ChatResponse
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return nil, err
+		}
+		if out.Answer == "" || isError(out.Answer) {
+			return nil, chatError(cmp.Or(out.Answer, out.Message, resp.Status), out.Error)
+		}
+		done := &api.DoneEvent{ChatResponse: out.ChatResponse}
+		return done, fn(api.StreamEvent{Type: "done", Done: done})
+	}
+
+	var done *api.DoneEvent
+	err = readSSE(resp.Body, func(event string, data []byte) error {
+		ev := api.StreamEvent{Type: event}
+		switch event {
+		case "start":
+			ev.Start = &api.StartEvent{}
+			if err := json.Unmarshal(data, ev.Start); err != nil {
+				return err
+			}
+		case "citations":
+			ev.Citations = &api.CitationsEvent{}
+			if err := json.Unmarshal(data, ev.Citations); err != nil {
+				return err
+			}
+		case "token":
+			ev.Token = &api.TokenEvent{}
+			if err := json.Unmarshal(data, ev.Token); err != nil {
+				return err
+			}
+		case "attribution":
+			ev.Attribution = &api.Attribution{}
+			if err := json.Unmarshal(data, ev.Attribution); err != nil {
+				return err
+			}
+		case "done":
+			done = &api.DoneEvent{}
+			if err := json.Unmarshal(data, done); err != nil {
+				return err
+			}
+			ev.Done = done
+		case "error":
+			var e struct {
+				Answer string     `json:"answer"`
+				Error  *api.Error `json:"error"`
+			}
+			json.Unmarshal(data, &e)
+			ev.Error, ev.Failure = e.Answer, e.Error
+			if err := fn(ev); err != nil {
+				return err
+			}
+			return chatError(e.Answer, e.Error)
+		default:
+			return nil // events this client doesn't know yet
+		}
+		return fn(ev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if done == nil {
+		return nil, errors.New("docuchat: stream ended before the answer was done")
+	}
+	return done, nil
+}
+
+// Retrieve returns the chunks that best match a query, without answering.
+func (c *Client) Retrieve(ctx context.Context, req api.RetrieveRequest) ([]api.RetrievedDocument, error) {
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var out api.RetrieveResponse
+	if err := c.getJSON(ctx, http.MethodPost, "/v1/retrieve", "application/json", bytes.NewReader(raw), &out); err != nil {
+		return nil, err
+	}
+	return out.Documents, nil
+}
+
+func isError(answer string) bool {
+	return strings.HasPrefix(answer, "❌") || strings.HasPrefix(answer, "🔥")
+}
+
+func chatError(answer string, e *api.Error) *Error {
+	msg := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(answer, "❌"), "🔥"))
+	return apiError(e, strings.TrimPrefix(msg, "Error: "))
+}
+
+// readSSE calls fn with the type and data of each server-sent event in r.
+func readSSE(r io.Reader, fn func(event string, data []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	var event string
+	var data bytes.Buffer
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case line == "":
+			if event != "" || data.Len() > 0 {
+				if err := fn(event, data.Bytes()); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// protectedRe matches text the chunker must not cut through: fenced code
+// blocks (an unclosed fence runs to the end of the text), display math in
+// $$…$$, \[…\] or a LaTeX environment, and short inline $…$ math.
+var protectedRe = regexp.MustCompile("(?s)(?:^|\\n)[ \\t]*(```|~~~)[^\\n]*\\n.*?(?:\\n[ \\t]*```|\\n[ \\t]*~~~|$)|\\$\\$.+?\\$\\$|\\\\\\[.+?\\\\\\]|\\\\begin\\{([a-zA-Z*]+)\\}.*?\\\\end\\{[a-zA-Z*]+\\}|\\$[^$\\n]{1,200}\\$")
+
+// span is a [start, end) range of rune offsets.
+type span struct{ start, end int }
+
+// protectedSpans returns the protected ranges of r, in rune offsets.
+func protectedSpans(r []rune) []span {
+	s := string(r)
+	if !strings.ContainsAny(s, "`~$\\") {
+		return nil
+	}
+	var spans []span
+	// byte offsets come back in order, so one pass converts them to runes
+	runeAt, byteAt := 0, 0
+	toRune := func(b int) int {
+		runeAt += utf8.RuneCountInString(s[byteAt:b])
+		byteAt = b
+		return runeAt
+	}
+	for _, m := range protectedRe.FindAllStringIndex(s, -1) {
+		start := m[0]
+		if s[start] == '\n' {
+			start++
+		}
+		spans = append(spans, span{toRune(start), toRune(m[1])})
+	}
+	return spans
+}
+
+// spanAt returns the protected span that a cut before r[i] would split.
+func spanAt(spans []span, i int) (span, bool) {
+	k := sort.Search(len(spans), func(k int) bool { return spans[k].end > i })
+	if k < len(spans) && spans[k].start < i {
+		return spans[k], true
+	}
+	return span{}, false
+}
+
+// codeInstruction asks the model to keep code and math copy-pastable.
+const codeInstruction = "\n\nWhen the answer includes code, commands, configuration or formulas from the context, reproduce them exactly: code in fenced code blocks with a language tag, math in LaTeX ($…$ inline, $$…$$ on its own line)."
+
+// monospaceFont reports whether a PDF font name is a fixed-width face, the
+// usual sign of code in a typeset document.
+func monospaceFont(name string) bool {
+	name = strings.ToLower(name)
+	for _, mono := range []string{"courier", "mono", "consolas", "menlo", "inconsolata", "code", "typewriter", "lucidaconsole", "cmtt"} {
+		if strings.Contains(name, mono) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
+)
+
+// Settings are read from the environment when they're used, so most take
+// effect as soon as the environment changes. The env file (ENV_FILE,
+// default .env) can be reloaded at runtime; variables set in the process
+// environment at startup always win over it.
+//
+// APP_ENV names a profile: <env file>.<profile>, such as .env.prod, is
+// layered over the base file. A profile can set EXTENDS to build on another
+// profile, which is layered in between, so one binary is promoted across
+// environments by changing APP_ENV alone.
+
+// restartSettings are read once, when connections and queues are set up; a
+// reload reports them changed but they need a restart.
+var restartSettings = []string{
+	"QDRANT_URL", "QDRANT_API_KEY", "QDRANT_CONNECTIONS", "STORE_BACKEND", "METADATA_PATH", "REDIS_URL", "JOB_QUEUE",
+	"ENCRYPTION_KEY_PROVIDER", "ENCRYPTION_KEY_FILE", "ENCRYPTION_KMS_KEY_ID", "ENCRYPTION_KMS_WRAPPED_KEY",
+	"LLM_MAX_CONCURRENT", "LLM_QUEUE_DEPTH", "LLM_QUEUE_TIMEOUT_SECONDS", "PARSER_TIMEOUT_SECONDS",
+	"CHAT_MODEL", "LOG_LEVEL", "CORS_ORIGINS",
+	"PORT", "TLS_CERT_FILE", "TLS_KEY_FILE", "TLS_AUTOCERT_DOMAINS", "TLS_CLIENT_CA_FILE",
+}
+
+var envConfig = struct {
+	sync.Mutex
+	pinned map[string]bool   // set in the process environment before the file was read
+	file   map[string]string // what the file last set
+	files  []string          // the files it was layered from, a missing base file too
+	stamp  string            // their modification times when read
+}{}
+
+func envFile() string { return cmp.Or(os.Getenv("ENV_FILE"), ".env") }
+
+// loadConfig reads the env file at startup.
+func loadConfig() {
+	envConfig.Lock()
+	defer envConfig.Unlock()
+	envConfig.pinned = map[string]bool{}
+	for _, kv := range os.Environ() {
+		if k, _, ok := strings.Cut(kv, "="); ok {
+			envConfig.pinned[k] = true
+		}
+	}
+	envConfig.file = map[string]string{}
+	applyEnvFile()
+}
+
+// applyEnvFile sets the env file's variables, unsetting any it set before
+// that it no longer has. It returns the names whose value changed. Callers
+// hold envConfig.
+func applyEnvFile() []string {
+	values, files, err := readProfile()
+	if err != nil {
+		log.Printf("❌ Config Error: %v", err)
+		return nil
+	}
+	envConfig.files = files
+	envConfig.stamp = filesStamp(files)
+	next := map[string]string{}
+	for k, v := range values {
+		if !envConfig.pinned[k] {
+			next[k] = v
+		}
+	}
+	var changed []string
+	for k := range envConfig.file {
+		if _, ok := next[k]; !ok {
+			os.Unsetenv(k)
+			changed = append(changed, k)
+		}
+	}
+	for k, v := range next {
+		if old, ok := envConfig.file[k]; !ok || old != v {
+			os.Setenv(k, v)
+			changed = append(changed, k)
+		}
+	}
+	envConfig.file = next
+	slices.Sort(changed)
+	return changed
+}
+
+// readProfile merges the base env file with the APP_ENV profile and the
+// profiles it extends, and returns the files it looked at. Missing files
+// count as empty, except a named profile's own.
+func readProfile() (map[string]string, []string, error) {
+	var files []string
+	read := func(path string, required bool) (map[string]string, error) {
+		files = append(files, path)
+		_, err := os.Stat(path)
+		if err != nil && os.IsNotExist(err) && !required {
+			return map[string]string{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return godotenv.Read(path)
+	}
+	values, err := read(envFile(), false)
+	if err != nil {
+		return nil, files, err
+	}
+	profile := values["APP_ENV"]
+	if envConfig.pinned["APP_ENV"] {
+		profile = os.Getenv("APP_ENV")
+	}
+	var layers []map[string]string
+	for seen := map[string]bool{}; profile != ""; {
+		if seen[profile] {
+			return nil, files, fmt.Errorf("profile %q extends itself", profile)
+		}
+		seen[profile] = true
+		layer, err := read(envFile()+"."+profile, true)
+		if err != nil {
+			return nil, files, err
+		}
+		layers = append(layers, layer)
+		profile = layer["EXTENDS"]
+	}
+	for _, layer := range slices.Backward(layers) {
+		maps.Copy(values, layer)
+	}
+	delete(values, "EXTENDS")
+	return values, files, nil
+}
+
+// filesStamp records each file's modification time, or that it is missing,
+// so a file that is edited, created or removed changes it.
+func filesStamp(files []string) string {
+	var b strings.Builder
+	for _, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "%s@%d;", path, info.ModTime().UnixNano())
+		} else {
+			fmt.Fprintf(&b, "%s@-;", path)
+		}
+	}
+	return b.String()
+}
+
+// reloadConfig re-reads the env file and, if anything changed, makes sure
+// the collection exists as startup does, in case the embedding settings
+// moved. It returns the settings that changed and those of them that need a
+// restart.
+func reloadConfig(ctx context.Context) (changed, restart []string) {
+	envConfig.Lock()
+	changed = applyEnvFile()
+	envConfig.Unlock()
+	for _, k := range changed {
+		if slices.Contains(restartSettings, k) {
+			restart = append(restart, k)
+		}
+	}
+	if len(changed) > 0 {
+		ensureCollection(ctx)
+		log.Printf("🔄 Config reloaded: %s", strings.Join(changed, ", "))
+	}
+	if len(restart) > 0 {
+		log.Printf("⚠️ Restart needed for: %s", strings.Join(restart, ", "))
+	}
+	return changed, restart
+}
+
+// watchConfig reloads the env file whenever it changes, checking every
+// CONFIG_WATCH_SECONDS; unset, the file is only reloaded through
+// POST /admin/reload.
+func watchConfig(ctx context.Context) {
+	if os.Getenv("CONFIG_WATCH_SECONDS") == "" {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(envInt("CONFIG_WATCH_SECONDS", 5)) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		envConfig.Lock()
+		files, stamp := envConfig.files, envConfig.stamp
+		envConfig.Unlock()
+		stale := filesStamp(files) != stamp
+		if stale {
+			reloadConfig(ctx)
+		}
+	}
+}
+
+// handleReload re-reads the env file: POST /admin/reload.
+func handleReload(c *gin.Context) {
+	changed, restart := reloadConfig(c.Request.Context())
+	audit(auditEntry{Action: "config_reload", Detail: "changed: " + strings.Join(changed, ", ")})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "changed": append([]string{}, changed...), "restart_required": append([]string{}, restart...)})
+}
+
+// apiKeyTransport sends the OPENAI_API_KEY in force at request time, so a
+// reload can rotate it.
+type apiKeyTransport struct{ base http.RoundTripper }
+
+func (t apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("OPENAI_API_KEY"))
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// connectorPlugin pulls the documents of an external system, such as a help
+// center, into a workspace. Each sync lists everything the source publishes;
+// new and changed items are ingested, and items that disappeared are
+// deleted. Deployers add their own by implementing it in a new file and
+// calling registerConnector from an init function, as with retrievers.
+type connectorPlugin interface {
+	Name() string
+	Fetch(ctx context.Context, workspace string) ([]connectorItem, error)
+}
+
+// connectorItem is one document from a connector. ID must be stable across
+// syncs; an item is re-ingested when UpdatedAt changes.
+type connectorItem struct {
+	ID        string
+	Title     string
+	URL       string // cited as the item's source
+	Text      string
+	Tags      []string
+	Metadata  map[string]string
+	Date      time.Time // when it was published, as the document's date
+	UpdatedAt time.Time
+}
+
+var connectorRegistry = map[string]connectorPlugin{}
+
+func registerConnector(c connectorPlugin) {
+	if _, dup := connectorRegistry[c.Name()]; dup {
+		panic("connector registered twice: " + c.Name())
+	}
+	connectorRegistry[c.Name()] = c
+}
+
+// connectorTarget is a connector synced into a workspace.
+type connectorTarget struct {
+	connector connectorPlugin
+	workspace string
+}
+
+func (t connectorTarget) key() string { return t.connector.Name() + "/" + t.workspace }
+
+// configuredConnectors reads CONNECTORS, comma-separated name:workspace
+// pairs ("zendesk:support"; the workspace defaults to "default"). Unknown
+// names are logged and skipped.
+func configuredConnectors() []connectorTarget {
+	var targets []connectorTarget
+	for _, entry := range strings.Split(os.Getenv("CONNECTORS"), ",") {
+		name, ws, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if name == "" {
+			continue
+		}
+		c, ok := connectorRegistry[name]
+		if !ok {
+			log.Printf("⚠️ Unknown connector %q in CONNECTORS", name)
+			continue
+		}
+		if ws == "" {
+			ws = "default"
+		}
+		targets = append(targets, connectorTarget{c, ws})
+	}
+	return targets
+}
+
+// connectorItemState is what a sync remembers about an ingested item.
+type connectorItemState struct {
+	DocumentID string    `json:"document_id"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// connectorStatus reports a connector's last sync.
+type connectorStatus struct {
+	Connector string    `json:"connector"`
+	Workspace string    `json:"workspace"`
+	SyncedAt  time.Time `json:"synced_at"`
+	Items     int       `json:"items"`
+	Added     int       `json:"added"`
+	Updated   int       `json:"updated"`
+	Removed   int       `json:"removed"`
+	Failed    int       `json:"failed"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// connectorSyncs keeps two syncs of one target from overlapping.
+var connectorSyncs sync.Map
+
+// syncConnectors syncs every configured connector; the scheduler runs it
+// every CONNECTOR_SYNC_MINUTES.
+func syncConnectors(ctx context.Context) {
+	for _, t := range configuredConnectors() {
+		st := syncConnector(ctx, t)
+		if st.Error != "" {
+			log.Printf("❌ Connector Sync Error (%s): %s", t.key(), st.Error)
+		} else if st.Added+st.Updated+st.Removed > 0 {
+			log.Printf("🔄 Synced %s: %d added, %d updated, %d removed", t.key(), st.Added, st.Updated, st.Removed)
+		}
+	}
+}
+
+// syncConnector brings a workspace up to date with its connector and
+// records how it went under connectors/<name>/<workspace>.
+func syncConnector(ctx context.Context, t connectorTarget) connectorStatus {
+	st := connectorStatus{Connector: t.connector.Name(), Workspace: t.workspace, SyncedAt: time.Now()}
+	if _, running := connectorSyncs.LoadOrStore(t.key(), true); running {
+		st.Error = "a sync is already running"
+		return st
+	}
+	defer connectorSyncs.Delete(t.key())
+	defer func() {
+		if err := metaStore.Put("connectors", t.key(), st); err != nil {
+			log.Printf("❌ Metadata Store Error: %v", err)
+		}
+	}()
+
+	items, err := t.connector.Fetch(ctx, t.workspace)
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	st.Items = len(items)
+	bucket := "connector_items:" + t.key()
+	all, err := metaStore.List(bucket)
+	if err != nil {
+		st.Error = "Metadata Store Error: " + err.Error()
+		return st
+	}
+	known := map[string]connectorItemState{}
+	for id, raw := range all {
+		var s connectorItemState
+		if json.Unmarshal(raw, &s) == nil {
+			known[id] = s
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, item := range items {
+		seen[item.ID] = true
+		prev, ok := known[item.ID]
+		if ok && prev.UpdatedAt.Equal(item.UpdatedAt) {
+			continue
+		}
+		documentID, err := ingestConnectorItem(ctx, t.workspace, t.connector.Name(), item)
+		if err != nil {
+			log.Printf("❌ Connector Ingest Error (%s %s): %v", t.key(), item.ID, err)
+			st.Failed++
+			continue
+		}
+		if err := metaStore.Put(bucket, item.ID, connectorItemState{DocumentID: documentID, UpdatedAt: item.UpdatedAt}); err != nil {
+			log.Printf("❌ Metadata Store Error: %v", err)
+		}
+		if ok {
+			removeConnectorDocument(ctx, prev.DocumentID)
+			st.Updated++
+		} else {
+			st.Added++
+		}
+	}
+	for id, prev := range known {
+		if seen[id] {
+			continue
+		}
+		removeConnectorDocument(ctx, prev.DocumentID)
+		metaStore.Delete(bucket, id)
+		st.Removed++
+	}
+	return st
+}
+
+// ingestConnectorItem ingests an item into a workspace as a text document
+// from source, titled and cited by its URL, and returns the new document's
+// ID.
+func ingestConnectorItem(ctx context.Context, workspace, source string, item connectorItem) (string, error) {
+	if strings.TrimSpace(item.Text) == "" {
+		return "", fmt.Errorf("item has no text")
+	}
+	tmp, err := os.CreateTemp("", "docuchat-connector-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(item.Text)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	job := ingestJob{
+		ID:         uuid.New().String(),
+		DocumentID: uuid.New().String(),
+		Filename:   textFilename(item.Title),
+		Path:       tmp.Name(),
+		Workspace:  workspace,
+		Graph:      graphEnabled(""),
+		Tags:       parseTags(strings.Join(item.Tags, ",")),
+		Source:     source,
+		Title:      strings.TrimSpace(item.Title),
+		URL:        item.URL,
+		Metadata:   item.Metadata,
+		Date:       item.Date,
+	}
+	res, err := executeIngest(ctx, job)
+	if err != nil {
+		return "", err
+	}
+	if res.Chunks == 0 {
+		return "", fmt.Errorf("no text found")
+	}
+	return job.DocumentID, nil
+}
+
+func removeConnectorDocument(ctx context.Context, documentID string) {
+	var doc documentRecord
+	if found, _ := metaStore.Get("documents", documentID, &doc); !found {
+		return
+	}
+	if _, err := deleteDocument(ctx, doc); err != nil {
+		log.Printf("❌ Connector Delete Error (%s): %v", documentID, err)
+	}
+}
+
+// connectorGet fetches a connector API page, giving it a minute.
+func connectorGet(ctx context.Context, url string, auth func(*http.Request)) ([]byte, error) {
+	return connectorCall(ctx, retrieverClient, http.MethodGet, url, nil, auth)
+}
+
+// pageGet fetches a page at a URL found in fetched content, such as a feed
+// entry's link, through askClient so it can't reach private addresses.
+func pageGet(ctx context.Context, url string, auth func(*http.Request)) ([]byte, error) {
+	return connectorCall(ctx, askClient, http.MethodGet, url, nil, auth)
+}
+
+// connectorPost sends a JSON body to a connector API, as for GraphQL.
+func connectorPost(ctx context.Context, url string, body any, auth func(*http.Request)) ([]byte, error) {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return connectorCall(ctx, retrieverClient, http.MethodPost, url, raw, auth)
+}
+
+func connectorCall(ctx context.Context, client *http.Client, method, url string, body []byte, auth func(*http.Request)) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if auth != nil {
+		auth(req)
+	}
+	return clientCall(client, req)
+}
+
+// handleListConnectors reports each configured connector's last sync:
+// GET /admin/connectors.
+func handleListConnectors(c *gin.Context) {
+	out := []connectorStatus{}
+	for _, t := range configuredConnectors() {
+		st := connectorStatus{Connector: t.connector.Name(), Workspace: t.workspace}
+		metaStore.Get("connectors", t.key(), &st)
+		out = append(out, st)
+	}
+	available := make([]string, 0, len(connectorRegistry))
+	for name := range connectorRegistry {
+		available = append(available, name)
+	}
+	slices.Sort(available)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "connectors": out, "available": available})
+}
+
+// handleSyncConnector syncs a configured connector now, waiting for it to
+// finish: POST /admin/connectors/:name/sync?workspace=.
+func handleSyncConnector(c *gin.Context) {
+	ws := c.DefaultQuery("workspace", "default")
+	for _, t := range configuredConnectors() {
+		if t.connector.Name() != c.Param("name") || t.workspace != ws {
+			continue
+		}
+		st := syncConnector(c.Request.Context(), t)
+		audit(auditEntry{
+			Action:    "connector_sync",
+			Workspace: ws,
+			Detail:    fmt.Sprintf("synced %s: %d added, %d updated, %d removed, %d failed", t.connector.Name(), st.Added, st.Updated, st.Removed, st.Failed),
+		})
+		if st.Error != "" {
+			resp := errorReply(c, codeUpstream, st.Error)
+			resp["sync"] = st
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success", "sync": st})
+		return
+	}
+	c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Connector is not configured for this workspace"))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// retrievalDebug is the query log entry for one chat: everything retrieval
+// considered, how it scored and what made it into the prompt.
+type retrievalDebug struct {
+	ChatID       string               `json:"chat_id"`
+	Question     string               `json:"question"`
+	Mode         string               `json:"mode,omitempty"`
+	Filter       json.RawMessage      `json:"filter,omitempty"`
+	ExactMatches []retrievedChunk     `json:"exact_matches"`
+	Candidates   []retrievalCandidate `json:"candidates"` // vector search, before reranking
+	GraphChunks  []retrievedChunk     `json:"graph_chunks,omitempty"`
+	Facts        []string             `json:"facts,omitempty"`
+	Selected     []retrievedChunk     `json:"selected"`
+	CreatedAt    time.Time            `json:"created_at"`
+}
+
+// retrievalCandidate is a vector search hit with its raw similarity and its
+// score after reranking (language boost).
+type retrievalCandidate struct {
+	retrievedChunk
+	RerankScore float32 `json:"rerank_score"`
+	Selected    bool    `json:"selected"`
+}
+
+// filterJSON renders a Qdrant filter for the log.
+func filterJSON(f *pb.Filter) json.RawMessage {
+	if f == nil {
+		return nil
+	}
+	data, err := protojson.Marshal(f)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// vectorCandidates snapshots search hits before reranking changes their
+// scores; call reranked with the final selection to fill in the rest.
+func vectorCandidates(hits []*pb.ScoredPoint) (cands []retrievalCandidate, reranked func(selected []*pb.ScoredPoint)) {
+	cands = make([]retrievalCandidate, len(hits))
+	for i, h := range hits {
+		cands[i] = retrievalCandidate{retrievedChunk: chunkRef(h.Id, h.Payload, h.Score, "vector")}
+	}
+	return cands, func(selected []*pb.ScoredPoint) {
+		for i, h := range hits {
+			cands[i].RerankScore = h.Score
+		}
+		for _, s := range selected {
+			for i := range cands {
+				if cands[i].ChunkID == s.Id.GetUuid() {
+					cands[i].Selected = true
+				}
+			}
+		}
+	}
+}
+
+func saveDebug(d retrievalDebug) {
+	d.CreatedAt = time.Now()
+	if err := metaStore.Put("chat_debug", d.ChatID, d); err != nil {
+		log.Printf("❌ Query Log Error: %v", err)
+	}
+}
+
+// handleChatDebug shows why a chat got the context it did:
+// GET /chats/:id/debug.
+func handleChatDebug(c *gin.Context) {
+	var d retrievalDebug
+	if found, _ := metaStore.Get("chat_debug", c.Param("id"), &d); !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No retrieval log for that chat"))
+		return
+	}
+	var rec chatRecord
+	metaStore.Get("chats", d.ChatID, &rec)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "debug": d, "answer": rec.Answer})
+}
+
+// explainChat is the dry-run reply for explain=true: the request that would
+// go to the model, the retrieval behind it and where its tokens go.
+func explainChat(req openai.ChatCompletionRequest, dbg retrievalDebug, history []openai.ChatCompletionMessage, texts []string, question string, tools []toolPlugin) gin.H {
+	budget := newPromptBudget(req.Model)
+	total := 0
+	for _, m := range req.Messages {
+		total += countTokens(req.Model, m.Content) + messageOverhead
+	}
+	historyTokens := 0
+	for _, m := range history {
+		historyTokens += countTokens(req.Model, m.Content) + messageOverhead
+	}
+	contextTokens := 0
+	for _, t := range texts {
+		contextTokens += countTokens(req.Model, t)
+	}
+	questionTokens := countTokens(req.Model, question)
+	toolNames := make([]string, len(tools))
+	for i, t := range tools {
+		toolNames[i] = t.Name()
+	}
+	return gin.H{
+		"status":   "success",
+		"explain":  true,
+		"model":    req.Model,
+		"messages": req.Messages,
+		"tools":    toolNames,
+		"filter":   dbg.Filter,
+		"retrieval": gin.H{
+			"exact_matches": dbg.ExactMatches,
+			"candidates":    dbg.Candidates,
+			"graph_chunks":  dbg.GraphChunks,
+			"facts":         dbg.Facts,
+			"selected":      dbg.Selected,
+		},
+		"tokens": gin.H{
+			"history":      historyTokens,
+			"context":      contextTokens,
+			"question":     questionTokens,
+			"instructions": max(total-historyTokens-contextTokens-questionTokens, 0),
+			"total":        total,
+			"budget":       budget.limit,
+		},
+	}
+}
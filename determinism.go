@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// A deterministic chat answers at temperature 0 with a fixed seed, the
+// request's or DETERMINISTIC_SEED (default 0), so the same prompt gets the
+// same answer as far as the provider allows. The seed, temperature and the
+// provider's system fingerprint are recorded with the turn: an answer can
+// be reproduced while the fingerprint stays the same, and when it changes
+// the backend did, not the inputs. Agent and tool answers, which depend on
+// what the tools return, aren't seeded.
+
+// chatSampling is the seed and temperature a chat asks for; nil leaves the
+// provider's default.
+func chatSampling(deterministic bool, seed *int) (*int, *float32) {
+	if !deterministic {
+		return seed, nil
+	}
+	if seed == nil {
+		s := envInt("DETERMINISTIC_SEED", 0)
+		seed = &s
+	}
+	zero := float32(0)
+	return seed, &zero
+}
+
+// applySampling sets a turn's seed and temperature on a request.
+func applySampling(req *openai.ChatCompletionRequest, rec chatRecord) {
+	req.Seed = rec.Seed
+	if rec.Temperature != nil {
+		req.Temperature = providerTemperature(*rec.Temperature)
+	}
+}
+
+// providerTemperature stands a temperature of 0 in with the smallest one
+// above, which the client sends instead of omitting it as unset.
+func providerTemperature(t float32) float32 {
+	if t == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return t
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+const diffPrompt = "You summarise what changed between two versions of a document. Below are the numbered changes found by comparing them passage by passage: removed passages were only in the old version, added ones only in the new, and changed ones show the old text then the new. Write a short summary of the changes that matter to a reader, in %s, ignoring pure rewording and formatting. Then list the key changes, each as one sentence with the numbers of the changes it describes."
+
+// passage is a paragraph of a document, or a chunk when its page text
+// wasn't stored.
+type passage struct {
+	Page int64
+	Text string
+	key  string // normalised text, for matching
+}
+
+// docChange is one difference between two versions of a document.
+type docChange struct {
+	Number  int    `json:"number"`
+	Type    string `json:"type"` // added, removed or changed
+	OldPage int64  `json:"old_page,omitempty"`
+	NewPage int64  `json:"new_page,omitempty"`
+	Old     string `json:"old,omitempty"`
+	New     string `json:"new,omitempty"`
+}
+
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+// documentPassages splits a document into passages in reading order: its
+// stored page text by paragraph, or else its chunks.
+func documentPassages(ctx context.Context, documentID string) ([]passage, error) {
+	var out []passage
+	pages, n, err := storedPages(ctx, documentID)
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 {
+		for page := range pages {
+			for _, para := range paragraphBreak.Split(page.Text, -1) {
+				if para = strings.TrimSpace(para); para != "" {
+					out = append(out, passage{Page: int64(page.Number), Text: para})
+				}
+			}
+		}
+	} else {
+		var points []*pb.RetrievedPoint
+		err := scrollPoints(ctx, andFilters(documentFilter(documentID), enabledFilter), true, func(batch []*pb.RetrievedPoint) error {
+			points = append(points, batch...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Payload["chunk_index"].GetIntegerValue() < points[j].Payload["chunk_index"].GetIntegerValue()
+		})
+		for _, p := range points {
+			out = append(out, passage{Page: p.Payload["page"].GetIntegerValue(), Text: payloadString(p.Payload, "text")})
+		}
+	}
+	for i := range out {
+		out[i].key = strings.Join(strings.Fields(strings.ToLower(out[i].Text)), " ")
+	}
+	return out, nil
+}
+
+// diffPassages aligns two passage lists on their longest common subsequence
+// of identical passages. Between matches, a removed and an added passage
+// sharing at least DIFF_CHANGED_SIMILARITY (default 0.5) of their words are
+// reported as one changed passage.
+func diffPassages(before, after []passage) []docChange {
+	n, m := len(before), len(after)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if before[i].key == after[j].key {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var changes []docChange
+	var removed, added []passage
+	threshold := envFloat("DIFF_CHANGED_SIMILARITY", 0.5)
+	flush := func() {
+		paired := make([]bool, len(added))
+		for _, r := range removed {
+			best, bestScore := -1, threshold
+			for j, a := range added {
+				if s := jaccard(strings.Fields(r.key), strings.Fields(a.key)); !paired[j] && s >= bestScore {
+					best, bestScore = j, s
+				}
+			}
+			if best < 0 {
+				changes = append(changes, docChange{Type: "removed", OldPage: r.Page, Old: r.Text})
+				continue
+			}
+			paired[best] = true
+			changes = append(changes, docChange{Type: "changed", OldPage: r.Page, NewPage: added[best].Page, Old: r.Text, New: added[best].Text})
+		}
+		for j, a := range added {
+			if !paired[j] {
+				changes = append(changes, docChange{Type: "added", NewPage: a.Page, New: a.Text})
+			}
+		}
+		removed, added = nil, nil
+	}
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && before[i].key == after[j].key:
+			flush()
+			i, j = i+1, j+1
+		case j == m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, before[i])
+			i++
+		default:
+			added = append(added, after[j])
+			j++
+		}
+	}
+	flush()
+	for k := range changes {
+		changes[k].Number = k + 1
+	}
+	return changes
+}
+
+// handleDocumentDiff compares two versions of a document:
+// POST /documents/:id/diff?against=<other_id>. against defaults to the
+// version the document superseded, and is treated as the old version. The
+// reply lists every added, removed and changed passage with its pages, and a
+// model-written summary of the changes.
+func handleDocumentDiff(c *gin.Context) {
+	user := requestUser(c)
+	load := func(id string) (documentRecord, bool) {
+		var doc documentRecord
+		found, _ := metaStore.Get("documents", id, &doc)
+		return doc, found && (doc.Owner == "" || doc.Owner == user)
+	}
+	doc, ok := load(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document not found"))
+		return
+	}
+	againstID := cmp.Or(c.Query("against"), doc.Supersedes)
+	if againstID == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "against is required for a document with no previous version"))
+		return
+	}
+	against, ok := load(againstID)
+	if !ok {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document to compare against not found"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	oldPassages, err := documentPassages(ctx, against.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeUpstream, "❌ Diff Error: "+err.Error()))
+		return
+	}
+	newPassages, err := documentPassages(ctx, doc.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeUpstream, "❌ Diff Error: "+err.Error()))
+		return
+	}
+	if limit := envInt("DIFF_MAX_PASSAGES", 2000); len(oldPassages) > limit || len(newPassages) > limit {
+		c.JSON(http.StatusOK, errorReply(c, codeTooLarge, fmt.Sprintf("Documents are too long to compare (over %d passages)", limit)))
+		return
+	}
+	changes := diffPassages(oldPassages, newPassages)
+
+	oldPages, newPages := changedPages(changes)
+	summary, keyChanges := "No differences found.", []gin.H{}
+	if len(changes) > 0 {
+		if summary, keyChanges, err = summarizeDiff(ctx, changes, cmp.Or(doc.Language, "en")); err != nil {
+			c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err)))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"document_id": doc.ID,
+		"against":     against.ID,
+		"summary":     summary,
+		"key_changes": keyChanges,
+		"changes":     changes,
+		"pages":       gin.H{"old": oldPages, "new": newPages},
+	})
+}
+
+// summarizeDiff has the model summarise the first DIFF_MAX_SUMMARIZED
+// (default 60) changes.
+func summarizeDiff(ctx context.Context, changes []docChange, lang string) (string, []gin.H, error) {
+	var listed strings.Builder
+	for _, ch := range changes[:min(len(changes), envInt("DIFF_MAX_SUMMARIZED", 60))] {
+		switch ch.Type {
+		case "removed":
+			fmt.Fprintf(&listed, "[%d] removed (old page %d): %s\n\n", ch.Number, ch.OldPage, snippet(ch.Old, 500))
+		case "added":
+			fmt.Fprintf(&listed, "[%d] added (new page %d): %s\n\n", ch.Number, ch.NewPage, snippet(ch.New, 500))
+		default:
+			fmt.Fprintf(&listed, "[%d] changed (old page %d, new page %d):\nOld: %s\nNew: %s\n\n", ch.Number, ch.OldPage, ch.NewPage, snippet(ch.Old, 500), snippet(ch.New, 500))
+		}
+	}
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"summary": map[string]any{"type": "string"},
+			"key_changes": map[string]any{"type": "array", "items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"description": map[string]any{"type": "string"},
+					"changes":     map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+				},
+				"required":             []string{"description", "changes"},
+				"additionalProperties": false,
+			}},
+		},
+		"required":             []string{"summary", "key_changes"},
+		"additionalProperties": false,
+	})
+	raw, err := completeStructured(ctx, fmt.Sprintf(diffPrompt, languageName(lang))+"\n\nChanges:\n"+listed.String(), "document_diff", schema)
+	if err != nil {
+		return "", nil, err
+	}
+	var out struct {
+		Summary    string `json:"summary"`
+		KeyChanges []struct {
+			Description string `json:"description"`
+			Changes     []int  `json:"changes"`
+		} `json:"key_changes"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return "", nil, err
+	}
+	keyChanges := []gin.H{}
+	for _, kc := range out.KeyChanges {
+		refs, described := []int{}, []docChange{}
+		for _, n := range kc.Changes {
+			if n >= 1 && n <= len(changes) {
+				refs, described = append(refs, n), append(described, changes[n-1])
+			}
+		}
+		oldPages, newPages := changedPages(described)
+		keyChanges = append(keyChanges, gin.H{"description": kc.Description, "changes": refs, "old_pages": oldPages, "new_pages": newPages})
+	}
+	return strings.TrimSpace(out.Summary), keyChanges, nil
+}
+
+// changedPages lists the old and new pages the changes touch.
+func changedPages(changes []docChange) ([]int64, []int64) {
+	oldPages, newPages := []int64{}, []int64{}
+	for _, ch := range changes {
+		if ch.Old != "" && !slices.Contains(oldPages, ch.OldPage) {
+			oldPages = append(oldPages, ch.OldPage)
+		}
+		if ch.New != "" && !slices.Contains(newPages, ch.NewPage) {
+			newPages = append(newPages, ch.NewPage)
+		}
+	}
+	slices.Sort(oldPages)
+	slices.Sort(newPages)
+	return oldPages, newPages
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// documentRecord is the metadata kept for each ingested document.
+type documentRecord struct {
+	ID          string            `json:"id"`
+	Filename    string            `json:"filename"`
+	Workspace   string            `json:"workspace"`
+	Owner       string            `json:"owner,omitempty"`
+	ParentID    string            `json:"parent_id,omitempty"`
+	Language    string            `json:"language"`
+	Graph       bool              `json:"graph,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Source      string            `json:"source,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"` // supplied with pushed text or by a connector; its chunks' fields
+	Chunks      int               `json:"chunks"`
+	Pages       int               `json:"pages,omitempty"`
+	FailedPages []pageError       `json:"failed_pages,omitempty"` // coverage gaps
+	CreatedAt   time.Time         `json:"created_at"`
+	documentMeta
+	documentVersion
+}
+
+func recordDocument(ctx context.Context, job ingestJob, res ingestResult, doc ingestDoc) error {
+	rec := documentRecord{
+		ID:              job.DocumentID,
+		Filename:        job.Filename,
+		Workspace:       job.Workspace,
+		Owner:           job.Owner,
+		ParentID:        job.ParentID,
+		Graph:           job.Graph,
+		Tags:            job.Tags,
+		Source:          job.Source,
+		URL:             job.URL,
+		Metadata:        job.Metadata,
+		Language:        res.Language,
+		Chunks:          res.Chunks,
+		Pages:           res.Pages,
+		FailedPages:     res.FailedPages,
+		CreatedAt:       time.Now(),
+		documentMeta:    doc.Meta,
+		documentVersion: doc.Version,
+	}
+	if rec.Supersedes != "" {
+		return supersede(ctx, &rec)
+	}
+	return metaStore.Put("documents", job.DocumentID, rec)
+}
+
+// deleteDocument removes a document's vectors and everything derived from
+// them, its stored text, usage stats, extractions, its record and its attachments' child
+// documents. Deleting a newer version puts the one it superseded back in
+// force. It returns what it deleted.
+func deleteDocument(ctx context.Context, doc documentRecord) (deletion, error) {
+	n, err := deleteChunks(ctx, doc)
+	if err != nil {
+		return n, err
+	}
+	if all, err := metaStore.List("documents"); err == nil {
+		for _, raw := range all {
+			var child documentRecord
+			if json.Unmarshal(raw, &child) == nil && child.ParentID == doc.ID {
+				m, err := deleteDocument(ctx, child)
+				n.Chunks, n.Relations = n.Chunks+m.Chunks, n.Relations+m.Relations
+				if err != nil {
+					return n, err
+				}
+			}
+		}
+	}
+	if doc.Supersedes != "" {
+		reopenPrevious(ctx, doc)
+	}
+	if pages, err := metaStore.List(pagesBucket(doc.ID)); err == nil {
+		for key := range pages {
+			metaStore.Delete(pagesBucket(doc.ID), key)
+		}
+	}
+	metaStore.Delete("analytics", doc.ID)
+	if all, err := metaStore.List("extractions"); err == nil {
+		for key := range all {
+			if strings.HasPrefix(key, doc.ID+"/") {
+				metaStore.Delete("extractions", key)
+			}
+		}
+	}
+	return n, metaStore.Delete("documents", doc.ID)
+}
+
+// deletion counts what deleteChunks or deleteDocument removed.
+type deletion struct {
+	Chunks    int
+	Relations int // graph relations taken from the chunks
+}
+
+// deleteChunks removes a document's points and what was derived from them:
+// term index and graph references and relations and glossary definitions.
+// Chunks in other documents marked as duplicates of them become searchable
+// again.
+func deleteChunks(ctx context.Context, doc documentRecord) (deletion, error) {
+	chunkIDs := map[string]bool{}
+	err := scrollPoints(ctx, documentFilter(doc.ID), false, func(points []*pb.RetrievedPoint) error {
+		for _, p := range points {
+			chunkIDs[p.GetId().GetUuid()] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return deletion{}, err
+	}
+	_, err = qdrantClient.Delete(ctx, &pb.DeletePoints{
+		CollectionName: collectionName,
+		Points:         pb.NewPointsSelectorFilter(documentFilter(doc.ID)),
+	})
+	if err != nil {
+		return deletion{}, err
+	}
+	n := deletion{Chunks: len(chunkIDs)}
+	if err := releaseDuplicates(ctx, chunkIDs); err != nil {
+		return n, err
+	}
+	if err := forgetTermChunks(chunkIDs); err != nil {
+		return n, err
+	}
+	if n.Relations, err = forgetGraphChunks(doc.Workspace, chunkIDs); err != nil {
+		return n, err
+	}
+	if err := forgetFAQChunks(chunkIDs); err != nil {
+		return n, err
+	}
+	return n, forgetGlossary(doc.Workspace, doc.ID)
+}
+
+// auditEntry records a deletion or other data-handling action.
+type auditEntry struct {
+	Action     string    `json:"action"`
+	Workspace  string    `json:"workspace,omitempty"`
+	DocumentID string    `json:"document_id,omitempty"`
+	Detail     string    `json:"detail"`
+	At         time.Time `json:"at"`
+}
+
+func audit(e auditEntry) {
+	e.At = time.Now()
+	// keys sort by time
+	key := e.At.UTC().Format(time.RFC3339Nano) + "-" + uuid.New().String()[:8]
+	if err := metaStore.Put("audit", key, e); err != nil {
+		log.Printf("❌ Audit Error: %v", err)
+	}
+}
+
+// retentionDays is how long a workspace keeps documents:
+// RETENTION_DAYS_<WORKSPACE>, falling back to RETENTION_DAYS. 0 keeps them
+// forever.
+func retentionDays(workspace string) int {
+	if v, ok := os.LookupEnv("RETENTION_DAYS_" + strings.ToUpper(workspace)); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return envInt("RETENTION_DAYS", 0)
+}
+
+// sweepRetention purges documents older than their workspace's retention
+// period, auditing each purge.
+func sweepRetention(ctx context.Context) {
+	all, err := metaStore.List("documents")
+	if err != nil {
+		log.Printf("❌ Retention Sweep Error: %v", err)
+		return
+	}
+	for _, raw := range all {
+		var doc documentRecord
+		if json.Unmarshal(raw, &doc) != nil {
+			continue
+		}
+		days := retentionDays(doc.Workspace)
+		if days <= 0 || time.Since(doc.CreatedAt) < time.Duration(days)*24*time.Hour {
+			continue
+		}
+		deleted, err := deleteDocument(ctx, doc)
+		if err != nil {
+			log.Printf("❌ Retention Purge Error (%s): %v", doc.ID, err)
+			continue
+		}
+		log.Printf("🗑️ Purged %s (%s) after %d days", doc.Filename, doc.ID, days)
+		audit(auditEntry{
+			Action:     "retention_purge",
+			Workspace:  doc.Workspace,
+			DocumentID: doc.ID,
+			Detail:     fmt.Sprintf("purged %q (%d chunks) after %d day retention", doc.Filename, deleted.Chunks, days),
+		})
+	}
+	purgeChats()
+}
+
+// purgeChats deletes chat history, and sessions last used, longer ago than
+// their workspace's retention.
+func purgeChats() {
+	chats, err := metaStore.List("chats")
+	if err != nil {
+		log.Printf("❌ Retention Sweep Error: %v", err)
+		return
+	}
+	purged := map[string]int{}
+	for id, raw := range chats {
+		var rec chatRecord
+		if json.Unmarshal(raw, &rec) != nil {
+			continue
+		}
+		days := retentionDays(rec.Workspace)
+		if days <= 0 || time.Since(rec.CreatedAt) < time.Duration(days)*24*time.Hour {
+			continue
+		}
+		if metaStore.Delete("chats", id) == nil {
+			metaStore.Delete("chat_debug", id)
+			metaStore.Delete("shadow", id)
+			purged[rec.Workspace]++
+		}
+	}
+	sessions, err := metaStore.List("sessions")
+	if err != nil {
+		log.Printf("❌ Retention Sweep Error: %v", err)
+		return
+	}
+	for id, raw := range sessions {
+		var s session
+		if json.Unmarshal(raw, &s) != nil {
+			continue
+		}
+		if days := retentionDays(s.Workspace); days > 0 && time.Since(s.UpdatedAt) >= time.Duration(days)*24*time.Hour {
+			metaStore.Delete("sessions", id)
+		}
+	}
+	for ws, n := range purged {
+		audit(auditEntry{Action: "retention_purge", Workspace: ws, Detail: fmt.Sprintf("purged %d chats after %d day retention", n, retentionDays(ws))})
+	}
+}
+
+// handleAudit lists audit entries, oldest first: GET /admin/audit.
+func handleAudit(c *gin.Context) {
+	all, err := metaStore.List("audit")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	entries := make([]auditEntry, 0, len(keys))
+	for _, k := range keys {
+		var e auditEntry
+		if json.Unmarshal(all[k], &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "entries": entries})
+}
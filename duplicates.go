@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// Near-duplicate chunks: a paragraph repeated across many uploaded variants
+// of the same template would otherwise fill every retrieval slot with the
+// same text. At ingest each new chunk is compared with the workspace's
+// chunks in force from other documents, bar the version it supersedes; one
+// at least DUPLICATE_SIMILARITY (default 0.97) similar is stored with
+// duplicate: true and duplicate_of, the ID of the chunk it repeats. Searches across the workspace skip duplicates;
+// searches scoped to documents still see them. DUPLICATE_CHUNKS=off (per
+// workspace with a _<WORKSPACE> suffix) turns this off.
+
+// notDuplicateFilter leaves out chunks marked as near-duplicates.
+var notDuplicateFilter = &pb.Filter{MustNot: []*pb.Condition{pb.NewMatchBool("duplicate", true)}}
+
+// duplicatesFilter hides duplicates from a search unless it is limited to
+// particular documents.
+func duplicatesFilter(documentIDs []string) *pb.Filter {
+	if len(documentIDs) > 0 {
+		return nil
+	}
+	return notDuplicateFilter
+}
+
+// markDuplicates flags the points of a batch that repeat an existing chunk,
+// before they are written, and returns how many it flagged. vectors are the
+// points' embeddings, in order. A failed lookup leaves the batch unflagged.
+func markDuplicates(ctx context.Context, doc ingestDoc, points []*pb.PointStruct, vectors [][]float32) int {
+	if strings.EqualFold(envWorkspace("DUPLICATE_CHUNKS", doc.Workspace), "off") || len(points) == 0 {
+		return 0
+	}
+	filter := andFilters(
+		&pb.Filter{Must: []*pb.Condition{pb.NewMatch("workspace", doc.Workspace)}},
+		&pb.Filter{MustNot: []*pb.Condition{pb.NewMatch("document_id", doc.DocumentID)}},
+		notDuplicateFilter,
+		enabledFilter,
+		validAtFilter(time.Now()),
+	)
+	if prev := doc.Version.Supersedes; prev != "" {
+		// the version this one replaces is about to expire, taking anything
+		// marked as repeating it out of results
+		filter = andFilters(filter, &pb.Filter{MustNot: []*pb.Condition{pb.NewFilterAsCondition(versionFilter(prev))}})
+	}
+	threshold := float32(envFloat("DUPLICATE_SIMILARITY", 0.97))
+	searches := make([]*pb.SearchPoints, len(vectors))
+	for i, v := range vectors {
+		searches[i] = &pb.SearchPoints{
+			CollectionName: collectionName,
+			Vector:         v,
+			Limit:          1,
+			Filter:         filter,
+			ScoreThreshold: &threshold,
+		}
+	}
+	res, err := qdrantClient.SearchBatch(ctx, &pb.SearchBatchPoints{CollectionName: collectionName, SearchPoints: searches})
+	if err != nil {
+		log.Printf("❌ Duplicate Check Error: %v", err)
+		return 0
+	}
+	marked := 0
+	for i, batch := range res.GetResult() {
+		if hits := batch.GetResult(); len(hits) > 0 && i < len(points) {
+			points[i].Payload["duplicate"] = pb.NewValueBool(true)
+			points[i].Payload["duplicate_of"] = pb.NewValueString(hits[0].GetId().GetUuid())
+			marked++
+		}
+	}
+	return marked
+}
+
+// releaseDuplicates unmarks the chunks that repeated any of the given
+// chunks, which are about to be deleted, so their text stays findable.
+func releaseDuplicates(ctx context.Context, chunkIDs map[string]bool) error {
+	ids := make([]string, 0, len(chunkIDs))
+	for id := range chunkIDs {
+		ids = append(ids, id)
+	}
+	for i := 0; i < len(ids); i += 256 {
+		_, err := qdrantClient.DeletePayload(ctx, &pb.DeletePayloadPoints{
+			CollectionName: collectionName,
+			Keys:           []string{"duplicate", "duplicate_of"},
+			PointsSelector: pb.NewPointsSelectorFilter(&pb.Filter{Must: []*pb.Condition{pb.NewMatchKeywords("duplicate_of", ids[i:min(i+256, len(ids))]...)}}),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
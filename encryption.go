@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// keyProvider supplies the 256-bit data key used for encryption at rest.
+type keyProvider interface {
+	DataKey(ctx context.Context) ([]byte, error)
+}
+
+// envKey reads a base64 key from ENCRYPTION_KEY.
+type envKey struct{}
+
+func (envKey) DataKey(context.Context) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(os.Getenv("ENCRYPTION_KEY"))
+}
+
+// fileKey reads a base64 key from a file, such as a mounted secret.
+type fileKey struct{ path string }
+
+func (k fileKey) DataKey(context.Context) ([]byte, error) {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+}
+
+// kmsKey unwraps a data key that was encrypted with AWS KMS (envelope
+// encryption), so the plaintext key never sits in configuration.
+type kmsKey struct {
+	keyID   string
+	wrapped string // base64 CiphertextBlob from kms GenerateDataKey
+}
+
+func (k kmsKey) DataKey(ctx context.Context) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(k.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	in := &kms.DecryptInput{CiphertextBlob: blob}
+	if k.keyID != "" {
+		in.KeyId = aws.String(k.keyID)
+	}
+	out, err := kms.NewFromConfig(cfg).Decrypt(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// atRest is the AEAD for stored files and chunk text, or nil when
+// encryption at rest is off.
+var atRest cipher.AEAD
+
+// setupEncryption enables AES-256-GCM at rest when ENCRYPTION_KEY_PROVIDER is
+// "env" (ENCRYPTION_KEY), "file" (ENCRYPTION_KEY_FILE) or "aws-kms"
+// (ENCRYPTION_KMS_WRAPPED_KEY, optionally ENCRYPTION_KMS_KEY_ID).
+func setupEncryption(ctx context.Context) error {
+	var provider keyProvider
+	switch name := os.Getenv("ENCRYPTION_KEY_PROVIDER"); name {
+	case "":
+		return nil
+	case "env":
+		provider = envKey{}
+	case "file":
+		provider = fileKey{path: os.Getenv("ENCRYPTION_KEY_FILE")}
+	case "aws-kms":
+		provider = kmsKey{keyID: os.Getenv("ENCRYPTION_KMS_KEY_ID"), wrapped: os.Getenv("ENCRYPTION_KMS_WRAPPED_KEY")}
+	default:
+		return fmt.Errorf("unknown ENCRYPTION_KEY_PROVIDER %q", name)
+	}
+	key, err := provider.DataKey(ctx)
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("data key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	atRest, err = cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	log.Println("🔐 Encryption at rest enabled")
+	return nil
+}
+
+// sealedTextPrefix marks an encrypted payload string.
+const sealedTextPrefix = "enc:v1:"
+
+// sealText encrypts a payload string when encryption at rest is on.
+func sealText(s string) string {
+	if atRest == nil {
+		return s
+	}
+	nonce := make([]byte, atRest.NonceSize())
+	rand.Read(nonce)
+	return sealedTextPrefix + base64.StdEncoding.EncodeToString(atRest.Seal(nonce, nonce, []byte(s), nil))
+}
+
+// openText decrypts a payload string written by sealText; anything else is
+// returned as is.
+func openText(s string) string {
+	if !strings.HasPrefix(s, sealedTextPrefix) {
+		return s
+	}
+	if atRest == nil {
+		log.Println("❌ Encrypted payload found but encryption at rest is not configured")
+		return ""
+	}
+	data, err := base64.StdEncoding.DecodeString(s[len(sealedTextPrefix):])
+	n := atRest.NonceSize()
+	if err != nil || len(data) < n {
+		return ""
+	}
+	plain, err := atRest.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		log.Printf("❌ Payload Decrypt Error: %v", err)
+		return ""
+	}
+	return string(plain)
+}
+
+// Sealed files start with sealedFileMagic and are a sequence of frames, each
+// a big-endian uint32 length followed by nonce and ciphertext of up to
+// sealFrameSize plaintext bytes. The top bit of the length marks the last
+// frame. Each frame is sealed with its index and that mark as additional
+// data, so frames can't be reordered, dropped from the end or taken from
+// another file. Frames let an upload be appended to across requests without
+// re-encrypting what is already there.
+const (
+	sealedFileMagic = "DCENC2\n"
+	sealFrameSize   = 64 << 10
+	finalFrame      = 1 << 31
+)
+
+// legacySealedMagic heads sealed files from before frames were bound to
+// their place in the file, which are no longer read.
+const legacySealedMagic = "DCENC1\n"
+
+// frameAAD is the additional data frame index of a sealed file is sealed
+// with.
+func frameAAD(index uint64, final bool) []byte {
+	aad := binary.BigEndian.AppendUint64(nil, index)
+	if final {
+		return append(aad, 1)
+	}
+	return append(aad, 0)
+}
+
+// sealWriter encrypts everything written to it into frames on w, numbering
+// them from the index it is given. Close writes the partial frame so more
+// can be appended later by a sealWriter starting at Frames; Finish writes
+// it as the file's last frame. Neither closes w.
+type sealWriter struct {
+	w     io.Writer
+	buf   []byte
+	frame uint64 // index of the next frame
+}
+
+func newSealWriter(w io.Writer, frame uint64) *sealWriter {
+	return &sealWriter{w: w, buf: make([]byte, 0, sealFrameSize), frame: frame}
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), sealFrameSize-len(s.buf))
+		s.buf = append(s.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(s.buf) == sealFrameSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (s *sealWriter) flush(final bool) error {
+	if len(s.buf) == 0 && !final {
+		return nil
+	}
+	nonce := make([]byte, atRest.NonceSize())
+	rand.Read(nonce)
+	frame := atRest.Seal(nonce, nonce, s.buf, frameAAD(s.frame, final))
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(frame)))
+	if final {
+		size[0] |= finalFrame >> 24
+	}
+	s.buf = s.buf[:0]
+	s.frame++
+	if _, err := s.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(frame)
+	return err
+}
+
+func (s *sealWriter) Close() error { return s.flush(false) }
+
+func (s *sealWriter) Finish() error { return s.flush(true) }
+
+// Frames is the number of frames written, counting those before the writer.
+func (s *sealWriter) Frames() uint64 { return s.frame }
+
+// sealFile copies from into a new sealed file at to.
+func sealFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := out.WriteString(sealedFileMagic); err == nil {
+		sw := newSealWriter(out, 0)
+		if _, err = io.Copy(sw, in); err == nil {
+			err = sw.Finish()
+		}
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to)
+	}
+	return err
+}
+
+// plainFile returns a path to read path's plaintext from, decrypting a sealed
+// file into a temp file if needed, and a cleanup function for it.
+func plainFile(path string) (string, func(), error) {
+	noop := func() {}
+	in, err := os.Open(path)
+	if err != nil {
+		return "", noop, err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	head, _ := r.Peek(len(sealedFileMagic))
+	if bytes.Equal(head, []byte(legacySealedMagic)) {
+		return "", noop, errors.New("file was encrypted by an older version; upload it again")
+	}
+	if !bytes.Equal(head, []byte(sealedFileMagic)) {
+		return path, noop, nil
+	}
+	if atRest == nil {
+		return "", noop, errors.New("file is encrypted but encryption at rest is not configured")
+	}
+	r.Discard(len(sealedFileMagic))
+
+	tmp, err := os.CreateTemp("", "docuchat-plain-*")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	defer tmp.Close()
+	var size [4]byte
+	n := atRest.NonceSize()
+	maxFrame := uint32(n + sealFrameSize + atRest.Overhead())
+	final := false
+	for index := uint64(0); ; index++ {
+		if _, err := io.ReadFull(r, size[:]); err == io.EOF && final {
+			break
+		} else if err == io.EOF {
+			cleanup()
+			return "", noop, errors.New("truncated encrypted file")
+		} else if err != nil {
+			cleanup()
+			return "", noop, err
+		}
+		if final {
+			cleanup()
+			return "", noop, errors.New("encrypted file continues past its last frame")
+		}
+		length := binary.BigEndian.Uint32(size[:])
+		final = length&finalFrame != 0
+		if length &^= finalFrame; length > maxFrame || length < uint32(n) {
+			cleanup()
+			return "", noop, fmt.Errorf("encrypted frame of %d bytes is out of range", length)
+		}
+		frame := make([]byte, length)
+		if _, err := io.ReadFull(r, frame); err != nil {
+			cleanup()
+			return "", noop, errors.New("truncated encrypted file")
+		}
+		plain, err := atRest.Open(nil, frame[:n], frame[n:], frameAAD(index, final))
+		if err != nil {
+			cleanup()
+			return "", noop, err
+		}
+		if _, err := tmp.Write(plain); err != nil {
+			cleanup()
+			return "", noop, err
+		}
+	}
+	return tmp.Name(), cleanup, nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Ephemeral sessions are for documents that mustn't be kept: the file is
+// parsed, chunked and embedded into an index held in this process's memory,
+// and the upload is removed as soon as it's read. Nothing goes to Qdrant or
+// the metadata store; questions and answers aren't recorded. A session ends
+// when deleted or after EPHEMERAL_TTL_MINUTES (default 60) unused, and with
+// the process. Behind a load balancer, chats must reach the process that
+// holds the session.
+
+// ephemeralSession is a document indexed in memory, with its conversation.
+type ephemeralSession struct {
+	mu        sync.Mutex
+	id        string
+	owner     string
+	workspace string // for the persona and answer filters
+	filename  string
+	chunks    []textChunk
+	vectors   [][]float32
+	history   []openai.ChatCompletionMessage
+	expires   time.Time
+}
+
+var ephemeralSessions sync.Map
+
+func ephemeralTTL() time.Duration {
+	return time.Duration(envInt("EPHEMERAL_TTL_MINUTES", 60)) * time.Minute
+}
+
+// sweepEphemeral forgets expired sessions.
+func sweepEphemeral() {
+	ephemeralSessions.Range(func(key, value any) bool {
+		s := value.(*ephemeralSession)
+		s.mu.Lock()
+		expired := time.Now().After(s.expires)
+		s.mu.Unlock()
+		if expired {
+			ephemeralSessions.Delete(key)
+		}
+		return true
+	})
+}
+
+// ephemeralFor finds a live session of the caller's.
+func ephemeralFor(c *gin.Context) (*ephemeralSession, bool) {
+	sweepEphemeral()
+	v, ok := ephemeralSessions.Load(c.Param("id"))
+	if !ok {
+		return nil, false
+	}
+	s := v.(*ephemeralSession)
+	return s, s.owner == requestUser(c)
+}
+
+// handleCreateEphemeral indexes a file in memory: POST /ephemeral with
+// multipart form fields file, workspace and password. Files of more than
+// EPHEMERAL_MAX_CHUNKS (default 2000) chunks are refused.
+func handleCreateEphemeral(c *gin.Context) {
+	sweepEphemeral()
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "No file uploaded"))
+		return
+	}
+	tmp, err := os.CreateTemp("", "docuchat-ephemeral-*"+filepath.Ext(file.Filename))
+	if err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeStorage, "Upload Storage Error: "+err.Error()))
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeStorage, "Upload Storage Error: "+err.Error()))
+		return
+	}
+
+	ctx := c.Request.Context()
+	chunks, pages, err := chunkFile(ctx, tmp.Name(), file.Filename, c.PostForm("password"))
+	os.Remove(tmp.Name())
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeIngestFailed, err))
+		return
+	}
+	if len(chunks) == 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeNoText, "No text found in PDF"))
+		return
+	}
+	if limit := envInt("EPHEMERAL_MAX_CHUNKS", 2000); len(chunks) > limit {
+		c.JSON(http.StatusOK, errorReply(c, codeTooLarge, fmt.Sprintf("Document is too long for an ephemeral session (%d chunks, at most %d)", len(chunks), limit)))
+		return
+	}
+	vectors, err := embedChunks(ctx, chunks)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err)))
+		return
+	}
+
+	s := &ephemeralSession{
+		id:        uuid.New().String(),
+		owner:     requestUser(c),
+		workspace: c.DefaultPostForm("workspace", "default"),
+		filename:  file.Filename,
+		chunks:    chunks,
+		vectors:   vectors,
+		expires:   time.Now().Add(ephemeralTTL()),
+	}
+	ephemeralSessions.Store(s.id, s)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "session_id": s.id, "filename": s.filename, "pages": pages, "chunks": len(chunks), "expires_at": s.expires})
+}
+
+// chunkFile parses and chunks a file without storing anything, and returns
+// its chunks and page count.
+func chunkFile(ctx context.Context, path, filename, password string) ([]textChunk, int, error) {
+	pages, err := readPages(ctx, path, filename, password)
+	if err != nil {
+		return nil, 0, err
+	}
+	chunker := newChunker("")
+	var chunks []textChunk
+	n := 0
+	for page := range pages {
+		n++
+		if page.Err == "" {
+			chunks = append(chunks, chunker.Add(page.Number, page.Text)...)
+		}
+	}
+	return append(chunks, chunker.Flush()...), n, nil
+}
+
+// embedChunks embeds chunks held in memory, INGEST_BATCH (default 64) at a
+// time.
+func embedChunks(ctx context.Context, chunks []textChunk) ([][]float32, error) {
+	var vectors [][]float32
+	batch := envInt("INGEST_BATCH", 64)
+	for start := 0; start < len(chunks); start += batch {
+		texts := make([]string, 0, batch)
+		for _, ch := range chunks[start:min(start+batch, len(chunks))] {
+			texts = append(texts, ch.Text)
+		}
+		vs, err := embedTexts(ctx, texts)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, vs...)
+	}
+	return vectors, nil
+}
+
+// rankedVector is the position of a vector held in memory and its
+// similarity to a query.
+type rankedVector struct {
+	index int
+	score float64
+}
+
+// rankVectors returns the n vectors most similar to the query, best first.
+func rankVectors(query []float32, vectors [][]float32, n int) []rankedVector {
+	ranked := make([]rankedVector, len(vectors))
+	for i, v := range vectors {
+		ranked[i] = rankedVector{i, cosine(query, v)}
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	return ranked[:min(len(ranked), n)]
+}
+
+// handleEphemeralChat answers from an ephemeral session's document:
+// POST /ephemeral/:id/chat {"question", "format"}. The session's last
+// SESSION_HISTORY_TURNS turns go along as history.
+func handleEphemeralChat(c *gin.Context) {
+	s, ok := ephemeralFor(c)
+	if !ok {
+		c.JSON(http.StatusOK, chatErrorReply(c, codeNotFound, "❌ Error: ephemeral session not found."))
+		return
+	}
+	var body struct {
+		Question string `json:"question"`
+		Format   string `json:"format"`
+	}
+	if err := c.BindJSON(&body); err != nil || strings.TrimSpace(body.Question) == "" {
+		c.JSON(http.StatusOK, chatErrorReply(c, codeInvalidRequest, "❌ Error: Invalid JSON format."))
+		return
+	}
+	body.Format = cmp.Or(body.Format, "markdown")
+	if !slices.Contains(answerFormats, body.Format) {
+		c.JSON(http.StatusOK, chatErrorReply(c, codeInvalidRequest, "❌ Error: format must be markdown, plain or html."))
+		return
+	}
+	ctx := c.Request.Context()
+	vector, err := embedText(ctx, body.Question)
+	if err != nil {
+		c.JSON(http.StatusOK, chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err)))
+		return
+	}
+
+	s.mu.Lock()
+	s.expires = time.Now().Add(ephemeralTTL())
+	var texts []string
+	citations := []gin.H{}
+	for _, r := range rankVectors(vector, s.vectors, 5) {
+		ch := s.chunks[r.index]
+		texts = append(texts, ch.Text)
+		citations = append(citations, gin.H{"chunk_index": r.index, "page": ch.Page, "score": r.score, "snippet": snippet(ch.Text, 300)})
+	}
+	history := append([]openai.ChatCompletionMessage(nil), s.history...)
+	s.mu.Unlock()
+
+	lang := answerLanguage(body.Question)
+	prompt := fmt.Sprintf("%s%s\n\nContext: %s\n\nQuestion: %s",
+		personaFor(s.workspace, nil), languageInstruction(lang)+formatInstruction(body.Format), strings.Join(texts, "\n\n---\n\n"), body.Question)
+	resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    chatModel,
+		Messages: append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt}),
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err)))
+		return
+	}
+	if len(resp.Choices) == 0 {
+		c.JSON(http.StatusOK, chatUpstreamReply(c, upstreamOpenAI, "❌ OpenAI Chat Error: the model returned no answer"))
+		return
+	}
+	answer := filterAnswer(s.workspace, resp.Choices[0].Message.Content)
+
+	s.mu.Lock()
+	s.history = append(s.history,
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: body.Question},
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: answer},
+	)
+	if n := 2 * envInt("SESSION_HISTORY_TURNS", 5); len(s.history) > n {
+		s.history = s.history[len(s.history)-n:]
+	}
+	s.mu.Unlock()
+	c.JSON(http.StatusOK, gin.H{"answer": formatAnswer(answer, body.Format), "format": body.Format, "language": lang, "session_id": s.id, "citations": citations})
+}
+
+// handleDeleteEphemeral ends an ephemeral session at once:
+// DELETE /ephemeral/:id.
+func handleDeleteEphemeral(c *gin.Context) {
+	if _, ok := ephemeralFor(c); !ok {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Ephemeral session not found"))
+		return
+	}
+	ephemeralSessions.Delete(c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Session discarded"})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Every failure is reported in one shape, whatever the endpoint. Replies
+// stay HTTP 200 with status "error", the message and its code, and add an
+// error object clients can branch on:
+//
+//	"error": {"code": "upstream_error", "message": "Qdrant Error: ...",
+//	          "retryable": true, "upstream": "qdrant", "request_id": "..."}
+//
+// Chat replies keep their ❌ answer and add the same object beside it. The
+// request ID is the caller's X-Request-ID, or one made up for the request,
+// and is sent back in that header on every reply.
+
+type apiError = api.Error
+
+const (
+	codeInvalidRequest = "invalid_request"
+	codeUnauthorized   = "unauthorized"
+	codeForbidden      = "forbidden"
+	codeNotFound       = "not_found"
+	codeConflict       = "conflict"
+	codeTooLarge       = "too_large"
+	codeRateLimited    = "rate_limited"
+	codeInterrupted    = "interrupted" // an upload cut off midway; resume it
+	codeNoText         = "no_text"
+	codeUpstream       = "upstream_error"
+	codeStorage        = "storage_error"
+	codeIngestFailed   = "ingest_failed"
+	codeInternal       = "internal_error"
+
+	codePDFPasswordRequired = "pdf_password_required"
+	codePDFPasswordInvalid  = "pdf_password_invalid"
+	codeInvalidRecords      = "invalid_records"
+)
+
+// The dependencies an upstream_error names.
+const (
+	upstreamOpenAI   = "openai"
+	upstreamQdrant   = "qdrant"
+	upstreamMetadata = "metadata_store"
+	upstreamJobQueue = "job_queue"
+	upstreamPapers   = "paper_index"
+	upstreamVideos   = "video_host"
+	upstreamSTT      = "stt"
+	upstreamTTS      = "tts"
+	upstreamWeb      = "web"
+)
+
+// retryableCodes are the codes of failures the same request may get past
+// later.
+var retryableCodes = map[string]bool{
+	codeRateLimited: true,
+	codeInterrupted: true,
+	codeUpstream:    true,
+}
+
+// codedError is an error with a stable code clients can act on.
+type codedError struct{ code, message string }
+
+func (e codedError) Error() string { return e.message }
+
+// errorCode returns err's code, or "" if it has none.
+func errorCode(err error) string {
+	var coded codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return ""
+}
+
+// requestIDKey is the request context key of the request's ID.
+type requestIDKey struct{}
+
+// requestID gives each request an ID, the caller's X-Request-ID if it sent
+// a usable one, and sends it back in that header.
+func requestID(c *gin.Context) {
+	id := c.GetHeader("X-Request-ID")
+	if id == "" || len(id) > 128 {
+		id = uuid.New().String()
+	}
+	c.Header("X-Request-ID", id)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, id))
+}
+
+// requestIDOf is the ID of the request ctx belongs to, "" outside one.
+func requestIDOf(ctx context.Context) string {
+	if c, ok := ctx.(*gin.Context); ok {
+		if c.Request == nil {
+			return ""
+		}
+		ctx = c.Request.Context()
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newAPIError describes a failure of the request ctx belongs to; upstream
+// is "" unless a dependency failed.
+func newAPIError(ctx context.Context, code, upstream, message string) apiError {
+	return apiError{Code: code, Message: message, Retryable: retryableCodes[code], Upstream: upstream, RequestID: requestIDOf(ctx)}
+}
+
+// errorReply is the reply to a request that failed with code.
+func errorReply(ctx context.Context, code, message string) gin.H {
+	return failureReply(newAPIError(ctx, code, "", message))
+}
+
+// upstreamReply is the reply to a request that failed because upstream did.
+func upstreamReply(ctx context.Context, upstream, message string) gin.H {
+	return failureReply(newAPIError(ctx, codeUpstream, upstream, message))
+}
+
+// errReply reports err by its own code, or fallback if it has none.
+func errReply(ctx context.Context, fallback string, err error) gin.H {
+	return errorReply(ctx, cmp.Or(errorCode(err), fallback), err.Error())
+}
+
+func failureReply(e apiError) gin.H {
+	return gin.H{"status": "error", "message": e.Message, "code": e.Code, "error": e}
+}
+
+// chatErrorReply is the reply to a chat that failed with code: the ❌ answer
+// and its error, whose message is the answer without the emoji.
+func chatErrorReply(ctx context.Context, code, answer string) gin.H {
+	return chatFailureReply(ctx, code, "", answer)
+}
+
+// chatUpstreamReply is the reply to a chat that failed because upstream did.
+func chatUpstreamReply(ctx context.Context, upstream, answer string) gin.H {
+	return chatFailureReply(ctx, codeUpstream, upstream, answer)
+}
+
+func chatFailureReply(ctx context.Context, code, upstream, answer string) gin.H {
+	message := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(answer, "❌"), "🔥"))
+	message = strings.TrimPrefix(message, "Error: ")
+	return gin.H{"answer": answer, "error": newAPIError(ctx, code, upstream, message)}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// embeddingPrices are list prices in USD per million tokens; EMBEDDING_PRICES
+// ("model=usd,...") adds or overrides them.
+var embeddingPrices = map[string]float64{
+	"text-embedding-3-small": 0.02,
+	"text-embedding-3-large": 0.13,
+	"text-embedding-ada-002": 0.10,
+}
+
+// embeddingDims are the vector sizes of the embedding models we know about.
+var embeddingDims = map[string]int{
+	"text-embedding-3-small": 1536,
+	"text-embedding-3-large": 3072,
+	"text-embedding-ada-002": 1536,
+}
+
+// fileEstimate is what ingesting one file would produce.
+type fileEstimate struct {
+	Path   string
+	Pages  int
+	Chunks int
+	Tokens map[string]int // by model
+	Err    error
+}
+
+// runEstimate is `docuchat estimate [-models a,b] [-dimensions n] [-password pw] <path>...`:
+// it parses and chunks files locally as /ingest would and prints the tokens,
+// points and embedding cost per model, without calling OpenAI or Qdrant.
+// PDFs are read locally; with PARSER_SERVICE set, the other files it can
+// parse are estimated too. Embedded attachments aren't counted.
+func runEstimate(args []string) error {
+	fset := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	modelList := fset.String("models", cmp.Or(os.Getenv("EMBEDDING_MODEL"), "text-embedding-3-small"), "comma-separated embedding models to price")
+	password := fset.String("password", "", "password for encrypted PDFs")
+	dimensions := fset.Int("dimensions", envInt("EMBEDDING_DIMENSIONS", 0), "shortened vector size to estimate storage for (text-embedding-3 models)")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() == 0 {
+		return fmt.Errorf("usage: docuchat estimate [-models a,b] [-dimensions n] [-password pw] <file or directory>...")
+	}
+	var models []string
+	for _, m := range strings.Split(*modelList, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	for _, pair := range strings.Split(os.Getenv("EMBEDDING_PRICES"), ",") {
+		if m, p, ok := strings.Cut(pair, "="); ok {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(p), 64); err == nil {
+				embeddingPrices[strings.TrimSpace(m)] = f
+			}
+		}
+	}
+
+	var files []string
+	for _, root := range fset.Args() {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != root && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") {
+				return nil
+			}
+			if configuredParser() != nil || strings.EqualFold(filepath.Ext(path), ".pdf") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no files to estimate")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "FILE\tPAGES\tCHUNKS\tTOKENS (%s)\t\n", models[0])
+	totalPages, totalChunks := 0, 0
+	totalTokens := map[string]int{}
+	failed := 0
+	for _, path := range files {
+		e := estimateFile(context.Background(), path, *password, models)
+		if e.Err != nil {
+			failed++
+			fmt.Fprintf(w, "%s\t-\t-\t%v\t\n", path, e.Err)
+			continue
+		}
+		totalPages += e.Pages
+		totalChunks += e.Chunks
+		for m, n := range e.Tokens {
+			totalTokens[m] += n
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n", path, e.Pages, e.Chunks, e.Tokens[models[0]])
+	}
+	fmt.Fprintf(w, "TOTAL (%d files, %d failed)\t%d\t%d\t%d\t\n", len(files), failed, totalPages, totalChunks, totalTokens[models[0]])
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "MODEL\tTOKENS\tPOINTS\tVECTOR STORAGE\tCOST (USD)\t")
+	for _, m := range models {
+		storage := "?"
+		dims, ok := embeddingDims[m]
+		if *dimensions > 0 && validDimensions(m, *dimensions) == nil {
+			dims, ok = *dimensions, true
+		}
+		if ok {
+			storage = fmt.Sprintf("%.1f MB", float64(totalChunks*dims*4)/(1<<20))
+		}
+		cost := "? (set EMBEDDING_PRICES)"
+		if price, ok := embeddingPrices[m]; ok {
+			cost = fmt.Sprintf("%.6f", float64(totalTokens[m])/1e6*price)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t\n", m, totalTokens[m], totalChunks, storage, cost)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, m := range models {
+		if validDimensions(m, *dimensions) == nil {
+			if note := dimensionsNote(embeddingConfig{Model: m, Dimensions: *dimensions}); note != "" {
+				fmt.Println("\n" + note)
+			}
+		}
+	}
+	return nil
+}
+
+// estimateFile runs a file through parsing, boilerplate removal and
+// chunking, counting each chunk's tokens for every model.
+func estimateFile(ctx context.Context, path, password string, models []string) fileEstimate {
+	e := fileEstimate{Path: path, Tokens: map[string]int{}}
+	pages, err := readPages(ctx, path, filepath.Base(path), password)
+	if err != nil {
+		e.Err = err
+		return e
+	}
+	pages = stripBoilerplate(ctx, pages)
+	count := func(chunks []textChunk) {
+		for _, c := range chunks {
+			e.Chunks++
+			for _, m := range models {
+				e.Tokens[m] += countTokens(m, c.Text)
+			}
+		}
+	}
+	chunker := newChunker("")
+	for page := range pages {
+		e.Pages++
+		if page.Err == "" {
+			count(chunker.Add(page.Number, page.Text))
+		}
+	}
+	count(chunker.Flush())
+	if extra, err := extractFormsAndAnnotations(path, password); err == nil {
+		count(extra)
+	}
+	return e
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+const extractPrompt = "You extract a single field from a document. Read the numbered excerpts and report the value of the requested field. Set found to false and value to null if the excerpts do not contain it. List the excerpt numbers you used in sources and rate your confidence from 0 to 1."
+
+type extractField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"` // string, number, integer, boolean or array; defaults to string
+}
+
+type citation struct {
+	PointID string  `json:"point_id"`
+	Score   float32 `json:"score"`
+	Snippet string  `json:"snippet"`
+}
+
+type fieldResult struct {
+	Value      any        `json:"value"`
+	Confidence float64    `json:"confidence"`
+	Citations  []citation `json:"citations"`
+	Error      string     `json:"error,omitempty"`
+}
+
+func handleExtract(c *gin.Context) {
+	var body struct {
+		Fields []extractField `json:"fields"`
+	}
+	if err := c.BindJSON(&body); err != nil || len(body.Fields) == 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Provide a non-empty list of fields"))
+		return
+	}
+	documentID := c.Param("id")
+	var doc documentRecord
+	if found, err := metaStore.Get("documents", documentID, &doc); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	} else if !found || (doc.Owner != "" && doc.Owner != requestUser(c)) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document not found"))
+		return
+	}
+
+	fields := make(map[string]fieldResult, len(body.Fields))
+	values := make(map[string]any, len(body.Fields))
+	for _, f := range body.Fields {
+		if f.Name == "" {
+			c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Every field needs a name"))
+			return
+		}
+		res := extractOne(context.Background(), documentID, f)
+		fields[f.Name] = res
+		values[f.Name] = res.Value
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "document_id": documentID, "data": values, "fields": fields})
+}
+
+// extractOne retrieves the chunks most relevant to a single field and asks the
+// model to pull its value out of them.
+func extractOne(ctx context.Context, documentID string, f extractField) fieldResult {
+	fieldType := jsonschema.DataType(f.Type)
+	switch fieldType {
+	case "":
+		fieldType = jsonschema.String
+	case jsonschema.String, jsonschema.Number, jsonschema.Integer, jsonschema.Boolean, jsonschema.Array:
+	default:
+		return fieldResult{Error: fmt.Sprintf("Unsupported field type %q", f.Type)}
+	}
+
+	vector, err := embedText(ctx, strings.TrimSpace(f.Name+": "+f.Description))
+	if err != nil {
+		return fieldResult{Error: "Embedding Error: " + err.Error()}
+	}
+	hits, err := searchChunks(ctx, vector, 3, documentFilter(documentID))
+	if err != nil {
+		return fieldResult{Error: "Search Error: " + err.Error()}
+	}
+	if len(hits) == 0 {
+		return fieldResult{Error: "No indexed text for this document"}
+	}
+
+	var excerpts strings.Builder
+	for i, hit := range hits {
+		fmt.Fprintf(&excerpts, "[%d] %s\n\n", i+1, payloadString(hit.Payload, "text"))
+	}
+	prompt := fmt.Sprintf("%s\n\nField: %s (%s)\nDescription: %s\n\nExcerpts:\n%s", extractPrompt, f.Name, fieldType, f.Description, excerpts.String())
+
+	raw, err := completeStructured(ctx, prompt, "field_extraction", fieldSchema(fieldType))
+	if err != nil {
+		return fieldResult{Error: "OpenAI Chat Error: " + err.Error()}
+	}
+	var out struct {
+		Found      bool    `json:"found"`
+		Value      any     `json:"value"`
+		Confidence float64 `json:"confidence"`
+		Sources    []int   `json:"sources"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return fieldResult{Error: "Invalid model output: " + err.Error()}
+	}
+	if !out.Found || out.Value == nil {
+		return fieldResult{Citations: []citation{}}
+	}
+	if !jsonschema.Validate(jsonschema.Definition{Type: fieldType, Items: &jsonschema.Definition{Type: jsonschema.String}}, out.Value) {
+		return fieldResult{Error: fmt.Sprintf("Extracted value is not of type %s", fieldType)}
+	}
+
+	res := fieldResult{Value: out.Value, Confidence: min(max(out.Confidence, 0), 1), Citations: []citation{}}
+	for _, n := range out.Sources {
+		if n >= 1 && n <= len(hits) {
+			res.Citations = append(res.Citations, newCitation(hits[n-1]))
+		}
+	}
+	return res
+}
+
+// fieldSchema is the response schema for a single field extraction.
+func fieldSchema(fieldType jsonschema.DataType) json.RawMessage {
+	value := map[string]any{"type": []string{string(fieldType), "null"}}
+	if fieldType == jsonschema.Array {
+		value["items"] = map[string]any{"type": "string"}
+	}
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"found":      map[string]any{"type": "boolean"},
+			"value":      value,
+			"confidence": map[string]any{"type": "number"},
+			"sources":    map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+		},
+		"required":             []string{"found", "value", "confidence", "sources"},
+		"additionalProperties": false,
+	})
+	return schema
+}
+
+func newCitation(hit *pb.ScoredPoint) citation {
+	return citation{
+		PointID: hit.Id.GetUuid(),
+		Score:   hit.Score,
+		Snippet: snippet(payloadString(hit.Payload, "text"), 300),
+	}
+}
+
+// snippet shortens text to at most n runes for display.
+func snippet(text string, n int) string {
+	r := []rune(strings.TrimSpace(text))
+	if len(r) <= n {
+		return string(r)
+	}
+	return string(r[:n]) + "…"
+}
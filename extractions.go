@@ -0,0 +1,259 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Extraction templates are named sets of fields kept in the
+// "extraction_templates" bucket. A template runs against a document on
+// request, and against every newly ingested document carrying one of its
+// tags in its workspace. Each run's result is kept in the "extractions"
+// bucket under document and template, the latest run replacing the one
+// before.
+
+// extractionTemplate is a saved set of fields to extract.
+type extractionTemplate struct {
+	Name      string         `json:"name"`
+	Fields    []extractField `json:"fields"`
+	Workspace string         `json:"workspace,omitempty"` // only run on ingest in this workspace, or in any when empty
+	Tags      []string       `json:"tags,omitempty"`      // run on each new document with any of these tags
+	Owner     string         `json:"owner,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// extraction is the result of running a template against a document.
+type extraction struct {
+	DocumentID string                 `json:"document_id"`
+	Filename   string                 `json:"filename"`
+	Workspace  string                 `json:"workspace"`
+	Template   string                 `json:"template"`
+	Trigger    string                 `json:"trigger"` // request or ingest
+	Data       map[string]any         `json:"data"`
+	Fields     map[string]fieldResult `json:"fields"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+func extractionKey(documentID, template string) string { return documentID + "/" + template }
+
+// validateFields checks a field list and returns what's wrong with it.
+func validateFields(fields []extractField) string {
+	if len(fields) == 0 {
+		return "Provide a non-empty list of fields"
+	}
+	seen := map[string]bool{}
+	for _, f := range fields {
+		switch {
+		case f.Name == "":
+			return "Every field needs a name"
+		case seen[f.Name]:
+			return "Duplicate field " + f.Name
+		}
+		seen[f.Name] = true
+	}
+	return ""
+}
+
+// runExtraction extracts every field of a template from a document and
+// stores the result.
+func runExtraction(ctx context.Context, doc documentRecord, t extractionTemplate, trigger string) (extraction, error) {
+	ex := extraction{
+		DocumentID: doc.ID,
+		Filename:   doc.Filename,
+		Workspace:  doc.Workspace,
+		Template:   t.Name,
+		Trigger:    trigger,
+		Data:       make(map[string]any, len(t.Fields)),
+		Fields:     make(map[string]fieldResult, len(t.Fields)),
+		CreatedAt:  time.Now(),
+	}
+	for _, f := range t.Fields {
+		res := extractOne(ctx, doc.ID, f)
+		ex.Fields[f.Name] = res
+		ex.Data[f.Name] = res.Value
+	}
+	return ex, metaStore.Put("extractions", extractionKey(doc.ID, t.Name), ex)
+}
+
+// runIngestExtractions runs the templates whose tags a newly ingested
+// document carries.
+func runIngestExtractions(ctx context.Context, documentID string) {
+	var doc documentRecord
+	if found, _ := metaStore.Get("documents", documentID, &doc); !found || len(doc.Tags) == 0 {
+		return
+	}
+	all, err := metaStore.List("extraction_templates")
+	if err != nil {
+		log.Printf("❌ Metadata Store Error: %v", err)
+		return
+	}
+	for _, raw := range all {
+		var t extractionTemplate
+		if json.Unmarshal(raw, &t) != nil || (t.Workspace != "" && t.Workspace != doc.Workspace) ||
+			!slices.ContainsFunc(t.Tags, func(tag string) bool { return slices.Contains(doc.Tags, tag) }) {
+			continue
+		}
+		if _, err := runExtraction(ctx, doc, t, "ingest"); err != nil {
+			log.Printf("❌ Extraction Error: %s on %s: %v", t.Name, doc.ID, err)
+			continue
+		}
+		log.Printf("🧾 Extracted %s from %s", t.Name, doc.Filename)
+	}
+}
+
+// handlePutExtractionTemplate saves a template, replacing one of the same
+// name: PUT /extraction-templates/:name {"fields", "workspace", "tags"}.
+func handlePutExtractionTemplate(c *gin.Context) {
+	var body struct {
+		Fields    []extractField `json:"fields"`
+		Workspace string         `json:"workspace"`
+		Tags      []string       `json:"tags"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	if msg := validateFields(body.Fields); msg != "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, msg))
+		return
+	}
+	name := strings.TrimSpace(c.Param("name"))
+	var t extractionTemplate
+	found, err := metaStore.Get("extraction_templates", name, &t)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	if found && t.Owner != "" && t.Owner != requestUser(c) {
+		c.JSON(http.StatusOK, errorReply(c, codeForbidden, "Template belongs to another user"))
+		return
+	}
+	now := time.Now()
+	t = extractionTemplate{
+		Name:      name,
+		Fields:    body.Fields,
+		Workspace: body.Workspace,
+		Tags:      parseTags(strings.Join(body.Tags, ",")),
+		Owner:     cmp.Or(t.Owner, requestUser(c)),
+		CreatedAt: cmp.Or(t.CreatedAt, now),
+		UpdatedAt: now,
+	}
+	if err := metaStore.Put("extraction_templates", name, t); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "template": t})
+}
+
+// handleListExtractionTemplates lists the saved templates:
+// GET /extraction-templates.
+func handleListExtractionTemplates(c *gin.Context) {
+	all, err := metaStore.List("extraction_templates")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	templates := []extractionTemplate{}
+	for _, raw := range all {
+		var t extractionTemplate
+		if json.Unmarshal(raw, &t) == nil {
+			templates = append(templates, t)
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "templates": templates})
+}
+
+// handleDeleteExtractionTemplate removes a template; its past results stay:
+// DELETE /extraction-templates/:name.
+func handleDeleteExtractionTemplate(c *gin.Context) {
+	name := c.Param("name")
+	var t extractionTemplate
+	if found, _ := metaStore.Get("extraction_templates", name, &t); !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Template not found"))
+		return
+	} else if t.Owner != "" && t.Owner != requestUser(c) {
+		c.JSON(http.StatusOK, errorReply(c, codeForbidden, "Template belongs to another user"))
+		return
+	}
+	if err := metaStore.Delete("extraction_templates", name); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Template removed"})
+}
+
+// handleRunExtractionTemplate runs a template against a document and stores
+// the result: POST /documents/:id/extract/:template.
+func handleRunExtractionTemplate(c *gin.Context) {
+	var doc documentRecord
+	if found, err := metaStore.Get("documents", c.Param("id"), &doc); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	} else if !found || (doc.Owner != "" && doc.Owner != requestUser(c)) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document not found"))
+		return
+	}
+	var t extractionTemplate
+	if found, _ := metaStore.Get("extraction_templates", c.Param("template"), &t); !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Template not found"))
+		return
+	}
+	ex, err := runExtraction(c.Request.Context(), doc, t, "request")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "extraction": ex})
+}
+
+// handleListExtractions queries stored results:
+// GET /extractions?template=&document_id=&workspace=&field=&value=. With
+// field and value, only results whose extracted field equals value (compared
+// as text, ignoring case) are listed. Newest first.
+func handleListExtractions(c *gin.Context) {
+	all, err := metaStore.List("extractions")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	template, documentID, ws := c.Query("template"), c.Query("document_id"), c.Query("workspace")
+	field, value := c.Query("field"), c.Query("value")
+	user := requestUser(c)
+	visible := map[string]bool{}
+	results := []extraction{}
+	for _, raw := range all {
+		var ex extraction
+		if json.Unmarshal(raw, &ex) != nil || (template != "" && ex.Template != template) ||
+			(documentID != "" && ex.DocumentID != documentID) || (ws != "" && ex.Workspace != ws) {
+			continue
+		}
+		if field != "" && !strings.EqualFold(fmt.Sprint(ex.Data[field]), value) {
+			continue
+		}
+		ok, checked := visible[ex.DocumentID]
+		if !checked {
+			var doc documentRecord
+			found, _ := metaStore.Get("documents", ex.DocumentID, &doc)
+			ok = found && (doc.Owner == "" || doc.Owner == user)
+			visible[ex.DocumentID] = ok
+		}
+		if !ok {
+			continue
+		}
+		results = append(results, ex)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].CreatedAt.After(results[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "extractions": results})
+}
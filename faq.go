@@ -0,0 +1,462 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+const (
+	faqQuestionsPrompt = "You help write an FAQ. From the numbered excerpts of a knowledge base, write %d questions its readers are most likely to ask, each answerable from the excerpts. Write each as a user would type it."
+	faqRephrasePrompt  = "You help write a public FAQ. Rewrite each numbered question users asked as a general FAQ question on the same subject, leaving out names, figures and any other details particular to the asker. Return one question per number, in order."
+	faqAnswerPrompt    = "You write the canonical answer to an FAQ question. Using only the numbered excerpts, answer the question clearly and completely. Set found to false if the excerpts do not answer it. List the excerpt numbers you used in sources."
+)
+
+// faqEntry is one question of a workspace FAQ with its approved answer.
+// Vector is the question's embedding, used to match it in chat. The question
+// is always the model's wording, never a user's.
+type faqEntry struct {
+	Question  string     `json:"question"`
+	Answer    string     `json:"answer"`
+	Citations []citation `json:"citations"`
+	Asked     int        `json:"asked"`              // past questions it stands for; 0 if generated from content
+	AskedBy   []string   `json:"asked_by,omitempty"` // users who asked them
+	Vector    []float32  `json:"vector,omitempty"`
+}
+
+// faqMu serialises updates of stored FAQs.
+var faqMu sync.Mutex
+
+// faqSet is a workspace's FAQ, kept in the "faq" bucket.
+type faqSet struct {
+	Workspace   string     `json:"workspace"`
+	Entries     []faqEntry `json:"entries"`
+	GeneratedAt time.Time  `json:"generated_at"`
+}
+
+// handleBuildFAQ (re)builds a workspace's FAQ: POST /admin/faq/:workspace
+// {"source": "questions", "content" or "auto", "size"}. Past chat questions
+// are clustered and each cluster asked about at least FAQ_MIN_ASKS (default
+// 2) times gives an entry; with "content", or "auto" when there are too few
+// clusters, questions generated from the workspace's chunks fill the rest.
+// Each question is answered from the documents, and questions that can't be
+// are dropped.
+func handleBuildFAQ(c *gin.Context) {
+	var body struct {
+		Source string `json:"source"`
+		Size   int    `json:"size"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+			return
+		}
+	}
+	body.Source = cmp.Or(body.Source, "auto")
+	if body.Source != "questions" && body.Source != "content" && body.Source != "auto" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, `source must be "questions", "content" or "auto"`))
+		return
+	}
+	if body.Size <= 0 {
+		body.Size = 20
+	}
+	body.Size = min(body.Size, 100)
+	workspace := c.Param("workspace")
+	ctx := c.Request.Context()
+
+	var entries []faqEntry
+	if body.Source != "content" {
+		var err error
+		if entries, err = askedQuestions(ctx, workspace, body.Size); err != nil {
+			c.JSON(http.StatusOK, errorReply(c, codeUpstream, "❌ FAQ Error: "+err.Error()))
+			return
+		}
+	}
+	if body.Source != "questions" && len(entries) < body.Size {
+		generated, err := contentQuestions(ctx, workspace, body.Size-len(entries))
+		if err != nil {
+			c.JSON(http.StatusOK, errorReply(c, codeUpstream, "❌ FAQ Error: "+err.Error()))
+			return
+		}
+		entries = append(entries, generated...)
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, 4)
+	for i := range entries {
+		wg.Go(func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			answerFAQ(ctx, workspace, &entries[i])
+		})
+	}
+	wg.Wait()
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Answer != "" {
+			kept = append(kept, e)
+		}
+	}
+
+	set := faqSet{Workspace: workspace, Entries: kept, GeneratedAt: time.Now()}
+	faqMu.Lock()
+	err := metaStore.Put("faq", workspace, set)
+	faqMu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "faq_build", Workspace: workspace, Detail: fmt.Sprintf("FAQ built with %d entries from %s", len(kept), body.Source)})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "faq": set.public()})
+}
+
+// handleGetFAQ returns a workspace's FAQ: GET /faq?workspace=.
+func handleGetFAQ(c *gin.Context) {
+	var set faqSet
+	if found, err := metaStore.Get("faq", c.DefaultQuery("workspace", "default"), &set); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	} else if !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No FAQ for that workspace"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "faq": set.public()})
+}
+
+// handleDeleteFAQ removes a workspace's FAQ: DELETE /admin/faq/:workspace.
+func handleDeleteFAQ(c *gin.Context) {
+	if err := metaStore.Delete("faq", c.Param("workspace")); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "faq_delete", Workspace: c.Param("workspace"), Detail: "FAQ removed"})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "FAQ removed"})
+}
+
+// public is the FAQ without its embeddings or who asked what.
+func (s faqSet) public() faqSet {
+	entries := make([]faqEntry, len(s.Entries))
+	for i, e := range s.Entries {
+		e.Vector, e.AskedBy = nil, nil
+		entries[i] = e
+	}
+	s.Entries = entries
+	return s
+}
+
+// askedQuestions clusters the workspace's past chat questions, the latest
+// FAQ_MAX_QUESTIONS (default 1000) of them, greedily by embedding similarity
+// of at least FAQ_CLUSTER_SIMILARITY (default 0.85), and returns the size
+// most asked clusters, each led by its most central question as the model
+// rephrases it.
+func askedQuestions(ctx context.Context, workspace string, size int) ([]faqEntry, error) {
+	all, err := metaStore.List("chats")
+	if err != nil {
+		return nil, err
+	}
+	var chats []chatRecord
+	for _, raw := range all {
+		var rec chatRecord
+		if json.Unmarshal(raw, &rec) == nil && rec.Workspace == workspace && len(strings.Fields(rec.Question)) >= 3 {
+			chats = append(chats, rec)
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i].CreatedAt.After(chats[j].CreatedAt) })
+	chats = chats[:min(len(chats), envInt("FAQ_MAX_QUESTIONS", 1000))]
+	if len(chats) == 0 {
+		return nil, nil
+	}
+	questions := make([]string, len(chats))
+	for i, rec := range chats {
+		questions[i] = strings.TrimSpace(rec.Question)
+	}
+	vectors, err := embedTexts(ctx, questions)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := envFloat("FAQ_CLUSTER_SIMILARITY", 0.85)
+	var clusters [][]int
+	for i, v := range vectors {
+		placed := false
+		for k, members := range clusters {
+			if cosine(vectors[members[0]], v) >= threshold {
+				clusters[k] = append(members, i)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			clusters = append(clusters, []int{i})
+		}
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return len(clusters[i]) > len(clusters[j]) })
+
+	var entries []faqEntry
+	for _, members := range clusters {
+		if len(entries) == size || len(members) < envInt("FAQ_MIN_ASKS", 2) {
+			break
+		}
+		best, bestScore := members[0], -1.0
+		for _, m := range members {
+			var score float64
+			for _, o := range members {
+				score += cosine(vectors[m], vectors[o])
+			}
+			if score > bestScore {
+				best, bestScore = m, score
+			}
+		}
+		var askedBy []string
+		for _, m := range members {
+			if owner := chats[m].Owner; owner != "" && !slices.Contains(askedBy, owner) {
+				askedBy = append(askedBy, owner)
+			}
+		}
+		entries = append(entries, faqEntry{Question: questions[best], Asked: len(members), AskedBy: askedBy, Vector: vectors[best]})
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	if err := rephraseQuestions(ctx, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// rephraseQuestions replaces the entries' questions, which users asked, with
+// general ones the model writes on the same subjects, so the public FAQ
+// never repeats what anyone typed.
+func rephraseQuestions(ctx context.Context, entries []faqEntry) error {
+	var asked strings.Builder
+	for i, e := range entries {
+		fmt.Fprintf(&asked, "[%d] %s\n", i+1, e.Question)
+	}
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"questions": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+		"required":             []string{"questions"},
+		"additionalProperties": false,
+	})
+	raw, err := completeStructured(ctx, faqRephrasePrompt+"\n\nQuestions:\n"+asked.String(), "faq_rephrased", schema)
+	if err != nil {
+		return err
+	}
+	var out struct {
+		Questions []string `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return err
+	}
+	if len(out.Questions) != len(entries) {
+		return fmt.Errorf("rephrased %d of %d questions", len(out.Questions), len(entries))
+	}
+	for i, q := range out.Questions {
+		if q = strings.TrimSpace(q); q == "" {
+			return fmt.Errorf("question %d came back empty", i+1)
+		}
+		entries[i].Question = q
+	}
+	return nil
+}
+
+// contentQuestions has the model write n likely questions from chunks spread
+// through the workspace's documents that nobody owns, since the FAQ is
+// public.
+func contentQuestions(ctx context.Context, workspace string, n int) ([]faqEntry, error) {
+	var points []*pb.RetrievedPoint
+	public := documentVisibleTo("")
+	err := scrollPoints(ctx, andFilters(workspacesFilter([]string{workspace}), enabledFilter, validAtFilter(time.Now())), true, func(batch []*pb.RetrievedPoint) error {
+		for _, p := range batch {
+			if public(payloadString(p.Payload, "document_id")) {
+				points = append(points, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, nil
+	}
+	points = spreadPoints(points, 30)
+	var excerpts strings.Builder
+	for i, p := range points {
+		fmt.Fprintf(&excerpts, "[%d] %s\n\n", i+1, payloadString(p.Payload, "text"))
+	}
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"questions": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+		},
+		"required":             []string{"questions"},
+		"additionalProperties": false,
+	})
+	raw, err := completeStructured(ctx, fmt.Sprintf(faqQuestionsPrompt, n)+"\n\nExcerpts:\n"+excerpts.String(), "faq_questions", schema)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Questions []string `json:"questions"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, err
+	}
+	var questions []string
+	for _, q := range out.Questions {
+		if q = strings.TrimSpace(q); q != "" && len(questions) < n {
+			questions = append(questions, q)
+		}
+	}
+	if len(questions) == 0 {
+		return nil, nil
+	}
+	vectors, err := embedTexts(ctx, questions)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]faqEntry, len(questions))
+	for i, q := range questions {
+		entries[i] = faqEntry{Question: q, Vector: vectors[i]}
+	}
+	return entries, nil
+}
+
+// answerFAQ fills in an entry's answer from the workspace's documents that
+// nobody owns, leaving it empty if they don't answer it.
+func answerFAQ(ctx context.Context, workspace string, e *faqEntry) {
+	// twice the hits, leaving room for chunks of owned documents
+	hits, err := searchChunks(ctx, e.Vector, 8, andFilters(workspacesFilter([]string{workspace}), validAtFilter(time.Now())))
+	if err != nil {
+		return
+	}
+	public := documentVisibleTo("")
+	hits = slices.DeleteFunc(hits, func(hit *pb.ScoredPoint) bool { return !public(payloadString(hit.Payload, "document_id")) })
+	if len(hits) == 0 {
+		return
+	}
+	hits = hits[:min(len(hits), 4)]
+	var excerpts strings.Builder
+	for i, hit := range hits {
+		fmt.Fprintf(&excerpts, "[%d] %s\n\n", i+1, payloadString(hit.Payload, "text"))
+	}
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"found":   map[string]any{"type": "boolean"},
+			"answer":  map[string]any{"type": "string"},
+			"sources": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+		},
+		"required":             []string{"found", "answer", "sources"},
+		"additionalProperties": false,
+	})
+	prompt := faqAnswerPrompt + languageInstruction(answerLanguage(e.Question)) + "\n\nQuestion: " + e.Question + "\n\nExcerpts:\n" + excerpts.String()
+	raw, err := completeStructured(ctx, prompt, "faq_answer", schema)
+	if err != nil {
+		recordError("faq", err)
+		return
+	}
+	var out struct {
+		Found   bool   `json:"found"`
+		Answer  string `json:"answer"`
+		Sources []int  `json:"sources"`
+	}
+	if json.Unmarshal([]byte(raw), &out) != nil || !out.Found {
+		return
+	}
+	e.Answer = filterAnswer(workspace, strings.TrimSpace(out.Answer))
+	e.Citations = []citation{}
+	for _, n := range out.Sources {
+		if n >= 1 && n <= len(hits) {
+			e.Citations = append(e.Citations, newCitation(hits[n-1]))
+		}
+	}
+}
+
+// forgetFAQ removes the entries drop picks from every workspace's FAQ and
+// returns how many it removed.
+func forgetFAQ(drop func(faqEntry) bool) (int, error) {
+	faqMu.Lock()
+	defer faqMu.Unlock()
+	all, err := metaStore.List("faq")
+	if err != nil {
+		return 0, err
+	}
+	dropped := 0
+	for workspace, raw := range all {
+		var set faqSet
+		if json.Unmarshal(raw, &set) != nil {
+			continue
+		}
+		kept := slices.DeleteFunc(slices.Clone(set.Entries), drop)
+		if len(kept) == len(set.Entries) {
+			continue
+		}
+		dropped += len(set.Entries) - len(kept)
+		set.Entries = kept
+		if err := metaStore.Put("faq", workspace, set); err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+// forgetFAQChunks drops FAQ entries citing chunks about to be deleted, whose
+// answers may no longer hold.
+func forgetFAQChunks(chunkIDs map[string]bool) error {
+	_, err := forgetFAQ(func(e faqEntry) bool {
+		return slices.ContainsFunc(e.Citations, func(ct citation) bool { return chunkIDs[ct.PointID] })
+	})
+	return err
+}
+
+// workspaceFAQ loads a workspace's FAQ, if it has one.
+func workspaceFAQ(workspace string) (faqSet, bool) {
+	var set faqSet
+	found, _ := metaStore.Get("faq", workspace, &set)
+	return set, found && len(set.Entries) > 0
+}
+
+// match finds the entry closest to a question's embedding, if it is at least
+// FAQ_MATCH_SIMILARITY (default 0.92) similar.
+func (s faqSet) match(vector []float32) (faqEntry, bool) {
+	var best faqEntry
+	bestScore := envFloat("FAQ_MATCH_SIMILARITY", 0.92)
+	matched := false
+	for _, e := range s.Entries {
+		if score := cosine(e.Vector, vector); score >= bestScore {
+			best, bestScore, matched = e, score, true
+		}
+	}
+	return best, matched
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
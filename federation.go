@@ -0,0 +1,136 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// federatedCollections are the collections (or aliases) besides the serving
+// one a chat may also search, from FEDERATED_COLLECTIONS; none by default.
+func federatedCollections() []string {
+	var names []string
+	for _, n := range strings.Split(os.Getenv("FEDERATED_COLLECTIONS"), ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// workspaceGrants are the workspaces each user may search besides a chat's
+// own, from WORKSPACE_GRANTS ("alice=legal|finance;bob=hr"), where "*"
+// grants to every user; none by default.
+func workspaceGrants(user string) []string {
+	var granted []string
+	for _, entry := range strings.Split(os.Getenv("WORKSPACE_GRANTS"), ";") {
+		who, workspaces, ok := strings.Cut(entry, "=")
+		if who = strings.TrimSpace(who); !ok || (who != "*" && who != user) {
+			continue
+		}
+		for _, w := range strings.Split(workspaces, "|") {
+			if w = strings.TrimSpace(w); w != "" {
+				granted = append(granted, w)
+			}
+		}
+	}
+	return granted
+}
+
+// chatWorkspaces are the workspaces a chat in workspace searches: the
+// requested ones, each of which must be the chat's own or granted to user,
+// or else just its own.
+func chatWorkspaces(user, workspace string, requested []string) ([]string, error) {
+	workspace = cmp.Or(workspace, "default")
+	if len(requested) == 0 {
+		return []string{workspace}, nil
+	}
+	granted := workspaceGrants(user)
+	for _, w := range requested {
+		if w != workspace && !slices.Contains(granted, w) {
+			return nil, fmt.Errorf("workspace %s is not available", w)
+		}
+	}
+	return requested, nil
+}
+
+// collectionModel is the embedding model a collection was built with.
+func collectionModel(collection string) embeddingConfig {
+	if collection == collectionName {
+		return currentEmbedding()
+	}
+	var model embeddingConfig
+	if found, _ := metaStore.Get("collection_models", collection, &model); found {
+		return model
+	}
+	return currentEmbedding()
+}
+
+// federatedSearch searches the serving collection and the given ones, each
+// with the question embedded by that collection's own model, and merges the
+// hits. Raw scores from different
+// collections aren't comparable, so each collection's are min-max normalised
+// to 0..1 first. Hits from other collections are tagged with it in the
+// payload (not stored) so citations can say where they came from. vector is the question
+// embedded for the serving collection.
+func federatedSearch(ctx context.Context, question string, vector []float32, collections []string, limit uint64, filter *pb.Filter) ([]*pb.ScoredPoint, error) {
+	allowed := federatedCollections()
+	vectors := map[embeddingConfig][]float32{currentEmbedding(): vector}
+	var merged []*pb.ScoredPoint
+	if !slices.Contains(collections, collectionName) {
+		collections = append([]string{collectionName}, collections...)
+	}
+	for _, collection := range collections {
+		if collection != collectionName && !slices.Contains(allowed, collection) {
+			return nil, fmt.Errorf("collection %s is not available", collection)
+		}
+		model := collectionModel(collection)
+		v, ok := vectors[model]
+		if !ok {
+			embedded, err := embedTextsWith(ctx, model, []string{question})
+			if err != nil {
+				return nil, err
+			}
+			v, vectors[model] = embedded[0], embedded[0]
+		}
+		hits, err := searchCollection(ctx, collection, v, limit, filter)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", collection, err)
+		}
+		normalizeScores(hits)
+		for _, h := range hits {
+			if collection != collectionName {
+				h.Payload["collection"] = pb.NewValueString(collection)
+			}
+		}
+		merged = append(merged, hits...)
+	}
+	slices.SortStableFunc(merged, func(a, b *pb.ScoredPoint) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return merged, nil
+}
+
+// normalizeScores rescales hits' scores to 0..1 between the lowest and
+// highest; a lone hit, or hits all scoring the same, get 1.
+func normalizeScores(hits []*pb.ScoredPoint) {
+	if len(hits) == 0 {
+		return
+	}
+	lo, hi := hits[0].Score, hits[0].Score
+	for _, h := range hits {
+		lo, hi = min(lo, h.Score), max(hi, h.Score)
+	}
+	for _, h := range hits {
+		if hi == lo {
+			h.Score = 1
+		} else {
+			h.Score = (h.Score - lo) / (hi - lo)
+		}
+	}
+}
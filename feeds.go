@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// feedSubscription is an RSS or Atom feed polled into a workspace. New
+// entries are ingested, with the full text of the page they link to when
+// FullText is set; entries older than the retention period are expired.
+// Subscriptions are kept in the "feeds" bucket and each one's entries in
+// "feed_entries:<id>".
+type feedSubscription struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	Workspace     string    `json:"workspace"`
+	Title         string    `json:"title,omitempty"` // the feed's own, from the last poll
+	Tags          []string  `json:"tags,omitempty"`
+	FullText      bool      `json:"full_text"`
+	RetentionDays int       `json:"retention_days,omitempty"` // FEED_RETENTION_DAYS when 0
+	CreatedAt     time.Time `json:"created_at"`
+	PolledAt      time.Time `json:"polled_at,omitzero"`
+	Entries       int       `json:"entries"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// feedEntryState is what polling remembers about an entry.
+type feedEntryState struct {
+	DocumentID string    `json:"document_id,omitempty"`
+	Published  time.Time `json:"published"` // or when it was first seen, for undated entries
+	UpdatedAt  time.Time `json:"updated_at"`
+	Expired    bool      `json:"expired,omitempty"`
+}
+
+// feedPoll reports one poll of a feed.
+type feedPoll struct {
+	Entries int    `json:"entries"`
+	Added   int    `json:"added"`
+	Updated int    `json:"updated"`
+	Expired int    `json:"expired"`
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// feedEntry is an entry of either kind of feed.
+type feedEntry struct {
+	ID         string
+	Title      string
+	Link       string
+	Content    string // HTML
+	Author     string
+	Categories []string
+	Published  time.Time
+	Updated    time.Time
+}
+
+// feedRetention is how many days a feed keeps its entries: its own
+// setting, then FEED_RETENTION_DAYS (per workspace with a _<WORKSPACE>
+// suffix). 0 keeps them until the workspace's document retention.
+func feedRetention(f feedSubscription) int {
+	if f.RetentionDays > 0 {
+		return f.RetentionDays
+	}
+	n, _ := strconv.Atoi(envWorkspace("FEED_RETENTION_DAYS", f.Workspace))
+	return n
+}
+
+// feedPolls keeps two polls of one feed from overlapping.
+var feedPolls sync.Map
+
+// pollFeeds polls every subscription; the scheduler runs it every
+// FEED_POLL_MINUTES.
+func pollFeeds(ctx context.Context) {
+	feeds, err := feedSubscriptions("")
+	if err != nil {
+		log.Printf("❌ Feed Poll Error: %v", err)
+		return
+	}
+	for _, f := range feeds {
+		p := pollFeed(ctx, f)
+		if p.Error != "" {
+			log.Printf("❌ Feed Poll Error (%s): %s", f.URL, p.Error)
+		} else if p.Added+p.Updated+p.Expired > 0 {
+			log.Printf("📰 Polled %s: %d added, %d updated, %d expired", f.URL, p.Added, p.Updated, p.Expired)
+		}
+	}
+}
+
+// pollFeed ingests a feed's new and changed entries and expires those past
+// its retention, recording the outcome on the subscription.
+func pollFeed(ctx context.Context, f feedSubscription) (p feedPoll) {
+	if _, running := feedPolls.LoadOrStore(f.ID, true); running {
+		p.Error = "a poll is already running"
+		return p
+	}
+	defer feedPolls.Delete(f.ID)
+	defer func() {
+		// the subscription may have been removed while polling
+		var current feedSubscription
+		if found, _ := metaStore.Get("feeds", f.ID, &current); !found {
+			return
+		}
+		current.Title, current.PolledAt, current.Entries, current.Error = f.Title, time.Now(), p.Entries, p.Error
+		if err := metaStore.Put("feeds", f.ID, current); err != nil {
+			log.Printf("❌ Metadata Store Error: %v", err)
+		}
+	}()
+
+	body, err := connectorGet(ctx, f.URL, func(req *http.Request) {
+		req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	})
+	if err != nil {
+		p.Error = err.Error()
+		return p
+	}
+	title, entries, err := parseFeed(body)
+	if err != nil {
+		p.Error = "Feed Parse Error: " + err.Error()
+		return p
+	}
+	f.Title = cmp.Or(title, f.Title)
+	p.Entries = len(entries)
+
+	bucket := "feed_entries:" + f.ID
+	all, err := metaStore.List(bucket)
+	if err != nil {
+		p.Error = "Metadata Store Error: " + err.Error()
+		return p
+	}
+	known := map[string]feedEntryState{}
+	for key, raw := range all {
+		var s feedEntryState
+		if json.Unmarshal(raw, &s) == nil {
+			known[key] = s
+		}
+	}
+	var cutoff time.Time
+	if days := feedRetention(f); days > 0 {
+		cutoff = time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	}
+	base, _ := url.Parse(f.URL)
+
+	seen := map[string]bool{}
+	for _, e := range entries {
+		key := feedEntryKey(e)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		prev, ok := known[key]
+		published := e.Published
+		if published.IsZero() {
+			published = cmp.Or(prev.Published, time.Now())
+		}
+		updated := cmp.Or(e.Updated, e.Published)
+		if prev.Expired || (ok && prev.UpdatedAt.Equal(updated)) || (!cutoff.IsZero() && published.Before(cutoff)) {
+			continue
+		}
+		if link, err := base.Parse(e.Link); err == nil && e.Link != "" {
+			e.Link = link.String()
+		}
+		documentID, err := ingestConnectorItem(ctx, f.Workspace, "feed", feedItem(ctx, f, e, published))
+		if err != nil {
+			log.Printf("❌ Feed Ingest Error (%s %s): %v", f.URL, cmp.Or(e.Link, e.ID), err)
+			p.Failed++
+			continue
+		}
+		if err := metaStore.Put(bucket, key, feedEntryState{DocumentID: documentID, Published: published, UpdatedAt: updated}); err != nil {
+			log.Printf("❌ Metadata Store Error: %v", err)
+		}
+		if ok {
+			removeConnectorDocument(ctx, prev.DocumentID)
+			p.Updated++
+		} else {
+			p.Added++
+		}
+	}
+
+	for key, s := range known {
+		if s.Expired {
+			if !seen[key] {
+				metaStore.Delete(bucket, key) // out of the feed, so it can't come back
+			}
+			continue
+		}
+		if cutoff.IsZero() || !s.Published.Before(cutoff) {
+			continue
+		}
+		removeConnectorDocument(ctx, s.DocumentID)
+		if seen[key] {
+			// remembered while the feed still lists it, so it isn't ingested again
+			metaStore.Put(bucket, key, feedEntryState{Published: s.Published, UpdatedAt: s.UpdatedAt, Expired: true})
+		} else {
+			metaStore.Delete(bucket, key)
+		}
+		p.Expired++
+	}
+	return p
+}
+
+// feedEntryKey names an entry by its GUID or link, hashed to keep keys
+// plain.
+func feedEntryKey(e feedEntry) string {
+	sum := sha256.Sum256([]byte(cmp.Or(e.ID, e.Link, e.Title)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// feedItem turns an entry into a document: the readable text of the page it
+// links to when the feed wants full text and the page has more to say than
+// the feed does, otherwise the entry's own content.
+func feedItem(ctx context.Context, f feedSubscription, e feedEntry, published time.Time) connectorItem {
+	text := htmlToText(e.Content)
+	if f.FullText && e.Link != "" {
+		page, err := pageGet(ctx, e.Link, func(req *http.Request) {
+			req.Header.Set("Accept", "text/html, application/xhtml+xml;q=0.9, */*;q=0.8")
+		})
+		if err != nil {
+			log.Printf("⚠️ Feed Full Text Error (%s): %v", e.Link, err)
+		} else if full := readableText(string(page)); len(full) > len(text) {
+			text = full
+		}
+	}
+	title := cmp.Or(strings.TrimSpace(e.Title), e.Link)
+	meta := map[string]string{"feed": f.ID}
+	if f.Title != "" {
+		meta["feed_title"] = f.Title
+	}
+	if e.Author != "" {
+		meta["author"] = e.Author
+	}
+	return connectorItem{
+		ID:        e.ID,
+		Title:     title,
+		URL:       e.Link,
+		Text:      title + "\n\n" + text,
+		Tags:      append(slices.Clone(f.Tags), e.Categories...),
+		Metadata:  meta,
+		Date:      published,
+		UpdatedAt: cmp.Or(e.Updated, e.Published),
+	}
+}
+
+var (
+	readableNoiseRes = func() []*regexp.Regexp {
+		var res []*regexp.Regexp
+		for _, tag := range []string{"script", "style", "noscript", "template", "svg", "iframe", "nav", "header", "footer", "aside", "form", "button"} {
+			res = append(res, regexp.MustCompile(`(?is)<`+tag+`\b[^>]*>.*?</`+tag+`\s*>`))
+		}
+		return append(res, regexp.MustCompile(`(?s)<!--.*?-->`))
+	}()
+	articleRe = regexp.MustCompile(`(?is)<article\b[^>]*>(.*?)</article\s*>`)
+	mainRe    = regexp.MustCompile(`(?is)<main\b[^>]*>(.*?)</main\s*>`)
+	bodyRe    = regexp.MustCompile(`(?is)<body\b[^>]*>(.*)</body\s*>`)
+)
+
+// readableText extracts the main text of a web page, readability style:
+// scripts, navigation, headers, footers and sidebars are dropped, and the
+// longest <article>, else <main>, else the body, is kept.
+func readableText(page string) string {
+	for _, re := range readableNoiseRes {
+		page = re.ReplaceAllString(page, "")
+	}
+	for _, re := range []*regexp.Regexp{articleRe, mainRe, bodyRe} {
+		best := ""
+		for _, m := range re.FindAllStringSubmatch(page, -1) {
+			if text := htmlToText(m[1]); len(text) > len(best) {
+				best = text
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return htmlToText(page)
+}
+
+// parseFeed reads an RSS 2.0, RSS 1.0 or Atom document, returning the
+// feed's title and entries.
+func parseFeed(body []byte) (string, []feedEntry, error) {
+	type text struct {
+		Type  string `xml:"type,attr"`
+		Text  string `xml:",chardata"`
+		Inner string `xml:",innerxml"`
+	}
+	html := func(t text) string {
+		if t.Type == "xhtml" {
+			return t.Inner
+		}
+		return t.Text
+	}
+	type rssItem struct {
+		Title       string   `xml:"title"`
+		Link        string   `xml:"link"`
+		GUID        string   `xml:"guid"`
+		PubDate     string   `xml:"pubDate"`
+		DCDate      string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+		Description string   `xml:"description"`
+		Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+		Author      string   `xml:"author"`
+		Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Categories  []string `xml:"category"`
+	}
+	var doc struct {
+		XMLName xml.Name
+		Title   text `xml:"title"`
+		Channel struct {
+			Title string    `xml:"title"`
+			Items []rssItem `xml:"item"`
+		} `xml:"channel"`
+		Items   []rssItem `xml:"item"` // RSS 1.0 lists items beside the channel
+		Entries []struct {
+			ID    string `xml:"id"`
+			Title text   `xml:"title"`
+			Links []struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+			} `xml:"link"`
+			Published  string `xml:"published"`
+			Updated    string `xml:"updated"`
+			Summary    text   `xml:"summary"`
+			Content    text   `xml:"content"`
+			Author     string `xml:"author>name"`
+			Categories []struct {
+				Term string `xml:"term,attr"`
+			} `xml:"category"`
+		} `xml:"entry"`
+	}
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.Strict = false
+	d.CharsetReader = feedCharset
+	if err := d.Decode(&doc); err != nil {
+		return "", nil, err
+	}
+
+	var entries []feedEntry
+	switch doc.XMLName.Local {
+	case "rss", "RDF":
+		for _, it := range append(doc.Channel.Items, doc.Items...) {
+			date := parseFeedDate(cmp.Or(it.PubDate, it.DCDate))
+			entries = append(entries, feedEntry{
+				ID:         strings.TrimSpace(it.GUID),
+				Title:      it.Title,
+				Link:       strings.TrimSpace(it.Link),
+				Content:    cmp.Or(it.Content, it.Description),
+				Author:     strings.TrimSpace(cmp.Or(it.Creator, it.Author)),
+				Categories: it.Categories,
+				Published:  date,
+			})
+		}
+		return strings.TrimSpace(doc.Channel.Title), entries, nil
+	case "feed":
+		for _, en := range doc.Entries {
+			e := feedEntry{
+				ID:        strings.TrimSpace(en.ID),
+				Title:     htmlToText(html(en.Title)),
+				Content:   cmp.Or(html(en.Content), html(en.Summary)),
+				Author:    strings.TrimSpace(en.Author),
+				Published: parseFeedDate(en.Published),
+				Updated:   parseFeedDate(en.Updated),
+			}
+			for _, l := range en.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					e.Link = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			for _, c := range en.Categories {
+				e.Categories = append(e.Categories, c.Term)
+			}
+			if e.Published.IsZero() {
+				e.Published = e.Updated
+			}
+			entries = append(entries, e)
+		}
+		return htmlToText(html(doc.Title)), entries, nil
+	}
+	return "", nil, fmt.Errorf("not an RSS or Atom feed: <%s>", doc.XMLName.Local)
+}
+
+var feedDateLayouts = []string{
+	time.RFC1123Z, time.RFC1123, time.RFC3339, time.RFC3339Nano,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04 -0700", "2006-01-02",
+}
+
+// parseFeedDate reads the RFC 822 dates of RSS and the RFC 3339 ones of
+// Atom, in the variants feeds actually use; zero if none fits.
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// feedCharset decodes the single-byte charsets older feeds declare; the
+// decoder reads UTF-8 itself.
+func feedCharset(label string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "iso-8859-1", "latin1", "windows-1252", "us-ascii":
+		raw, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		runes := make([]rune, len(raw))
+		for i, b := range raw {
+			runes[i] = rune(b)
+		}
+		return strings.NewReader(string(runes)), nil
+	}
+	return nil, fmt.Errorf("unsupported charset %q", label)
+}
+
+// feedSubscriptions lists the subscriptions, of one workspace when it isn't
+// empty.
+func feedSubscriptions(workspace string) ([]feedSubscription, error) {
+	all, err := metaStore.List("feeds")
+	if err != nil {
+		return nil, err
+	}
+	feeds := []feedSubscription{}
+	for _, raw := range all {
+		var f feedSubscription
+		if json.Unmarshal(raw, &f) == nil && (workspace == "" || f.Workspace == workspace) {
+			feeds = append(feeds, f)
+		}
+	}
+	sort.Slice(feeds, func(i, j int) bool { return feeds[i].CreatedAt.Before(feeds[j].CreatedAt) })
+	return feeds, nil
+}
+
+// handleListFeeds lists feed subscriptions: GET /admin/feeds?workspace=.
+func handleListFeeds(c *gin.Context) {
+	feeds, err := feedSubscriptions(c.Query("workspace"))
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "feeds": feeds})
+}
+
+// handleAddFeed subscribes a workspace to a feed and polls it once:
+// POST /admin/feeds {"url", "workspace", "tags", "full_text",
+// "retention_days"}. full_text, on by default, ingests the page each entry
+// links to rather than the feed's summary of it.
+func handleAddFeed(c *gin.Context) {
+	var body struct {
+		URL           string   `json:"url"`
+		Workspace     string   `json:"workspace"`
+		Tags          []string `json:"tags"`
+		FullText      *bool    `json:"full_text"`
+		RetentionDays int      `json:"retention_days"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	u, err := url.Parse(strings.TrimSpace(body.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "url must be an http or https feed address"))
+		return
+	}
+	if body.RetentionDays < 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "retention_days can't be negative"))
+		return
+	}
+	f := feedSubscription{
+		ID:            uuid.New().String(),
+		URL:           u.String(),
+		Workspace:     cmp.Or(body.Workspace, "default"),
+		Tags:          parseTags(strings.Join(body.Tags, ",")),
+		FullText:      body.FullText == nil || *body.FullText,
+		RetentionDays: body.RetentionDays,
+		CreatedAt:     time.Now(),
+	}
+	if err := metaStore.Put("feeds", f.ID, f); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "feed_add", Workspace: f.Workspace, Detail: "subscribed to " + f.URL})
+	p := pollFeed(c.Request.Context(), f)
+	metaStore.Get("feeds", f.ID, &f)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "feed": f, "poll": p})
+}
+
+// handlePollFeed polls a feed now, waiting for it to finish:
+// POST /admin/feeds/:id/poll.
+func handlePollFeed(c *gin.Context) {
+	var f feedSubscription
+	if found, _ := metaStore.Get("feeds", c.Param("id"), &f); !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Feed not found"))
+		return
+	}
+	p := pollFeed(c.Request.Context(), f)
+	audit(auditEntry{
+		Action:    "feed_poll",
+		Workspace: f.Workspace,
+		Detail:    fmt.Sprintf("polled %s: %d added, %d updated, %d expired, %d failed", f.URL, p.Added, p.Updated, p.Expired, p.Failed),
+	})
+	if p.Error != "" {
+		resp := upstreamReply(c, upstreamWeb, p.Error)
+		resp["poll"] = p
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "poll": p})
+}
+
+// handleDeleteFeed unsubscribes from a feed: DELETE /admin/feeds/:id. The
+// entries already ingested stay, until their workspace's retention, unless
+// ?purge=true.
+func handleDeleteFeed(c *gin.Context) {
+	var f feedSubscription
+	if found, _ := metaStore.Get("feeds", c.Param("id"), &f); !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Feed not found"))
+		return
+	}
+	if err := metaStore.Delete("feeds", f.ID); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	bucket := "feed_entries:" + f.ID
+	purge := c.Query("purge") == "true"
+	purged := 0
+	all, _ := metaStore.List(bucket)
+	for key, raw := range all {
+		var s feedEntryState
+		if purge && json.Unmarshal(raw, &s) == nil && s.DocumentID != "" {
+			removeConnectorDocument(c.Request.Context(), s.DocumentID)
+			purged++
+		}
+		metaStore.Delete(bucket, key)
+	}
+	audit(auditEntry{Action: "feed_remove", Workspace: f.Workspace, Detail: fmt.Sprintf("unsubscribed from %s, %d entries purged", f.URL, purged)})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Feed removed", "purged": purged})
+}
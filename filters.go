@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// outputRule rewrites generated answers: every match of Pattern, or of any
+// of Words as a whole word in any case, becomes Replace. An empty Replace
+// masks the match with asterisks.
+type outputRule struct {
+	Pattern string   `json:"pattern,omitempty"`
+	Words   []string `json:"words,omitempty"`
+	Replace string   `json:"replace,omitempty"`
+	re      *regexp.Regexp
+}
+
+// outputRules are a workspace's answer filters: a JSON list of rules in
+// OUTPUT_FILTERS_<WORKSPACE> or OUTPUT_FILTERS, or in the file named by
+// OUTPUT_FILTERS_FILE_<WORKSPACE> or OUTPUT_FILTERS_FILE. Rules that don't
+// compile are logged and skipped; the parsed rules are cached per setting.
+func outputRules(workspace string) []outputRule {
+	raw := envWorkspace("OUTPUT_FILTERS", workspace)
+	if raw == "" {
+		if path := envWorkspace("OUTPUT_FILTERS_FILE", workspace); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("❌ Output Filter Error: %v", err)
+				return nil
+			}
+			raw = string(data)
+		}
+	}
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	if rules, ok := outputRuleCache.Load(raw); ok {
+		return rules.([]outputRule)
+	}
+	var parsed []outputRule
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		log.Printf("❌ Output Filter Error: %v", err)
+		return nil
+	}
+	rules := parsed[:0]
+	for _, r := range parsed {
+		pattern := r.Pattern
+		if len(r.Words) > 0 {
+			quoted := make([]string, len(r.Words))
+			for i, w := range r.Words {
+				quoted[i] = regexp.QuoteMeta(strings.TrimSpace(w))
+			}
+			pattern = `(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil || pattern == "" {
+			log.Printf("❌ Output Filter Error: bad rule %q: %v", pattern, err)
+			continue
+		}
+		r.re = re
+		rules = append(rules, r)
+	}
+	outputRuleCache.Store(raw, rules)
+	return rules
+}
+
+var outputRuleCache sync.Map
+
+// filterAnswer applies the workspace's output rules to a generated answer.
+func filterAnswer(workspace, answer string) string {
+	for _, r := range outputRules(workspace) {
+		if r.Replace != "" {
+			answer = r.re.ReplaceAllString(answer, r.Replace)
+			continue
+		}
+		answer = r.re.ReplaceAllStringFunc(answer, func(m string) string {
+			return strings.Repeat("*", len([]rune(m)))
+		})
+	}
+	return answer
+}
+
+// answerFilter filters a streamed answer a sentence at a time, so rules
+// apply to the tokens sent as well as the final answer. Matches spanning a
+// sentence break are only caught in the final answer.
+type answerFilter struct {
+	workspace string
+	active    bool
+	pending   strings.Builder
+}
+
+func newAnswerFilter(workspace string) *answerFilter {
+	return &answerFilter{workspace: workspace, active: len(outputRules(workspace)) > 0}
+}
+
+// Add takes a token and returns the filtered text ready to send, if any.
+func (f *answerFilter) Add(token string) string {
+	if !f.active {
+		return token
+	}
+	f.pending.WriteString(token)
+	text := f.pending.String()
+	cut := -1
+	for _, sep := range []string{". ", "! ", "? ", "\n"} {
+		if i := strings.LastIndex(text, sep); i >= 0 {
+			cut = max(cut, i+len(sep))
+		}
+	}
+	if cut < 0 {
+		return ""
+	}
+	f.pending.Reset()
+	f.pending.WriteString(text[cut:])
+	return filterAnswer(f.workspace, text[:cut])
+}
+
+// Flush returns whatever is still held back, filtered.
+func (f *answerFilter) Flush() string {
+	text := f.pending.String()
+	f.pending.Reset()
+	if !f.active {
+		return text
+	}
+	return filterAnswer(f.workspace, text)
+}
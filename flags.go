@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// featureFlag gates an optional pipeline stage. A stage whose flag isn't
+// defined runs as its own settings say; once defined, it runs for the
+// listed workspaces ("*" for all) and for Percent of the remaining traffic,
+// unless Off is set. Flags come from FEATURE_FLAGS (a JSON list) and the
+// "flags" bucket, the stored ones taking precedence, so they can be changed
+// without a redeploy.
+type featureFlag struct {
+	Name       string    `json:"name"`
+	Workspaces []string  `json:"workspaces,omitempty"`
+	Percent    int       `json:"percent"`
+	Off        bool      `json:"off,omitempty"` // kill switch
+	UpdatedAt  time.Time `json:"updated_at,omitzero"`
+}
+
+// Pipeline stages gated by flags.
+const (
+	flagIntentRouter     = "intent_router"
+	flagFAQ              = "faq"
+	flagRetrievers       = "retrievers"
+	flagWebSearch        = "web_search"
+	flagTranslateContext = "translate_context"
+	flagHighlights       = "highlights" // opt-in: off until defined
+)
+
+var knownFlags = []string{flagIntentRouter, flagFAQ, flagRetrievers, flagWebSearch, flagTranslateContext, flagHighlights}
+
+func featureFlags() map[string]featureFlag {
+	flags := map[string]featureFlag{}
+	if raw := os.Getenv("FEATURE_FLAGS"); raw != "" {
+		var list []featureFlag
+		if err := json.Unmarshal([]byte(raw), &list); err != nil {
+			log.Printf("❌ Feature Flag Error: %v", err)
+		}
+		for _, f := range list {
+			flags[f.Name] = f
+		}
+	}
+	stored, err := metaStore.List("flags")
+	if err != nil {
+		log.Printf("❌ Feature Flag Error: %v", err)
+	}
+	for name, raw := range stored {
+		var f featureFlag
+		if json.Unmarshal(raw, &f) == nil {
+			flags[name] = f
+		}
+	}
+	return flags
+}
+
+// on reports whether the flag is on for a workspace and a rollout key,
+// which always lands in the same bucket.
+func (f featureFlag) on(workspace, key string) bool {
+	if f.Off {
+		return false
+	}
+	if slices.Contains(f.Workspaces, "*") || slices.Contains(f.Workspaces, workspace) {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(f.Name + ":" + key))
+	return int(h.Sum32()%100) < f.Percent
+}
+
+// requestFlags evaluates flags for one chat. Seen records every flag
+// checked and its outcome, for the chat record.
+type requestFlags struct {
+	flags     map[string]featureFlag
+	workspace string
+	key       string
+	Seen      map[string]bool
+}
+
+// flagsFor loads the flags for a chat, bucketed by user, or by session for
+// anonymous callers.
+func flagsFor(workspace, user, sessionID string) *requestFlags {
+	key := user
+	if key == "" {
+		key = sessionID
+	}
+	return &requestFlags{flags: featureFlags(), workspace: workspace, key: key, Seen: map[string]bool{}}
+}
+
+func (r *requestFlags) On(name string) bool {
+	if _, ok := r.flags[name]; !ok {
+		return true
+	}
+	return r.OptIn(name)
+}
+
+// OptIn is On for a stage that is off until its flag is defined.
+func (r *requestFlags) OptIn(name string) bool {
+	f, ok := r.flags[name]
+	if !ok {
+		return false
+	}
+	on := f.on(r.workspace, r.key)
+	r.Seen[name] = on
+	return on
+}
+
+// handleListFlags lists the defined flags and the stages that can be
+// flagged: GET /admin/flags.
+func handleListFlags(c *gin.Context) {
+	flags := featureFlags()
+	list := make([]featureFlag, 0, len(flags))
+	for _, f := range flags {
+		list = append(list, f)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "flags": list, "stages": knownFlags})
+}
+
+// handlePutFlag defines or replaces a flag: PUT /admin/flags/:name. Only
+// the stages in knownFlags have flags.
+func handlePutFlag(c *gin.Context) {
+	if !slices.Contains(knownFlags, c.Param("name")) {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, fmt.Sprintf("Unknown flag %q; flags are %s", c.Param("name"), strings.Join(knownFlags, ", "))))
+		return
+	}
+	var f featureFlag
+	if err := c.BindJSON(&f); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	if f.Percent < 0 || f.Percent > 100 {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "percent must be between 0 and 100"))
+		return
+	}
+	f.Name = c.Param("name")
+	f.UpdatedAt = time.Now()
+	if err := metaStore.Put("flags", f.Name, f); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "flag_update", Detail: fmt.Sprintf("flag %s: %d%%, workspaces %v, off %v", f.Name, f.Percent, f.Workspaces, f.Off)})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "flag": f})
+}
+
+// handleDeleteFlag removes a stored flag, leaving any FEATURE_FLAGS
+// definition in effect: DELETE /admin/flags/:name.
+func handleDeleteFlag(c *gin.Context) {
+	if err := metaStore.Delete("flags", c.Param("name")); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "flag_delete", Detail: "flag " + c.Param("name") + " removed"})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Flag removed"})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Answers are generated as Markdown. The chat `format` parameter converts
+// them for embedders: "markdown" (the default) as is, "plain" with the
+// markup taken out, or "html" rendered on the server. The HTML renderer
+// escapes everything and only emits the handful of tags it produces itself,
+// so the result is safe to inject into a page.
+
+// answerFormats are the accepted values of the chat format parameter.
+var answerFormats = []string{"markdown", "plain", "html"}
+
+// formatInstruction steers the model towards output that converts cleanly.
+func formatInstruction(format string) string {
+	if format == "plain" {
+		return "\n\nWrite plain text: no Markdown, no tables, no headings. Still copy any code or formulas from the context exactly."
+	}
+	return codeInstruction
+}
+
+// formatAnswer converts a Markdown answer to format.
+func formatAnswer(answer, format string) string {
+	switch format {
+	case "plain":
+		return markdownToPlain(answer)
+	case "html":
+		return markdownToHTML(answer)
+	}
+	return answer
+}
+
+var (
+	fenceRe      = regexp.MustCompile("^\\s*(```+|~~~+)\\s*([\\w+#.-]*)")
+	headingRe    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	ruleRe       = regexp.MustCompile(`^\s*(?:(?:\*\s*){3,}|(?:-\s*){3,}|(?:_\s*){3,})$`)
+	bulletRe     = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	numberedRe   = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	quoteRe      = regexp.MustCompile(`^\s*>\s?(.*)$`)
+	tableSepRe   = regexp.MustCompile(`^\s*\|?\s*:?-{2,}:?\s*(?:\|\s*:?-{2,}:?\s*)*\|?\s*$`)
+	linkRe       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	boldRe       = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	italicStarRe = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+	italicUndRe  = regexp.MustCompile(`(^|[^\w])_(\S(?:[^_]*?\S)?)_([^\w]|$)`)
+	codeSpanRe   = regexp.MustCompile("`([^`]+)`")
+)
+
+// markdownToHTML renders the Markdown that chat models write: headings,
+// paragraphs, bullet and numbered lists, block quotes, fenced code, tables,
+// rules, and inline code, bold, italics and links.
+func markdownToHTML(md string) string {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var b strings.Builder
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case fenceRe.MatchString(line):
+			m := fenceRe.FindStringSubmatch(line)
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), m[1][:3]); i++ {
+				code = append(code, lines[i])
+			}
+			i++ // closing fence
+			if m[2] != "" {
+				fmt.Fprintf(&b, "<pre><code class=\"language-%s\">", html.EscapeString(m[2]))
+			} else {
+				b.WriteString("<pre><code>")
+			}
+			b.WriteString(html.EscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+		case headingRe.MatchString(line):
+			m := headingRe.FindStringSubmatch(line)
+			fmt.Fprintf(&b, "<h%d>%s</h%d>\n", len(m[1]), inlineHTML(m[2]), len(m[1]))
+			i++
+		case ruleRe.MatchString(line):
+			b.WriteString("<hr>\n")
+			i++
+		case quoteRe.MatchString(line):
+			var quoted []string
+			for ; i < len(lines) && quoteRe.MatchString(lines[i]); i++ {
+				quoted = append(quoted, quoteRe.FindStringSubmatch(lines[i])[1])
+			}
+			b.WriteString("<blockquote>\n" + markdownToHTML(strings.Join(quoted, "\n")) + "</blockquote>\n")
+		case bulletRe.MatchString(line), numberedRe.MatchString(line):
+			re, tag := bulletRe, "ul"
+			if !bulletRe.MatchString(line) {
+				re, tag = numberedRe, "ol"
+			}
+			b.WriteString("<" + tag + ">\n")
+			for i < len(lines) && re.MatchString(lines[i]) {
+				item := re.FindStringSubmatch(lines[i])[1]
+				// indented lines continue the item
+				for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "" && (strings.HasPrefix(lines[i], "  ") || strings.HasPrefix(lines[i], "\t")) && !re.MatchString(lines[i]); i++ {
+					item += " " + strings.TrimSpace(lines[i])
+				}
+				b.WriteString("<li>" + inlineHTML(item) + "</li>\n")
+			}
+			b.WriteString("</" + tag + ">\n")
+		case strings.Contains(line, "|") && i+1 < len(lines) && tableSepRe.MatchString(lines[i+1]):
+			b.WriteString("<table>\n<thead><tr>")
+			for _, cell := range tableCells(line) {
+				b.WriteString("<th>" + inlineHTML(cell) + "</th>")
+			}
+			b.WriteString("</tr></thead>\n<tbody>\n")
+			for i += 2; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+				b.WriteString("<tr>")
+				for _, cell := range tableCells(lines[i]) {
+					b.WriteString("<td>" + inlineHTML(cell) + "</td>")
+				}
+				b.WriteString("</tr>\n")
+			}
+			b.WriteString("</tbody>\n</table>\n")
+		default:
+			var para []string
+			for ; i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines, i); i++ {
+				para = append(para, inlineHTML(strings.TrimSpace(lines[i])))
+			}
+			if len(para) == 0 { // a block start that startsBlock saw but no case took
+				para, i = []string{inlineHTML(strings.TrimSpace(line))}, i+1
+			}
+			b.WriteString("<p>" + strings.Join(para, "<br>\n") + "</p>\n")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// startsBlock reports whether lines[i] begins something other than a
+// paragraph line, which ends the paragraph before it.
+func startsBlock(lines []string, i int) bool {
+	line := lines[i]
+	return fenceRe.MatchString(line) || headingRe.MatchString(line) || ruleRe.MatchString(line) ||
+		quoteRe.MatchString(line) || bulletRe.MatchString(line) || numberedRe.MatchString(line) ||
+		(strings.Contains(line, "|") && i+1 < len(lines) && tableSepRe.MatchString(lines[i+1]))
+}
+
+func tableCells(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimSuffix(strings.TrimPrefix(line, "|"), "|")
+	cells := strings.Split(line, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+// inlineHTML escapes a line of text and renders its inline Markdown. Code
+// spans are rendered first and left alone by the rest.
+func inlineHTML(s string) string {
+	parts := strings.Split(s, "`")
+	if len(parts)%2 == 0 { // unbalanced backtick: treat it as text
+		parts[len(parts)-2] += "`" + parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+	var b strings.Builder
+	for i, part := range parts {
+		if i%2 == 1 {
+			b.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		t := html.EscapeString(part)
+		t = linkRe.ReplaceAllStringFunc(t, func(m string) string {
+			sub := linkRe.FindStringSubmatch(m)
+			href := html.UnescapeString(sub[2])
+			if !safeHref(href) {
+				return sub[1]
+			}
+			return fmt.Sprintf(`<a href="%s" rel="nofollow noopener" target="_blank">%s</a>`, html.EscapeString(href), sub[1])
+		})
+		t = boldRe.ReplaceAllString(t, "<strong>$1$2</strong>")
+		t = italicStarRe.ReplaceAllString(t, "<em>$1</em>")
+		t = italicUndRe.ReplaceAllString(t, "$1<em>$2</em>$3")
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+func safeHref(href string) bool {
+	lower := strings.ToLower(href)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "mailto:")
+}
+
+// markdownToPlain strips Markdown down to readable text: links become
+// "text (url)", list items keep a dash, code keeps its content.
+func markdownToPlain(md string) string {
+	lines := strings.Split(strings.ReplaceAll(md, "\r\n", "\n"), "\n")
+	var out []string
+	inFence := false
+	for _, line := range lines {
+		if fenceRe.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			out = append(out, line)
+			continue
+		}
+		switch {
+		case headingRe.MatchString(line):
+			line = headingRe.FindStringSubmatch(line)[2]
+		case ruleRe.MatchString(line):
+			line = ""
+		case tableSepRe.MatchString(line) && strings.Contains(line, "-"):
+			continue
+		case bulletRe.MatchString(line):
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			line = indent + "- " + bulletRe.FindStringSubmatch(line)[1]
+		case quoteRe.MatchString(line):
+			line = quoteRe.FindStringSubmatch(line)[1]
+		}
+		if strings.Count(line, "|") >= 2 {
+			line = strings.Join(tableCells(line), "  ")
+		}
+		line = linkRe.ReplaceAllString(line, "$1 ($2)")
+		line = boldRe.ReplaceAllString(line, "$1$2")
+		line = italicStarRe.ReplaceAllString(line, "$1")
+		line = italicUndRe.ReplaceAllString(line, "$1$2$3")
+		line = codeSpanRe.ReplaceAllString(line, "$1")
+		out = append(out, line)
+	}
+	return strings.TrimSpace(blankRunRe.ReplaceAllString(strings.Join(out, "\n"), "\n\n"))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// annotationTypes are the annotation subtypes that carry a reviewer's
+// comment; links, widgets and popups are skipped.
+var annotationTypes = map[string]bool{
+	"Text": true, "FreeText": true, "Highlight": true, "Underline": true,
+	"StrikeOut": true, "Squiggly": true, "Caret": true, "Ink": true,
+	"Square": true, "Circle": true, "Polygon": true, "Stamp": true,
+}
+
+// extractFormsAndAnnotations returns a PDF's filled-in AcroForm fields and
+// its comments as extra chunks typed "form_field" and "annotation", so they
+// are retrievable apart from the page text. Field values are packed into
+// chunks of up to CHUNK_SIZE runes; each comment is its own chunk.
+func extractFormsAndAnnotations(path, password string) (chunks []textChunk, err error) {
+	f, r, err := openPdf(path, password)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	defer func() {
+		if p := recover(); p != nil {
+			chunks, err = nil, fmt.Errorf("%v", p)
+		}
+	}()
+
+	var lines []string
+	var walk func(field pdf.Value, prefix string)
+	walk = func(field pdf.Value, prefix string) {
+		name := field.Key("T").Text()
+		if prefix != "" && name != "" {
+			name = prefix + "." + name
+		} else if name == "" {
+			name = prefix
+		}
+		if kids := field.Key("Kids"); kids.Len() > 0 {
+			for i := 0; i < kids.Len(); i++ {
+				walk(kids.Index(i), name)
+			}
+		}
+		if value := fieldValue(field.Key("V")); value != "" {
+			label := field.Key("TU").Text()
+			if label == "" {
+				label = name
+			}
+			lines = append(lines, label+": "+value)
+		}
+	}
+	fields := r.Trailer().Key("Root").Key("AcroForm").Key("Fields")
+	for i := 0; i < fields.Len(); i++ {
+		walk(fields.Index(i), "")
+	}
+
+	size := envInt("CHUNK_SIZE", 1000)
+	var b strings.Builder
+	for _, line := range lines {
+		if b.Len() > 0 && len([]rune(b.String()))+len([]rune(line)) > size {
+			chunks = append(chunks, textChunk{Text: b.String(), Page: 1, Type: "form_field"})
+			b.Reset()
+		}
+		if b.Len() == 0 {
+			b.WriteString("Form fields:")
+		}
+		b.WriteString("\n" + line)
+	}
+	if b.Len() > 0 {
+		chunks = append(chunks, textChunk{Text: b.String(), Page: 1, Type: "form_field"})
+	}
+
+	for i := 1; i <= r.NumPage(); i++ {
+		annots := r.Page(i).V.Key("Annots")
+		for j := 0; j < annots.Len(); j++ {
+			a := annots.Index(j)
+			subtype := a.Key("Subtype").Name()
+			contents := strings.TrimSpace(a.Key("Contents").Text())
+			if !annotationTypes[subtype] || contents == "" {
+				continue
+			}
+			text := fmt.Sprintf("Comment on page %d", i)
+			if author := a.Key("T").Text(); author != "" {
+				text += " by " + author
+			}
+			chunks = append(chunks, textChunk{Text: fmt.Sprintf("%s (%s): %s", text, subtype, contents), Page: i, Type: "annotation"})
+		}
+	}
+	return chunks, nil
+}
+
+// fieldValue renders a form field value: text as is, checkbox and radio
+// names ("/Yes"), and each selected option of a list.
+func fieldValue(v pdf.Value) string {
+	switch v.Kind() {
+	case pdf.String:
+		return strings.TrimSpace(v.Text())
+	case pdf.Name:
+		if name := v.Name(); name != "Off" {
+			return name
+		}
+	case pdf.Array:
+		var parts []string
+		for i := 0; i < v.Len(); i++ {
+			if s := fieldValue(v.Index(i)); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// glossaryEntry is a definition detected in an ingested document.
+type glossaryEntry struct {
+	Term       string `json:"term"`
+	Definition string `json:"definition"`
+	DocumentID string `json:"document_id"`
+}
+
+var (
+	// "Term" means ..., Term shall mean ..., Term refers to ...
+	definitionRe = regexp.MustCompile(`["“]?\b([A-Z][A-Za-z0-9\-]*(?:\s+[A-Z][A-Za-z0-9\-]*){0,5})["”]?\s+(?:means|shall mean|refers to|is defined as)\s+([^\n]{3,300}?)(?:[.;]\s|[.;]?$|\n)`)
+	// Full Name Of Thing (FNOT)
+	expansionRe = regexp.MustCompile(`\b((?:[A-Z][a-z]+[\s\-]+(?:(?:of|and|for|the|&)\s+)?){1,7})\(([A-Z]{2,8})\)`)
+)
+
+// glossaryBucket keeps each workspace's glossary separate.
+func glossaryBucket(workspace string) string { return "glossary:" + workspace }
+
+// detectDefinitions finds "X means ..." definitions and acronym expansions.
+func detectDefinitions(text string) map[string]glossaryEntry {
+	found := map[string]glossaryEntry{}
+	for _, m := range definitionRe.FindAllStringSubmatch(text, -1) {
+		term := strings.TrimSpace(m[1])
+		found[termKey(term)] = glossaryEntry{Term: term, Definition: strings.TrimSpace(m[2])}
+	}
+	for _, m := range expansionRe.FindAllStringSubmatch(text, -1) {
+		expansion, acronym := trimExpansion(m[1], m[2])
+		if expansion == "" {
+			continue
+		}
+		if _, ok := found[termKey(acronym)]; !ok {
+			found[termKey(acronym)] = glossaryEntry{Term: acronym, Definition: expansion}
+		}
+	}
+	return found
+}
+
+// trimExpansion drops leading words ("The", sentence starts) until the rest
+// of the capitalised phrase spells the acronym. It returns "" if nothing does.
+func trimExpansion(phrase, acronym string) (string, string) {
+	words := strings.Fields(phrase)
+	for i := range words {
+		if candidate := strings.Join(words[i:], " "); initialsMatch(candidate, acronym) {
+			return candidate, acronym
+		}
+	}
+	return "", acronym
+}
+
+// initialsMatch checks that an acronym is spelled by the capitalised words
+// of its expansion, so "Service Level Agreement (SLA)" counts but a random
+// parenthetical does not.
+func initialsMatch(expansion, acronym string) bool {
+	var initials strings.Builder
+	for _, w := range strings.FieldsFunc(expansion, func(r rune) bool { return unicode.IsSpace(r) || r == '-' }) {
+		if unicode.IsUpper([]rune(w)[0]) {
+			initials.WriteRune([]rune(w)[0])
+		}
+	}
+	return strings.EqualFold(initials.String(), acronym)
+}
+
+// buildGlossary adds the definitions found in a document's chunks to the
+// workspace glossary. Existing definitions from other documents are kept.
+func buildGlossary(workspace, documentID string, points []*pb.PointStruct) error {
+	batch := map[string]any{}
+	for _, p := range points {
+		for key, entry := range detectDefinitions(payloadString(p.Payload, "text")) {
+			if _, seen := batch[key]; seen {
+				continue
+			}
+			var existing glossaryEntry
+			if ok, err := metaStore.Get(glossaryBucket(workspace), key, &existing); err != nil {
+				return err
+			} else if ok {
+				continue
+			}
+			entry.DocumentID = documentID
+			batch[key] = entry
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return metaStore.PutBatch(glossaryBucket(workspace), batch)
+}
+
+// glossaryContext returns definitions for glossary terms that appear in the
+// question or retrieved text, formatted for the prompt. Only definitions
+// from documents visible accepts are used.
+func glossaryContext(workspace string, visible func(documentID string) bool, texts ...string) string {
+	all, err := metaStore.List(glossaryBucket(workspace))
+	if err != nil || len(all) == 0 {
+		return ""
+	}
+	haystack := strings.Join(texts, "\n")
+	const maxDefinitions = 10
+	var lines []string
+	for _, raw := range all {
+		var e glossaryEntry
+		if json.Unmarshal(raw, &e) != nil || !visible(e.DocumentID) {
+			continue
+		}
+		if mentionsTerm(haystack, e.Term) {
+			lines = append(lines, fmt.Sprintf("- %s: %s", e.Term, e.Definition))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+	return "Definitions:\n" + strings.Join(lines[:min(len(lines), maxDefinitions)], "\n")
+}
+
+// mentionsTerm does a whole-word match, case-sensitive for acronyms.
+func mentionsTerm(text, term string) bool {
+	pattern := `\b` + regexp.QuoteMeta(term) + `s?\b`
+	if strings.ToUpper(term) != term {
+		pattern = `(?i)` + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	return err == nil && re.MatchString(text)
+}
+
+// handleGlossary lists the glossary built for a workspace, as far as the
+// caller may see the documents it comes from.
+func handleGlossary(c *gin.Context) {
+	all, err := metaStore.List(glossaryBucket(c.DefaultQuery("workspace", "default")))
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	entries := []glossaryEntry{}
+	visible := documentVisibleTo(requestUser(c))
+	for _, raw := range all {
+		var e glossaryEntry
+		if json.Unmarshal(raw, &e) == nil && visible(e.DocumentID) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Term < entries[j].Term })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "glossary": entries})
+}
+
+// forgetGlossary removes the definitions a document contributed.
+func forgetGlossary(workspace, documentID string) error {
+	all, err := metaStore.List(glossaryBucket(workspace))
+	if err != nil {
+		return err
+	}
+	for key, raw := range all {
+		var e glossaryEntry
+		if json.Unmarshal(raw, &e) == nil && e.DocumentID == documentID {
+			if err := metaStore.Delete(glossaryBucket(workspace), key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+const (
+	graphExtractPrompt = "Extract the named entities (people, organisations, products, places, documents, sections, dates, amounts) and the relations between them from the text below. Use short, canonical entity names and short verb phrases for relations."
+	graphQueryPrompt   = "List the named entities mentioned in this question, using short canonical names."
+)
+
+var graphSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {
+		"entities": {"type": "array", "items": {"type": "object", "properties": {"name": {"type": "string"}, "type": {"type": "string"}}, "required": ["name", "type"], "additionalProperties": false}},
+		"relations": {"type": "array", "items": {"type": "object", "properties": {"source": {"type": "string"}, "relation": {"type": "string"}, "target": {"type": "string"}}, "required": ["source", "relation", "target"], "additionalProperties": false}}
+	},
+	"required": ["entities", "relations"],
+	"additionalProperties": false
+}`)
+
+var queryEntitiesSchema = json.RawMessage(`{
+	"type": "object",
+	"properties": {"entities": {"type": "array", "items": {"type": "string"}}},
+	"required": ["entities"],
+	"additionalProperties": false
+}`)
+
+// graphEdge is a relation between two entities, stored on both ends.
+type graphEdge struct {
+	Source     string `json:"source"`
+	Relation   string `json:"relation"`
+	Target     string `json:"target"`
+	ChunkID    string `json:"chunk_id"`
+	DocumentID string `json:"document_id"`
+}
+
+// graphNode is an entity and everything known to touch it.
+type graphNode struct {
+	Name   string      `json:"name"`
+	Type   string      `json:"type"`
+	Chunks []string    `json:"chunks"` // point IDs of chunks mentioning the entity
+	Edges  []graphEdge `json:"edges"`
+}
+
+func (e graphEdge) String() string { return e.Source + " " + e.Relation + " " + e.Target }
+
+// graphMu serialises read-modify-write updates of graph nodes.
+var graphMu sync.Mutex
+
+func graphKey(name string) string { return strings.ToLower(strings.Join(strings.Fields(name), " ")) }
+
+// graphBucket keeps each workspace's graph separate.
+func graphBucket(workspace string) string { return "graph:" + workspace }
+
+// graphEnabled reports whether an ingest should run entity extraction: the
+// per-request "graph" form field wins over the GRAPH_EXTRACTION default.
+func graphEnabled(formValue string) bool {
+	if formValue != "" {
+		return formValue == "true"
+	}
+	return os.Getenv("GRAPH_EXTRACTION") == "true"
+}
+
+// extractGraph runs entity and relation extraction over each chunk of a
+// freshly ingested document and merges the results into the workspace graph.
+func extractGraph(ctx context.Context, workspace string, points []*pb.PointStruct) error {
+	var firstErr error
+	for _, p := range points {
+		chunkID := p.Id.GetUuid()
+		raw, err := completeStructured(ctx, graphExtractPrompt+"\n\nText:\n"+payloadString(p.Payload, "text"), "knowledge_graph", graphSchema)
+		if err == nil {
+			err = mergeGraph(workspace, payloadString(p.Payload, "document_id"), chunkID, raw)
+		}
+		if err != nil {
+			log.Printf("❌ Graph Extraction Error (chunk %s): %v", chunkID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func mergeGraph(workspace, documentID, chunkID, raw string) error {
+	var out struct {
+		Entities []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"entities"`
+		Relations []graphEdge `json:"relations"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return err
+	}
+
+	graphMu.Lock()
+	defer graphMu.Unlock()
+	nodes := map[string]*graphNode{}
+	node := func(name, typ string) (*graphNode, error) {
+		key := graphKey(name)
+		if n, ok := nodes[key]; ok {
+			return n, nil
+		}
+		n := &graphNode{Name: name, Type: typ}
+		if _, err := metaStore.Get(graphBucket(workspace), key, n); err != nil {
+			return nil, err
+		}
+		if n.Type == "" {
+			n.Type = typ
+		}
+		if !slices.Contains(n.Chunks, chunkID) {
+			n.Chunks = append(n.Chunks, chunkID)
+		}
+		nodes[key] = n
+		return n, nil
+	}
+
+	for _, e := range out.Entities {
+		if graphKey(e.Name) == "" {
+			continue
+		}
+		if _, err := node(e.Name, e.Type); err != nil {
+			return err
+		}
+	}
+	for _, r := range out.Relations {
+		if graphKey(r.Source) == "" || graphKey(r.Target) == "" {
+			continue
+		}
+		r.ChunkID, r.DocumentID = chunkID, documentID
+		src, err := node(r.Source, "")
+		if err != nil {
+			return err
+		}
+		dst, err := node(r.Target, "")
+		if err != nil {
+			return err
+		}
+		src.Edges = append(src.Edges, r)
+		if dst != src {
+			dst.Edges = append(dst.Edges, r)
+		}
+	}
+
+	batch := make(map[string]any, len(nodes))
+	for key, n := range nodes {
+		batch[key] = n
+	}
+	return metaStore.PutBatch(graphBucket(workspace), batch)
+}
+
+// graphContext finds the entities a question mentions and walks up to
+// GRAPH_HOPS relations out from them in the graphs of workspaces, following
+// only relations taken from documents visible accepts. It returns the facts
+// found along the way and the IDs of chunks that mention the matched
+// entities.
+func graphContext(ctx context.Context, question string, workspaces []string, visible func(documentID string) bool) ([]string, []string, error) {
+	raw, err := completeStructured(ctx, graphQueryPrompt+"\n\nQuestion: "+question, "query_entities", queryEntitiesSchema)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out struct {
+		Entities []string `json:"entities"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, nil, err
+	}
+
+	const maxFacts = 30
+	hops := envInt("GRAPH_HOPS", 2)
+	seen := map[string]bool{}
+	var facts, chunkIDs []string
+	frontier := out.Entities
+	for depth := 0; depth < hops && len(frontier) > 0 && len(facts) < maxFacts; depth++ {
+		var next []string
+		for _, name := range frontier {
+			key := graphKey(name)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			for _, workspace := range workspaces {
+				var n graphNode
+				found, err := metaStore.Get(graphBucket(workspace), key, &n)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !found {
+					continue
+				}
+				if depth == 0 {
+					chunkIDs = append(chunkIDs, n.Chunks...)
+				}
+				for _, e := range n.Edges {
+					if !visible(e.DocumentID) {
+						continue
+					}
+					if fact := e.String(); !slices.Contains(facts, fact) && len(facts) < maxFacts {
+						facts = append(facts, fact)
+					}
+					if graphKey(e.Source) != key {
+						next = append(next, e.Source)
+					} else {
+						next = append(next, e.Target)
+					}
+				}
+			}
+		}
+		frontier = next
+	}
+	return facts, chunkIDs, nil
+}
+
+// formatFacts renders graph facts as a bullet list for the prompt.
+func formatFacts(facts []string) string {
+	var b strings.Builder
+	for _, f := range facts {
+		fmt.Fprintf(&b, "- %s\n", f)
+	}
+	return b.String()
+}
+
+// forgetGraphChunks removes deleted chunks, and the relations taken from
+// them, from the nodes of a workspace graph; a node with no chunks left is
+// removed. It returns how many relations it removed, each counted once
+// though it is stored on both its ends.
+func forgetGraphChunks(workspace string, chunkIDs map[string]bool) (int, error) {
+	graphMu.Lock()
+	defer graphMu.Unlock()
+	all, err := metaStore.List(graphBucket(workspace))
+	if err != nil {
+		return 0, err
+	}
+	batch := map[string]any{}
+	dropped := map[graphEdge]bool{}
+	for key, raw := range all {
+		var n graphNode
+		if json.Unmarshal(raw, &n) != nil {
+			continue
+		}
+		kept := slices.DeleteFunc(slices.Clone(n.Chunks), func(id string) bool { return chunkIDs[id] })
+		edges := slices.DeleteFunc(slices.Clone(n.Edges), func(e graphEdge) bool {
+			if chunkIDs[e.ChunkID] {
+				dropped[e] = true
+			}
+			return chunkIDs[e.ChunkID]
+		})
+		switch {
+		case len(kept) == len(n.Chunks) && len(edges) == len(n.Edges):
+		case len(kept) == 0:
+			if err := metaStore.Delete(graphBucket(workspace), key); err != nil {
+				return 0, err
+			}
+		default:
+			n.Chunks, n.Edges = kept, edges
+			batch[key] = n
+		}
+	}
+	return len(dropped), metaStore.PutBatch(graphBucket(workspace), batch)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zendeskConnector syncs the published articles of a Zendesk help center:
+// ZENDESK_SUBDOMAIN (or ZENDESK_URL for a custom host), optionally limited
+// to ZENDESK_LOCALE. Private help centers need ZENDESK_EMAIL and
+// ZENDESK_API_TOKEN. Each may be set per workspace with a _<WORKSPACE>
+// suffix. Article labels become tags.
+type zendeskConnector struct{}
+
+func (zendeskConnector) Name() string { return "zendesk" }
+
+func (zendeskConnector) Fetch(ctx context.Context, workspace string) ([]connectorItem, error) {
+	base := strings.TrimRight(envWorkspace("ZENDESK_URL", workspace), "/")
+	if base == "" {
+		sub := envWorkspace("ZENDESK_SUBDOMAIN", workspace)
+		if sub == "" {
+			return nil, fmt.Errorf("ZENDESK_SUBDOMAIN or ZENDESK_URL must be set")
+		}
+		base = "https://" + sub + ".zendesk.com"
+	}
+	next := base + "/api/v2/help_center/articles.json?per_page=100"
+	if locale := envWorkspace("ZENDESK_LOCALE", workspace); locale != "" {
+		next = base + "/api/v2/help_center/" + url.PathEscape(locale) + "/articles.json?per_page=100"
+	}
+	email, token := envWorkspace("ZENDESK_EMAIL", workspace), envWorkspace("ZENDESK_API_TOKEN", workspace)
+	auth := func(req *http.Request) {
+		if email != "" && token != "" {
+			req.SetBasicAuth(email+"/token", token)
+		}
+	}
+
+	var items []connectorItem
+	for next != "" {
+		body, err := connectorGet(ctx, next, auth)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Articles []struct {
+				ID        int64     `json:"id"`
+				Title     string    `json:"title"`
+				Body      string    `json:"body"`
+				HTMLURL   string    `json:"html_url"`
+				Draft     bool      `json:"draft"`
+				Locale    string    `json:"locale"`
+				SectionID int64     `json:"section_id"`
+				Labels    []string  `json:"label_names"`
+				UpdatedAt time.Time `json:"updated_at"`
+				EditedAt  time.Time `json:"edited_at"` // content changes only, unlike updated_at
+			} `json:"articles"`
+			NextPage string `json:"next_page"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, a := range page.Articles {
+			if a.Draft {
+				continue
+			}
+			meta := map[string]string{}
+			if a.Locale != "" {
+				meta["locale"] = a.Locale
+			}
+			if a.SectionID != 0 {
+				meta["section_id"] = strconv.FormatInt(a.SectionID, 10)
+			}
+			items = append(items, connectorItem{
+				ID:        strconv.FormatInt(a.ID, 10),
+				Title:     a.Title,
+				URL:       a.HTMLURL,
+				Text:      a.Title + "\n\n" + htmlToText(a.Body),
+				Tags:      a.Labels,
+				Metadata:  meta,
+				UpdatedAt: cmp.Or(a.EditedAt, a.UpdatedAt),
+			})
+		}
+		next = page.NextPage
+	}
+	return items, nil
+}
+
+// intercomConnector syncs the published articles of an Intercom help
+// center with INTERCOM_ACCESS_TOKEN, against INTERCOM_API_URL (default
+// https://api.intercom.io; use the EU or AU host for workspaces hosted
+// there). Both may be set per workspace with a _<WORKSPACE> suffix.
+type intercomConnector struct{}
+
+func (intercomConnector) Name() string { return "intercom" }
+
+func (intercomConnector) Fetch(ctx context.Context, workspace string) ([]connectorItem, error) {
+	token := envWorkspace("INTERCOM_ACCESS_TOKEN", workspace)
+	if token == "" {
+		return nil, fmt.Errorf("INTERCOM_ACCESS_TOKEN must be set")
+	}
+	base := strings.TrimRight(cmp.Or(envWorkspace("INTERCOM_API_URL", workspace), "https://api.intercom.io"), "/")
+	auth := func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Intercom-Version", "2.11")
+	}
+
+	var items []connectorItem
+	for page, pages := 1, 1; page <= pages; page++ {
+		body, err := connectorGet(ctx, fmt.Sprintf("%s/articles?per_page=50&page=%d", base, page), auth)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Data []struct {
+				ID        string          `json:"id"`
+				Title     string          `json:"title"`
+				Body      string          `json:"body"`
+				URL       string          `json:"url"`
+				State     string          `json:"state"`
+				ParentID  json.RawMessage `json:"parent_id"`
+				UpdatedAt int64           `json:"updated_at"`
+			} `json:"data"`
+			Pages struct {
+				TotalPages int `json:"total_pages"`
+			} `json:"pages"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+		pages = res.Pages.TotalPages
+		for _, a := range res.Data {
+			if a.State != "published" {
+				continue
+			}
+			meta := map[string]string{}
+			if id := strings.Trim(string(a.ParentID), `"`); id != "" && id != "null" {
+				meta["collection_id"] = id
+			}
+			items = append(items, connectorItem{
+				ID:        a.ID,
+				Title:     a.Title,
+				URL:       a.URL,
+				Text:      a.Title + "\n\n" + htmlToText(a.Body),
+				Metadata:  meta,
+				UpdatedAt: time.Unix(a.UpdatedAt, 0),
+			})
+		}
+	}
+	return items, nil
+}
+
+func init() {
+	registerConnector(zendeskConnector{})
+	registerConnector(intercomConnector{})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotentResult is the stored outcome of a request made with an
+// Idempotency-Key.
+type idempotentResult struct {
+	Fingerprint string         `json:"fingerprint"`
+	Response    map[string]any `json:"response"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// inflight tracks keys whose first request is still running, so a retry
+// that arrives mid-ingest waits for it instead of ingesting again.
+var inflight = struct {
+	sync.Mutex
+	m map[string]chan struct{}
+}{m: map[string]chan struct{}{}}
+
+// idempotencyLookups and idempotencyHits count replay checks for /admin/status.
+var idempotencyLookups, idempotencyHits atomic.Uint64
+
+func idempotencyTTL() time.Duration {
+	return time.Duration(envInt("IDEMPOTENCY_TTL_HOURS", 24)) * time.Hour
+}
+
+// withIdempotency runs handle unless the request's Idempotency-Key has
+// already produced a successful response, in which case that response is
+// replayed. Keys are the caller's own: two users picking the same key don't
+// see each other's responses. fingerprint identifies the request body so a
+// key reused for a different upload is rejected. Failed attempts are not
+// stored, so they can be retried with the same key.
+func withIdempotency(c *gin.Context, fingerprint string, handle func() gin.H) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		c.JSON(http.StatusOK, handle())
+		return
+	}
+	key = userKey(requestUser(c), key)
+
+	for {
+		if replayed := replayIdempotent(c, key, fingerprint); replayed {
+			return
+		}
+		inflight.Lock()
+		wait, busy := inflight.m[key]
+		if !busy {
+			inflight.m[key] = make(chan struct{})
+		}
+		inflight.Unlock()
+		if !busy {
+			break
+		}
+		<-wait
+	}
+	defer func() {
+		inflight.Lock()
+		close(inflight.m[key])
+		delete(inflight.m, key)
+		inflight.Unlock()
+	}()
+
+	resp := handle()
+	if resp["status"] == "success" {
+		metaStore.Put("idempotency", key, idempotentResult{Fingerprint: fingerprint, Response: resp, CreatedAt: time.Now()})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// replayIdempotent writes the stored response for key, if there is a live one.
+func replayIdempotent(c *gin.Context, key, fingerprint string) bool {
+	idempotencyLookups.Add(1)
+	var prev idempotentResult
+	found, err := metaStore.Get("idempotency", key, &prev)
+	if err != nil || !found || time.Since(prev.CreatedAt) > idempotencyTTL() {
+		return false
+	}
+	idempotencyHits.Add(1)
+	if prev.Fingerprint != fingerprint {
+		c.JSON(http.StatusOK, errorReply(c, codeConflict, "Idempotency-Key was already used for a different request"))
+		return true
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.JSON(http.StatusOK, prev.Response)
+	return true
+}
+
+// userKey is what a user's Idempotency-Key is stored under.
+func userKey(user, key string) string {
+	return fingerprint([]byte(user), key)
+}
+
+// fileFingerprint hashes a file together with the form fields that change
+// what an ingest does, reading the file a piece at a time.
+func fileFingerprint(path string, fields ...string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fieldsDigest(h, fields), nil
+}
+
+// fingerprint hashes a request body and the fields that accompany it.
+func fingerprint(data []byte, fields ...string) string {
+	h := sha256.New()
+	h.Write(data)
+	return fieldsDigest(h, fields)
+}
+
+// fieldsDigest adds fields to the hash of a body and returns it in hex.
+func fieldsDigest(h hash.Hash, fields []string) string {
+	for _, field := range fields {
+		h.Write([]byte{0})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sweepIdempotency drops stored responses older than the TTL.
+func sweepIdempotency(ctx context.Context) {
+	all, err := metaStore.List("idempotency")
+	if err != nil {
+		log.Printf("❌ Idempotency Sweep Error: %v", err)
+		return
+	}
+	for key, raw := range all {
+		var prev idempotentResult
+		if json.Unmarshal(raw, &prev) == nil && time.Since(prev.CreatedAt) > idempotencyTTL() {
+			metaStore.Delete("idempotency", key)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/qdrant/go-client/qdrant"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+)
+
+// embeddingSize is the vector size of the default embedding model.
+const embeddingSize = 1536
+
+// ingestDoc describes a document being ingested.
+type ingestDoc struct {
+	DocumentID string
+	Filename   string
+	Workspace  string
+	ParentID   string
+	Graph      bool              // run entity/relation extraction
+	Tags       []string          // labels given at ingest
+	Source     string            // where the document came from, for SOURCE_BOOSTS
+	URL        string            // the original's address, for citations
+	Fields     map[string]string // metadata every chunk carries for filtering, unless its record has its own
+	StoreText  bool              // keep the parsed page text for re-chunking
+	Extra      []textChunk       // chunks from outside the page text, added as they are
+	Meta       documentMeta
+	Version    documentVersion
+}
+
+// ingestResult summarises a finished ingest.
+type ingestResult struct {
+	Chunks      int
+	Language    string
+	Pages       int
+	FailedPages []pageError // pages whose text couldn't be extracted
+	Attachments []attachmentResult
+	Duplicates  int   // chunks marked as near-duplicates of another document's
+	GraphErr    error // first graph extraction failure, if any
+}
+
+// report describes how much of the document made it in.
+func (r ingestResult) report() gin.H {
+	failed := r.FailedPages
+	if failed == nil {
+		failed = []pageError{}
+	}
+	report := gin.H{"pages": r.Pages, "failed_pages": failed}
+	if r.Duplicates > 0 {
+		report["duplicate_chunks"] = r.Duplicates
+	}
+	if len(r.Attachments) > 0 {
+		report["attachments"] = r.Attachments
+	}
+	return report
+}
+
+// pendingChunk is a chunk moving through the pipeline, holding its share of
+// the memory budget until it has been written.
+type pendingChunk struct {
+	textChunk
+	index  int
+	vector []float32
+	weight int64
+}
+
+// ensureCollection makes sure collectionName can be searched and written,
+// with its payload indexes. A fresh deployment creates a collection named
+// for the time and points the collectionName alias at it, so a reindex only
+// ever swaps the alias and the live collection never goes away. A
+// deployment from before aliases keeps its collection named collectionName.
+func ensureCollection(ctx context.Context) {
+	if servingEnsured.Load() {
+		return
+	}
+	serving, err := aliasTarget(ctx, collectionName)
+	if err != nil {
+		log.Printf("❌ Qdrant Error: %v", err)
+		return
+	}
+	if serving == collectionName {
+		res, err := collectionsClient.CollectionExists(ctx, &pb.CollectionExistsRequest{CollectionName: collectionName})
+		if err != nil {
+			log.Printf("❌ Qdrant Error: %v", err)
+			return
+		}
+		if !res.GetResult().GetExists() {
+			if serving, err = createServingCollection(ctx); err != nil {
+				log.Printf("❌ Qdrant Error: %v", err)
+				return
+			}
+		}
+	}
+	ensurePayloadIndexes(ctx, serving)
+	servingEnsured.Store(true)
+}
+
+// servingEnsured is set once this process has found or created the serving
+// collection.
+var servingEnsured atomic.Bool
+
+// createServingCollection creates a collection for a fresh deployment and
+// points collectionName at it. If another replica got there first, its
+// collection is used and this one dropped.
+func createServingCollection(ctx context.Context) (string, error) {
+	name := newCollectionName()
+	model := newEmbedding()
+	_, err := collectionsClient.Create(ctx, &pb.CreateCollection{
+		CollectionName: name,
+		VectorsConfig: &pb.VectorsConfig{Config: &pb.VectorsConfig_Params{Params: &pb.VectorParams{
+			Size:     uint64(model.Size),
+			Distance: pb.Distance_Cosine,
+		}}},
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := switchAlias(ctx, collectionName, name, false); err != nil {
+		collectionsClient.Delete(ctx, &pb.DeleteCollection{CollectionName: name})
+		return aliasTarget(ctx, collectionName)
+	}
+	metaStore.Put("collection_models", name, model)
+	setServingEmbedding(model)
+	log.Printf("🆕 Created %s, served as %s", name, collectionName)
+	return name, nil
+}
+
+// payloadIndexes are the payload fields searches filter on, indexed so
+// filtered searches stay fast as the collection grows.
+var payloadIndexes = map[string]pb.FieldType{
+	"document_id":  pb.FieldType_FieldTypeKeyword,
+	"parent_id":    pb.FieldType_FieldTypeKeyword,
+	"workspace":    pb.FieldType_FieldTypeKeyword,
+	"tags":         pb.FieldType_FieldTypeKeyword,
+	"language":     pb.FieldType_FieldTypeKeyword,
+	"doc_date":     pb.FieldType_FieldTypeInteger,
+	"valid_from":   pb.FieldType_FieldTypeInteger,
+	"valid_to":     pb.FieldType_FieldTypeInteger,
+	"disabled":     pb.FieldType_FieldTypeBool,
+	"duplicate":    pb.FieldType_FieldTypeBool,
+	"duplicate_of": pb.FieldType_FieldTypeKeyword,
+}
+
+// indexedCollections are those whose payload indexes this process has
+// already made sure of.
+var indexedCollections sync.Map
+
+// ensurePayloadIndexes creates any missing payload indexes on a collection,
+// once per process; creating an index that exists is a no-op for Qdrant.
+// Indexes are built in the background, so this doesn't wait for them.
+func ensurePayloadIndexes(ctx context.Context, collection string) {
+	if _, done := indexedCollections.Load(collection); done {
+		return
+	}
+	for field, fieldType := range payloadIndexes {
+		_, err := qdrantClient.CreateFieldIndex(ctx, &pb.CreateFieldIndexCollection{
+			CollectionName: collection,
+			FieldName:      field,
+			FieldType:      fieldType.Enum(),
+		})
+		if err != nil {
+			log.Printf("❌ Payload Index Error: %s.%s: %v", collection, field, err)
+			return
+		}
+	}
+	indexedCollections.Store(collection, true)
+}
+
+// runIngest streams pages through chunk → embed → upsert stages connected by
+// channels. Chunks wait for room in an INGEST_MEMORY_MB budget before they
+// enter the pipeline, so a huge document applies backpressure to the parser
+// instead of being held in memory; points are written batch by batch as soon
+// as their embeddings come back.
+func runIngest(ctx context.Context, pages <-chan pdfPage, doc ingestDoc) (ingestResult, error) {
+	batchSize := envInt("INGEST_BATCH", 64)
+	// The budget must fit at least two full batches, or the embed stage would
+	// wait forever for a batch the chunk stage can't admit.
+	maxWeight := int64(envInt("CHUNK_SIZE", 1000)*4 + embeddingSize*4)
+	budget := semaphore.NewWeighted(max(int64(envInt("INGEST_MEMORY_MB", 64))<<20, 2*int64(batchSize)*maxWeight))
+	ensureCollection(ctx)
+
+	g, ctx := errgroup.WithContext(ctx)
+	chunks := make(chan pendingChunk, batchSize)
+	embedded := make(chan []pendingChunk, 1)
+	var res ingestResult
+
+	// 1. CHUNK
+	chunker := newChunker("")
+	timed := isTranscriptFile(doc.Filename)
+	g.Go(func() error {
+		defer close(chunks)
+		emit := func(cs []textChunk) error {
+			for _, c := range cs {
+				// text plus its embedding, roughly
+				w := int64(len(c.Text) + embeddingSize*4)
+				if err := budget.Acquire(ctx, w); err != nil {
+					return err
+				}
+				select {
+				case chunks <- pendingChunk{textChunk: c, index: res.Chunks, weight: w}:
+					res.Chunks++
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+		stored := map[string]any{}
+		fields := map[int]map[string]string{}
+		starts := map[int]float64{}
+		for page := range pages {
+			res.Pages++
+			if page.Err != "" {
+				res.FailedPages = append(res.FailedPages, pageError{Page: page.Number, Error: page.Err})
+				continue
+			}
+			if doc.StoreText {
+				if page.Fields != nil {
+					fields[page.Number] = page.Fields
+				}
+				if timed {
+					starts[page.Number] = page.Start
+				}
+				if stored[pageKey(page.Number)] = sealText(page.Text); len(stored) == 20 {
+					if err := metaStore.PutBatch(pagesBucket(doc.DocumentID), stored); err != nil {
+						return err
+					}
+					clear(stored)
+				}
+			}
+			if page.Fields != nil || timed {
+				// a record is chunked on its own, so its chunks carry only its
+				// fields; so is a transcript window, so its chunks start at its time
+				cs := append(chunker.Add(page.Number, page.Text), chunker.Flush()...)
+				for i := range cs {
+					cs[i].Fields, cs[i].Start = page.Fields, page.Start
+				}
+				if err := emit(cs); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := emit(chunker.Add(page.Number, page.Text)); err != nil {
+				return err
+			}
+		}
+		if len(fields) > 0 {
+			stored[fieldsKey] = fields
+		}
+		if len(starts) > 0 {
+			stored[startsKey] = starts
+		}
+		if doc.StoreText && len(doc.Extra) > 0 {
+			sealed := make([]textChunk, len(doc.Extra))
+			for i, c := range doc.Extra {
+				sealed[i] = c
+				sealed[i].Text = sealText(c.Text)
+			}
+			stored[extraKey] = sealed
+		}
+		if len(stored) > 0 {
+			if err := metaStore.PutBatch(pagesBucket(doc.DocumentID), stored); err != nil {
+				return err
+			}
+		}
+		if err := emit(chunker.Flush()); err != nil {
+			return err
+		}
+		return emit(doc.Extra)
+	})
+
+	// 2. EMBED
+	g.Go(func() error {
+		defer close(embedded)
+		var batch []pendingChunk
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			texts := make([]string, len(batch))
+			for i, c := range batch {
+				texts[i] = c.Text
+			}
+			vectors, err := embedTexts(ctx, texts)
+			if err != nil {
+				return err
+			}
+			for i := range batch {
+				batch[i].vector = vectors[i]
+			}
+			select {
+			case embedded <- batch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			batch = nil
+			return nil
+		}
+		for c := range chunks {
+			if batch = append(batch, c); len(batch) == batchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return flush()
+	})
+
+	// 3. UPSERT: batches go out without waiting for Qdrant to apply them,
+	// except every INGEST_ACK_EVERY-th as a checkpoint and the last, which
+	// returns once everything before it is applied too. INGEST_UPSERT_WAIT=batch
+	// waits on every batch instead.
+	g.Go(func() error {
+		waitEach := os.Getenv("INGEST_UPSERT_WAIT") == "batch"
+		ackEvery := envInt("INGEST_ACK_EVERY", 0)
+		sent := 0
+		write := func(batch []pendingChunk, wait bool) error {
+			points := make([]*pb.PointStruct, len(batch))
+			vectors := make([][]float32, len(batch))
+			for i, c := range batch {
+				points[i] = &pb.PointStruct{
+					Id:      pb.NewIDUUID(chunkPointID(doc.DocumentID, c.index)),
+					Vectors: pb.NewVectorsDense(c.vector),
+					Payload: map[string]*pb.Value{
+						"text":        pb.NewValueString(sealText(c.Text)),
+						"document_id": pb.NewValueString(doc.DocumentID),
+						"filename":    pb.NewValueString(doc.Filename),
+						"chunk_index": pb.NewValueInt(int64(c.index)),
+						"page":        pb.NewValueInt(int64(c.Page)),
+						"workspace":   pb.NewValueString(doc.Workspace),
+						"parent_id":   pb.NewValueString(doc.ParentID),
+						"type":        pb.NewValueString(cmp.Or(c.Type, "text")),
+						"language":    pb.NewValueString(chunker.Lang()),
+						"title":       pb.NewValueString(doc.Meta.Title),
+						"tags":        tagValues(doc.Tags),
+						"source":      pb.NewValueString(doc.Source),
+					},
+				}
+				if !doc.Meta.Date.IsZero() {
+					points[i].Payload["doc_date"] = pb.NewValueInt(doc.Meta.Date.Unix())
+				}
+				if doc.URL != "" {
+					points[i].Payload["url"] = pb.NewValueString(doc.URL)
+				}
+				if timed {
+					points[i].Payload["start"] = pb.NewValueDouble(c.Start)
+				}
+				fields := c.Fields
+				if fields == nil {
+					fields = doc.Fields
+				}
+				if len(fields) > 0 {
+					points[i].Payload["fields"] = fieldsPayload(fields)
+				}
+				maps.Copy(points[i].Payload, validityPayload(doc.Version))
+				vectors[i] = c.vector
+			}
+			res.Duplicates += markDuplicates(ctx, doc, points, vectors)
+			if _, err := qdrantClient.Upsert(ctx, &pb.UpsertPoints{CollectionName: collectionName, Points: points, Wait: &wait}); err != nil {
+				return err
+			}
+			sent++
+			indexBatch(ctx, doc, points, &res)
+			for _, c := range batch {
+				budget.Release(c.weight)
+			}
+			return nil
+		}
+		// the newest batch is held back until the next arrives, so the last
+		// one is known when it's sent
+		var held []pendingChunk
+		for batch := range embedded {
+			if waitEach {
+				if err := write(batch, true); err != nil {
+					return err
+				}
+				continue
+			}
+			if held != nil {
+				if err := write(held, ackEvery > 0 && (sent+1)%ackEvery == 0); err != nil {
+					return err
+				}
+			}
+			held = batch
+		}
+		if held != nil {
+			return write(held, true)
+		}
+		return nil
+	})
+
+	err := g.Wait()
+	res.Language = chunker.Lang()
+	return res, err
+}
+
+// chunkPointID is the point ID of a document's index'th chunk. It is the
+// same every time, so a job retried after a worker died overwrites the
+// points it already wrote instead of adding copies.
+func chunkPointID(documentID string, index int) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("docuchat:chunk:"+documentID+":"+strconv.Itoa(index))).String()
+}
+
+// indexBatch runs the metadata stages over a batch of freshly written points.
+func indexBatch(ctx context.Context, doc ingestDoc, points []*pb.PointStruct, res *ingestResult) {
+	if err := indexTerms(points); err != nil {
+		log.Printf("❌ Term Index Error: %v", err)
+	}
+	if err := buildGlossary(doc.Workspace, doc.DocumentID, points); err != nil {
+		log.Printf("❌ Glossary Error: %v", err)
+	}
+	if doc.Graph {
+		if err := extractGraph(ctx, doc.Workspace, points); err != nil && res.GraphErr == nil {
+			res.GraphErr = err
+		}
+	}
+}
+
+// indexStatus is the serving collection's status: green once Qdrant has
+// finished indexing and optimising it, yellow while it still is.
+func indexStatus(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	info, err := collectionsClient.Get(ctx, &pb.GetCollectionInfoRequest{CollectionName: collectionName})
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(info.GetResult().GetStatus().String()), nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Intent routing sends greetings and questions about the assistant itself
+// down a small-talk path that skips retrieval, so "hello" doesn't come back
+// with citations. INTENT_ROUTER picks the router: "rules" (the default)
+// matches common phrasings, "llm" also asks the chat model about anything the
+// rules don't catch, and "off" sends everything through retrieval.
+
+var (
+	smallTalkRe = regexp.MustCompile(`^(?:(?:hi|hello|hey|hiya|howdy|yo|greetings|good (?:morning|afternoon|evening|day))(?: there| all| everyone)?|(?:thanks|thank you|thx|ty|cheers)(?: (?:so|very) much| a lot)?|(?:ok|okay|cool|great|nice|awesome|got it|perfect)|(?:bye|goodbye|see you|see ya)|how are you(?: doing)?(?: today)?)$`)
+	metaRe      = regexp.MustCompile(`^(?:what (?:can|do) you do|who are you|what are you|how (?:do you|does this) work|help|what do you know(?: about)?|what can i ask(?: you)?|what are you for)$`)
+	intentTrim  = regexp.MustCompile(`[^\p{L}\p{N}' ]+`)
+)
+
+const smallTalkPrompt = "This message is small talk or a question about you, not about the documents. Reply briefly and warmly in character, without citing or inventing document content; if asked what you can do, explain that you answer questions from the documents you've been given."
+
+// routeIntent classifies a question as "smalltalk" or "documents".
+func routeIntent(ctx context.Context, question string) string {
+	mode := os.Getenv("INTENT_ROUTER")
+	if mode == "off" {
+		return "documents"
+	}
+	q := normalizeQuestion(question)
+	if smallTalkRe.MatchString(q) || metaRe.MatchString(q) {
+		return "smalltalk"
+	}
+	if mode == "llm" && len(q) < 200 {
+		resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model: chatModel,
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: "Classify the user's message. Reply with exactly one word: smalltalk for greetings, thanks, chit-chat or questions about the assistant itself; documents for anything that needs information from documents."},
+				{Role: openai.ChatMessageRoleUser, Content: question},
+			},
+			MaxTokens: 3,
+		})
+		if err == nil && len(resp.Choices) > 0 && strings.Contains(strings.ToLower(resp.Choices[0].Message.Content), "smalltalk") {
+			return "smalltalk"
+		}
+	}
+	return "documents"
+}
+
+// normalizeQuestion lowercases a question and drops its punctuation, for
+// matching it against fixed phrasings.
+func normalizeQuestion(question string) string {
+	return strings.Join(strings.Fields(intentTrim.ReplaceAllString(strings.ToLower(question), " ")), " ")
+}
+
+// smallTalkRequest is the completion for a small-talk turn: the persona and
+// the session history, but no retrieved context.
+func smallTalkRequest(persona, question, lang string, history []openai.ChatCompletionMessage) openai.ChatCompletionRequest {
+	prompt := fmt.Sprintf("%s\n\n%s%s\n\nMessage: %s", persona, smallTalkPrompt, languageInstruction(lang), question)
+	return openai.ChatCompletionRequest{
+		Model:    chatModel,
+		Messages: append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: prompt}),
+	}
+}
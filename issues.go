@@ -0,0 +1,368 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// issueComment is one comment on an issue.
+type issueComment struct {
+	Author string
+	Body   string
+}
+
+// issueText lays an issue out for chunking: its key and title, where it
+// stands, its description, then the discussion.
+func issueText(key, title, status, assignee, description string, comments []issueComment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s\n\nStatus: %s\nAssignee: %s\n", key, title, cmp.Or(status, "unknown"), cmp.Or(assignee, "unassigned"))
+	if d := strings.TrimSpace(description); d != "" {
+		b.WriteString("\n" + d + "\n")
+	}
+	if len(comments) > 0 {
+		b.WriteString("\nComments:\n")
+		for _, cm := range comments {
+			if body := strings.TrimSpace(cm.Body); body != "" {
+				fmt.Fprintf(&b, "\n%s: %s\n", cmp.Or(cm.Author, "someone"), body)
+			}
+		}
+	}
+	return b.String()
+}
+
+// issueMetadata is the filterable fields of an issue, skipping those it
+// doesn't have. open is "true" or "false", so "what open issues mention
+// X?" can be asked with fields {"open": "true"}.
+func issueMetadata(open bool, pairs ...string) map[string]string {
+	meta := map[string]string{"open": fmt.Sprint(open)}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			meta[pairs[i]] = pairs[i+1]
+		}
+	}
+	return meta
+}
+
+// jiraConnector syncs the issues of Jira projects: JIRA_URL (the site,
+// e.g. https://acme.atlassian.net) with JIRA_PROJECTS (comma-separated
+// keys) or a JIRA_JQL query of its own. Jira Cloud needs JIRA_EMAIL and
+// JIRA_API_TOKEN; a Data Center personal access token goes in
+// JIRA_API_TOKEN alone. Each may be set per workspace with a _<WORKSPACE>
+// suffix. Issue labels become tags.
+type jiraConnector struct{}
+
+func (jiraConnector) Name() string { return "jira" }
+
+func (jiraConnector) Fetch(ctx context.Context, workspace string) ([]connectorItem, error) {
+	base := strings.TrimRight(envWorkspace("JIRA_URL", workspace), "/")
+	if base == "" {
+		return nil, fmt.Errorf("JIRA_URL must be set")
+	}
+	jql := envWorkspace("JIRA_JQL", workspace)
+	if jql == "" {
+		var projects []string
+		for _, p := range strings.Split(envWorkspace("JIRA_PROJECTS", workspace), ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				projects = append(projects, `"`+p+`"`)
+			}
+		}
+		if len(projects) == 0 {
+			return nil, fmt.Errorf("JIRA_PROJECTS or JIRA_JQL must be set")
+		}
+		jql = "project in (" + strings.Join(projects, ",") + ") ORDER BY updated DESC"
+	}
+	email, token := envWorkspace("JIRA_EMAIL", workspace), envWorkspace("JIRA_API_TOKEN", workspace)
+	auth := func(req *http.Request) {
+		switch {
+		case email != "" && token != "":
+			req.SetBasicAuth(email, token)
+		case token != "":
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	q := url.Values{
+		"jql":        {jql},
+		"fields":     {"summary,description,comment,status,assignee,priority,issuetype,project,labels,updated"},
+		"maxResults": {"100"},
+	}
+	var items []connectorItem
+	for {
+		body, err := connectorGet(ctx, base+"/rest/api/3/search/jql?"+q.Encode(), auth)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Issues []struct {
+				ID     string `json:"id"`
+				Key    string `json:"key"`
+				Fields struct {
+					Summary     string          `json:"summary"`
+					Description json.RawMessage `json:"description"`
+					Status      struct {
+						Name     string `json:"name"`
+						Category struct {
+							Key string `json:"key"`
+						} `json:"statusCategory"`
+					} `json:"status"`
+					Assignee *struct {
+						DisplayName string `json:"displayName"`
+					} `json:"assignee"`
+					Priority *struct {
+						Name string `json:"name"`
+					} `json:"priority"`
+					IssueType struct {
+						Name string `json:"name"`
+					} `json:"issuetype"`
+					Project struct {
+						Key string `json:"key"`
+					} `json:"project"`
+					Labels  []string `json:"labels"`
+					Updated string   `json:"updated"`
+					Comment struct {
+						Comments []struct {
+							Author struct {
+								DisplayName string `json:"displayName"`
+							} `json:"author"`
+							Body json.RawMessage `json:"body"`
+						} `json:"comments"`
+					} `json:"comment"`
+				} `json:"fields"`
+			} `json:"issues"`
+			NextPageToken string `json:"nextPageToken"`
+			IsLast        bool   `json:"isLast"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		for _, is := range page.Issues {
+			f := is.Fields
+			var assignee, priority string
+			if f.Assignee != nil {
+				assignee = f.Assignee.DisplayName
+			}
+			if f.Priority != nil {
+				priority = f.Priority.Name
+			}
+			comments := make([]issueComment, 0, len(f.Comment.Comments))
+			for _, cm := range f.Comment.Comments {
+				comments = append(comments, issueComment{Author: cm.Author.DisplayName, Body: adfText(cm.Body)})
+			}
+			updated, _ := time.Parse("2006-01-02T15:04:05.000-0700", f.Updated)
+			items = append(items, connectorItem{
+				ID:    is.ID,
+				Title: is.Key + " " + f.Summary,
+				URL:   base + "/browse/" + is.Key,
+				Text:  issueText(is.Key, f.Summary, f.Status.Name, assignee, adfText(f.Description), comments),
+				Tags:  f.Labels,
+				Metadata: issueMetadata(f.Status.Category.Key != "done",
+					"key", is.Key,
+					"status", f.Status.Name,
+					"status_category", f.Status.Category.Key,
+					"assignee", assignee,
+					"priority", priority,
+					"type", f.IssueType.Name,
+					"project", f.Project.Key),
+				UpdatedAt: updated,
+			})
+		}
+		if page.IsLast || page.NextPageToken == "" {
+			return items, nil
+		}
+		q.Set("nextPageToken", page.NextPageToken)
+	}
+}
+
+// adfText flattens an Atlassian Document Format value, the rich text Jira
+// Cloud uses for descriptions and comments, to plain text. Older servers
+// send a plain string, which is returned as is.
+func adfText(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	type node struct {
+		Type  string `json:"type"`
+		Text  string `json:"text"`
+		Attrs struct {
+			Text string `json:"text"` // mentions, emoji
+		} `json:"attrs"`
+		Content []node `json:"content"`
+	}
+	var doc node
+	if json.Unmarshal(raw, &doc) != nil {
+		return ""
+	}
+	var b strings.Builder
+	var walk func(n node)
+	walk = func(n node) {
+		switch n.Type {
+		case "text":
+			b.WriteString(n.Text)
+		case "hardBreak":
+			b.WriteString("\n")
+		case "mention", "emoji":
+			b.WriteString(n.Attrs.Text)
+		case "listItem":
+			b.WriteString("- ")
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+		switch n.Type {
+		case "paragraph", "heading", "codeBlock", "blockquote", "rule", "tableRow":
+			b.WriteString("\n")
+		case "tableCell", "tableHeader":
+			b.WriteString(" | ")
+		}
+	}
+	walk(doc)
+	return strings.TrimSpace(b.String())
+}
+
+// linearConnector syncs the issues of a Linear workspace with
+// LINEAR_API_KEY, optionally only those of the teams in LINEAR_TEAMS
+// (comma-separated keys, e.g. ENG,OPS). Both may be set per workspace with
+// a _<WORKSPACE> suffix. Issue labels become tags.
+type linearConnector struct{}
+
+func (linearConnector) Name() string { return "linear" }
+
+const linearIssuesQuery = `query($after: String, $filter: IssueFilter) {
+  issues(first: 50, after: $after, filter: $filter) {
+    nodes {
+      id identifier title description url updatedAt priorityLabel
+      state { name type }
+      assignee { name }
+      team { key }
+      labels { nodes { name } }
+      comments { nodes { body user { name } } }
+    }
+    pageInfo { hasNextPage endCursor }
+  }
+}`
+
+func (linearConnector) Fetch(ctx context.Context, workspace string) ([]connectorItem, error) {
+	key := envWorkspace("LINEAR_API_KEY", workspace)
+	if key == "" {
+		return nil, fmt.Errorf("LINEAR_API_KEY must be set")
+	}
+	auth := func(req *http.Request) { req.Header.Set("Authorization", key) }
+	vars := map[string]any{}
+	var teams []string
+	for _, t := range strings.Split(envWorkspace("LINEAR_TEAMS", workspace), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			teams = append(teams, t)
+		}
+	}
+	if len(teams) > 0 {
+		vars["filter"] = map[string]any{"team": map[string]any{"key": map[string]any{"in": teams}}}
+	}
+
+	var items []connectorItem
+	for {
+		body, err := connectorPost(ctx, "https://api.linear.app/graphql", map[string]any{"query": linearIssuesQuery, "variables": vars}, auth)
+		if err != nil {
+			return nil, err
+		}
+		var res struct {
+			Data struct {
+				Issues struct {
+					Nodes []struct {
+						ID            string    `json:"id"`
+						Identifier    string    `json:"identifier"`
+						Title         string    `json:"title"`
+						Description   string    `json:"description"`
+						URL           string    `json:"url"`
+						UpdatedAt     time.Time `json:"updatedAt"`
+						PriorityLabel string    `json:"priorityLabel"`
+						State         struct {
+							Name string `json:"name"`
+							Type string `json:"type"`
+						} `json:"state"`
+						Assignee *struct {
+							Name string `json:"name"`
+						} `json:"assignee"`
+						Team struct {
+							Key string `json:"key"`
+						} `json:"team"`
+						Labels struct {
+							Nodes []struct {
+								Name string `json:"name"`
+							} `json:"nodes"`
+						} `json:"labels"`
+						Comments struct {
+							Nodes []struct {
+								Body string `json:"body"`
+								User *struct {
+									Name string `json:"name"`
+								} `json:"user"`
+							} `json:"nodes"`
+						} `json:"comments"`
+					} `json:"nodes"`
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+				} `json:"issues"`
+			} `json:"data"`
+			Errors []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return nil, err
+		}
+		if len(res.Errors) > 0 {
+			return nil, fmt.Errorf("linear: %s", res.Errors[0].Message)
+		}
+		for _, is := range res.Data.Issues.Nodes {
+			var assignee string
+			if is.Assignee != nil {
+				assignee = is.Assignee.Name
+			}
+			comments := make([]issueComment, 0, len(is.Comments.Nodes))
+			for _, cm := range is.Comments.Nodes {
+				c := issueComment{Body: cm.Body}
+				if cm.User != nil {
+					c.Author = cm.User.Name
+				}
+				comments = append(comments, c)
+			}
+			var tags []string
+			for _, l := range is.Labels.Nodes {
+				tags = append(tags, l.Name)
+			}
+			items = append(items, connectorItem{
+				ID:    is.ID,
+				Title: is.Identifier + " " + is.Title,
+				URL:   is.URL,
+				Text:  issueText(is.Identifier, is.Title, is.State.Name, assignee, is.Description, comments),
+				Tags:  tags,
+				Metadata: issueMetadata(is.State.Type != "completed" && is.State.Type != "canceled",
+					"key", is.Identifier,
+					"status", is.State.Name,
+					"status_category", is.State.Type,
+					"assignee", assignee,
+					"priority", is.PriorityLabel,
+					"project", is.Team.Key),
+				UpdatedAt: is.UpdatedAt,
+			})
+		}
+		if !res.Data.Issues.PageInfo.HasNextPage {
+			return items, nil
+		}
+		vars["after"] = res.Data.Issues.PageInfo.EndCursor
+	}
+}
+
+func init() {
+	registerConnector(jiraConnector{})
+	registerConnector(linearConnector{})
+}
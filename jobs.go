@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// ingestJob is an ingest handed to a worker process. Its record in the
+// "jobs" bucket is how the API reports progress.
+type ingestJob struct {
+	ID          string             `json:"id"`
+	DocumentID  string             `json:"document_id"`
+	Filename    string             `json:"filename"`
+	Path        string             `json:"path"`
+	UploadID    string             `json:"upload_id,omitempty"`
+	ParentID    string             `json:"parent_id,omitempty"` // set for files embedded in another document
+	Depth       int                `json:"depth,omitempty"`
+	Workspace   string             `json:"workspace"`
+	Owner       string             `json:"owner,omitempty"`
+	Graph       bool               `json:"graph"`
+	Tags        []string           `json:"tags,omitempty"`
+	Source      string             `json:"source,omitempty"`
+	Title       string             `json:"title,omitempty"` // overrides the title found in the document
+	Date        time.Time          `json:"date,omitzero"`   // overrides the date found in the document
+	URL         string             `json:"url,omitempty"`   // where the original can be read, for citations
+	Metadata    map[string]string  `json:"metadata,omitempty"`
+	Mapping     *recordMapping     `json:"mapping,omitempty"`    // for JSON and JSONL records
+	Supersedes  string             `json:"supersedes,omitempty"` // document this is a new version of
+	Password    string             `json:"password,omitempty"`   // sealed; cleared once the job finishes
+	State       string             `json:"state"`                // queued, running, done, failed
+	Chunks      int                `json:"chunks"`
+	Pages       int                `json:"pages,omitempty"`
+	FailedPages []pageError        `json:"failed_pages,omitempty"`
+	Attachments []attachmentResult `json:"attachments,omitempty"`
+	Language    string             `json:"language,omitempty"`
+	Duplicates  int                `json:"duplicate_chunks,omitempty"`
+	IndexStatus string             `json:"index_status,omitempty"` // the collection's status when reported: green, or yellow while still optimising
+	Error       string             `json:"error,omitempty"`
+	ErrorCode   string             `json:"error_code,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+}
+
+// jobQueue carries ingest jobs from the API to `docuchat worker` processes.
+type jobQueue interface {
+	Enqueue(ctx context.Context, job ingestJob) error
+	// Consume hands jobs to handle until ctx is cancelled. A job is
+	// acknowledged once handle returns.
+	Consume(ctx context.Context, handle func(ingestJob)) error
+}
+
+// jobs is nil when ingestion runs inline in the API process.
+var jobs jobQueue
+
+// openJobQueue picks the queue from JOB_QUEUE: unset for inline ingestion, or
+// "redis" for a Redis stream at REDIS_URL. Workers and the API must share
+// UPLOAD_DIR and a STORE_BACKEND=redis store.
+func openJobQueue() (jobQueue, error) {
+	switch backend := os.Getenv("JOB_QUEUE"); backend {
+	case "":
+		return nil, nil
+	case "redis":
+		if os.Getenv("STORE_BACKEND") != "redis" {
+			log.Println("⚠️ JOB_QUEUE=redis without STORE_BACKEND=redis: job status won't be visible across processes")
+		}
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			url = "redis://localhost:6379/0"
+		}
+		return openRedisQueue(url)
+	default:
+		return nil, fmt.Errorf("unknown JOB_QUEUE %q", backend)
+	}
+}
+
+// enqueueIngest stages the file somewhere workers can read it and queues the
+// job. Uploads already live in UPLOAD_DIR; multipart files are moved there.
+func enqueueIngest(ctx context.Context, job ingestJob) gin.H {
+	if job.UploadID == "" {
+		staged := filepath.Join(uploadDir(), "job-"+job.ID+filepath.Ext(job.Filename))
+		move := moveFile
+		if atRest != nil {
+			move = sealFile
+		}
+		if err := move(job.Path, staged); err != nil {
+			return errorReply(ctx, codeStorage, "Upload Storage Error: "+err.Error())
+		}
+		job.Path = staged
+	}
+	job.State = "queued"
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = job.CreatedAt
+	if err := metaStore.Put("jobs", job.ID, job); err != nil {
+		return upstreamReply(ctx, upstreamMetadata, "Metadata Store Error: "+err.Error())
+	}
+	if err := jobs.Enqueue(ctx, job); err != nil {
+		return upstreamReply(ctx, upstreamJobQueue, "Job Queue Error: "+err.Error())
+	}
+	return gin.H{"status": "success", "message": "File queued for ingestion", "job_id": job.ID, "document_id": job.DocumentID}
+}
+
+func moveFile(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	if os.Rename(from, to) == nil {
+		return nil
+	}
+	// different filesystems
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(to)
+		return err
+	}
+	return out.Close()
+}
+
+// handleJobStatus reports a queued ingest: GET /jobs/:id.
+func handleJobStatus(c *gin.Context) {
+	var job ingestJob
+	if found, _ := metaStore.Get("jobs", c.Param("id"), &job); !found || (job.Owner != "" && job.Owner != requestUser(c)) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Job not found"))
+		return
+	}
+	job.Path, job.Password = "", ""
+	if job.State == "done" {
+		job.IndexStatus, _ = indexStatus(c.Request.Context())
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "job": job})
+}
+
+// runWorker is `docuchat worker`: it consumes ingest jobs with
+// WORKER_CONCURRENCY goroutines until the process is stopped.
+func runWorker() {
+	if jobs == nil {
+		log.Fatal("❌ docuchat worker needs JOB_QUEUE to be set")
+	}
+	concurrency := max(envInt("WORKER_CONCURRENCY", 1), 1)
+	log.Printf("🛠️ Worker consuming ingest jobs (%d at a time)", concurrency)
+	errs := make(chan error, concurrency)
+	for range concurrency {
+		go func() { errs <- jobs.Consume(context.Background(), processJob) }()
+	}
+	log.Fatalf("❌ Job Queue Error: %v", <-errs)
+}
+
+// processJob runs one queued ingest and records how it went.
+func processJob(job ingestJob) {
+	update := func() {
+		job.UpdatedAt = time.Now()
+		if err := metaStore.Put("jobs", job.ID, job); err != nil {
+			log.Printf("❌ Metadata Store Error: %v", err)
+		}
+	}
+	var current ingestJob
+	if found, _ := metaStore.Get("jobs", job.ID, &current); found && (current.State == "done" || current.State == "failed") {
+		return // redelivered after it finished
+	}
+	job.State = "running"
+	update()
+
+	res, err := func() (res ingestResult, err error) {
+		// a crash fails the job rather than the worker, which would only
+		// crash again on the redelivered job
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("🔥 PANIC in job %s: %v\nStack: %s", job.ID, r, string(debug.Stack()))
+				err = fmt.Errorf("ingest crashed: %v", r)
+			}
+		}()
+		return executeIngest(context.Background(), job)
+	}()
+	job.Chunks, job.Language, job.Pages, job.FailedPages = res.Chunks, res.Language, res.Pages, res.FailedPages
+	job.Attachments, job.Duplicates = res.Attachments, res.Duplicates
+	switch {
+	case err != nil:
+		job.State, job.Error, job.ErrorCode = "failed", err.Error(), errorCode(err)
+	case res.Chunks == 0:
+		job.State, job.Error = "failed", "No text found in PDF"
+	default:
+		// the last batch was written with wait, so the chunks are searchable
+		// already; GET /jobs/:id reports how far Qdrant's optimising has got
+		job.State = "done"
+		if res.GraphErr != nil {
+			job.Error = "graph extraction failed for some chunks: " + res.GraphErr.Error()
+		}
+	}
+
+	if job.UploadID != "" {
+		var u upload
+		if found, _ := metaStore.Get("uploads", job.UploadID, &u); found && job.State == "done" {
+			finishUpload(u)
+		}
+	} else {
+		os.Remove(job.Path)
+	}
+	job.Password = ""
+	update()
+	log.Printf("📄 Job %s %s (%d chunks)", job.ID, job.State, job.Chunks)
+}
+
+// redisQueue is a jobQueue on a Redis stream with one consumer group, so
+// each job goes to exactly one worker. Jobs left unacknowledged by a worker
+// that died are claimed by another after JOB_CLAIM_IDLE_MINUTES.
+type redisQueue struct {
+	client *redis.Client
+	stream string
+	group  string
+}
+
+func openRedisQueue(url string) (*redisQueue, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	q := &redisQueue{client: redis.NewClient(opts), stream: "docuchat:ingest", group: "workers"}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	err = q.client.XGroupCreateMkStream(ctx, q.stream, q.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *redisQueue) Enqueue(ctx context.Context, job ingestJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.client.XAdd(ctx, &redis.XAddArgs{Stream: q.stream, Values: map[string]any{"job": data}}).Err()
+}
+
+func (q *redisQueue) Consume(ctx context.Context, handle func(ingestJob)) error {
+	host, _ := os.Hostname()
+	consumer := fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	idle := time.Duration(envInt("JOB_CLAIM_IDLE_MINUTES", 10)) * time.Minute
+
+	for ctx.Err() == nil {
+		msgs, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream: q.stream, Group: q.group, Consumer: consumer, MinIdle: idle, Start: "0", Count: 1,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(msgs) == 0 {
+			streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group: q.group, Consumer: consumer, Streams: []string{q.stream, ">"}, Count: 1, Block: 5 * time.Second,
+			}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for _, s := range streams {
+				msgs = append(msgs, s.Messages...)
+			}
+		}
+
+		for _, msg := range msgs {
+			var job ingestJob
+			raw, _ := msg.Values["job"].(string)
+			if err := json.Unmarshal([]byte(raw), &job); err != nil {
+				log.Printf("❌ Bad job %s: %v", msg.ID, err)
+			} else {
+				handle(job)
+			}
+			if err := q.client.XAck(ctx, q.stream, q.group, msg.ID).Err(); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai"
+)
+
+var languageNames = map[string]string{
+	"en": "English", "es": "Spanish", "fr": "French", "de": "German", "it": "Italian",
+	"pt": "Portuguese", "nl": "Dutch", "sv": "Swedish", "pl": "Polish", "tr": "Turkish",
+	"ru": "Russian", "uk": "Ukrainian", "el": "Greek", "ar": "Arabic", "he": "Hebrew",
+	"fa": "Persian", "hi": "Hindi", "th": "Thai", "zh": "Chinese", "ja": "Japanese", "ko": "Korean",
+}
+
+// stopwords are frequent function words used to tell Latin-script languages apart.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "is", "of", "to", "in", "that", "what", "for", "with", "are", "this", "how", "does"},
+	"es": {"el", "la", "de", "que", "y", "en", "los", "es", "por", "para", "las", "una", "qué", "cómo"},
+	"fr": {"le", "la", "les", "de", "et", "est", "des", "que", "une", "pour", "dans", "quel", "quelle", "comment"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "ein", "eine", "zu", "den", "was", "wie", "für"},
+	"it": {"il", "di", "che", "e", "la", "per", "non", "sono", "una", "del", "della", "cosa", "come", "gli"},
+	"pt": {"o", "de", "que", "e", "do", "da", "em", "um", "para", "não", "uma", "os", "como", "qual"},
+	"nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "voor", "wat", "hoe", "zijn", "met"},
+	"sv": {"och", "att", "det", "som", "en", "är", "på", "för", "med", "vad", "hur", "inte", "av", "till"},
+	"pl": {"i", "w", "nie", "na", "się", "że", "jest", "do", "to", "jak", "co", "czy", "z", "dla"},
+	"tr": {"ve", "bir", "bu", "için", "ile", "da", "de", "ne", "nasıl", "mi", "değil", "olan", "çok", "gibi"},
+}
+
+// detectLanguage guesses the ISO 639-1 code of text: by script for non-Latin
+// text, by stopword frequency otherwise. It returns "und" when unsure.
+func detectLanguage(text string) string {
+	scripts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			scripts["ja"] += 3 // kana is decisive even in kanji-heavy text
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			scripts["cyrl"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Devanagari, r):
+			scripts["hi"]++
+		case unicode.Is(unicode.Thai, r):
+			scripts["th"]++
+		case unicode.Is(unicode.Latin, r):
+			scripts["latn"]++
+		}
+	}
+	if letters == 0 {
+		return "und"
+	}
+	best, bestCount := "", 0
+	for s, n := range scripts {
+		if n > bestCount {
+			best, bestCount = s, n
+		}
+	}
+	if scripts["ja"] > 0 && best == "zh" {
+		best = "ja"
+	}
+	switch best {
+	case "latn":
+		return detectLatin(text)
+	case "cyrl":
+		if strings.ContainsAny(text, "їієґ") {
+			return "uk"
+		}
+		return "ru"
+	case "ar":
+		if strings.ContainsAny(text, "پچژگ") {
+			return "fa"
+		}
+	}
+	return best
+}
+
+func detectLatin(text string) string {
+	counts := map[string]int{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, w := range words {
+		for lang, list := range stopwords {
+			for _, sw := range list {
+				if w == sw {
+					counts[lang]++
+				}
+			}
+		}
+	}
+	best, bestCount := "und", 0
+	for _, lang := range []string{"en", "es", "fr", "de", "it", "pt", "nl", "sv", "pl", "tr"} {
+		if counts[lang] > bestCount {
+			best, bestCount = lang, counts[lang]
+		}
+	}
+	if best == "und" && len(words) > 0 {
+		return "en"
+	}
+	return best
+}
+
+func languageName(code string) string {
+	if name, ok := languageNames[code]; ok {
+		return name
+	}
+	return code
+}
+
+// languageInstruction is appended to system prompts to pin the answer language.
+func languageInstruction(lang string) string {
+	if lang == "und" || lang == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n\nAlways answer in %s, whatever language the context is in.", languageName(lang))
+}
+
+// answerLanguage picks the language to answer in: ANSWER_LANGUAGE when set,
+// otherwise the question's own language.
+func answerLanguage(question string) string {
+	if forced := os.Getenv("ANSWER_LANGUAGE"); forced != "" {
+		return forced
+	}
+	return detectLanguage(question)
+}
+
+// translateContext rewrites retrieved passages into the answer language when
+// TRANSLATE_CONTEXT is enabled. Passages already in that language, and any
+// that fail to translate, are passed through unchanged.
+func translateContext(ctx context.Context, texts []string, lang string) []string {
+	if os.Getenv("TRANSLATE_CONTEXT") != "true" || lang == "und" {
+		return texts
+	}
+	out := make([]string, len(texts))
+	for i, text := range texts {
+		out[i] = text
+		if detectLanguage(text) == lang {
+			continue
+		}
+		resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model: chatModel,
+			Messages: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: fmt.Sprintf("Translate the user's text into %s. Keep names, numbers and formatting. Reply with the translation only.", languageName(lang))},
+				{Role: openai.ChatMessageRoleUser, Content: text},
+			},
+		})
+		if err == nil && len(resp.Choices) > 0 {
+			out[i] = resp.Choices[0].Message.Content
+		}
+	}
+	return out
+}
+
+// languageFilter restricts a search to chunks in one language, if given.
+func languageFilter(lang string) *pb.Filter {
+	if lang == "" {
+		return nil
+	}
+	return &pb.Filter{Must: []*pb.Condition{pb.NewMatch("language", lang)}}
+}
+
+// languageBoost is the LANGUAGE_BOOST score multiplier for chunks in the
+// question's language; 1 (the default) disables boosting.
+func languageBoost() float32 {
+	if f, err := strconv.ParseFloat(os.Getenv("LANGUAGE_BOOST"), 32); err == nil && f > 0 {
+		return float32(f)
+	}
+	return 1
+}
+
+// languageOversample is how many extra candidates to fetch per result slot so
+// boosting has something to reorder.
+func languageOversample() uint64 {
+	if languageBoost() == 1 && !loadRankingBoosts().enabled() {
+		return 1
+	}
+	return 2
+}
+
+// boostLanguage rescales scores of hits in lang by LANGUAGE_BOOST, and by the
+// ranking boosts, and keeps the best limit of them.
+func boostLanguage(hits []*pb.ScoredPoint, lang string, limit int) []*pb.ScoredPoint {
+	boosted := applyRankingBoosts(hits)
+	if boost := languageBoost(); boost != 1 {
+		for _, h := range hits {
+			if payloadString(h.Payload, "language") == lang {
+				h.Score *= boost
+			}
+		}
+		boosted = true
+	}
+	if boosted {
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	}
+	return hits[:min(limit, len(hits))]
+}
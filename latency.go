@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stages a latency budget may drop are named as their feature flags are;
+// these are the ones without a flag, the answer generation the budget
+// keeps time for, and the stages that always run, timed for debug.timings.
+const (
+	stageGraph     = "graph"
+	stageGenerate  = "generate"
+	stageOverrides = "overrides"
+	stageEmbed     = "embed"
+	stageSearch    = "search"
+	stageRerank    = "rerank"
+)
+
+// stageTimes is a moving average of each stage's recent duration, learned
+// from every chat whether or not it has a budget.
+var stageTimes = struct {
+	sync.Mutex
+	avg map[string]time.Duration
+}{avg: map[string]time.Duration{}}
+
+func recordStage(stage string, took time.Duration) {
+	stageTimes.Lock()
+	defer stageTimes.Unlock()
+	if prev, ok := stageTimes.avg[stage]; ok {
+		took = (prev*4 + took) / 5
+	}
+	stageTimes.avg[stage] = took
+}
+
+func expectedStage(stage string) time.Duration {
+	stageTimes.Lock()
+	defer stageTimes.Unlock()
+	return stageTimes.avg[stage]
+}
+
+// stageLatencies reports the expected duration of each stage, in ms.
+func stageLatencies() map[string]float64 {
+	stageTimes.Lock()
+	defer stageTimes.Unlock()
+	out := make(map[string]float64, len(stageTimes.avg))
+	for stage, d := range stageTimes.avg {
+		out[stage] = float64(d.Microseconds()) / 1000
+	}
+	return out
+}
+
+// latencyBudget bounds how long a chat may take before its answer is
+// generated. A stage runs only if, by the stages' recent durations, it and
+// the generation after it still fit; otherwise it's skipped and named in
+// dropped. The budget is the request's latency_budget_ms, else
+// LATENCY_BUDGET_MS (per workspace with a _<WORKSPACE> suffix); none by
+// default. It also keeps what each stage of this chat took.
+type latencyBudget struct {
+	start   time.Time
+	limit   time.Duration
+	dropped *[]string
+	took    map[string]time.Duration
+}
+
+func newLatencyBudget(requestMs int, workspace string, dropped *[]string) *latencyBudget {
+	ms := requestMs
+	if ms <= 0 {
+		ms, _ = strconv.Atoi(envWorkspace("LATENCY_BUDGET_MS", workspace))
+	}
+	return &latencyBudget{start: time.Now(), limit: time.Duration(max(ms, 0)) * time.Millisecond, dropped: dropped, took: map[string]time.Duration{}}
+}
+
+// run runs an optional stage if it fits in the budget, reporting whether it
+// ran.
+func (b *latencyBudget) run(stage string, fn func()) bool {
+	if b.limit > 0 && time.Since(b.start)+expectedStage(stage)+expectedStage(stageGenerate) > b.limit {
+		*b.dropped = append(*b.dropped, stage)
+		log.Printf("⏱️ Latency budget: skipped %s", stage)
+		return false
+	}
+	b.measure(stage, fn)
+	return true
+}
+
+// measure runs a stage that always runs, learning its duration.
+func (b *latencyBudget) measure(stage string, fn func()) {
+	start := time.Now()
+	fn()
+	recordStage(stage, time.Since(start))
+	b.took[stage] += time.Since(start)
+}
+
+// timings is what each stage of the chat has taken so far and the total,
+// in ms, with the generation as "completion", for debug.timings.
+func (b *latencyBudget) timings() map[string]float64 {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	out := make(map[string]float64, len(b.took)+1)
+	for stage, d := range b.took {
+		if stage == stageGenerate {
+			stage = "completion"
+		}
+		out[stage] = ms(d)
+	}
+	out["total"] = ms(time.Since(b.start))
+	return out
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// layoutEnabled reports whether PDF pages are extracted with layoutText
+// rather than in content-stream order; PDF_LAYOUT=true turns it on.
+func layoutEnabled() bool {
+	return os.Getenv("PDF_LAYOUT") == "true"
+}
+
+// layoutRow is a run of text on one baseline within one column.
+type layoutRow struct {
+	x0, x1 float64
+	y      float64
+	size   float64
+	text   string
+	mono   bool // set entirely in a fixed-width font, as code usually is
+}
+
+// layoutText extracts a page in reading order: glyphs are grouped into lines
+// by baseline, a two-column page is read left column first (full-width lines
+// such as titles stay where they are), paragraphs are separated where the
+// line spacing opens up, lines set noticeably larger than the body text
+// become their own paragraph so the chunker treats them as headings, and runs
+// of fixed-width lines become fenced code blocks with their indentation
+// restored. It returns "" if the page can't be laid out.
+func layoutText(page pdf.Page) (text string) {
+	defer func() {
+		if recover() != nil {
+			text = ""
+		}
+	}()
+	return arrangeGlyphs(page.Content().Text)
+}
+
+func arrangeGlyphs(glyphs []pdf.Text) string {
+	lines := layoutLines(glyphs)
+	if len(lines) == 0 {
+		return ""
+	}
+
+	var blocks [][]layoutRow
+	gutter, ok := findGutter(lines)
+	if !ok {
+		var all []layoutRow
+		for _, line := range lines {
+			all = append(all, joinRows(line))
+		}
+		blocks = append(blocks, all)
+	} else {
+		var left, right []layoutRow
+		flush := func() {
+			for _, col := range [][]layoutRow{left, right} {
+				if len(col) > 0 {
+					blocks = append(blocks, col)
+				}
+			}
+			left, right = nil, nil
+		}
+		for _, line := range lines {
+			var l, r []layoutRow
+			spans := false
+			for _, seg := range line {
+				switch {
+				case seg.x1 <= gutter:
+					l = append(l, seg)
+				case seg.x0 >= gutter:
+					r = append(r, seg)
+				default:
+					spans = true
+				}
+			}
+			if spans {
+				flush()
+				blocks = append(blocks, []layoutRow{joinRows(line)})
+				continue
+			}
+			if len(l) > 0 {
+				left = append(left, joinRows(l))
+			}
+			if len(r) > 0 {
+				right = append(right, joinRows(r))
+			}
+		}
+		flush()
+	}
+	return renderBlocks(blocks)
+}
+
+// layoutLines groups glyphs into lines, top of the page first, each split
+// into segments wherever a gap is too wide to be a word space.
+func layoutLines(glyphs []pdf.Text) [][]layoutRow {
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		if glyphs[i].Y != glyphs[j].Y {
+			return glyphs[i].Y > glyphs[j].Y
+		}
+		return glyphs[i].X < glyphs[j].X
+	})
+	var groups [][]pdf.Text
+	for _, g := range glyphs {
+		if n := len(groups); n > 0 {
+			last := groups[n-1]
+			if math.Abs(last[0].Y-g.Y) <= glyphSize(last[0])*0.5 {
+				groups[n-1] = append(last, g)
+				continue
+			}
+		}
+		groups = append(groups, []pdf.Text{g})
+	}
+
+	var lines [][]layoutRow
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool { return group[i].X < group[j].X })
+		var segs []layoutRow
+		var b strings.Builder
+		var cur layoutRow
+		proportional := false // cur has a glyph in a proportional font
+		// PDFs that draw their spaces don't need word gaps guessed
+		spaced := slices.ContainsFunc(group, func(g pdf.Text) bool { return g.S == " " })
+		for i, g := range group {
+			size := glyphSize(g)
+			if i > 0 {
+				gap := g.X - cur.x1
+				if gap > 2*size && !(cur.mono && monospaceFont(g.Font)) {
+					cur.text = b.String()
+					segs = append(segs, cur)
+					b.Reset()
+				} else if cur.mono && monospaceFont(g.Font) && gap > 0.2*size {
+					// code keeps its spacing: one space per character cell
+					b.WriteString(strings.Repeat(" ", max(1, int(math.Round(gap/(0.6*size))))))
+				} else if !spaced && gap > 0.2*size && !strings.HasSuffix(b.String(), " ") {
+					b.WriteByte(' ')
+				}
+			}
+			if b.Len() == 0 {
+				cur = layoutRow{x0: g.X, y: g.Y, mono: true}
+				proportional = false
+			}
+			if strings.TrimSpace(g.S) != "" && !monospaceFont(g.Font) {
+				proportional = true
+			}
+			cur.mono = !proportional
+			b.WriteString(g.S)
+			cur.x1 = max(cur.x1, g.X+glyphWidth(g))
+			cur.size = max(cur.size, size)
+		}
+		cur.text = b.String()
+		segs = append(segs, cur)
+		kept := segs[:0]
+		for _, s := range segs {
+			if s.text = strings.TrimSpace(s.text); s.text != "" {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) > 0 {
+			lines = append(lines, kept)
+		}
+	}
+	return lines
+}
+
+// glyphSize is a glyph's font size, with a body-text guess for PDFs that
+// scale text through the matrix instead.
+func glyphSize(g pdf.Text) float64 {
+	if g.FontSize < 2 {
+		return 10
+	}
+	return g.FontSize
+}
+
+// glyphWidth is a glyph's advance, estimated at half an em when the PDF
+// doesn't say.
+func glyphWidth(g pdf.Text) float64 {
+	if g.W > 0 {
+		return g.W
+	}
+	return glyphSize(g) * 0.5 * float64(len([]rune(g.S)))
+}
+
+// findGutter looks for a vertical strip in the middle of the page that
+// almost no text crosses, with plenty of text on either side: the space
+// between two columns. It returns the strip's centre.
+func findGutter(lines [][]layoutRow) (float64, bool) {
+	if len(lines) < 6 {
+		return 0, false
+	}
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for _, line := range lines {
+		for _, s := range line {
+			minX, maxX = min(minX, s.x0), max(maxX, s.x1)
+		}
+	}
+	const bin = 2.0
+	width := maxX - minX
+	if width < 100 {
+		return 0, false
+	}
+	cover := make([]int, int(width/bin)+1)
+	for _, line := range lines {
+		for _, s := range line {
+			for i := int((s.x0 - minX) / bin); i <= int((s.x1-minX)/bin) && i < len(cover); i++ {
+				cover[i]++
+			}
+		}
+	}
+
+	// longest run of rarely crossed bins in the middle 40% of the page
+	limit := len(lines) / 10
+	lo, hi := int(0.3*width/bin), int(0.7*width/bin)
+	bestStart, bestLen, runStart := 0, 0, -1
+	for i := lo; i <= hi && i < len(cover); i++ {
+		if cover[i] <= limit {
+			if runStart < 0 {
+				runStart = i
+			}
+			if n := i - runStart + 1; n > bestLen {
+				bestStart, bestLen = runStart, n
+			}
+		} else {
+			runStart = -1
+		}
+	}
+	if float64(bestLen)*bin < 8 {
+		return 0, false
+	}
+	gutter := minX + (float64(bestStart)+float64(bestLen)/2)*bin
+
+	left, right := 0, 0
+	for _, line := range lines {
+		for _, s := range line {
+			if s.x1 <= gutter {
+				left++
+			} else if s.x0 >= gutter {
+				right++
+			}
+		}
+	}
+	total := left + right
+	if left*4 < total || right*4 < total {
+		return 0, false
+	}
+	return gutter, true
+}
+
+// joinRows merges the segments of one line back into a single row.
+func joinRows(segs []layoutRow) layoutRow {
+	row := segs[0]
+	texts := []string{row.text}
+	for _, s := range segs[1:] {
+		texts = append(texts, s.text)
+		row.x1 = max(row.x1, s.x1)
+		row.size = max(row.size, s.size)
+		row.mono = row.mono && s.mono
+	}
+	row.text = strings.Join(texts, " ")
+	return row
+}
+
+// renderBlocks writes blocks in order, starting a new paragraph at each
+// block, where the line spacing widens and around headings.
+func renderBlocks(blocks [][]layoutRow) string {
+	body := bodySize(blocks)
+	var b strings.Builder
+	paragraph := func() {
+		if s := b.String(); s != "" && !strings.HasSuffix(s, "\n\n") {
+			b.WriteString("\n")
+		}
+	}
+	for _, block := range blocks {
+		paragraph()
+		for i := 0; i < len(block); i++ {
+			row := block[i]
+			if row.mono {
+				j := i
+				for j < len(block) && block[j].mono {
+					j++
+				}
+				paragraph()
+				b.WriteString(codeFence(block[i:j]))
+				i = j - 1
+				continue
+			}
+			heading := row.size >= body*1.25 && len(row.text) < 120
+			if heading || (i > 0 && block[i-1].y-row.y > 1.8*max(row.size, block[i-1].size)) {
+				paragraph()
+			}
+			b.WriteString(row.text)
+			b.WriteString("\n")
+			if heading {
+				b.WriteString("\n")
+			}
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// codeFence renders fixed-width rows as a fenced code block, indenting each
+// line by how far right of the leftmost one it starts.
+func codeFence(rows []layoutRow) string {
+	left := rows[0].x0
+	for _, r := range rows {
+		left = min(left, r.x0)
+	}
+	var b strings.Builder
+	b.WriteString("```\n")
+	for i, r := range rows {
+		// a blank line where the spacing opens up, as between functions
+		if i > 0 && rows[i-1].y-r.y > 1.8*max(r.size, rows[i-1].size) {
+			b.WriteString("\n")
+		}
+		b.WriteString(strings.Repeat(" ", int(math.Round((r.x0-left)/(0.6*r.size)))))
+		b.WriteString(r.text)
+		b.WriteString("\n")
+	}
+	b.WriteString("```\n\n")
+	return b.String()
+}
+
+// bodySize is the font size most of the text is set in.
+func bodySize(blocks [][]layoutRow) float64 {
+	chars := map[float64]int{}
+	for _, block := range blocks {
+		for _, row := range block {
+			chars[math.Round(row.size)] += len(row.text)
+		}
+	}
+	best, most := 10.0, 0
+	for size, n := range chars {
+		if n > most || (n == most && size < best) {
+			best, most = size, n
+		}
+	}
+	return best
+}
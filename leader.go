@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// leaseStore is implemented by metadata stores that can hand out a named
+// lease to one holder at a time.
+type leaseStore interface {
+	// TryLease takes or renews the lease for holder and reports whether
+	// holder now has it.
+	TryLease(name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(name, holder string) error
+}
+
+type lease struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// leaderElection keeps one replica in charge of scheduled work. Every
+// replica competes for a lease in the metadata store (LEADER_LEASE_SECONDS,
+// default 15) and the holder renews it a few times per term, so a dead
+// leader is replaced within one lease.
+type leaderElection struct {
+	name     string
+	holder   string
+	ttl      time.Duration
+	isLeader atomic.Bool
+
+	mu    sync.Mutex
+	tasks []scheduledTask
+}
+
+type scheduledTask struct {
+	name  string
+	every time.Duration
+	run   func(ctx context.Context)
+}
+
+var scheduler = &leaderElection{name: "scheduler"}
+
+// identity names this replica: the pod name under Kubernetes, otherwise
+// host and pid.
+func identity() string {
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		return pod
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// schedule registers a task to run every interval on the leader only.
+func (e *leaderElection) schedule(name string, every time.Duration, run func(ctx context.Context)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tasks = append(e.tasks, scheduledTask{name, every, run})
+}
+
+// start campaigns for the lease and runs the scheduled tasks while this
+// replica holds it.
+func (e *leaderElection) start(ctx context.Context) {
+	store, ok := metaStore.(leaseStore)
+	if !ok {
+		log.Printf("⚠️ Metadata store has no leases; %s tasks will not run", e.name)
+		return
+	}
+	e.holder = identity()
+	e.ttl = time.Duration(max(envInt("LEADER_LEASE_SECONDS", 15), 1)) * time.Second
+
+	go func() {
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+		for {
+			held, err := store.TryLease(e.name, e.holder, e.ttl)
+			if err != nil {
+				log.Printf("❌ Leader Election Error: %v", err)
+				held = false
+			}
+			if held != e.isLeader.Swap(held) {
+				if held {
+					log.Printf("👑 %s is now the %s leader", e.holder, e.name)
+				} else {
+					log.Printf("👋 %s lost the %s lease", e.holder, e.name)
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				if e.isLeader.Load() {
+					store.ReleaseLease(e.name, e.holder)
+				}
+				return
+			}
+		}
+	}()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, task := range e.tasks {
+		go func() {
+			ticker := time.NewTicker(task.every)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if e.isLeader.Load() {
+						task.run(ctx)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (s *fileStore) TryLease(name, holder string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cur lease
+	if raw, ok := s.buckets["leases"][name]; ok {
+		if err := json.Unmarshal(raw, &cur); err != nil {
+			return false, err
+		}
+	}
+	if cur.Holder != "" && cur.Holder != holder && time.Now().Before(cur.Expires) {
+		return false, nil
+	}
+	raw, err := json.Marshal(lease{Holder: holder, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return false, err
+	}
+	if s.buckets["leases"] == nil {
+		s.buckets["leases"] = map[string]json.RawMessage{}
+	}
+	s.buckets["leases"][name] = raw
+	return true, s.flush()
+}
+
+func (s *fileStore) ReleaseLease(name, holder string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cur lease
+	if raw, ok := s.buckets["leases"][name]; !ok || json.Unmarshal(raw, &cur) != nil || cur.Holder != holder {
+		return nil
+	}
+	delete(s.buckets["leases"], name)
+	return s.flush()
+}
+
+var (
+	tryLeaseScript = redis.NewScript(`
+local cur = redis.call('GET', KEYS[1])
+if cur == false or cur == ARGV[1] then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+	return 1
+end
+return 0`)
+	releaseLeaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+func (s *redisStore) TryLease(name, holder string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	n, err := tryLeaseScript.Run(ctx, s.client, []string{s.prefix + "lease:" + name}, holder, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (s *redisStore) ReleaseLease(name, holder string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return releaseLeaseScript.Run(ctx, s.client, []string{s.prefix + "lease:" + name}, holder).Err()
+}
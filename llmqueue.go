@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errLLMQueueFull    = errors.New("LLM request queue is full")
+	errLLMQueueTimeout = errors.New("timed out waiting in the LLM request queue")
+)
+
+// llmLimiter caps concurrent requests to the LLM provider at
+// LLM_MAX_CONCURRENT. Up to LLM_QUEUE_DEPTH more wait their turn for at most
+// LLM_QUEUE_TIMEOUT_SECONDS; beyond that requests are turned away at once, so
+// a burst degrades into queueing rather than provider rate limits.
+type llmLimiter struct {
+	slots   chan struct{}
+	depth   int64
+	timeout time.Duration
+
+	queued   atomic.Int64
+	rejected atomic.Uint64
+	timeouts atomic.Uint64
+}
+
+// llmQueue is built on first use, once loadConfig has read the env file.
+var llmQueue = sync.OnceValue(newLLMLimiter)
+
+func newLLMLimiter() *llmLimiter {
+	return &llmLimiter{
+		slots:   make(chan struct{}, max(envInt("LLM_MAX_CONCURRENT", 8), 1)),
+		depth:   int64(envInt("LLM_QUEUE_DEPTH", 64)),
+		timeout: time.Duration(envInt("LLM_QUEUE_TIMEOUT_SECONDS", 30)) * time.Second,
+	}
+}
+
+// acquire waits for a slot and returns the function that gives it back.
+func (l *llmLimiter) acquire(req *http.Request) (func(), error) {
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.depth {
+		l.queued.Add(-1)
+		l.rejected.Add(1)
+		return nil, errLLMQueueFull
+	}
+	defer l.queued.Add(-1)
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		l.timeouts.Add(1)
+		return nil, errLLMQueueTimeout
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// active is the number of requests currently holding a slot.
+func (l *llmLimiter) active() int { return len(l.slots) }
+
+// limitedTransport holds an llmQueue slot for the whole of a request,
+// retries included, and until its response body has been read.
+type limitedTransport struct {
+	base http.RoundTripper
+}
+
+func (t limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := llmQueue().acquire(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody frees a queue slot when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    atomic.Bool
+}
+
+func (b *releasingBody) Close() error {
+	if b.once.CompareAndSwap(false, true) {
+		b.release()
+	}
+	return b.ReadCloser.Close()
+}
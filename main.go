@@ -1,185 +1,829 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
-	"path/filepath"
 	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/gebt2000/go-docuchat/api"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
-	"github.com/ledongthuc/pdf"
 	pb "github.com/qdrant/go-client/qdrant"
 	"github.com/sashabaranov/go-openai"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
+	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 var (
 	collectionName    = "pdf_collection"
+	chatModel         = "gpt-4o-mini"
 	aiClient          *openai.Client
 	qdrantClient      pb.PointsClient
 	collectionsClient pb.CollectionsClient
+	qdrantConns       *qdrantPool
 )
 
+const personaPrompt = "You are George Barakat's AI Agent. Your job is to impress recruiters. Answer questions about George's skills, experience, and projects enthusiastically using the context provided."
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "estimate" {
+		loadConfig()
+		if err := runEstimate(os.Args[2:]); err != nil {
+			log.Fatalf("Estimate Error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "chat" {
+		loadConfig()
+		if err := runChatREPL(os.Args[2:]); err != nil {
+			log.Fatalf("Chat Error: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mcp" {
+		loadConfig()
+		if err := runMCP(os.Args[2:]); err != nil {
+			log.Fatalf("MCP Error: %v", err)
+		}
+		return
+	}
 	setupInfrastructure()
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		runWorker()
+		return
+	}
+	if err := restoreSnapshot(context.Background()); err != nil {
+		log.Fatalf("Snapshot Restore Error: %v", err)
+	}
+
+	scheduler.schedule("uploads", time.Hour, sweepUploads)
+	scheduler.schedule("idempotency", time.Hour, sweepIdempotency)
+	scheduler.schedule("retention", time.Hour, sweepRetention)
+	scheduler.schedule("connectors", time.Duration(envInt("CONNECTOR_SYNC_MINUTES", 60))*time.Minute, syncConnectors)
+	scheduler.schedule("feeds", time.Duration(envInt("FEED_POLL_MINUTES", 30))*time.Minute, pollFeeds)
+	scheduler.start(context.Background())
 
-	r := gin.Default()
+	r := newRouter()
 	config := cors.DefaultConfig()
-	config.AllowAllOrigins = true
-	r.Use(cors.New(config))
+	corsOrigins(&config)
+	config.AddAllowHeaders("Idempotency-Key", "Upload-Offset", "X-User-ID", "X-Request-ID")
+	config.AddExposeHeaders("Idempotent-Replayed", "Upload-Offset", "Upload-Length", "Location", "X-Request-ID")
+	config.AddAllowMethods("HEAD", "PATCH", "PUT", "DELETE")
+	r.Use(cors.New(config), rateLimit)
 
 	r.POST("/ingest", handleIngest)
+	r.POST("/ingest/text", handleIngestText)
+	r.POST("/ingest/paper", handleIngestPaper)
+	r.POST("/ingest/video", handleIngestVideo)
+	r.POST("/v1/ask", handleAsk)
+	r.POST("/v1/retrieve", handleRetrieve)
+	r.POST("/ephemeral", handleCreateEphemeral)
+	r.POST("/ephemeral/:id/chat", handleEphemeralChat)
+	r.DELETE("/ephemeral/:id", handleDeleteEphemeral)
+	r.POST("/uploads", handleCreateUpload)
+	r.HEAD("/uploads/:id", handleUploadStatus)
+	r.PATCH("/uploads/:id", handlePatchUpload)
 	r.POST("/chat", handleChat)
+	r.POST("/chat/batch", handleChatBatch)
+	r.POST("/chat/:id/cancel", handleCancelChat)
+	r.GET("/chats/:id/debug", adminAuth, handleChatDebug)
+	r.GET("/sessions", handleListSessions)
+	r.POST("/sessions/:id/messages/:mid/regenerate", handleRegenerate)
+	r.POST("/sessions/:id/messages/:mid/feedback", handleFeedback)
+	r.GET("/sessions/:id/messages/:mid/audio", handleChatAudio)
+	r.POST("/documents/:id/extract", handleExtract)
+	r.POST("/documents/:id/extract/:template", handleRunExtractionTemplate)
+	r.GET("/extraction-templates", handleListExtractionTemplates)
+	r.PUT("/extraction-templates/:name", handlePutExtractionTemplate)
+	r.DELETE("/extraction-templates/:name", handleDeleteExtractionTemplate)
+	r.GET("/extractions", handleListExtractions)
+	r.POST("/documents/:id/quiz", handleQuiz)
+	r.POST("/documents/:id/diff", handleDocumentDiff)
+	r.GET("/documents", handleListDocuments)
+	r.GET("/documents/:id/chunks", handleDocumentChunks)
+	r.GET("/documents/:id/similar", handleSimilarDocuments)
+	r.PATCH("/chunks/:id", adminAuth, handlePatchChunk)
+	r.GET("/terms", handleTerms)
+	r.GET("/glossary", handleGlossary)
+	r.GET("/faq", handleGetFAQ)
+	r.GET("/analytics/documents", handleDocumentAnalytics)
+	r.GET("/analytics/topics", adminAuth, handleTopics)
+	r.GET("/healthz", handleHealthz)
+	r.GET("/metrics", handleMetrics)
+
+	r.GET("/jobs/:id", handleJobStatus)
+	r.DELETE("/users/:id/data", adminAuth, handleDeleteUserData)
+
+	admin := r.Group("/admin", adminAuth)
+	admin.GET("/status", handleAdminStatus)
+	admin.POST("/reload", handleReload)
+	admin.GET("/audit", handleAudit)
+	admin.POST("/rechunk", handleRechunk)
+	admin.POST("/reindex", handleReindex)
+	admin.GET("/reindex", handleReindexStatus)
+	admin.GET("/aliases", handleListAliases)
+	admin.PUT("/aliases/:name", handlePutAlias)
+	admin.DELETE("/aliases/:name", handleDeleteAlias)
+	admin.POST("/snapshots", handleCreateSnapshot)
+	admin.GET("/snapshots", handleListSnapshots)
+	admin.GET("/personas/:workspace", handleGetPersona)
+	admin.PUT("/personas/:workspace", handlePutPersona)
+	admin.DELETE("/personas/:workspace", handleDeletePersona)
+	admin.POST("/faq/:workspace", handleBuildFAQ)
+	admin.GET("/flags", handleListFlags)
+	admin.PUT("/flags/:name", handlePutFlag)
+	admin.DELETE("/flags/:name", handleDeleteFlag)
+	admin.GET("/overrides/:workspace", handleListOverrides)
+	admin.POST("/overrides/:workspace", handlePutOverride)
+	admin.PUT("/overrides/:workspace/:id", handlePutOverride)
+	admin.DELETE("/overrides/:workspace/:id", handleDeleteOverride)
+	admin.DELETE("/faq/:workspace", handleDeleteFAQ)
+	admin.GET("/prompts", handleListPrompts)
+	admin.GET("/prompts/:name", handleGetPrompt)
+	admin.POST("/prompts/:name", handlePutPrompt)
+	admin.PUT("/prompts/:name/active", handleActivatePrompt)
+	admin.GET("/shadow", handleShadowReport)
+	admin.GET("/shadow/:id", handleGetShadow)
+	admin.GET("/connectors", handleListConnectors)
+	admin.POST("/connectors/:name/sync", handleSyncConnector)
+	admin.GET("/feeds", handleListFeeds)
+	admin.POST("/feeds", handleAddFeed)
+	admin.POST("/feeds/:id/poll", handlePollFeed)
+	admin.DELETE("/feeds/:id", handleDeleteFeed)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
-	log.Println("🚀 Server running on port " + port)
-	r.Run(":" + port)
+	if err := serve(r, port); err != nil {
+		log.Fatalf("Server Error: %v", err)
+	}
 }
 
 func handleChat(c *gin.Context) {
+	var body api.ChatRequest
+	// a multipart request asks its question as audio, brings a file along, or both
+	var transcript string
+	var attached bool
+	if c.ContentType() == "multipart/form-data" {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(envInt("STT_MAX_MB", 25)+envInt("CHAT_ATTACHMENT_MAX_MB", 50))<<20)
+		attached = hasAttachment(c)
+		if _, err := c.FormFile("audio"); err == nil || !attached {
+			if transcript, err = bindVoiceQuestion(c, &body); err != nil {
+				c.JSON(http.StatusOK, chatUpstreamReply(c, upstreamSTT, fmt.Sprintf("❌ Transcription Error: %v", err)))
+				return
+			}
+			body.Question = transcript
+		} else if raw := c.PostForm("request"); raw != "" {
+			if err := json.Unmarshal([]byte(raw), &body); err != nil {
+				c.JSON(http.StatusOK, chatErrorReply(c, codeInvalidRequest, "❌ Error: Invalid JSON format."))
+				return
+			}
+		}
+	} else if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, chatErrorReply(c, codeInvalidRequest, "❌ Error: Invalid JSON format."))
+		return
+	}
+
+	if reply := answerChat(c, body, transcript, attached); reply != nil {
+		c.JSON(http.StatusOK, reply)
+	}
+}
+
+// answerChat answers a chat request for c's caller and returns the reply,
+// or nil once it has streamed one. transcript is the question as
+// transcribed from audio, and attached whether c's form brings a file to
+// attach. Batches and /v1/ask call it directly for each question.
+func answerChat(c *gin.Context, body api.ChatRequest, transcript string, attached bool) (reply gin.H) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("🔥 PANIC: %v\nStack: %s", r, string(debug.Stack()))
-			c.JSON(http.StatusOK, gin.H{"answer": fmt.Sprintf("🔥 Server Crash: %v", r)})
+			reply = chatErrorReply(c, codeInternal, fmt.Sprintf("🔥 Server Crash: %v", r))
 		}
 	}()
 
-	var body struct {
-		Question string `json:"question"`
+	if body.Workspace == "" {
+		body.Workspace = "default"
 	}
-	if err := c.BindJSON(&body); err != nil {
-		c.JSON(http.StatusOK, gin.H{"answer": "❌ Error: Invalid JSON format."})
-		return
+	if body.Format == "" {
+		body.Format = "markdown"
 	}
-
-	// 1. EMBEDDING
-	resp, err := aiClient.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
-		Input: []string{body.Question},
-		Model: openai.SmallEmbedding3,
-	})
+	if !slices.Contains(answerFormats, body.Format) {
+		return chatErrorReply(c, codeInvalidRequest, "❌ Error: format must be markdown, plain or html.")
+	}
+	if body.Length != "" && !slices.Contains(answerLengths, body.Length) {
+		return chatErrorReply(c, codeInvalidRequest, "❌ Error: length must be brief, normal or detailed.")
+	}
+	if body.ReadingLevel != "" && !slices.Contains(readingLevels, body.ReadingLevel) {
+		return chatErrorReply(c, codeInvalidRequest, "❌ Error: reading_level must be beginner, general or expert.")
+	}
+	after, err := parseDateBound(body.After, true)
 	if err != nil {
-		log.Printf("❌ Embedding Error: %v", err)
-		c.JSON(http.StatusOK, gin.H{"answer": fmt.Sprintf("❌ OpenAI Embedding Error: %v", err)})
-		return
+		return chatErrorReply(c, codeInvalidRequest, fmt.Sprintf("❌ Error: %v", err))
+	}
+	before, err := parseDateBound(body.Before, false)
+	if err != nil {
+		return chatErrorReply(c, codeInvalidRequest, fmt.Sprintf("❌ Error: %v", err))
+	}
+	asOf, err := parseDateBound(body.AsOf, false)
+	if err != nil {
+		return chatErrorReply(c, codeInvalidRequest, fmt.Sprintf("❌ Error: %v", err))
+	}
+	if asOf.IsZero() {
+		asOf = time.Now()
+	}
+	excludeTags := parseTags(strings.Join(body.ExcludeTags, ","))
+	sess, err := openSession(c, body.SessionID, body.Workspace, body.DocumentIDs)
+	if err != nil {
+		return chatErrorReply(c, codeNotFound, fmt.Sprintf("❌ Error: %v", err))
+	}
+	var attachment *chatAttachment
+	if attached {
+		if attachment, err = attachToSession(c, &sess, body.Attachment); err != nil {
+			return chatErrorReply(c, cmp.Or(errorCode(err), codeInvalidRequest), fmt.Sprintf("❌ Attachment Error: %v", err))
+		}
+	}
+	workspaces, err := chatWorkspaces(requestUser(c), sess.Workspace, body.Workspaces)
+	if err != nil {
+		return chatErrorReply(c, codeForbidden, fmt.Sprintf("❌ Error: %v", err))
+	}
+	visible := visibleTo(requestUser(c))
+	skip := func(payload map[string]*pb.Value) bool {
+		return excluded(payload, body.ExcludeDocs, excludeTags) || !validAt(payload, asOf) || !slices.Contains(workspaces, payloadString(payload, "workspace")) || !visible(payload)
+	}
+	inMemory, hasFiles := sessionAttachments(sess.ID)
+	tools := workspaceTools(sess.Workspace)
+	lang := answerLanguage(body.Question)
+	rec := newChatRecord(c, sess, body.Question, lang)
+	rec.Attachment = attachment
+	rec.Format = body.Format
+	rec.Length, rec.ReadingLevel = body.Length, body.ReadingLevel
+	rec.Seed, rec.Temperature = chatSampling(body.Deterministic, body.Seed)
+	rec.Audio = body.Audio
+	rec.Transcribed = transcript != ""
+	flags := flagsFor(sess.Workspace, rec.Owner, sess.ID)
+	rec.Flags = flags.Seen // fills in as stages check their flags
+	budget := newLatencyBudget(body.LatencyBudget, sess.Workspace, &rec.Degraded)
+	if body.Debug {
+		rec.timing = budget
 	}
 
-	// 2. SEARCH
-	searchResult, err := qdrantClient.Search(context.Background(), &pb.SearchPoints{
-		CollectionName: collectionName,
-		Vector:         resp.Data[0].Embedding,
-		Limit:          3, // Context window
-		WithPayload:    &pb.WithPayloadSelector{SelectorOptions: &pb.WithPayloadSelector_Enable{Enable: true}},
-	})
-	
-	payloadText := ""
-	if err == nil && len(searchResult.Result) > 0 {
-		if item, ok := searchResult.Result[0].Payload["text"]; ok {
-			payloadText = item.GetStringValue()
+	// OVERRIDES: curated answers replace the pipeline for the questions they match
+	// (not for a question about a file it brings)
+	var vector []float32
+	if len(body.ResponseSchema) == 0 && !attached {
+		var o answerOverride
+		var ok bool
+		var v []float32
+		budget.measure(stageOverrides, func() { o, ok, v, err = matchOverride(context.Background(), sess.Workspace, body.Question) })
+		if err != nil {
+			log.Printf("❌ Embedding Error: %v", err)
+			return chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err))
+		}
+		vector = v
+		if ok {
+			if body.Explain {
+				return gin.H{"status": "success", "explain": true, "override": o.ID}
+			}
+			rec.Answer, rec.AnswerSource = o.Answer, "override"
+			if body.Stream {
+				streamAnswer(c, sess, rec)
+				return nil
+			}
+			finishChat(sess, rec)
+			return withReplyExtras(gin.H{"answer": formatAnswer(rec.Answer, rec.Format), "format": rec.Format, "language": lang, "chat_id": rec.ID, "session_id": sess.ID, "answer_source": "override", "override_id": o.ID}, rec)
 		}
 	}
 
-	// 3. CHAT (THE PERSONA)
-	systemPrompt := "You are George Barakat's AI Agent. Your job is to impress recruiters. Answer questions about George's skills, experience, and projects enthusiastically using the context provided. If the answer isn't in the context, say 'I don't have that detail handy, but George is a fast learner!'"
-	
-	fullPrompt := fmt.Sprintf("%s\n\nContext from Resume: %s\n\nRecruiter Question: %s", systemPrompt, payloadText, body.Question)
+	// ROUTING: greetings and questions about the assistant skip retrieval
+	var smalltalk bool
+	if len(body.ResponseSchema) == 0 && !body.Explain && flags.On(flagIntentRouter) {
+		budget.run(flagIntentRouter, func() { smalltalk = routeIntent(context.Background(), body.Question) == "smalltalk" })
+	}
+	if smalltalk {
+		chatReq := smallTalkRequest(personaFor(sess.Workspace, rec.Prompts)+formatInstruction(rec.Format), body.Question, lang, sessionHistory(sess))
+		rec.Prompt = sealText(chatReq.Messages[len(chatReq.Messages)-1].Content)
+		applySampling(&chatReq, rec)
+		if body.Stream {
+			streamChat(c, chatReq, sess, rec)
+			return nil
+		}
+		chatResp, err := aiClient.CreateChatCompletion(context.Background(), chatReq)
+		if err != nil {
+			return chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err))
+		}
+		if len(chatResp.Choices) == 0 {
+			return chatUpstreamReply(c, upstreamOpenAI, "❌ OpenAI Chat Error: the model returned no answer")
+		}
+		rec.SystemFingerprint = chatResp.SystemFingerprint
+		rec.Answer = filterAnswer(sess.Workspace, chatResp.Choices[0].Message.Content)
+		finishChat(sess, rec)
+		return withReplyExtras(gin.H{"answer": formatAnswer(rec.Answer, rec.Format), "format": rec.Format, "language": lang, "chat_id": rec.ID, "session_id": sess.ID, "intent": "smalltalk"}, rec)
+	}
 
-	chatResp, err := aiClient.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model: "gpt-4o-mini",
-		Messages: []openai.ChatCompletionMessage{
-			{Role: openai.ChatMessageRoleUser, Content: fullPrompt},
-		},
-	})
+	// FAQ: a question matching the workspace FAQ gets its approved answer
+	unscoped := body.Mode == "" && body.Language == "" && len(sess.Documents) == 0 && !attached && !hasFiles && after.IsZero() && before.IsZero() && body.AsOf == "" &&
+		len(body.ExcludeDocs)+len(excludeTags)+len(body.Collections)+len(body.Workspaces)+len(body.Fields) == 0
+	if faq, ok := workspaceFAQ(sess.Workspace); ok && len(body.ResponseSchema) == 0 && !body.Explain && unscoped && flags.On(flagFAQ) {
+		if vector == nil {
+			budget.measure(stageEmbed, func() { vector, err = embedText(context.Background(), body.Question) })
+			if err != nil {
+				log.Printf("❌ Embedding Error: %v", err)
+				return chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err))
+			}
+		}
+		if entry, ok := faq.match(vector); ok {
+			rec.Answer, rec.AnswerSource = entry.Answer, "faq"
+			for _, ct := range entry.Citations {
+				rec.Sources = append(rec.Sources, retrievedChunk{ChunkID: ct.PointID, Score: ct.Score, Source: "faq"})
+			}
+			if body.Stream {
+				streamAnswer(c, sess, rec)
+				return nil
+			}
+			finishChat(sess, rec)
+			return withReplyExtras(gin.H{"answer": formatAnswer(rec.Answer, rec.Format), "format": rec.Format, "language": lang, "chat_id": rec.ID, "session_id": sess.ID, "answer_source": "faq", "faq_question": entry.Question, "citations": entry.Citations}, rec)
+		}
+	}
+
+	// the filter every document search uses
+	filter := andFilters(languageFilter(body.Language), documentsFilter(sess.Documents), dateFilter(after, before), excludeFilter(body.ExcludeDocs, excludeTags), validAtFilter(asOf), workspacesFilter(workspaces), duplicatesFilter(sess.Documents), fieldsFilter(body.Fields))
+
+	// AGENT MODE: the model runs its own searches
+	if body.Mode == "agent" {
+		answer, trace, err := runAgent(context.Background(), body.Question, lang, sess.Workspace, tools, rec.Prompts, searchScope{filter: filter, skip: skip})
+		if err != nil {
+			reply := chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err))
+			reply["trace"] = trace
+			return reply
+		}
+		answer = filterAnswer(sess.Workspace, answer)
+		rec.Answer = answer
+		finishChat(sess, rec)
+		return withReplyExtras(gin.H{"answer": formatAnswer(answer, rec.Format), "format": rec.Format, "trace": trace, "language": lang, "chat_id": rec.ID, "session_id": sess.ID}, rec)
+	}
+
+	// SCAN MODE: every chunk of the session's documents is read, for questions
+	// the top matches can't answer completely
+	if body.Mode == "scan" {
+		if len(sess.Documents) == 0 {
+			return chatErrorReply(c, codeInvalidRequest, "❌ Error: scan mode needs document_ids.")
+		}
+		answer, findings, err := runScan(context.Background(), body.Question, lang, sess.Documents, skip)
+		if err != nil {
+			return chatErrorReply(c, codeUpstream, fmt.Sprintf("❌ Scan Error: %v", err))
+		}
+		rec.Answer, rec.Sources = filterAnswer(sess.Workspace, answer), findingSources(findings)
+		finishChat(sess, rec)
+		return withReplyExtras(gin.H{"answer": formatAnswer(rec.Answer, rec.Format), "format": rec.Format, "findings": findings, "language": lang, "chat_id": rec.ID, "session_id": sess.ID}, rec)
+	}
+
+	// 1. EXACT MATCH: identifiers and defined terms go straight to their chunks
+	// (under the vector search's filter; skipped under federation, which only the vector search applies)
+	var texts []string
+	var sources []retrievedChunk
+	if len(body.Collections) == 0 {
+		budget.measure(stageSearch, func() { texts, sources = exactMatchContext(context.Background(), body.Question, 3, filter, skip) })
+	}
+	dbg := retrievalDebug{ChatID: rec.ID, Question: body.Question, Mode: body.Mode, ExactMatches: sources, Candidates: []retrievalCandidate{}}
+
+	if len(texts) == 0 {
+		// 2. EMBEDDING
+		if vector == nil {
+			budget.measure(stageEmbed, func() { vector, err = embedText(context.Background(), body.Question) })
+			if err != nil {
+				log.Printf("❌ Embedding Error: %v", err)
+				return chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err))
+			}
+		}
+
+		// 3. SEARCH
+		dbg.Filter = filterJSON(filter)
+		var results []*pb.ScoredPoint
+		// twice the context window, for room for chunks of documents the caller can't see
+		if len(body.Collections) > 0 {
+			budget.measure(stageSearch, func() {
+				results, err = federatedSearch(context.Background(), body.Question, vector, body.Collections, 6*languageOversample(), filter)
+			})
+			if err != nil {
+				return chatUpstreamReply(c, upstreamQdrant, fmt.Sprintf("❌ Search Error: %v", err))
+			}
+		} else {
+			budget.measure(stageSearch, func() { results, err = searchChunks(context.Background(), vector, 6*languageOversample(), filter) })
+		}
+		if err == nil {
+			results = slices.DeleteFunc(results, func(hit *pb.ScoredPoint) bool { return !visible(hit.Payload) })
+			var reranked func([]*pb.ScoredPoint)
+			dbg.Candidates, reranked = vectorCandidates(results)
+			budget.measure(stageRerank, func() {
+				results = boostLanguage(results, detectLanguage(body.Question), 3) // Context window
+				reranked(results)
+			})
+			for _, hit := range results {
+				texts = append(texts, payloadString(hit.Payload, "text"))
+				sources = append(sources, chunkRef(hit.Id, hit.Payload, hit.Score, "vector"))
+			}
+		}
+	}
+
+	// ATTACHMENTS: passages of the files the session holds in memory compete with the documents'
+	if hasFiles {
+		if vector == nil {
+			budget.measure(stageEmbed, func() { vector, err = embedText(context.Background(), body.Question) })
+			if err != nil {
+				log.Printf("❌ Embedding Error: %v", err)
+				return chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err))
+			}
+		}
+		lists := [][]blendedItem{inMemory.search(vector, 3), make([]blendedItem, len(texts))}
+		for i := range texts {
+			lists[1][i] = blendedItem{text: texts[i], ref: sources[i]}
+		}
+		// one more context slot, and ties go to the attachments
+		texts, sources = nil, nil
+		for _, item := range blendContext(lists, 4) {
+			texts = append(texts, item.text)
+			sources = append(sources, item.ref)
+		}
+	}
+
+	// RETRIEVERS: blend in the workspace's external search sources
+	var external map[string][]externalHit
+	if flags.On(flagRetrievers) {
+		budget.run(flagRetrievers, func() { external = externalContext(context.Background(), sess.Workspace, body.Question, 3) })
+	}
+	if len(external) > 0 {
+		lists := [][]blendedItem{make([]blendedItem, len(texts))}
+		for i := range texts {
+			lists[0][i] = blendedItem{text: texts[i], ref: sources[i]}
+		}
+		for _, name := range slices.Sorted(maps.Keys(external)) {
+			lists = append(lists, externalItems(name, external[name]))
+		}
+		// one more context slot per retriever, competing with the vector hits for rank
+		texts, sources = nil, nil
+		for _, item := range blendContext(lists, 3+len(external)) {
+			texts = append(texts, item.text)
+			sources = append(sources, item.ref)
+		}
+	}
+
+	// GRAPH MODE: add facts about the entities the question mentions
+	if body.Mode == "graph" {
+		budget.run(stageGraph, func() {
+			facts, chunkIDs, err := graphContext(context.Background(), body.Question, workspaces, documentVisibleTo(requestUser(c)))
+			if err != nil {
+				log.Printf("❌ Graph Lookup Error: %v", err)
+			}
+			if len(chunkIDs) > 3 {
+				chunkIDs = chunkIDs[:3]
+			}
+			if len(chunkIDs) > 0 {
+				if linked, err := getPoints(context.Background(), chunkIDs); err == nil {
+					for _, p := range linked {
+						if text := payloadString(p.Payload, "text"); !slices.Contains(texts, text) && !skip(p.Payload) {
+							texts = append(texts, text)
+							sources = append(sources, chunkRef(p.Id, p.Payload, 0, "graph"))
+							dbg.GraphChunks = append(dbg.GraphChunks, sources[len(sources)-1])
+						}
+					}
+				}
+			}
+			if len(facts) > 0 {
+				texts = append(texts, "Known facts:\n"+formatFacts(facts))
+				dbg.Facts = facts
+			}
+		})
+	}
+	// WEB: with nothing confident in the documents, optionally answer from the web
+	if webSearchProvider() != "" && (len(sources) == 0 || lowConfidence(sources)) && flags.On(flagWebSearch) {
+		var hits []externalHit
+		var err error
+		budget.run(flagWebSearch, func() { hits, err = webSearch(context.Background(), body.Question, 3) })
+		if err != nil {
+			recordError("web_search", err)
+			log.Printf("❌ Web Search Error: %v", err)
+		} else if len(hits) > 0 {
+			texts, sources = nil, nil
+			for _, item := range externalItems("web", hits) {
+				texts = append(texts, item.text)
+				sources = append(sources, item.ref)
+			}
+			rec.AnswerSource = "web"
+		}
+	}
+	rec.Sources = sources
+	dbg.Selected = sources
+	if !body.Explain {
+		recordRetrieval(sources)
+		saveDebug(dbg)
+	}
+
+	// LANGUAGE: documents may be in any language; optionally translate them to the answer language
+	if flags.On(flagTranslateContext) {
+		budget.run(flagTranslateContext, func() { texts = translateContext(context.Background(), texts, lang) })
+	}
+	// the first texts are the sources', as put into the context; facts and definitions follow
+	rec.contexts = slices.Clone(texts[:min(len(texts), len(sources))])
+	rec.highlight = flags.OptIn(flagHighlights)
+
+	// GLOSSARY: spell out workspace acronyms and defined terms that come up
+	if defs := glossaryContext(sess.Workspace, documentVisibleTo(requestUser(c)), append(texts, body.Question)...); defs != "" {
+		texts = append(texts, defs)
+	}
+	// BUDGET: trim the session history and context to the model's context window
+	history := sessionHistory(sess)
+	instructions := outOfScopeInstruction(sess.Workspace) + languageInstruction(lang) + formatInstruction(rec.Format) + styleInstruction(rec.Prompts, rec.Length, rec.ReadingLevel)
+	if rec.AnswerSource == "web" {
+		instructions += rec.Prompts.text("web")
+	}
+	persona := personaFor(sess.Workspace, rec.Prompts)
+	history, texts = newPromptBudget(chatModel).fit(persona+instructions+body.Question, history, texts)
+	payloadText := strings.Join(texts, "\n\n---\n\n")
+
+	// 4. CHAT (THE PERSONA)
+	_, library := rec.Prompts["persona"]
+	framePrompt := func(context string) string {
+		if library {
+			return fmt.Sprintf("%s%s\n\nContext from Resume: %s\n\nRecruiter Question: %s", persona, instructions, context, body.Question)
+		}
+		return fmt.Sprintf("%s%s\n\nContext: %s\n\nQuestion: %s", persona, instructions, context, body.Question)
+	}
+	fullPrompt := framePrompt(payloadText)
+
+	// HISTORY: earlier turns of the session come first
+	chatReq := openai.ChatCompletionRequest{
+		Model:     chatModel,
+		Messages:  append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: fullPrompt}),
+		MaxTokens: answerTokenCap(rec.Length),
+	}
+	applySampling(&chatReq, rec)
+
+	// STRUCTURED OUTPUT: swap the persona for an extraction prompt and pin the reply to the schema
+	var schemaDef *jsonschema.Definition
+	if len(body.ResponseSchema) > 0 {
+		format, def, err := structuredFormat(body.ResponseSchema)
+		if err != nil {
+			return chatErrorReply(c, codeInvalidRequest, fmt.Sprintf("❌ Error: Invalid response_schema: %v", err))
+		}
+		schemaDef = def
+		chatReq.ResponseFormat = format
+		chatReq.MaxTokens = 0 // a capped reply would be cut off mid-JSON
+		chatReq.Messages = chatReq.Messages[len(history):]
+		chatReq.Messages[0].Content = fmt.Sprintf("%s\n\nContext: %s\n\nRequest: %s", rec.Prompts.text("structured"), payloadText, body.Question)
+	}
+
+	// EXPLAIN: stop before the completion and show what would have been sent
+	if body.Explain {
+		return explainChat(chatReq, dbg, history, texts, body.Question, tools)
+	}
+
+	// TOOLS: let the model call the workspace's plugins before it answers
+	if len(tools) > 0 && schemaDef == nil {
+		answer, trace, err := runToolLoop(context.Background(), chatReq.Messages, tools, nil)
+		if err != nil {
+			reply := chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err))
+			reply["trace"] = trace
+			return reply
+		}
+		answer = filterAnswer(sess.Workspace, labelAnswer(rec, answer))
+		rec.Answer = answer
+		finishChat(sess, rec)
+		return withReplyExtras(gin.H{"answer": formatAnswer(answer, rec.Format), "format": rec.Format, "trace": trace, "language": lang, "chat_id": rec.ID, "session_id": sess.ID, "answer_source": cmp.Or(rec.AnswerSource, "documents")}, rec)
+	}
+
+	if schemaDef == nil {
+		rec.Prompt = sealText(fullPrompt)
+	}
+	// SHADOW: a sample of plain document answers is also answered by the candidate pipeline
+	if schemaDef == nil && rec.AnswerSource == "" && shadowSampled() {
+		rec.shadow = &shadowInput{vector: vector, filter: filter, history: history, prefix: persona + instructions, prompt: framePrompt}
+	}
+	if body.Stream && schemaDef == nil {
+		streamChat(c, chatReq, sess, rec)
+		return nil
+	}
+
+	var chatResp openai.ChatCompletionResponse
+	budget.measure(stageGenerate, func() { chatResp, err = aiClient.CreateChatCompletion(context.Background(), chatReq) })
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{"answer": fmt.Sprintf("❌ OpenAI Chat Error: %v", err)})
-		return
+		return chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err))
+	}
+	if len(chatResp.Choices) == 0 {
+		return chatUpstreamReply(c, upstreamOpenAI, "❌ OpenAI Chat Error: the model returned no answer")
+	}
+	rec.SystemFingerprint = chatResp.SystemFingerprint
+	answer := chatResp.Choices[0].Message.Content
+	if chatResp.Choices[0].FinishReason == openai.FinishReasonLength && chatReq.MaxTokens > 0 {
+		answer = trimToSentence(answer)
+	}
+
+	if schemaDef != nil {
+		data, err := validateStructured(schemaDef, answer)
+		if err != nil {
+			return chatUpstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ Structured Output Error: %v", err))
+		}
+		rec.Answer = answer
+		finishChat(sess, rec)
+		return gin.H{"answer": answer, "data": data, "chat_id": rec.ID, "session_id": sess.ID}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"answer": chatResp.Choices[0].Message.Content})
+	answer = filterAnswer(sess.Workspace, labelAnswer(rec, answer))
+	rec.Answer = answer
+	finishChat(sess, rec)
+	return withReplyExtras(gin.H{"answer": formatAnswer(answer, rec.Format), "format": rec.Format, "language": lang, "chat_id": rec.ID, "session_id": sess.ID, "answer_source": cmp.Or(rec.AnswerSource, "documents"), "sources": citedSources(rec), "attributions": answerAttributions(rec)}, rec)
 }
 
 func handleIngest(c *gin.Context) {
-	file, err := c.FormFile("file")
+	workspace := c.DefaultPostForm("workspace", "default")
+	src, err := ingestSource(c, workspace)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{"status": "error", "message": "No file uploaded"})
+		c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
 		return
 	}
-	tempPath := filepath.Join(".", file.Filename)
-	c.SaveUploadedFile(file, tempPath)
-	defer os.Remove(tempPath)
-	content, err := readPdf(tempPath)
-	if err != nil {
-		c.JSON(http.StatusOK, gin.H{"status": "error", "message": "PDF Read Error"})
-		return
+	succeeded := false
+	defer func() { src.Done(succeeded) }()
+	var mapping *recordMapping
+	if isRecordFile(src.Filename) {
+		if mapping, err = recordMappingFor(c.PostForm("mapping"), workspace); err != nil {
+			c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
+			return
+		}
 	}
 
-	resp, err := aiClient.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{
-		Input: []string{content},
-		Model: openai.SmallEmbedding3,
+	withIdempotency(c, src.Fingerprint, func() gin.H {
+		if src.Path == "" {
+			return errorReply(c, codeConflict, "Upload was already ingested")
+		}
+		job := ingestJob{
+			ID:         uuid.New().String(),
+			DocumentID: uuid.New().String(),
+			Filename:   src.Filename,
+			Path:       src.Path,
+			UploadID:   src.UploadID,
+			Workspace:  workspace,
+			Owner:      requestUser(c),
+			Graph:      graphEnabled(c.PostForm("graph")),
+			Tags:       parseTags(c.PostForm("tags")),
+			Source:     c.PostForm("source"),
+			Supersedes: c.PostForm("supersedes"),
+			Mapping:    mapping,
+		}
+		if pw := c.PostForm("password"); pw != "" {
+			job.Password = sealText(pw)
+		}
+		var resp gin.H
+		resp, succeeded = submitIngest(c, job)
+		return resp
 	})
+}
+
+// submitIngest queues a job, or with no queue runs it inline, and returns
+// the reply for the API along with whether an inline ingest succeeded.
+func submitIngest(c *gin.Context, job ingestJob) (gin.H, bool) {
+	if job.Supersedes != "" {
+		if err := supersedable(job.Supersedes, job.Owner); err != nil {
+			return errReply(c, codeNotFound, err), false
+		}
+	}
+	if jobs != nil {
+		return enqueueIngest(c.Request.Context(), job), false
+	}
+
+	res, err := executeIngest(context.Background(), job)
 	if err != nil {
-		c.JSON(http.StatusOK, gin.H{"status": "error", "message": "Embedding Error: " + err.Error()})
-		return
+		resp := errReply(c, codeIngestFailed, err)
+		resp["document_id"], resp["chunks"] = job.DocumentID, res.Chunks
+		return resp, false
 	}
-	
-	collectionsClient.Create(context.Background(), &pb.CreateCollection{
-		CollectionName: collectionName,
-		VectorsConfig: &pb.VectorsConfig{Config: &pb.VectorsConfig_Params{Params: &pb.VectorParams{
-			Size: 1536,
-			Distance: pb.Distance_Cosine,
-		}}},
-	})
+	if res.Chunks == 0 {
+		resp := errorReply(c, codeNoText, "No text found in PDF")
+		resp["report"] = res.report()
+		return resp, false
+	}
+	message := "File processed!"
+	switch {
+	case res.GraphErr != nil:
+		message = "File processed, but graph extraction failed for some chunks: " + res.GraphErr.Error()
+	case len(res.FailedPages) > 0:
+		message = fmt.Sprintf("File processed, but %d of %d pages could not be read", len(res.FailedPages), res.Pages)
+	}
+	return gin.H{"status": "success", "message": message, "document_id": job.DocumentID, "chunks": res.Chunks, "language": res.Language, "report": res.report()}, true
+}
 
-	qdrantClient.Upsert(context.Background(), &pb.UpsertPoints{
-		CollectionName: collectionName,
-		Points: []*pb.PointStruct{{
-			Id: &pb.PointId{PointIdOptions: &pb.PointId_Uuid{Uuid: uuid.New().String()}},
-			Vectors: &pb.Vectors{VectorsOptions: &pb.Vectors_Vector{Vector: &pb.Vector{Data: resp.Data[0].Embedding}}},
-			Payload: map[string]*pb.Value{"text": {Kind: &pb.Value_StringValue{StringValue: content}}},
-		}},
-	})
-	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "File processed!"})
+// executeIngest parses a job's file and runs it through the ingest pipeline.
+// The API calls it inline; a `docuchat worker` calls it for queued jobs.
+func executeIngest(ctx context.Context, job ingestJob) (ingestResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	path, cleanup, err := plainFile(job.Path)
+	if err != nil {
+		return ingestResult{}, errors.New("Decrypt Error: " + err.Error())
+	}
+	defer cleanup()
+	var pages <-chan pdfPage
+	var extra []textChunk
+	var meta documentMeta
+	if isRecordFile(job.Filename) {
+		// records, like transcripts, have no page furniture, forms or
+		// document-wide metadata
+		pages, err = recordPages(ctx, path, job.Mapping)
+	} else {
+		pages, err = readPages(ctx, path, job.Filename, openText(job.Password))
+	}
+	if errorCode(err) != "" {
+		return ingestResult{}, err
+	}
+	if err != nil {
+		return ingestResult{}, errors.New("PDF Read Error")
+	}
+	if !isRecordFile(job.Filename) && !isTranscriptFile(job.Filename) {
+		pages = stripBoilerplate(ctx, pages)
+		extra, _ = extractFormsAndAnnotations(path, openText(job.Password))
+		meta, pages = documentMetadata(ctx, path, openText(job.Password), pages)
+	}
+	if job.Title != "" {
+		meta.Title = job.Title
+	}
+	if !job.Date.IsZero() {
+		meta = withDate(meta, job.Date, "supplied")
+	}
+
+	doc := ingestDoc{
+		DocumentID: job.DocumentID,
+		Filename:   job.Filename,
+		Workspace:  job.Workspace,
+		ParentID:   job.ParentID,
+		Graph:      job.Graph,
+		Tags:       job.Tags,
+		Source:     job.Source,
+		URL:        job.URL,
+		Fields:     job.Metadata,
+		StoreText:  true,
+		Extra:      extra,
+		Meta:       meta,
+	}
+	if job.Supersedes != "" {
+		doc.Version = documentVersion{Supersedes: job.Supersedes, ValidFrom: versionStart(meta)}
+	}
+
+	activeIngests.Add(1)
+	defer activeIngests.Add(-1)
+	res, err := runIngest(ctx, pages, doc)
+	if err != nil {
+		recordError("ingest", err)
+		return res, errors.New("Ingest Error: " + err.Error())
+	}
+	if res.Chunks > 0 {
+		if err := recordDocument(ctx, job, res, doc); err != nil {
+			log.Printf("❌ Metadata Store Error: %v", err)
+		} else if len(job.Tags) > 0 {
+			go runIngestExtractions(context.Background(), job.DocumentID)
+		}
+	}
+	res.Attachments = ingestAttachments(ctx, job, path)
+	return res, nil
 }
 
 func setupInfrastructure() {
-	godotenv.Load() 
-	aiClient = openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	loadConfig()
+	chatModel = cmp.Or(os.Getenv("CHAT_MODEL"), chatModel)
+	aiConfig := openai.DefaultConfig(os.Getenv("OPENAI_API_KEY"))
+	aiConfig.HTTPClient = &http.Client{Transport: limitedTransport{base: resilientTransport{base: apiKeyTransport{base: http.DefaultTransport}}}}
+	aiClient = openai.NewClientWithConfig(aiConfig)
 	qdrantURL := os.Getenv("QDRANT_URL")
 	if qdrantURL == "" { qdrantURL = "localhost:6334" }
 	
-	var conn *grpc.ClientConn
-	var err error
-	if os.Getenv("QDRANT_API_KEY") == "" {
-		conn, err = grpc.NewClient(qdrantURL, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	} else {
-		conn, err = grpc.NewClient(qdrantURL, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})), grpc.WithPerRPCCredentials(tokenAuth{token: os.Getenv("QDRANT_API_KEY")}))
-	}
+	pool, err := dialQdrant(qdrantURL)
 	if err != nil { log.Fatalf("Qdrant Connect Error: %v", err) }
+	qdrantConns = pool
 	
-	qdrantClient = pb.NewPointsClient(conn)
-	collectionsClient = pb.NewCollectionsClient(conn) 
+	store, err := openStore()
+	if err != nil { log.Fatalf("Metadata Store Error: %v", err) }
+	metaStore = store
+
+	if err := setupEncryption(context.Background()); err != nil { log.Fatalf("Encryption Error: %v", err) }
+
+	jobs, err = openJobQueue()
+	if err != nil { log.Fatalf("Job Queue Error: %v", err) }
+
+	qdrantClient = pb.NewPointsClient(pool)
+	collectionsClient = pb.NewCollectionsClient(pool) 
+	snapshotsClient = pb.NewSnapshotsClient(pool)
+	go pool.watch(context.Background())
+	go watchConfig(context.Background())
 }
 
 type tokenAuth struct { token string }
 func (t tokenAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) { return map[string]string{"api-key": t.token}, nil }
 func (t tokenAuth) RequireTransportSecurity() bool { return true }
-
-func readPdf(path string) (string, error) {
-	f, r, err := pdf.Open(path)
-	if err != nil { return "", err }
-	defer f.Close()
-	var totalText string
-	for i := 1; i <= r.NumPage(); i++ { text, _ := r.Page(i).GetPlainText(nil); totalText += text }
-	return totalText, nil
-}
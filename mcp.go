@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gebt2000/go-docuchat/client"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// The MCP server lets Model Context Protocol clients, such as Claude
+// Desktop, use a docuchat corpus as a knowledge source. It is a client of a
+// running docuchat API, so it needs no infrastructure of its own, and offers
+// two tools: search_documents, which returns matching passages, and
+// ask_documents, which returns docuchat's answer.
+
+// mcpProtocolVersion is the newest MCP revision this server speaks.
+const mcpProtocolVersion = "2025-03-26"
+
+// mcpMessage is a JSON-RPC 2.0 request, notification or response.
+type mcpMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
+}
+
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpServer answers MCP requests with calls to the docuchat API.
+type mcpServer struct {
+	client    *client.Client
+	workspace string
+}
+
+var mcpTools = []gin.H{
+	{
+		"name":        "search_documents",
+		"description": "Search the document corpus and return the passages that best match a query, with their source documents and pages.",
+		"inputSchema": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"query":        gin.H{"type": "string", "description": "What to look for"},
+				"top_k":        gin.H{"type": "integer", "description": "How many passages to return, at most 50 (default 5)"},
+				"document_ids": gin.H{"type": "array", "items": gin.H{"type": "string"}, "description": "Only search these documents"},
+				"tags":         gin.H{"type": "array", "items": gin.H{"type": "string"}, "description": "Only search documents with one of these tags"},
+			},
+			"required": []string{"query"},
+		},
+	},
+	{
+		"name":        "ask_documents",
+		"description": "Ask a question and get an answer written from the document corpus.",
+		"inputSchema": gin.H{
+			"type": "object",
+			"properties": gin.H{
+				"question":     gin.H{"type": "string", "description": "The question to answer"},
+				"document_ids": gin.H{"type": "array", "items": gin.H{"type": "string"}, "description": "Only answer from these documents"},
+			},
+			"required": []string{"question"},
+		},
+	},
+}
+
+// handle answers one message, or returns nil for a notification.
+func (s *mcpServer) handle(ctx context.Context, msg mcpMessage) *mcpMessage {
+	if len(msg.ID) == 0 {
+		return nil // notifications/initialized, cancellations...
+	}
+	reply := &mcpMessage{JSONRPC: "2.0", ID: msg.ID}
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		version := mcpProtocolVersion
+		if params.ProtocolVersion != "" && params.ProtocolVersion < version {
+			version = params.ProtocolVersion // revisions are dates; speak the client's older one
+		}
+		reply.Result = gin.H{
+			"protocolVersion": version,
+			"capabilities":    gin.H{"tools": gin.H{}},
+			"serverInfo":      gin.H{"name": "docuchat", "version": "1.0.0"},
+			"instructions":    "Use search_documents to find passages in the " + s.workspace + " workspace's documents, and ask_documents for a written answer from them.",
+		}
+	case "ping":
+		reply.Result = gin.H{}
+	case "tools/list":
+		reply.Result = gin.H{"tools": mcpTools}
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			reply.Error = &mcpError{-32602, "Invalid params"}
+			break
+		}
+		text, err := s.callTool(ctx, params.Name, params.Arguments)
+		if err != nil {
+			// tool failures go back to the model, not as protocol errors
+			reply.Result = gin.H{"content": []gin.H{{"type": "text", "text": err.Error()}}, "isError": true}
+			break
+		}
+		reply.Result = gin.H{"content": []gin.H{{"type": "text", "text": text}}, "isError": false}
+	default:
+		reply.Error = &mcpError{-32601, "Method not found: " + msg.Method}
+	}
+	return reply
+}
+
+func (s *mcpServer) callTool(ctx context.Context, name string, raw json.RawMessage) (string, error) {
+	var args struct {
+		Query       string   `json:"query"`
+		Question    string   `json:"question"`
+		TopK        int      `json:"top_k"`
+		DocumentIDs []string `json:"document_ids"`
+		Tags        []string `json:"tags"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+	switch name {
+	case "search_documents":
+		if strings.TrimSpace(args.Query) == "" {
+			return "", fmt.Errorf("query is required")
+		}
+		docs, err := s.client.Retrieve(ctx, api.RetrieveRequest{
+			Query:     args.Query,
+			TopK:      cmp.Or(args.TopK, 5),
+			Workspace: s.workspace,
+			Filters:   &api.RetrieveFilter{DocumentIDs: args.DocumentIDs, Tags: args.Tags},
+		})
+		if err != nil {
+			return "", err
+		}
+		if len(docs) == 0 {
+			return "No matching passages.", nil
+		}
+		var b strings.Builder
+		for i, d := range docs {
+			fmt.Fprintf(&b, "[%d] %v, page %v (document %v, score %.2f)\n%s\n\n", i+1, d.Metadata["filename"], d.Metadata["page"], d.Metadata["document_id"], d.Score, d.PageContent)
+		}
+		return strings.TrimSpace(b.String()), nil
+	case "ask_documents":
+		if strings.TrimSpace(args.Question) == "" {
+			return "", fmt.Errorf("question is required")
+		}
+		reply, err := s.client.Chat(ctx, api.ChatRequest{Question: args.Question, Workspace: s.workspace, DocumentIDs: args.DocumentIDs, Format: "plain"})
+		if err != nil {
+			return "", err
+		}
+		return reply.Answer, nil
+	}
+	return "", fmt.Errorf("unknown tool %q", name)
+}
+
+// serveStdio speaks MCP as newline-delimited JSON-RPC on stdin and stdout.
+// Requests run concurrently, so a ping isn't held up by a slow answer.
+func (s *mcpServer) serveStdio(in io.Reader, out io.Writer) error {
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+	send := func(m *mcpMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(m); err != nil {
+			log.Printf("❌ MCP Write Error: %v", err)
+		}
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	sc := bufio.NewScanner(in)
+	sc.Buffer(make([]byte, 64*1024), 4<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var msg mcpMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			send(&mcpMessage{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcpError{-32700, "Parse error"}})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reply := s.handle(context.Background(), msg); reply != nil {
+				send(reply)
+			}
+		}()
+	}
+	return sc.Err()
+}
+
+// serveSSE speaks MCP's HTTP+SSE transport: a client opens GET /sse, is
+// told where to POST its messages, and gets the replies as "message" events
+// on the stream.
+func (s *mcpServer) serveSSE(addr string) error {
+	type stream struct {
+		replies chan *mcpMessage
+		done    chan struct{}
+	}
+	var streams sync.Map // session ID -> *stream
+	r := newRouter()
+	r.GET("/sse", func(c *gin.Context) {
+		id := uuid.New().String()
+		st := &stream{make(chan *mcpMessage, 16), make(chan struct{})}
+		streams.Store(id, st)
+		defer func() {
+			streams.Delete(id)
+			close(st.done)
+		}()
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.SSEvent("endpoint", "/messages?session_id="+id)
+		c.Writer.Flush()
+		for {
+			select {
+			case m := <-st.replies:
+				data, _ := json.Marshal(m)
+				c.SSEvent("message", string(data))
+				c.Writer.Flush()
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+	})
+	r.POST("/messages", func(c *gin.Context) {
+		v, ok := streams.Load(c.Query("session_id"))
+		if !ok {
+			c.JSON(http.StatusNotFound, errorReply(c, codeNotFound, "Unknown MCP session"))
+			return
+		}
+		var msg mcpMessage
+		if err := c.BindJSON(&msg); err != nil {
+			return
+		}
+		c.Status(http.StatusAccepted)
+		st := v.(*stream)
+		go func() {
+			if reply := s.handle(context.Background(), msg); reply != nil {
+				select {
+				case st.replies <- reply:
+				case <-st.done: // the client went away
+				}
+			}
+		}()
+	})
+	log.Printf("🔌 MCP server listening on %s (SSE at /sse)", addr)
+	return r.Run(addr)
+}
+
+// runMCP is `docuchat mcp [-server url] [-workspace ws] [-user id]
+// [-sse addr]`: an MCP server over stdio, or over HTTP+SSE on addr. Every
+// tool call reaches the API as the given user. The SSE transport has no
+// authentication of its own, so bind it to localhost or put it behind a
+// proxy that has.
+func runMCP(args []string) error {
+	fset := flag.NewFlagSet("mcp", flag.ContinueOnError)
+	server := fset.String("server", cmp.Or(os.Getenv("DOCUCHAT_URL"), "http://localhost:8080"), "API base URL")
+	workspace := fset.String("workspace", "default", "workspace to search")
+	user := fset.String("user", os.Getenv("DOCUCHAT_USER"), "user ID sent as X-User-ID")
+	sse := fset.String("sse", "", "listen address for the HTTP+SSE transport instead of stdio, e.g. 127.0.0.1:8090")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	s := &mcpServer{client: client.New(*server, client.WithUser(*user)), workspace: *workspace}
+	if *sse != "" {
+		return s.serveSSE(*sse)
+	}
+	return s.serveStdio(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// documentMeta is what is known about a document beyond its text: from its
+// PDF properties, or detected in its first page when those are missing.
+type documentMeta struct {
+	Title      string    `json:"title,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Keywords   string    `json:"keywords,omitempty"`
+	Date       time.Time `json:"date,omitzero"`
+	DateSource string    `json:"date_source,omitempty"` // supplied, content_effective, properties or content
+}
+
+var (
+	monthNames  = `(?:jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t(?:ember)?)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?)\.?`
+	contentDate = regexp.MustCompile(`(?i)\b(?:` + monthNames + `\s+\d{1,2}(?:st|nd|rd|th)?,?\s+\d{4}|\d{1,2}(?:st|nd|rd|th)?\s+(?:of\s+)?` + monthNames + `,?\s+\d{4}|\d{4}-\d{2}-\d{2})\b`)
+	effectiveRe = regexp.MustCompile(`(?i)\b(?:effective(?:\s+(?:as\s+of|date|from|on))?|dated(?:\s+as\s+of)?|as\s+of)\b[\s:,]*(?:the\s+)?$`)
+	authorRe    = regexp.MustCompile(`(?im)^\s*(?:by|authors?:|prepared\s+by:?|written\s+by:?)\s+([^\n]{3,80})$`)
+	pdfDateRe   = regexp.MustCompile(`^D?:?(\d{4})(\d{2})?(\d{2})?(\d{2})?(\d{2})?(\d{2})?`)
+	ordinalRe   = regexp.MustCompile(`(\d)(?:st|nd|rd|th)\b`)
+	titleJunk   = regexp.MustCompile(`(?i)^(?:microsoft (?:word|powerpoint|excel) - |untitled$|document\d*$)|\.(?:docx?|pptx?|xlsx?|pdf)$`)
+)
+
+// pdfProperties reads the Info dictionary of a PDF.
+func pdfProperties(path, password string) (meta documentMeta) {
+	f, r, err := openPdf(path, password)
+	if err != nil {
+		return meta
+	}
+	defer f.Close()
+	defer func() { recover() }()
+	info := r.Trailer().Key("Info")
+	meta.Title = strings.TrimSpace(info.Key("Title").Text())
+	// "Microsoft Word - draft.docx" and the like are the producer's, not a title
+	meta.Title = strings.TrimSpace(titleJunk.ReplaceAllString(meta.Title, ""))
+	meta.Author = strings.TrimSpace(info.Key("Author").Text())
+	meta.Subject = strings.TrimSpace(info.Key("Subject").Text())
+	meta.Keywords = strings.TrimSpace(info.Key("Keywords").Text())
+	if t, ok := parsePdfDate(info.Key("CreationDate").Text()); ok {
+		meta.Date, meta.DateSource = t, "properties"
+	}
+	return meta
+}
+
+// parsePdfDate parses a PDF date string such as "D:20230115093000+01'00'";
+// the time zone is ignored.
+func parsePdfDate(s string) (time.Time, bool) {
+	m := pdfDateRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return time.Time{}, false
+	}
+	digits := strings.Join(m[1:], "")
+	layout := "20060102150405"[:len(digits)]
+	t, err := time.Parse(layout, digits)
+	return t, err == nil && t.Year() > 1970
+}
+
+// parseContentDate parses a date as written in a document.
+func parseContentDate(s string) (time.Time, bool) {
+	s = ordinalRe.ReplaceAllString(strings.ToLower(s), "$1")
+	s = strings.NewReplacer(",", " ", ".", " ", " of ", " ", "sept", "sep").Replace(s)
+	s = strings.Join(strings.Fields(s), " ")
+	for _, layout := range []string{"2006-01-02", "January 2 2006", "Jan 2 2006", "2 January 2006", "2 Jan 2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// detectMetadata fills in what the PDF properties leave out from the text of
+// the first page: the first line as title, a "By ..." line as author, and an
+// effective date, which wins over the creation date since it's what the
+// document means by its date.
+func detectMetadata(meta documentMeta, firstPage string) documentMeta {
+	if meta.Title == "" {
+		for _, line := range strings.Split(firstPage, "\n") {
+			if line = strings.TrimSpace(line); len(line) >= 3 && len(line) <= 150 {
+				meta.Title = line
+				break
+			}
+		}
+	}
+	if meta.Author == "" {
+		if m := authorRe.FindStringSubmatch(firstPage); m != nil {
+			meta.Author = strings.TrimSpace(m[1])
+		}
+	}
+	var first time.Time
+	for _, loc := range contentDate.FindAllStringIndex(firstPage, -1) {
+		t, ok := parseContentDate(firstPage[loc[0]:loc[1]])
+		if !ok {
+			continue
+		}
+		if effectiveRe.MatchString(firstPage[max(0, loc[0]-40):loc[0]]) {
+			return withDate(meta, t, "content_effective")
+		}
+		if first.IsZero() {
+			first = t
+		}
+	}
+	if meta.Date.IsZero() && !first.IsZero() {
+		meta = withDate(meta, first, "content")
+	}
+	return meta
+}
+
+func withDate(meta documentMeta, t time.Time, source string) documentMeta {
+	meta.Date, meta.DateSource = t, source
+	return meta
+}
+
+// dateFilter restricts a search to documents dated on or after after and
+// before before; either may be zero. Undated documents never match.
+func dateFilter(after, before time.Time) *pb.Filter {
+	if after.IsZero() && before.IsZero() {
+		return nil
+	}
+	r := &pb.Range{}
+	if !after.IsZero() {
+		gte := float64(after.Unix())
+		r.Gte = &gte
+	}
+	if !before.IsZero() {
+		lt := float64(before.Unix())
+		r.Lt = &lt
+	}
+	return &pb.Filter{Must: []*pb.Condition{pb.NewRange("doc_date", r)}}
+}
+
+// parseDateBound reads a date filter bound: a year ("2022"), a month
+// ("2022-06") or a day ("2022-06-30"). An "after" bound covers everything
+// after the period, so after=2022 means from 2023 on; a "before" bound
+// means before the period starts.
+func parseDateBound(s string, after bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, p := range []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	} {
+		if t, err := time.Parse(p.layout, s); err == nil {
+			if after {
+				return p.next(t), nil
+			}
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q; use YYYY, YYYY-MM or YYYY-MM-DD", s)
+}
+
+// handleListDocuments lists the caller's documents, newest first by their
+// own date: GET /documents?workspace=&after=&before=.
+func handleListDocuments(c *gin.Context) {
+	after, err := parseDateBound(c.Query("after"), true)
+	if err == nil {
+		var before time.Time
+		if before, err = parseDateBound(c.Query("before"), false); err == nil {
+			listDocuments(c, after, before)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
+}
+
+func listDocuments(c *gin.Context, after, before time.Time) {
+	all, err := metaStore.List("documents")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	user, ws := requestUser(c), c.Query("workspace")
+	docs := []documentRecord{}
+	for _, raw := range all {
+		var doc documentRecord
+		if json.Unmarshal(raw, &doc) != nil || (doc.Owner != "" && doc.Owner != user) || (ws != "" && doc.Workspace != ws) {
+			continue
+		}
+		if (!after.IsZero() || !before.IsZero()) && doc.Date.IsZero() {
+			continue
+		}
+		if (!after.IsZero() && doc.Date.Before(after)) || (!before.IsZero() && !doc.Date.Before(before)) {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		di, dj := docs[i].Date, docs[j].Date
+		if di.IsZero() {
+			di = docs[i].CreatedAt
+		}
+		if dj.IsZero() {
+			dj = docs[j].CreatedAt
+		}
+		return di.After(dj)
+	})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "documents": docs})
+}
+
+// documentMetadata works out a document's metadata from its PDF properties
+// and its first page, which it reads off pages and puts back.
+func documentMetadata(ctx context.Context, path, password string, pages <-chan pdfPage) (documentMeta, <-chan pdfPage) {
+	meta := pdfProperties(path, password)
+	first, ok := <-pages
+	if !ok {
+		return meta, pages
+	}
+	if first.Err == "" {
+		meta = detectMetadata(meta, first.Text)
+	}
+	out := make(chan pdfPage, 1)
+	out <- first
+	go func() {
+		defer close(out)
+		for p := range pages {
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return meta, out
+}
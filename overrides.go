@@ -0,0 +1,153 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// answerOverride is a curated answer that replaces the pipeline for
+// questions matching it, exactly (ignoring case and punctuation) or by
+// embedding similarity of at least Threshold (default OVERRIDE_SIMILARITY,
+// 0.92). Overrides are kept in a bucket per workspace.
+type answerOverride struct {
+	ID        string    `json:"id"`
+	Question  string    `json:"question"`
+	Match     string    `json:"match"` // exact or semantic
+	Threshold float64   `json:"threshold,omitempty"`
+	Answer    string    `json:"answer"`
+	Vector    []float32 `json:"vector,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func overridesBucket(workspace string) string { return "overrides:" + workspace }
+
+// handleListOverrides lists a workspace's answer overrides:
+// GET /admin/overrides/:workspace.
+func handleListOverrides(c *gin.Context) {
+	overrides, err := workspaceOverrides(c.Param("workspace"))
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	for i := range overrides {
+		overrides[i].Vector = nil
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "overrides": overrides})
+}
+
+// handlePutOverride creates or replaces an answer override:
+// POST /admin/overrides/:workspace, or PUT .../:id to replace one.
+func handlePutOverride(c *gin.Context) {
+	var o answerOverride
+	if err := c.BindJSON(&o); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	o.Question, o.Answer = strings.TrimSpace(o.Question), strings.TrimSpace(o.Answer)
+	if o.Question == "" || o.Answer == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "question and answer are required"))
+		return
+	}
+	if o.Match = cmp.Or(o.Match, "exact"); o.Match != "exact" && o.Match != "semantic" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, `match must be "exact" or "semantic"`))
+		return
+	}
+	if o.Threshold < 0 || o.Threshold > 1 {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "threshold must be between 0 and 1"))
+		return
+	}
+	o.ID = cmp.Or(c.Param("id"), uuid.New().String())
+	o.Vector = nil
+	if o.Match == "semantic" {
+		vector, err := embedText(c.Request.Context(), o.Question)
+		if err != nil {
+			c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err)))
+			return
+		}
+		o.Vector = vector
+	}
+	o.UpdatedAt = time.Now()
+	workspace := c.Param("workspace")
+	if err := metaStore.Put(overridesBucket(workspace), o.ID, o); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "override_update", Workspace: workspace, Detail: fmt.Sprintf("%s override %s for %q", o.Match, o.ID, o.Question)})
+	o.Vector = nil
+	c.JSON(http.StatusOK, gin.H{"status": "success", "override": o})
+}
+
+// handleDeleteOverride removes an answer override:
+// DELETE /admin/overrides/:workspace/:id.
+func handleDeleteOverride(c *gin.Context) {
+	workspace := c.Param("workspace")
+	if err := metaStore.Delete(overridesBucket(workspace), c.Param("id")); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "override_delete", Workspace: workspace, Detail: "override " + c.Param("id") + " removed"})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Override removed"})
+}
+
+func workspaceOverrides(workspace string) ([]answerOverride, error) {
+	all, err := metaStore.List(overridesBucket(workspace))
+	if err != nil {
+		return nil, err
+	}
+	overrides := make([]answerOverride, 0, len(all))
+	for _, raw := range all {
+		var o answerOverride
+		if json.Unmarshal(raw, &o) == nil {
+			overrides = append(overrides, o)
+		}
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Question < overrides[j].Question })
+	return overrides, nil
+}
+
+// matchOverride finds the override for a question: an exact match first,
+// then the most similar semantic one over its threshold. The question is
+// only embedded if the workspace has semantic overrides; the embedding is
+// returned for reuse.
+func matchOverride(ctx context.Context, workspace, question string) (answerOverride, bool, []float32, error) {
+	overrides, err := workspaceOverrides(workspace)
+	if err != nil || len(overrides) == 0 {
+		return answerOverride{}, false, nil, nil
+	}
+	q := normalizeQuestion(question)
+	semantic := false
+	for _, o := range overrides {
+		if o.Match == "exact" && normalizeQuestion(o.Question) == q {
+			return o, true, nil, nil
+		}
+		semantic = semantic || o.Match == "semantic"
+	}
+	if !semantic {
+		return answerOverride{}, false, nil, nil
+	}
+	vector, err := embedText(ctx, question)
+	if err != nil {
+		return answerOverride{}, false, nil, err
+	}
+	var best answerOverride
+	bestScore, found := 0.0, false
+	for _, o := range overrides {
+		if o.Match != "semantic" {
+			continue
+		}
+		score := cosine(o.Vector, vector)
+		if score >= cmp.Or(o.Threshold, envFloat("OVERRIDE_SIMILARITY", 0.92)) && score > bestScore {
+			best, bestScore, found = o, score, true
+		}
+	}
+	return best, found, vector, nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	arxivIDRe = regexp.MustCompile(`^(?:\d{4}\.\d{4,5}|[a-z][a-z-]*(?:\.[A-Z]{2})?/\d{7})(?:v\d+)?$`)
+	doiRe     = regexp.MustCompile(`^10\.\d{4,9}/\S+$`)
+)
+
+// paperRef is a paper named by an arXiv ID or a DOI.
+type paperRef struct{ arxiv, doi string }
+
+// parsePaperID reads an arXiv ID ("2401.01234", "arXiv:hep-th/9901001v2",
+// an arxiv.org link) or a DOI ("10.1038/nature12373", "doi:...", a doi.org
+// link). arXiv's own DOIs are looked up on arXiv.
+func parsePaperID(s string) (paperRef, error) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	for _, prefix := range []string{"https://arxiv.org/abs/", "https://arxiv.org/pdf/", "http://arxiv.org/abs/", "http://arxiv.org/pdf/", "arxiv:"} {
+		if strings.HasPrefix(lower, prefix) {
+			s = strings.TrimSuffix(s[len(prefix):], ".pdf")
+			break
+		}
+	}
+	for _, prefix := range []string{"https://doi.org/", "http://doi.org/", "https://dx.doi.org/", "http://dx.doi.org/", "doi:"} {
+		if strings.HasPrefix(lower, prefix) {
+			s = s[len(prefix):]
+			break
+		}
+	}
+	if rest, ok := strings.CutPrefix(strings.ToLower(s), "10.48550/arxiv."); ok {
+		s = s[len(s)-len(rest):]
+	}
+	switch {
+	case arxivIDRe.MatchString(s):
+		return paperRef{arxiv: s}, nil
+	case doiRe.MatchString(s):
+		return paperRef{doi: s}, nil
+	}
+	return paperRef{}, fmt.Errorf("id must be an arXiv ID or a DOI")
+}
+
+// handleIngestPaper fetches a paper's PDF and metadata and ingests it:
+// POST /ingest/paper {"id", "workspace", "tags"}. arXiv papers come from
+// arXiv; for a DOI the metadata comes from Crossref and the PDF from
+// Unpaywall's open-access copies, which needs PAPER_CONTACT_EMAIL, or from
+// the publisher's links. The document is titled and dated as published,
+// cited by its landing page, and carries authors, abstract and identifiers
+// as metadata. PDFs over PAPER_MAX_MB (default 100) are refused. Replies as
+// POST /ingest does, with the paper's metadata.
+func handleIngestPaper(c *gin.Context) {
+	var body api.IngestPaperRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	ref, err := parsePaperID(body.ID)
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
+		return
+	}
+	workspace := cmp.Or(body.Workspace, "default")
+
+	withIdempotency(c, fingerprint([]byte(ref.arxiv+ref.doi), workspace), func() gin.H {
+		ctx := c.Request.Context()
+		var paper api.Paper
+		var pdfs []string
+		source := "arxiv"
+		if ref.arxiv != "" {
+			paper, pdfs, err = arxivPaper(ctx, ref.arxiv)
+		} else {
+			paper, pdfs, err = doiPaper(ctx, ref.doi)
+			source = "doi"
+		}
+		if err != nil {
+			return upstreamReply(c, upstreamPapers, "Paper Lookup Error: "+err.Error())
+		}
+		path, err := downloadPaper(ctx, &paper, pdfs)
+		if err != nil {
+			resp := errReply(c, codeUpstream, err)
+			resp["paper"] = paper
+			return resp
+		}
+		defer os.Remove(path) // gone already if a queued job moved it
+
+		meta := map[string]string{"authors": strings.Join(paper.Authors, "; ")}
+		for k, v := range map[string]string{"arxiv_id": paper.ArXivID, "doi": paper.DOI, "journal": paper.Journal, "abstract": paper.Abstract} {
+			if v != "" {
+				meta[k] = v
+			}
+		}
+		if !paper.Published.IsZero() {
+			meta["year"] = strconv.Itoa(paper.Published.Year())
+		}
+		job := ingestJob{
+			ID:         uuid.New().String(),
+			DocumentID: uuid.New().String(),
+			Filename:   strings.TrimSuffix(textFilename(paper.Title), ".txt") + ".pdf",
+			Path:       path,
+			Workspace:  workspace,
+			Owner:      requestUser(c),
+			Graph:      graphEnabled(""),
+			Tags:       parseTags(strings.Join(body.Tags, ",")),
+			Source:     source,
+			Title:      paper.Title,
+			Date:       paper.Published,
+			URL:        paper.URL,
+			Metadata:   meta,
+		}
+		resp, _ := submitIngest(c, job)
+		resp["paper"] = paper
+		return resp
+	})
+}
+
+// arxivPaper looks a paper up with the arXiv API (ARXIV_API_URL, default
+// https://export.arxiv.org/api/query), returning it and where its PDF is.
+func arxivPaper(ctx context.Context, id string) (api.Paper, []string, error) {
+	endpoint := cmp.Or(os.Getenv("ARXIV_API_URL"), "https://export.arxiv.org/api/query")
+	body, err := connectorGet(ctx, endpoint+"?id_list="+url.QueryEscape(id), func(req *http.Request) {
+		req.Header.Set("Accept", "application/atom+xml")
+	})
+	if err != nil {
+		return api.Paper{}, nil, err
+	}
+	var feed struct {
+		Entries []struct {
+			ID        string `xml:"id"`
+			Title     string `xml:"title"`
+			Summary   string `xml:"summary"`
+			Published string `xml:"published"`
+			Authors   []struct {
+				Name string `xml:"name"`
+			} `xml:"author"`
+			Links []struct {
+				Href  string `xml:"href,attr"`
+				Title string `xml:"title,attr"`
+			} `xml:"link"`
+			DOI     string `xml:"http://arxiv.org/schemas/atom doi"`
+			Journal string `xml:"http://arxiv.org/schemas/atom journal_ref"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return api.Paper{}, nil, err
+	}
+	if len(feed.Entries) == 0 || strings.Contains(feed.Entries[0].ID, "/api/errors") || strings.TrimSpace(feed.Entries[0].Title) == "" {
+		return api.Paper{}, nil, fmt.Errorf("arXiv has no paper %s", id)
+	}
+	e := feed.Entries[0]
+	p := api.Paper{
+		ArXivID:   id,
+		DOI:       strings.TrimSpace(e.DOI),
+		Title:     strings.Join(strings.Fields(e.Title), " "),
+		Abstract:  strings.Join(strings.Fields(e.Summary), " "),
+		Journal:   strings.TrimSpace(e.Journal),
+		Published: parseFeedDate(e.Published),
+		URL:       "https://arxiv.org/abs/" + id,
+	}
+	for _, a := range e.Authors {
+		p.Authors = append(p.Authors, strings.TrimSpace(a.Name))
+	}
+	pdfs := []string{"https://arxiv.org/pdf/" + id}
+	for _, l := range e.Links {
+		if l.Title == "pdf" && l.Href != "" {
+			pdfs = []string{l.Href}
+		}
+	}
+	return p, pdfs, nil
+}
+
+// doiPaper looks a DOI up on Crossref, returning the paper and the PDFs to
+// try: Unpaywall's open-access copies first, then the publisher's links.
+func doiPaper(ctx context.Context, doi string) (api.Paper, []string, error) {
+	email := os.Getenv("PAPER_CONTACT_EMAIL")
+	endpoint := "https://api.crossref.org/works/" + url.PathEscape(doi)
+	if email != "" {
+		endpoint += "?mailto=" + url.QueryEscape(email) // Crossref's polite pool
+	}
+	body, err := connectorGet(ctx, endpoint, nil)
+	if err != nil {
+		return api.Paper{}, nil, err
+	}
+	type date struct {
+		Parts [][]int `json:"date-parts"`
+	}
+	var res struct {
+		Message struct {
+			Title   []string `json:"title"`
+			Authors []struct {
+				Given  string `json:"given"`
+				Family string `json:"family"`
+				Name   string `json:"name"`
+			} `json:"author"`
+			Abstract  string   `json:"abstract"` // JATS markup
+			Container []string `json:"container-title"`
+			Published date     `json:"published"`
+			Issued    date     `json:"issued"`
+			Links     []struct {
+				URL         string `json:"URL"`
+				ContentType string `json:"content-type"`
+			} `json:"link"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return api.Paper{}, nil, err
+	}
+	m := res.Message
+	p := api.Paper{DOI: doi, URL: "https://doi.org/" + doi, Abstract: strings.Join(strings.Fields(htmlToText(m.Abstract)), " ")}
+	if len(m.Title) > 0 {
+		p.Title = strings.Join(strings.Fields(m.Title[0]), " ")
+	}
+	p.Title = cmp.Or(p.Title, doi)
+	if len(m.Container) > 0 {
+		p.Journal = m.Container[0]
+	}
+	for _, a := range m.Authors {
+		if name := cmp.Or(strings.TrimSpace(a.Given+" "+a.Family), a.Name); name != "" {
+			p.Authors = append(p.Authors, name)
+		}
+	}
+	for _, d := range []date{m.Published, m.Issued} {
+		if len(d.Parts) > 0 && len(d.Parts[0]) > 0 {
+			parts := append(d.Parts[0], 1, 1)
+			p.Published = time.Date(parts[0], time.Month(max(parts[1], 1)), max(parts[2], 1), 0, 0, 0, 0, time.UTC)
+			break
+		}
+	}
+
+	var pdfs []string
+	if email != "" {
+		if body, err := connectorGet(ctx, "https://api.unpaywall.org/v2/"+url.PathEscape(doi)+"?email="+url.QueryEscape(email), nil); err == nil {
+			type location struct {
+				PDF string `json:"url_for_pdf"`
+			}
+			var oa struct {
+				Best      location   `json:"best_oa_location"`
+				Locations []location `json:"oa_locations"`
+			}
+			json.Unmarshal(body, &oa)
+			for _, l := range append([]location{oa.Best}, oa.Locations...) {
+				if l.PDF != "" && !slices.Contains(pdfs, l.PDF) {
+					pdfs = append(pdfs, l.PDF)
+				}
+			}
+		}
+	}
+	for _, l := range m.Links {
+		if l.ContentType == "application/pdf" && l.URL != "" {
+			pdfs = append(pdfs, l.URL)
+		}
+	}
+	return p, pdfs, nil
+}
+
+// downloadPaper fetches the first of pdfs that is a PDF into a temporary
+// file, noting on the paper where it came from. Landing pages served in
+// place of a PDF are skipped.
+func downloadPaper(ctx context.Context, paper *api.Paper, pdfs []string) (string, error) {
+	if len(pdfs) == 0 {
+		if os.Getenv("PAPER_CONTACT_EMAIL") == "" {
+			return "", codedError{codeNotFound, "No PDF link found for this paper; set PAPER_CONTACT_EMAIL to look for open-access copies"}
+		}
+		return "", codedError{codeNotFound, "No open-access PDF found for this paper"}
+	}
+	var last error
+	for _, link := range pdfs {
+		path, err := downloadPDF(ctx, link)
+		if err == nil {
+			paper.PDFURL = link
+			return path, nil
+		}
+		last = err
+	}
+	return "", fmt.Errorf("Paper Download Error: %v", last)
+}
+
+func downloadPDF(ctx context.Context, link string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/pdf")
+	resp, err := askClient.Do(req) // links come from the paper index, not the operator
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", link, resp.Status)
+	}
+	tmp, err := os.CreateTemp("", "docuchat-paper-*.pdf")
+	if err != nil {
+		return "", err
+	}
+	limit := int64(envInt("PAPER_MAX_MB", 100)) << 20
+	n, err := io.Copy(tmp, io.LimitReader(resp.Body, limit+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = fmt.Errorf("%s: PDF is larger than %d MB", link, limit>>20)
+	}
+	if err == nil {
+		err = checkPDF(tmp.Name(), link)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func checkPDF(path, link string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 5)
+	if _, err := io.ReadFull(f, head); err != nil || string(head) != "%PDF-" {
+		return fmt.Errorf("%s did not return a PDF", link)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// externalParser turns a document of any format it supports into pages.
+type externalParser interface {
+	Parse(ctx context.Context, path, filename, password string) ([]pdfPage, error)
+}
+
+// configuredParser returns the parsing service set by PARSER_SERVICE
+// ("tika" or "unstructured") at PARSER_URL, or nil to parse locally.
+func configuredParser() externalParser {
+	url := strings.TrimRight(os.Getenv("PARSER_URL"), "/")
+	switch os.Getenv("PARSER_SERVICE") {
+	case "tika":
+		if url == "" {
+			url = "http://localhost:9998"
+		}
+		return tikaParser{url: url}
+	case "unstructured":
+		if url == "" {
+			url = "https://api.unstructuredapp.io"
+		}
+		return unstructuredParser{url: url, key: os.Getenv("PARSER_API_KEY")}
+	}
+	return nil
+}
+
+// parserClient is built on first use, once loadConfig has read the env file.
+var parserClient = sync.OnceValue(func() *http.Client {
+	return &http.Client{Timeout: time.Duration(envInt("PARSER_TIMEOUT_SECONDS", 120)) * time.Second}
+})
+
+// readPages parses a document with the configured parsing service, falling
+// back to the local readers when there is none or it fails: plain text is
+// read as is, anything else as a PDF.
+func readPages(ctx context.Context, path, filename, password string) (<-chan pdfPage, error) {
+	if isTranscriptFile(filename) {
+		pages, err := transcriptPages(path)
+		if err != nil {
+			return nil, err
+		}
+		return pageChannel(ctx, pages), nil
+	}
+	if parser := configuredParser(); parser != nil {
+		pages, err := parser.Parse(ctx, path, filename, password)
+		if err == nil && len(pages) > 0 {
+			return pageChannel(ctx, pages), nil
+		}
+		if err == nil {
+			err = fmt.Errorf("no text returned")
+		}
+		recordError("parser", err)
+		log.Printf("⚠️ Parser Service Error (%s), parsing locally: %v", filename, err)
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".txt") {
+		pages, err := textPages(path)
+		if err != nil {
+			return nil, err
+		}
+		return pageChannel(ctx, pages), nil
+	}
+	return readPdfPages(ctx, path, password)
+}
+
+// textPages reads a plain text file, breaking pages at form feeds.
+func textPages(path string) ([]pdfPage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pages []pdfPage
+	for i, text := range strings.Split(string(data), "\f") {
+		pages = append(pages, pdfPage{Number: i + 1, Text: text})
+	}
+	return pages, nil
+}
+
+func pageChannel(ctx context.Context, pages []pdfPage) <-chan pdfPage {
+	out := make(chan pdfPage)
+	go func() {
+		defer close(out)
+		for _, p := range pages {
+			select {
+			case out <- p:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// tikaParser uses an Apache Tika server. Its XHTML output marks PDF pages
+// with <div class="page">; other formats come back as a single page.
+type tikaParser struct{ url string }
+
+var (
+	tikaPageRe  = regexp.MustCompile(`<div class="page">`)
+	htmlBlockRe = regexp.MustCompile(`(?i)</?(?:p|div|h[1-6]|li|tr|br)\b[^>]*>`)
+	htmlTagRe   = regexp.MustCompile(`<[^>]+>`)
+	blankRunRe  = regexp.MustCompile(`\n{3,}`)
+	preRe       = regexp.MustCompile(`(?is)<pre\b[^>]*>(.*?)</pre>`)
+)
+
+func (t tikaParser) Parse(ctx context.Context, path, filename, password string) ([]pdfPage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, t.url+"/tika", f)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if password != "" {
+		req.Header.Set("Password", password)
+	}
+	body, err := parserCall(req)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := string(body)
+	if i := strings.Index(doc, "<body"); i >= 0 {
+		doc = doc[i:]
+	}
+	parts := tikaPageRe.Split(doc, -1)
+	if len(parts) > 1 {
+		parts = parts[1:] // before the first page is the document head
+	}
+	var pages []pdfPage
+	for i, part := range parts {
+		if text := htmlToText(part); text != "" {
+			pages = append(pages, pdfPage{Number: i + 1, Text: text})
+		}
+	}
+	return pages, nil
+}
+
+// htmlToText strips markup, keeping block elements as line breaks.
+func htmlToText(s string) string {
+	// <pre> keeps its indentation, as a fenced block
+	var parts []string
+	last := 0
+	for _, m := range preRe.FindAllStringSubmatchIndex(s, -1) {
+		parts = append(parts, flowText(s[last:m[0]]))
+		if code := strings.Trim(html.UnescapeString(htmlTagRe.ReplaceAllString(s[m[2]:m[3]], "")), "\n"); strings.TrimSpace(code) != "" {
+			parts = append(parts, "```\n"+code+"\n```")
+		}
+		last = m[1]
+	}
+	parts = append(parts, flowText(s[last:]))
+	return strings.Join(slices.DeleteFunc(parts, func(p string) bool { return p == "" }), "\n\n")
+}
+
+func flowText(s string) string {
+	s = htmlBlockRe.ReplaceAllString(s, "\n")
+	s = html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankRunRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// unstructuredParser uses the unstructured.io partition API, hosted or self
+// run. Elements are grouped by page, with titles set apart as paragraphs.
+type unstructuredParser struct{ url, key string }
+
+func (u unstructuredParser) Parse(ctx context.Context, path, filename, password string) ([]pdfPage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("files", filepath.Base(filename))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil && password != "" {
+			err = mw.WriteField("pdf_password", password)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url+"/general/v0/general", pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("Accept", "application/json")
+	if u.key != "" {
+		req.Header.Set("unstructured-api-key", u.key)
+	}
+	body, err := parserCall(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Metadata struct {
+			PageNumber int `json:"page_number"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(body, &elements); err != nil {
+		return nil, err
+	}
+	texts := map[int]*strings.Builder{}
+	for _, e := range elements {
+		text := strings.TrimSpace(e.Text)
+		if text == "" || e.Type == "Header" || e.Type == "Footer" || e.Type == "PageNumber" {
+			continue
+		}
+		switch e.Type {
+		case "CodeSnippet":
+			text = "```\n" + strings.Trim(e.Text, "\n") + "\n```"
+		case "Formula":
+			text = "$$" + text + "$$"
+		}
+		n := max(e.Metadata.PageNumber, 1)
+		b, ok := texts[n]
+		if !ok {
+			b = &strings.Builder{}
+			texts[n] = b
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+			if e.Type == "Title" {
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString(text)
+		if e.Type == "Title" {
+			b.WriteString("\n")
+		}
+	}
+	pages := make([]pdfPage, 0, len(texts))
+	for n, b := range texts {
+		pages = append(pages, pdfPage{Number: n, Text: b.String()})
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].Number < pages[j].Number })
+	return pages, nil
+}
+
+func parserCall(req *http.Request) ([]byte, error) {
+	resp, err := parserClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body[:min(len(body), 300)])))
+	}
+	return body, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/ledongthuc/pdf"
+)
+
+// pdfPage is the extracted text of one page. Err is set when extraction
+// failed, in which case Text is empty. Fields is set for a structured
+// record, its metadata.
+type pdfPage struct {
+	Number int
+	Text   string
+	Err    string
+	Fields map[string]string
+	Start  float64 // seconds into the recording, for a transcript window
+}
+
+// pageError records a page whose text couldn't be extracted.
+type pageError = api.PageError
+
+// extractPage reads one page's text, recovering from the panics malformed
+// pages can cause in the PDF library.
+func extractPage(r *pdf.Reader, i int, layout bool) (text string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			text, err = "", fmt.Errorf("%v", p)
+		}
+	}()
+	page := r.Page(i)
+	if page.V.IsNull() {
+		return "", errors.New("page object is missing")
+	}
+	if layout {
+		if text = layoutText(page); text != "" {
+			return text, nil
+		}
+	}
+	return page.GetPlainText(nil)
+}
+
+// openPdf opens a PDF, decrypting it with password if it is protected. A
+// missing or wrong password is reported as a codedError.
+func openPdf(path, password string) (*os.File, *pdf.Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	tried := false
+	r, err := pdf.NewReaderEncrypted(f, info.Size(), func() string {
+		if tried {
+			return ""
+		}
+		tried = true
+		return password
+	})
+	switch {
+	case err == pdf.ErrInvalidPassword && password == "":
+		err = codedError{codePDFPasswordRequired, "PDF is password protected; provide its password"}
+	case err == pdf.ErrInvalidPassword:
+		err = codedError{codePDFPasswordInvalid, "Wrong password for PDF"}
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, r, nil
+}
+
+// readPdfPages extracts page text with a pool of PDF_WORKERS workers and
+// delivers pages on the returned channel in page order, each as soon as it
+// and every page before it are done. At most a few pages per worker are held
+// waiting for a slow predecessor. The channel is closed when the document is
+// finished or ctx is cancelled. A page that fails to extract is still
+// delivered, with Err set, so the ingest can report it. With PDF_LAYOUT=true pages are read in
+// layout order, falling back to content-stream order for pages that fail.
+func readPdfPages(ctx context.Context, path, password string) (<-chan pdfPage, error) {
+	f, r, err := openPdf(path, password)
+	if err != nil {
+		return nil, err
+	}
+	workers := envInt("PDF_WORKERS", runtime.NumCPU())
+	numPages := r.NumPage()
+	layout := layoutEnabled()
+
+	jobs := make(chan int)
+	results := make(chan pdfPage, workers)
+	out := make(chan pdfPage, workers)
+	window := make(chan struct{}, workers*4) // pages in flight ahead of the next one to emit
+
+	go func() {
+		defer close(jobs)
+		for i := 1; i <= numPages; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				p := pdfPage{Number: i}
+				if text, err := extractPage(r, i, layout); err != nil {
+					p.Err = err.Error()
+				} else {
+					p.Text = text
+				}
+				select {
+				case results <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		f.Close()
+		close(results)
+	}()
+
+	go func() {
+		defer close(out)
+		pending := map[int]pdfPage{}
+		next := 1
+		for p := range results {
+			pending[p.Number] = p
+			for q, ok := pending[next]; ok; q, ok = pending[next] {
+				delete(pending, next)
+				select {
+				case out <- q:
+				case <-ctx.Done():
+					return
+				}
+				<-window
+				next++
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// workspacePersona is how a workspace's assistant presents itself, kept in
+// the "personas" bucket by workspace and set with PUT /admin/personas/:workspace.
+type workspacePersona struct {
+	Name            string    `json:"name"`                       // e.g. "Acme Support Assistant"
+	Description     string    `json:"description,omitempty"`      // who it serves and what it knows about
+	Tone            string    `json:"tone,omitempty"`             // e.g. "friendly but concise"
+	Formatting      string    `json:"formatting,omitempty"`       // e.g. "use bullet lists for steps"
+	ForbiddenTopics []string  `json:"forbidden_topics,omitempty"` // topics it declines to discuss
+	Instructions    string    `json:"instructions,omitempty"`     // anything else for the system prompt
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// prompt renders the persona as the opening of the system prompt.
+func (p workspacePersona) prompt() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are %s.", p.Name)
+	if p.Description != "" {
+		b.WriteString(" " + p.Description)
+	}
+	b.WriteString(" Answer questions using the context provided.")
+	if p.Tone != "" {
+		fmt.Fprintf(&b, " Tone: %s.", strings.TrimRight(p.Tone, "."))
+	}
+	if p.Formatting != "" {
+		fmt.Fprintf(&b, " Formatting: %s.", strings.TrimRight(p.Formatting, "."))
+	}
+	if len(p.ForbiddenTopics) > 0 {
+		fmt.Fprintf(&b, " Never discuss these topics; if asked, politely decline: %s.", strings.Join(p.ForbiddenTopics, "; "))
+	}
+	if p.Instructions != "" {
+		b.WriteString(" " + p.Instructions)
+	}
+	return b.String()
+}
+
+// loadPersona returns a workspace's persona, if it has one.
+func loadPersona(workspace string) (workspacePersona, bool) {
+	var p workspacePersona
+	found, err := metaStore.Get("personas", workspace, &p)
+	return p, err == nil && found && p.Name != ""
+}
+
+// personaFor is the opening of the system prompt for a workspace: its
+// persona, or the library's persona template, whose version is recorded in
+// prompts.
+func personaFor(workspace string, prompts promptVersions) string {
+	if p, ok := loadPersona(workspace); ok {
+		return p.prompt()
+	}
+	return prompts.text("persona")
+}
+
+// handleGetPersona returns a workspace's persona: GET /admin/personas/:workspace.
+func handleGetPersona(c *gin.Context) {
+	p, ok := loadPersona(c.Param("workspace"))
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "persona": nil, "prompt": promptVersions(nil).text("persona")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "persona": p, "prompt": p.prompt()})
+}
+
+// handlePutPersona sets a workspace's persona: PUT /admin/personas/:workspace.
+func handlePutPersona(c *gin.Context) {
+	var p workspacePersona
+	if err := c.BindJSON(&p); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	if p.Name = strings.TrimSpace(p.Name); p.Name == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "name is required"))
+		return
+	}
+	p.UpdatedAt = time.Now()
+	if err := metaStore.Put("personas", c.Param("workspace"), p); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "persona_update", Workspace: c.Param("workspace"), Detail: fmt.Sprintf("persona set to %q", p.Name)})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "persona": p, "prompt": p.prompt()})
+}
+
+// handleDeletePersona puts a workspace back on the built-in persona:
+// DELETE /admin/personas/:workspace.
+func handleDeletePersona(c *gin.Context) {
+	if err := metaStore.Delete("personas", c.Param("workspace")); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "persona_delete", Workspace: c.Param("workspace"), Detail: "persona removed"})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Persona removed"})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The prompt library keeps the server's prompt templates in the "prompts"
+// bucket, one entry per template holding every version ever saved. Saving a
+// template adds a version and makes it active; an older version, or 0 for
+// the built-in text, can be made active again to roll an edit back. Each
+// chat records the version of every template that went into it, so a drop
+// in feedback can be traced to the edit that caused it.
+
+// builtinPrompts are the templates the library manages, with the text used
+// until a version is saved.
+var builtinPrompts = map[string]string{
+	"persona":    personaPrompt, // used when the workspace has no persona of its own
+	"agent":      agentPrompt,
+	"structured": structuredPrompt,
+	"web":        webInstruction,
+	// answer lengths and reading levels asked for per request
+	"length_brief":     lengthBriefPrompt,
+	"length_detailed":  lengthDetailedPrompt,
+	"reading_beginner": readingBeginnerPrompt,
+	"reading_expert":   readingExpertPrompt,
+}
+
+// promptVersion is one saved edit of a template.
+type promptVersion struct {
+	Version   int       `json:"version"`
+	Text      string    `json:"text"`
+	Author    string    `json:"author,omitempty"`
+	Changelog string    `json:"changelog,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// promptTemplate is a template's history. Active is the version in use; 0
+// means the built-in text.
+type promptTemplate struct {
+	Name      string          `json:"name"`
+	Active    int             `json:"active"`
+	Versions  []promptVersion `json:"versions"`
+	UpdatedAt time.Time       `json:"updated_at,omitzero"`
+}
+
+func loadPromptTemplate(name string) (promptTemplate, error) {
+	t := promptTemplate{Name: name}
+	_, err := metaStore.Get("prompts", name, &t)
+	return t, err
+}
+
+// text is the active version's text.
+func (t promptTemplate) text() string {
+	if t.Active > 0 && t.Active <= len(t.Versions) {
+		return t.Versions[t.Active-1].Text
+	}
+	return builtinPrompts[t.Name]
+}
+
+// promptVersions records which version of each template a chat used.
+type promptVersions map[string]int
+
+// text returns a template's active text and records its version. Should the
+// library be unreadable, the built-in text is used and recorded as version 0.
+func (v promptVersions) text(name string) string {
+	t, err := loadPromptTemplate(name)
+	if err != nil {
+		t = promptTemplate{Name: name}
+	}
+	if v != nil {
+		v[name] = t.Active
+	}
+	return t.text()
+}
+
+// promptStats counts the chats made with one version of a template and the
+// feedback they got.
+type promptStats struct {
+	Version int `json:"version"`
+	Chats   int `json:"chats"`
+	Up      int `json:"up"`
+	Down    int `json:"down"`
+}
+
+// promptUsage tallies chats and feedback per version of a template.
+func promptUsage(name string) ([]promptStats, error) {
+	all, err := metaStore.List("chats")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*promptStats{}
+	for _, raw := range all {
+		var rec chatRecord
+		if json.Unmarshal(raw, &rec) != nil {
+			continue
+		}
+		version, ok := rec.Prompts[name]
+		if !ok {
+			continue
+		}
+		st := byVersion[version]
+		if st == nil {
+			st = &promptStats{Version: version}
+			byVersion[version] = st
+		}
+		st.Chats++
+		if rec.Feedback != nil {
+			switch rec.Feedback.Rating {
+			case "up":
+				st.Up++
+			case "down":
+				st.Down++
+			}
+		}
+	}
+	stats := make([]promptStats, 0, len(byVersion))
+	for _, st := range byVersion {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Version < stats[j].Version })
+	return stats, nil
+}
+
+// handleListPrompts lists the templates and their active versions:
+// GET /admin/prompts.
+func handleListPrompts(c *gin.Context) {
+	names := slices.Sorted(func(yield func(string) bool) {
+		for name := range builtinPrompts {
+			if !yield(name) {
+				return
+			}
+		}
+	})
+	var list []gin.H
+	for _, name := range names {
+		t, err := loadPromptTemplate(name)
+		if err != nil {
+			c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+			return
+		}
+		list = append(list, gin.H{"name": name, "active": t.Active, "versions": len(t.Versions), "text": t.text(), "updated_at": t.UpdatedAt})
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "prompts": list})
+}
+
+// handleGetPrompt returns a template's history with usage and feedback per
+// version: GET /admin/prompts/:name.
+func handleGetPrompt(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := builtinPrompts[name]; !ok {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Unknown prompt"))
+		return
+	}
+	t, err := loadPromptTemplate(name)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	stats, err := promptUsage(name)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "prompt": t, "builtin": builtinPrompts[name], "usage": stats})
+}
+
+// handlePutPrompt saves a new version of a template and makes it active:
+// POST /admin/prompts/:name {"text", "changelog", "author"}. The author
+// defaults to the caller's X-User-ID.
+func handlePutPrompt(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := builtinPrompts[name]; !ok {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Unknown prompt"))
+		return
+	}
+	var body struct {
+		Text      string `json:"text"`
+		Changelog string `json:"changelog"`
+		Author    string `json:"author"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "text is required"))
+		return
+	}
+	t, err := loadPromptTemplate(name)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	v := promptVersion{
+		Version:   len(t.Versions) + 1,
+		Text:      body.Text,
+		Author:    cmp.Or(body.Author, requestUser(c)),
+		Changelog: body.Changelog,
+		CreatedAt: time.Now(),
+	}
+	t.Versions = append(t.Versions, v)
+	t.Active, t.UpdatedAt = v.Version, v.CreatedAt
+	if err := metaStore.Put("prompts", name, t); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "prompt_update", Detail: fmt.Sprintf("prompt %s: version %d by %s: %s", name, v.Version, cmp.Or(v.Author, "unknown"), v.Changelog)})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "prompt": name, "version": v})
+}
+
+// handleActivatePrompt switches a template to an earlier version, or to the
+// built-in text with version 0: PUT /admin/prompts/:name/active {"version"}.
+func handleActivatePrompt(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := builtinPrompts[name]; !ok {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Unknown prompt"))
+		return
+	}
+	var body struct {
+		Version int `json:"version"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	t, err := loadPromptTemplate(name)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	if body.Version < 0 || body.Version > len(t.Versions) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No such version"))
+		return
+	}
+	t.Active, t.UpdatedAt = body.Version, time.Now()
+	if err := metaStore.Put("prompts", name, t); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	audit(auditEntry{Action: "prompt_activate", Detail: fmt.Sprintf("prompt %s: version %d active", name, t.Active)})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "prompt": name, "active": t.Active, "text": t.text()})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+)
+
+// qdrantPool spreads Qdrant calls over QDRANT_CONNECTIONS (default 1) gRPC
+// connections, round-robin among the healthy ones, so heavy upserts aren't
+// limited to one HTTP/2 connection. Calls go through the qdrant breaker and
+// retries, and a retry may land on another connection. Every
+// QDRANT_HEALTH_SECONDS (default 10) each connection is health-checked; a
+// failing one is retried without waiting out gRPC's reconnect backoff, and
+// after QDRANT_REDIAL_AFTER (default 3) failed checks in a row it's replaced
+// by a fresh connection, so a restarted Qdrant is picked up within seconds.
+type qdrantPool struct {
+	target string
+	opts   []grpc.DialOption
+	conns  []*qdrantConn
+	next   atomic.Uint64
+}
+
+type qdrantConn struct {
+	mu         sync.Mutex
+	cc         *grpc.ClientConn
+	healthy    bool
+	failures   int // consecutive failed checks
+	redials    int
+	lastError  string
+	lastCheck  time.Time
+	lastChange time.Time
+}
+
+func dialQdrant(target string) (*qdrantPool, error) {
+	creds := grpc.WithTransportCredentials(insecure.NewCredentials())
+	p := &qdrantPool{target: target}
+	if key := os.Getenv("QDRANT_API_KEY"); key != "" {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+		p.opts = append(p.opts, grpc.WithPerRPCCredentials(tokenAuth{token: key}))
+	}
+	p.opts = append(p.opts, creds,
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.Config{BaseDelay: time.Second, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Duration(envInt("QDRANT_RECONNECT_MAX_SECONDS", 15)) * time.Second},
+			MinConnectTimeout: 5 * time.Second,
+		}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(envInt("QDRANT_KEEPALIVE_SECONDS", 30)) * time.Second,
+			Timeout:             10 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	)
+	for range envInt("QDRANT_CONNECTIONS", 1) {
+		cc, err := grpc.NewClient(target, p.opts...)
+		if err != nil {
+			return nil, err
+		}
+		p.conns = append(p.conns, &qdrantConn{cc: cc, healthy: true, lastChange: time.Now()})
+	}
+	return p, nil
+}
+
+// pick returns the next healthy connection, or the next one at all when
+// none is healthy.
+func (p *qdrantPool) pick() *grpc.ClientConn {
+	n := uint64(len(p.conns))
+	start := p.next.Add(1)
+	for i := range n {
+		c := p.conns[(start+i)%n]
+		c.mu.Lock()
+		cc, healthy := c.cc, c.healthy
+		c.mu.Unlock()
+		if healthy {
+			return cc
+		}
+	}
+	c := p.conns[start%n]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cc
+}
+
+// Invoke implements grpc.ClientConnInterface for the generated clients.
+func (p *qdrantPool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	return withRetry(ctx, "qdrant", retryableGRPC, func() error {
+		return p.pick().Invoke(ctx, method, args, reply, opts...)
+	})
+}
+
+func (p *qdrantPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return p.pick().NewStream(ctx, desc, method, opts...)
+}
+
+// watch health-checks the connections until ctx is done.
+func (p *qdrantPool) watch(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(envInt("QDRANT_HEALTH_SECONDS", 10)) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for i, c := range p.conns {
+			p.check(ctx, i, c)
+		}
+	}
+}
+
+func (p *qdrantPool) check(ctx context.Context, i int, c *qdrantConn) {
+	c.mu.Lock()
+	cc := c.cc
+	c.mu.Unlock()
+	checkCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	// straight to the connection: a health check shouldn't be retried or
+	// count against the breaker
+	_, err := pb.NewQdrantClient(cc).HealthCheck(checkCtx, &pb.HealthCheckRequest{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCheck = time.Now()
+	if err == nil {
+		if !c.healthy {
+			log.Printf("✅ Qdrant connection %d recovered", i)
+			c.healthy, c.lastChange = true, time.Now()
+		}
+		c.failures, c.lastError = 0, ""
+		return
+	}
+	c.failures++
+	c.lastError = err.Error()
+	if c.healthy {
+		log.Printf("❌ Qdrant Connection Error: connection %d: %v", i, err)
+		c.healthy, c.lastChange = false, time.Now()
+	}
+	if c.failures < envInt("QDRANT_REDIAL_AFTER", 3) || cc.GetState() == connectivity.Ready {
+		cc.ResetConnectBackoff()
+		return
+	}
+	fresh, err := grpc.NewClient(p.target, p.opts...)
+	if err != nil {
+		c.lastError = err.Error()
+		return
+	}
+	fresh.Connect()
+	c.cc, c.failures = fresh, 0
+	c.redials++
+	log.Printf("🔄 Qdrant connection %d redialled", i)
+	// let calls already on the old connection finish or fail on their own
+	time.AfterFunc(time.Minute, func() { cc.Close() })
+}
+
+// qdrantConnStatus is a connection's health for GET /admin/status.
+type qdrantConnStatus struct {
+	State     string    `json:"state"`
+	Healthy   bool      `json:"healthy"`
+	Failures  int       `json:"consecutive_failures"`
+	Redials   int       `json:"redials"`
+	LastError string    `json:"last_error,omitempty"`
+	LastCheck time.Time `json:"last_check,omitzero"`
+	Since     time.Time `json:"since"`
+}
+
+func (p *qdrantPool) status() []qdrantConnStatus {
+	out := make([]qdrantConnStatus, len(p.conns))
+	for i, c := range p.conns {
+		c.mu.Lock()
+		out[i] = qdrantConnStatus{c.cc.GetState().String(), c.healthy, c.failures, c.redials, c.lastError, c.lastCheck, c.lastChange}
+		c.mu.Unlock()
+	}
+	return out
+}
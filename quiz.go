@@ -0,0 +1,176 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+const quizPrompt = "You write study material from a document. Using only the numbered excerpts, write %d %s that test understanding of the most important facts and ideas, spread across the excerpts. Write them in %s. For each, give the excerpt numbers it is based on in sources, and a one-sentence explanation of the answer."
+
+// quizItem is one generated question. Multiple-choice items have options,
+// with answer repeating the correct one; flashcards have only a question
+// and its answer.
+type quizItem struct {
+	Question    string       `json:"question"`
+	Options     []string     `json:"options,omitempty"`
+	Answer      string       `json:"answer"`
+	Explanation string       `json:"explanation"`
+	Citations   []quizSource `json:"citations"`
+}
+
+// quizSource is an excerpt a quiz item was written from.
+type quizSource struct {
+	PointID string `json:"point_id"`
+	Page    int64  `json:"page"`
+	Snippet string `json:"snippet"`
+}
+
+// handleQuiz generates questions from a document's chunks:
+// POST /documents/:id/quiz {"kind": "multiple_choice" or "flashcards",
+// "count"}. Up to QUIZ_MAX_CHUNKS (default 20) chunks, spread evenly through
+// the document, are given to the model.
+func handleQuiz(c *gin.Context) {
+	var body struct {
+		Kind  string `json:"kind"`
+		Count int    `json:"count"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+			return
+		}
+	}
+	body.Kind = cmp.Or(body.Kind, "multiple_choice")
+	if body.Kind != "multiple_choice" && body.Kind != "flashcards" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, `kind must be "multiple_choice" or "flashcards"`))
+		return
+	}
+	if body.Count <= 0 {
+		body.Count = 10
+	}
+	body.Count = min(body.Count, 50)
+
+	documentID := c.Param("id")
+	var doc documentRecord
+	if found, err := metaStore.Get("documents", documentID, &doc); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	} else if !found || (doc.Owner != "" && doc.Owner != requestUser(c)) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document not found"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	var points []*pb.RetrievedPoint
+	err := scrollPoints(ctx, andFilters(documentFilter(documentID), enabledFilter), true, func(batch []*pb.RetrievedPoint) error {
+		points = append(points, batch...)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	if len(points) == 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No indexed text for this document"))
+		return
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Payload["chunk_index"].GetIntegerValue() < points[j].Payload["chunk_index"].GetIntegerValue()
+	})
+	points = spreadPoints(points, envInt("QUIZ_MAX_CHUNKS", 20))
+
+	var excerpts strings.Builder
+	for i, p := range points {
+		fmt.Fprintf(&excerpts, "[%d] %s\n\n", i+1, payloadString(p.Payload, "text"))
+	}
+	kind := "multiple-choice questions, each with four options of which exactly one is correct"
+	if body.Kind == "flashcards" {
+		kind = "flashcards, each a short question or term with a concise answer"
+	}
+	prompt := fmt.Sprintf(quizPrompt, body.Count, kind, languageName(cmp.Or(doc.Language, "en"))) + "\n\nExcerpts:\n" + excerpts.String()
+
+	raw, err := completeStructured(ctx, prompt, "quiz", quizSchema(body.Kind == "multiple_choice"))
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err)))
+		return
+	}
+	var out struct {
+		Items []struct {
+			quizItem
+			Sources []int `json:"sources"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, "Invalid model output: "+err.Error()))
+		return
+	}
+	items := make([]quizItem, 0, len(out.Items))
+	for _, it := range out.Items {
+		item := it.quizItem
+		item.Citations = []quizSource{}
+		for _, n := range it.Sources {
+			if n >= 1 && n <= len(points) {
+				p := points[n-1]
+				item.Citations = append(item.Citations, quizSource{
+					PointID: p.GetId().GetUuid(),
+					Page:    p.Payload["page"].GetIntegerValue(),
+					Snippet: snippet(payloadString(p.Payload, "text"), 300),
+				})
+			}
+		}
+		items = append(items, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "document_id": documentID, "kind": body.Kind, "items": items})
+}
+
+// spreadPoints picks at most n points evenly spaced through points.
+func spreadPoints(points []*pb.RetrievedPoint, n int) []*pb.RetrievedPoint {
+	if len(points) <= n {
+		return points
+	}
+	picked := make([]*pb.RetrievedPoint, n)
+	for i := range picked {
+		picked[i] = points[i*len(points)/n]
+	}
+	return picked
+}
+
+// quizSchema is the response schema for a quiz, with options for
+// multiple-choice questions.
+func quizSchema(options bool) json.RawMessage {
+	props := map[string]any{
+		"question":    map[string]any{"type": "string"},
+		"answer":      map[string]any{"type": "string"},
+		"explanation": map[string]any{"type": "string"},
+		"sources":     map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+	}
+	required := []string{"question", "answer", "explanation", "sources"}
+	if options {
+		props["options"] = map[string]any{"type": "array", "items": map[string]any{"type": "string"}}
+		required = append(required, "options")
+	}
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type":                 "object",
+					"properties":           props,
+					"required":             required,
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"items"},
+		"additionalProperties": false,
+	})
+	return schema
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// rankingBoosts are score multipliers applied to search hits before the best
+// ones are kept, so that between two near-identical chunks the current or
+// preferred one wins:
+//
+//   - RECENCY_BOOST=1.5: the multiplier for a document dated today, fading
+//     towards 1 with a half-life of RECENCY_HALF_LIFE_DAYS (365); undated
+//     documents aren't boosted
+//   - TAG_BOOSTS="policy=1.5,draft=0.5": per ingest tag; a chunk with several
+//     boosted tags gets all of them
+//   - SOURCE_BOOSTS="handbook*=1.3": per source, matched as a glob against
+//     the document's source label or its filename
+type rankingBoosts struct {
+	recency  float64
+	halfLife float64 // days
+	tags     map[string]float64
+	sources  map[string]float64
+}
+
+func loadRankingBoosts() rankingBoosts {
+	b := rankingBoosts{
+		halfLife: float64(envInt("RECENCY_HALF_LIFE_DAYS", 365)),
+		tags:     parseBoosts(os.Getenv("TAG_BOOSTS")),
+		sources:  parseBoosts(os.Getenv("SOURCE_BOOSTS")),
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("RECENCY_BOOST"), 64); err == nil && f > 0 {
+		b.recency = f
+	}
+	return b
+}
+
+// parseBoosts reads a "key=multiplier,..." list, skipping malformed entries.
+func parseBoosts(s string) map[string]float64 {
+	out := map[string]float64{}
+	for _, part := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(part, "=")
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); ok && err == nil && f > 0 {
+			out[strings.ToLower(strings.TrimSpace(k))] = f
+		}
+	}
+	return out
+}
+
+func (b rankingBoosts) enabled() bool {
+	return b.recency > 0 || len(b.tags) > 0 || len(b.sources) > 0
+}
+
+// multiplier is the combined boost for one hit.
+func (b rankingBoosts) multiplier(payload map[string]*pb.Value, now time.Time) float64 {
+	m := 1.0
+	if v, ok := payload["doc_date"]; ok && b.recency > 0 {
+		days := max(0, now.Sub(time.Unix(v.GetIntegerValue(), 0)).Hours()/24)
+		m *= 1 + (b.recency-1)*math.Exp2(-days/b.halfLife)
+	}
+	for _, t := range payload["tags"].GetListValue().GetValues() {
+		if f, ok := b.tags[strings.ToLower(t.GetStringValue())]; ok {
+			m *= f
+		}
+	}
+	source := strings.ToLower(payload["source"].GetStringValue())
+	filename := strings.ToLower(payload["filename"].GetStringValue())
+	for pattern, f := range b.sources {
+		if globMatch(pattern, source) || globMatch(pattern, filename) {
+			m *= f
+			break
+		}
+	}
+	return m
+}
+
+func globMatch(pattern, s string) bool {
+	ok, _ := path.Match(pattern, s)
+	return ok && s != ""
+}
+
+// applyRankingBoosts rescales hit scores by the configured boosts, reporting
+// whether any are configured (and so whether the hits need re-sorting).
+func applyRankingBoosts(hits []*pb.ScoredPoint) bool {
+	b := loadRankingBoosts()
+	if !b.enabled() {
+		return false
+	}
+	now := time.Now()
+	for _, h := range hits {
+		h.Score *= float32(b.multiplier(h.Payload, now))
+	}
+	return true
+}
+
+// parseTags reads a comma-separated tag list, lower-cased and de-duplicated.
+func parseTags(s string) []string {
+	var tags []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" && !seen[t] {
+			seen[t] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// tagValues is a tag list as a payload value.
+func tagValues(tags []string) *pb.Value {
+	values := make([]*pb.Value, len(tags))
+	for i, t := range tags {
+		values[i] = pb.NewValueString(t)
+	}
+	return pb.NewValueFromList(values...)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimits counts requests per caller in the current minute.
+var rateLimits = struct {
+	sync.Mutex
+	window time.Time
+	counts map[string]int
+}{counts: map[string]int{}}
+
+// rateLimit allows each client IP RATE_LIMIT_PER_MINUTE requests a minute;
+// unset, there is no limit. X-User-ID isn't used, since callers pick it
+// themselves. Health and metrics checks are never limited.
+func rateLimit(c *gin.Context) {
+	limit := envInt("RATE_LIMIT_PER_MINUTE", 0)
+	if limit == 0 || c.Request.Method == http.MethodOptions || c.FullPath() == "/healthz" || c.FullPath() == "/metrics" {
+		return
+	}
+	key := c.ClientIP()
+	now := time.Now()
+	rateLimits.Lock()
+	if window := now.Truncate(time.Minute); window != rateLimits.window {
+		rateLimits.window = window
+		clear(rateLimits.counts)
+	}
+	rateLimits.counts[key]++
+	over := rateLimits.counts[key] > limit
+	retry := rateLimits.window.Add(time.Minute).Sub(now)
+	rateLimits.Unlock()
+	if over {
+		seconds := int(retry.Seconds()) + 1
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.AbortWithStatusJSON(http.StatusOK, errorReply(c, codeRateLimited, fmt.Sprintf("Rate limit exceeded; retry in %d seconds", seconds)))
+	}
+}
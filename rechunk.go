@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pagesBucket holds a document's parsed page text, sealed at rest, so it can
+// be re-chunked without the original file.
+func pagesBucket(documentID string) string { return "pages:" + documentID }
+
+func pageKey(page int) string { return fmt.Sprintf("%06d", page) }
+
+// extraKey holds a document's ingestDoc.Extra chunks among its pages.
+const extraKey = "extra"
+
+// fieldsKey holds the metadata fields of a records document's pages, by
+// page number.
+const fieldsKey = "fields"
+
+// startsKey holds when each window of a transcript starts, in seconds, by
+// page number.
+const startsKey = "starts"
+
+// storedExtra returns a document's stored extra chunks, decrypted.
+func storedExtra(documentID string) []textChunk {
+	var extra []textChunk
+	metaStore.Get(pagesBucket(documentID), extraKey, &extra)
+	for i := range extra {
+		extra[i].Text = openText(extra[i].Text)
+	}
+	return extra
+}
+
+// storedPages streams a document's stored page text in page order.
+func storedPages(ctx context.Context, documentID string) (<-chan pdfPage, int, error) {
+	all, err := metaStore.List(pagesBucket(documentID))
+	if err != nil {
+		return nil, 0, err
+	}
+	var fields map[int]map[string]string
+	json.Unmarshal(all[fieldsKey], &fields)
+	var starts map[int]float64
+	json.Unmarshal(all[startsKey], &starts)
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		if k != extraKey && k != fieldsKey && k != startsKey {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	out := make(chan pdfPage)
+	go func() {
+		defer close(out)
+		for _, k := range keys {
+			var text string
+			if json.Unmarshal(all[k], &text) != nil {
+				continue
+			}
+			n, _ := strconv.Atoi(k)
+			select {
+			case out <- pdfPage{Number: n, Text: openText(text), Fields: fields[n], Start: starts[n]}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, len(keys), nil
+}
+
+// rechunkDocument replaces a document's chunks with ones cut from its stored
+// text under the current chunking settings.
+func rechunkDocument(ctx context.Context, doc documentRecord) (int, error) {
+	pages, n, err := storedPages(ctx, doc.ID)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no stored text; re-ingest the original file")
+	}
+	if _, err := deleteChunks(ctx, doc); err != nil {
+		return 0, err
+	}
+	res, err := runIngest(ctx, pages, ingestDoc{
+		DocumentID: doc.ID,
+		Filename:   doc.Filename,
+		Workspace:  doc.Workspace,
+		ParentID:   doc.ParentID,
+		Graph:      doc.Graph,
+		Tags:       doc.Tags,
+		Source:     doc.Source,
+		URL:        doc.URL,
+		Fields:     doc.Metadata,
+		Extra:      storedExtra(doc.ID),
+		Meta:       doc.documentMeta,
+		Version:    doc.documentVersion,
+	})
+	if err != nil {
+		return res.Chunks, err
+	}
+	doc.Chunks, doc.Language = res.Chunks, res.Language
+	return res.Chunks, metaStore.Put("documents", doc.ID, doc)
+}
+
+// handleRechunk re-chunks and re-embeds documents from their stored text:
+// POST /admin/rechunk {"document_id"} for one, {"workspace"} for a
+// workspace, or {} for everything.
+func handleRechunk(c *gin.Context) {
+	var body struct {
+		DocumentID string `json:"document_id"`
+		Workspace  string `json:"workspace"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+			return
+		}
+	}
+	all, err := metaStore.List("documents")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+
+	type outcome struct {
+		DocumentID string `json:"document_id"`
+		Chunks     int    `json:"chunks"`
+		Error      string `json:"error,omitempty"`
+	}
+	results := []outcome{}
+	failed := 0
+	for id, raw := range all {
+		var doc documentRecord
+		if json.Unmarshal(raw, &doc) != nil {
+			continue
+		}
+		if (body.DocumentID != "" && id != body.DocumentID) || (body.Workspace != "" && doc.Workspace != body.Workspace) {
+			continue
+		}
+		chunks, err := rechunkDocument(context.Background(), doc)
+		o := outcome{DocumentID: id, Chunks: chunks}
+		if err != nil {
+			o.Error = err.Error()
+			failed++
+		}
+		results = append(results, o)
+	}
+	if body.DocumentID != "" && len(results) == 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document not found"))
+		return
+	}
+	if failed > 0 {
+		resp := errorReply(c, codeUpstream, fmt.Sprintf("%d of %d documents couldn't be rechunked", failed, len(results)))
+		resp["documents"], resp["failed"] = results, failed
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "documents": results, "failed": failed})
+}
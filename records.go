@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gebt2000/go-docuchat/api"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// JSON and JSONL uploads are structured records, such as database exports
+// or ticket dumps, rather than prose. Each record is a page of its own,
+// chunked apart from its neighbours, so every chunk carries exactly its
+// record's metadata fields; those go into the chunk payload under "fields",
+// where chat and retrieval can filter on them.
+
+// recordMapping says which fields of a record are text and which metadata.
+type recordMapping = api.RecordMapping
+
+// isRecordFile reports whether a file is ingested as JSON records.
+func isRecordFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonl", ".ndjson", ".json":
+		return true
+	}
+	return false
+}
+
+// recordMappingFor parses the mapping sent with an upload, falling back to
+// the workspace's JSONL_MAPPING, then to indexing every field as text.
+func recordMappingFor(raw, workspace string) (*recordMapping, error) {
+	if raw == "" {
+		raw = envWorkspace("JSONL_MAPPING", workspace)
+	}
+	m := &recordMapping{}
+	if raw == "" {
+		return m, nil
+	}
+	if err := json.Unmarshal([]byte(raw), m); err != nil {
+		return nil, fmt.Errorf("invalid mapping: %v", err)
+	}
+	return m, nil
+}
+
+// recordPages reads a JSONL file, or a JSON array of records, as one page per
+// record, numbered by line (or array position). A line that isn't a JSON
+// object is delivered as a failed page.
+func recordPages(ctx context.Context, path string, m *recordMapping) (<-chan pdfPage, error) {
+	if m == nil {
+		m = &recordMapping{}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	if bom, _ := r.Peek(3); string(bom) == "\uFEFF" {
+		r.Discard(3)
+	}
+	var array []json.RawMessage
+	if first, _ := peekNonSpace(r); first == '[' {
+		err := json.NewDecoder(r).Decode(&array)
+		f.Close()
+		if err != nil {
+			return nil, codedError{codeInvalidRecords, "Invalid JSON array: " + err.Error()}
+		}
+		f = nil
+	}
+
+	out := make(chan pdfPage)
+	go func() {
+		defer close(out)
+		send := func(p pdfPage) bool {
+			select {
+			case out <- p:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		if f == nil {
+			for i, raw := range array {
+				if !send(recordPage(i+1, raw, m)) {
+					return
+				}
+			}
+			return
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 64*1024), 16<<20)
+		n := 0
+		for sc.Scan() {
+			n++
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			if !send(recordPage(n, []byte(line), m)) {
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			send(pdfPage{Number: n + 1, Err: err.Error()}) // the rest of the file is lost
+		}
+	}()
+	return out, nil
+}
+
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for n := 1; ; n++ {
+		b, err := r.Peek(n)
+		if err != nil {
+			return 0, err
+		}
+		if c := b[n-1]; c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return c, nil
+		}
+	}
+}
+
+// recordPage turns one record into a page of text with its metadata fields.
+func recordPage(n int, raw []byte, m *recordMapping) pdfPage {
+	var rec map[string]any
+	if err := json.Unmarshal(raw, &rec); err != nil || rec == nil {
+		return pdfPage{Number: n, Err: "not a JSON object"}
+	}
+	fields := map[string]string{}
+	for _, path := range m.Metadata {
+		if v, ok := recordField(rec, path); ok {
+			fields[strings.ReplaceAll(path, ".", "_")] = recordValue(v)
+		}
+	}
+	keys := m.Text
+	if len(keys) == 0 {
+		for k := range rec {
+			if !slices.Contains(m.Metadata, k) && k != m.Title {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+	}
+	var lines []string
+	if v, ok := recordField(rec, m.Title); ok {
+		lines = append(lines, recordValue(v))
+	}
+	for _, k := range keys {
+		v, ok := recordField(rec, k)
+		if !ok || v == nil {
+			continue
+		}
+		if s := recordValue(v); s != "" {
+			if len(keys) == 1 {
+				lines = append(lines, s)
+			} else {
+				lines = append(lines, k+": "+s)
+			}
+		}
+	}
+	return pdfPage{Number: n, Text: strings.Join(lines, "\n"), Fields: fields}
+}
+
+// recordField looks up a key, or a dotted path into nested objects.
+func recordField(rec map[string]any, path string) (any, bool) {
+	if path == "" {
+		return nil, false
+	}
+	if v, ok := rec[path]; ok {
+		return v, true
+	}
+	var cur any = rec
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// recordValue renders a field value: strings as they are, anything else as
+// JSON.
+func recordValue(v any) string {
+	if s, ok := v.(string); ok {
+		return strings.TrimSpace(s)
+	}
+	raw, _ := json.Marshal(v)
+	return string(raw)
+}
+
+// fieldsPayload is a record's metadata fields as a payload value.
+func fieldsPayload(fields map[string]string) *pb.Value {
+	m := make(map[string]any, len(fields))
+	for k, v := range fields {
+		m[k] = v
+	}
+	return pb.NewValueStruct(&pb.Struct{Fields: pb.NewValueMap(m)})
+}
+
+// fieldsFilter restricts a search to chunks whose fields, from their record
+// or their document's metadata, have the given values; nil for all.
+func fieldsFilter(fields map[string]string) *pb.Filter {
+	if len(fields) == 0 {
+		return nil
+	}
+	f := &pb.Filter{}
+	for k, v := range fields {
+		f.Must = append(f.Must, pb.NewMatch("fields."+k, v))
+	}
+	return f
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisStore is a metadataStore shared by every replica. Each bucket is a
+// Redis hash, so state survives restarts and is visible across instances.
+type redisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+const redisTimeout = 5 * time.Second
+
+func openRedisStore(url string) (*redisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	s := &redisStore{client: redis.NewClient(opts), prefix: "docuchat:"}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := s.client.Ping(ctx).Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *redisStore) key(bucket string) string { return s.prefix + bucket }
+
+func (s *redisStore) Get(bucket, key string, v any) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	raw, err := s.client.HGet(ctx, s.key(bucket), key).Bytes()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+func (s *redisStore) Put(bucket, key string, v any) error {
+	return s.PutBatch(bucket, map[string]any{key: v})
+}
+
+func (s *redisStore) PutBatch(bucket string, items map[string]any) error {
+	if len(items) == 0 {
+		return nil
+	}
+	fields := make(map[string]any, len(items))
+	for k, v := range items {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fields[k] = raw
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.HSet(ctx, s.key(bucket), fields).Err()
+}
+
+func (s *redisStore) Delete(bucket, key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return s.client.HDel(ctx, s.key(bucket), key).Err()
+}
+
+func (s *redisStore) List(bucket string) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	all, err := s.client.HGetAll(ctx, s.key(bucket)).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]json.RawMessage, len(all))
+	for k, v := range all {
+		out[k] = json.RawMessage(v)
+	}
+	return out, nil
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai"
+	"google.golang.org/protobuf/proto"
+)
+
+// embeddingConfig is the embedding model the serving collection was built
+// with. Queries must be embedded with the same model and dimensions.
+type embeddingConfig struct {
+	Model string `json:"model"`
+	Size  int    `json:"size"`
+	// Dimensions, when set, asks a text-embedding-3 model for shortened
+	// vectors of that size; 0 keeps the model's own.
+	Dimensions int `json:"dimensions,omitempty"`
+}
+
+// embeddingQuality is OpenAI's published MTEB average for the models and
+// dimensions it measured, quoted when vectors are shortened so the trade-off
+// is visible; quality on a given corpus should be measured, e.g. with shadow
+// mode, before switching.
+var embeddingQuality = map[string]map[int]float64{
+	"text-embedding-3-small": {512: 61.6, 1536: 62.3},
+	"text-embedding-3-large": {256: 62.0, 1024: 64.1, 3072: 64.6},
+	"text-embedding-ada-002": {1536: 61.0},
+}
+
+// dimensionsNote describes what shortening a model's vectors costs.
+func dimensionsNote(cfg embeddingConfig) string {
+	if cfg.Dimensions == 0 {
+		return ""
+	}
+	note := fmt.Sprintf("Vectors shortened to %d dimensions use less memory but retrieve somewhat less accurately.", cfg.Dimensions)
+	scores := embeddingQuality[cfg.Model]
+	full, native := embeddingDims[cfg.Model]
+	if reduced, ok := scores[cfg.Dimensions]; ok && native {
+		note += fmt.Sprintf(" OpenAI measured %s at %.1f MTEB with %d dimensions against %.1f with %d.", cfg.Model, reduced, cfg.Dimensions, scores[full], full)
+	}
+	return note + " Compare answers on your own documents before relying on it."
+}
+
+// validDimensions checks that a model can shorten its vectors to dims.
+func validDimensions(model string, dims int) error {
+	switch {
+	case dims == 0:
+		return nil
+	case !strings.HasPrefix(model, "text-embedding-3"):
+		return fmt.Errorf("%s can't shorten its vectors; dimensions needs a text-embedding-3 model", model)
+	case dims < 0 || (embeddingDims[model] > 0 && dims > embeddingDims[model]):
+		return fmt.Errorf("dimensions must be between 1 and %d for %s", embeddingDims[model], model)
+	}
+	return nil
+}
+
+// servingEmbedding caches the stored embeddingConfig briefly, so every
+// replica picks up a reindex's model switch within seconds.
+var servingEmbedding struct {
+	sync.Mutex
+	cfg    embeddingConfig
+	loaded time.Time
+}
+
+// currentEmbedding returns the serving embedding model: whatever the last
+// reindex switched to or the serving collection was created with, else
+// EMBEDDING_MODEL (default text-embedding-3-small) at its full size.
+func currentEmbedding() embeddingConfig {
+	servingEmbedding.Lock()
+	defer servingEmbedding.Unlock()
+	if time.Since(servingEmbedding.loaded) < 10*time.Second {
+		return servingEmbedding.cfg
+	}
+	cfg := embeddingConfig{Model: cmp.Or(os.Getenv("EMBEDDING_MODEL"), string(openai.SmallEmbedding3)), Size: embeddingSize}
+	if metaStore != nil {
+		var stored embeddingConfig
+		if found, _ := metaStore.Get("settings", "embedding", &stored); found {
+			cfg = stored
+		}
+	}
+	servingEmbedding.cfg, servingEmbedding.loaded = cfg, time.Now()
+	return cfg
+}
+
+// newEmbedding is the model a new serving collection is built with:
+// EMBEDDING_MODEL shortened to EMBEDDING_DIMENSIONS if set. An existing
+// collection changes either through a reindex.
+func newEmbedding() embeddingConfig {
+	cfg := embeddingConfig{Model: cmp.Or(os.Getenv("EMBEDDING_MODEL"), string(openai.SmallEmbedding3)), Size: embeddingSize, Dimensions: envInt("EMBEDDING_DIMENSIONS", 0)}
+	if cfg.Dimensions > 0 {
+		cfg.Size = cfg.Dimensions
+	}
+	return cfg
+}
+
+// setServingEmbedding records model as the serving one and drops the cached
+// copy.
+func setServingEmbedding(model embeddingConfig) {
+	metaStore.Put("settings", "embedding", model)
+	servingEmbedding.Lock()
+	servingEmbedding.loaded = time.Time{}
+	servingEmbedding.Unlock()
+}
+
+// reindexState is the progress of the current or most recent reindex.
+type reindexState struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source"`
+	Target     string    `json:"target"`
+	Model      string    `json:"model"`
+	Size       int       `json:"size"`
+	Dimensions int       `json:"dimensions,omitempty"`
+	Activate   bool      `json:"activate"`
+	State      string    `json:"state"` // running, done, ready (built but not serving) or failed
+	Copied     int       `json:"copied"`
+	Error      string    `json:"error,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+}
+
+var reindexRunning atomic.Bool
+
+// reindexMu guards the reindexState a running reindex updates.
+var reindexMu sync.Mutex
+
+// newCollectionName names a new collection for the time it was created.
+func newCollectionName() string {
+	return fmt.Sprintf("%s_%s", collectionName, time.Now().UTC().Format("20060102150405"))
+}
+
+// legacyCollection is the error for promoting over a collection from before
+// aliases, which could only be replaced by dropping it while it serves.
+func legacyCollection() error {
+	return codedError{codeConflict, fmt.Sprintf("%s is a collection from before aliases and can't be swapped without downtime; reindex with \"activate\": false to build the new collection alongside it", collectionName)}
+}
+
+// handleReindex starts rebuilding the serving collection in the background:
+// POST /admin/reindex {"embedding_model", "dimensions", "activate"}.
+// Dimensions shortens text-embedding-3 vectors to save memory, and defaults
+// to the serving collection's when the model stays the same. The old collection
+// keeps serving until the new one is complete; with "activate": false it keeps
+// serving after, and the new one can be switched to with PUT /admin/aliases.
+// GET /admin/reindex reports progress.
+func handleReindex(c *gin.Context) {
+	var body struct {
+		EmbeddingModel string `json:"embedding_model"`
+		Dimensions     *int   `json:"dimensions"`
+		Activate       *bool  `json:"activate"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+			return
+		}
+	}
+	current := currentEmbedding()
+	if body.EmbeddingModel == "" {
+		body.EmbeddingModel = current.Model
+	}
+	target := embeddingConfig{Model: body.EmbeddingModel}
+	if body.Dimensions != nil {
+		target.Dimensions = *body.Dimensions
+	} else if target.Model == current.Model {
+		target.Dimensions = current.Dimensions
+	}
+	if err := validDimensions(target.Model, target.Dimensions); err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
+		return
+	}
+	activate := body.Activate == nil || *body.Activate
+	if activate {
+		source, err := aliasTarget(c.Request.Context(), collectionName)
+		if err != nil {
+			c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+			return
+		}
+		if source == collectionName {
+			c.JSON(http.StatusOK, errReply(c, codeConflict, legacyCollection()))
+			return
+		}
+	}
+	if !reindexRunning.CompareAndSwap(false, true) {
+		c.JSON(http.StatusOK, errorReply(c, codeConflict, "A reindex is already running"))
+		return
+	}
+
+	// embed a probe to learn the new model's vector size
+	probe, err := embedTextsWith(c.Request.Context(), target, []string{"probe"})
+	if err != nil {
+		reindexRunning.Store(false)
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, "OpenAI Embedding Error: "+err.Error()))
+		return
+	}
+	st := reindexState{
+		ID:         uuid.New().String(),
+		Target:     newCollectionName(),
+		Model:      target.Model,
+		Size:       len(probe[0]),
+		Dimensions: target.Dimensions,
+		Activate:   activate,
+		State:      "running",
+		StartedAt:  time.Now(),
+	}
+	metaStore.Put("reindex", "current", st)
+	audit(auditEntry{Action: "reindex", Detail: fmt.Sprintf("started %s into %s with %s", st.ID, st.Target, st.Model)})
+	started := st
+
+	go func() {
+		defer reindexRunning.Store(false)
+		err := runReindex(context.Background(), &st)
+		reindexMu.Lock()
+		if err != nil {
+			st.State, st.Error = "failed", err.Error()
+			recordError("reindex", err)
+			log.Printf("❌ Reindex Error: %v", err)
+		} else {
+			st.State = "done"
+			if !st.Activate {
+				st.State = "ready"
+			}
+			log.Printf("✅ Reindexed %d chunks into %s", st.Copied, st.Target)
+		}
+		st.FinishedAt = time.Now()
+		final := st
+		reindexMu.Unlock()
+		metaStore.Put("reindex", "current", final)
+		audit(auditEntry{Action: "reindex", Detail: fmt.Sprintf("%s %s: %d chunks into %s", final.ID, final.State, final.Copied, final.Target)})
+	}()
+	reply := gin.H{"status": "success", "message": "Reindex started", "reindex": started}
+	if note := dimensionsNote(target); note != "" {
+		reply["note"] = note
+	}
+	c.JSON(http.StatusOK, reply)
+}
+
+// handleReindexStatus reports the current or last reindex: GET /admin/reindex.
+func handleReindexStatus(c *gin.Context) {
+	var st reindexState
+	found, err := metaStore.Get("reindex", "current", &st)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	if !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No reindex has run"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "reindex": st})
+}
+
+// runReindex copies every chunk of the serving collection into st.Target,
+// re-embedding its stored text with st.Model and keeping its ID so the term,
+// graph and glossary indexes stay valid, then makes it the serving
+// collection unless st.Activate is off.
+func runReindex(ctx context.Context, st *reindexState) error {
+	source, err := aliasTarget(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+	reindexMu.Lock()
+	st.Source = source
+	reindexMu.Unlock()
+	// remember both models, so either collection can be switched to later
+	metaStore.Put("collection_models", source, currentEmbedding())
+	if err := metaStore.Put("collection_models", st.Target, embeddingConfig{Model: st.Model, Size: st.Size, Dimensions: st.Dimensions}); err != nil {
+		return err
+	}
+	_, err = collectionsClient.Create(ctx, &pb.CreateCollection{
+		CollectionName: st.Target,
+		VectorsConfig: &pb.VectorsConfig{Config: &pb.VectorsConfig_Params{Params: &pb.VectorParams{
+			Size:     uint64(st.Size),
+			Distance: pb.Distance_Cosine,
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	ensurePayloadIndexes(ctx, st.Target)
+	progress := func(n int) {
+		reindexMu.Lock()
+		st.Copied += n
+		snapshot := *st
+		reindexMu.Unlock()
+		metaStore.Put("reindex", "current", snapshot)
+	}
+	if !st.Activate {
+		_, err := syncCollection(ctx, source, st.Target, embeddingConfig{Model: st.Model, Size: st.Size, Dimensions: st.Dimensions}, progress)
+		return err
+	}
+	return promoteCollection(ctx, source, st.Target, progress)
+}
+
+// promoteCollection makes target the serving collection in place of source.
+// Writes keep going to source meanwhile, so target is synced from it until a
+// pass finds nothing new; then the alias and embedding model are switched and
+// one last pass picks up writes that raced the switch. Source is left as it
+// is, still searchable, until the switch has succeeded; a collection from
+// before aliases, which only dropping could replace, isn't promoted over.
+func promoteCollection(ctx context.Context, source, target string, progress func(int)) error {
+	if source == collectionName {
+		return legacyCollection()
+	}
+	var model embeddingConfig
+	if found, err := metaStore.Get("collection_models", target, &model); err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("embedding model of %s is unknown", target)
+	}
+	for pass := 0; pass < 5; pass++ {
+		changed, err := syncCollection(ctx, source, target, model, progress)
+		if err != nil {
+			return err
+		}
+		if changed == 0 {
+			break
+		}
+	}
+
+	if err := switchAlias(ctx, collectionName, target, true); err != nil {
+		return err
+	}
+	setServingEmbedding(model)
+	log.Printf("🔀 %s now serves from %s", collectionName, target)
+
+	_, err := syncCollection(ctx, source, target, model, progress)
+	return err
+}
+
+// syncCollection makes target hold the same chunks as source: chunks it
+// lacks or whose text changed are re-embedded with model and copied, chunks
+// whose payload alone changed, such as an edited tag or a disabled flag, get
+// source's payload, and chunks gone from source are deleted. It returns how
+// many chunks changed, reporting copies to progress as it goes.
+func syncCollection(ctx context.Context, source, target string, model embeddingConfig, progress func(int)) (int, error) {
+	have := map[string]pointDigest{}
+	err := scrollCollection(ctx, target, nil, true, func(points []*pb.RetrievedPoint) error {
+		for _, p := range points {
+			have[p.GetId().GetUuid()] = digestPoint(p.Payload)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	changed := 0
+	inSource := map[string]bool{}
+	err = scrollCollection(ctx, source, nil, true, func(points []*pb.RetrievedPoint) error {
+		var stale, retagged []*pb.RetrievedPoint
+		var texts []string
+		for _, p := range points {
+			id := p.GetId().GetUuid()
+			inSource[id] = true
+			digest := digestPoint(p.Payload)
+			switch prev, ok := have[id]; {
+			case !ok || prev.text != digest.text:
+				stale = append(stale, p)
+				texts = append(texts, payloadString(p.Payload, "text"))
+			case prev.payload != digest.payload:
+				retagged = append(retagged, p)
+			}
+		}
+		for _, p := range retagged {
+			_, err := qdrantClient.OverwritePayload(ctx, &pb.SetPayloadPoints{
+				CollectionName: target,
+				Payload:        p.Payload,
+				PointsSelector: pb.NewPointsSelector(p.Id),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		changed += len(retagged)
+		if len(stale) == 0 {
+			return nil
+		}
+		vectors, err := embedTextsWith(ctx, model, texts)
+		if err != nil {
+			return err
+		}
+		copies := make([]*pb.PointStruct, len(stale))
+		for i, p := range stale {
+			copies[i] = &pb.PointStruct{Id: p.Id, Vectors: pb.NewVectorsDense(vectors[i]), Payload: p.Payload}
+		}
+		if _, err := qdrantClient.Upsert(ctx, &pb.UpsertPoints{CollectionName: target, Points: copies}); err != nil {
+			return err
+		}
+		changed += len(copies)
+		progress(len(copies))
+		return nil
+	})
+	if err != nil {
+		return changed, err
+	}
+
+	var gone []*pb.PointId
+	for id := range have {
+		if !inSource[id] {
+			gone = append(gone, pb.NewIDUUID(id))
+		}
+	}
+	if len(gone) > 0 {
+		_, err = qdrantClient.Delete(ctx, &pb.DeletePoints{
+			CollectionName: target,
+			Points:         pb.NewPointsSelector(gone...),
+		})
+		changed += len(gone)
+	}
+	return changed, err
+}
+
+// pointDigest fingerprints a chunk's text, which its vector depends on, and
+// its whole payload.
+type pointDigest struct {
+	text, payload [sha256.Size]byte
+}
+
+func digestPoint(payload map[string]*pb.Value) pointDigest {
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(payload)) {
+		v, _ := proto.MarshalOptions{Deterministic: true}.Marshal(payload[k])
+		fmt.Fprintf(h, "%d:%s%d:", len(k), k, len(v))
+		h.Write(v)
+	}
+	return pointDigest{
+		text:    sha256.Sum256([]byte(payloadString(payload, "text"))),
+		payload: [sha256.Size]byte(h.Sum(nil)),
+	}
+}
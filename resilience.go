@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errBreakerOpen is returned without calling the upstream while its circuit
+// breaker is open.
+var errBreakerOpen = errors.New("circuit breaker open")
+
+type breakerState string
+
+const (
+	breakerClosed   breakerState = "closed"
+	breakerOpen     breakerState = "open"
+	breakerHalfOpen breakerState = "half_open"
+)
+
+// breaker is a consecutive-failure circuit breaker for one upstream. After
+// BREAKER_THRESHOLD failures in a row it opens for BREAKER_COOLDOWN_SECONDS,
+// then lets a single trial call through to decide whether to close again.
+type breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	state     breakerState
+	failures  int // consecutive
+	openedAt  time.Time
+	trial     bool // a half-open trial call is in flight
+	calls     uint64
+	errors    uint64
+	retries   uint64
+	rejected  uint64
+	lastError string
+	latency   time.Duration // total over calls, for the mean
+	lastLat   time.Duration
+}
+
+// breakers are built on first use, once loadConfig has read the env file.
+var breakers = sync.OnceValue(func() map[string]*breaker {
+	return map[string]*breaker{
+		"openai": newBreaker("openai"),
+		"qdrant": newBreaker("qdrant"),
+	}
+})
+
+func newBreaker(name string) *breaker {
+	return &breaker{
+		name:      name,
+		threshold: envInt("BREAKER_THRESHOLD", 5),
+		cooldown:  time.Duration(envInt("BREAKER_COOLDOWN_SECONDS", 30)) * time.Second,
+		state:     breakerClosed,
+	}
+}
+
+// allow reports whether a call may go ahead, moving an open breaker to
+// half-open once its cooldown has passed.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			b.rejected++
+			return false
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.trial {
+			b.rejected++
+			return false
+		}
+		b.trial = true
+	}
+	b.calls++
+	return true
+}
+
+// record updates the breaker with the outcome of an allowed call. Only
+// upstream failures count; a caller's bad request says nothing about health.
+func (b *breaker) record(err error, upstreamFailure bool, took time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	b.latency += took
+	b.lastLat = took
+	if err == nil || !upstreamFailure {
+		b.failures = 0
+		b.state = breakerClosed
+		return
+	}
+	b.errors++
+	b.failures++
+	b.lastError = err.Error()
+	recordError(b.name, err)
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *breaker) retried() {
+	b.mu.Lock()
+	b.retries++
+	b.mu.Unlock()
+}
+
+type breakerSnapshot struct {
+	State     breakerState `json:"state"`
+	Failures  int          `json:"consecutive_failures"`
+	Calls     uint64       `json:"calls"`
+	Errors    uint64       `json:"errors"`
+	Retries   uint64       `json:"retries"`
+	Rejected  uint64       `json:"rejected"`
+	LastError string       `json:"last_error,omitempty"`
+	MeanMs    float64      `json:"mean_latency_ms"`
+	LastMs    float64      `json:"last_latency_ms"`
+}
+
+func (b *breaker) snapshot() breakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	if state == breakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		state = breakerHalfOpen
+	}
+	var mean float64
+	if b.calls > 0 {
+		mean = float64(b.latency.Microseconds()) / float64(b.calls) / 1000
+	}
+	return breakerSnapshot{state, b.failures, b.calls, b.errors, b.retries, b.rejected, b.lastError, mean, float64(b.lastLat.Microseconds()) / 1000}
+}
+
+// withRetry calls fn through the upstream's breaker, retrying retryable
+// failures up to RETRY_MAX times with full-jitter exponential backoff
+// between RETRY_BASE_MS and RETRY_MAX_MS.
+func withRetry(ctx context.Context, upstream string, retryable func(error) bool, fn func() error) error {
+	b := breakers()[upstream]
+	maxRetries := envInt("RETRY_MAX", 3)
+	base := time.Duration(envInt("RETRY_BASE_MS", 200)) * time.Millisecond
+	ceiling := time.Duration(envInt("RETRY_MAX_MS", 5000)) * time.Millisecond
+
+	for attempt := 0; ; attempt++ {
+		if !b.allow() {
+			return fmt.Errorf("%s: %w", upstream, errBreakerOpen)
+		}
+		start := time.Now()
+		err := fn()
+		failed := err != nil && retryable(err) && ctx.Err() == nil
+		b.record(err, failed, time.Since(start))
+		if !failed || attempt >= maxRetries {
+			return err
+		}
+
+		b.retried()
+		backoff := min(base<<attempt, ceiling)
+		select {
+		case <-time.After(rand.N(backoff) + time.Millisecond):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func retryableGRPC(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	}
+	return false
+}
+
+// resilientTransport applies retries and the openai breaker to HTTP requests.
+// Network errors, 429 and 5xx responses are retried; once retries run out the
+// last response is handed back so go-openai can report the API's error.
+type resilientTransport struct {
+	base http.RoundTripper
+}
+
+// errRetryableStatus carries a retryable HTTP status out of fn so withRetry
+// can see it; the final response is still returned to the caller.
+type errRetryableStatus struct{ code int }
+
+func (e errRetryableStatus) Error() string { return fmt.Sprintf("HTTP %d", e.code) }
+
+func (t resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	first := true
+	err := withRetry(req.Context(), "openai", retryableHTTP, func() error {
+		attempt := req
+		if !first && req.Body != nil {
+			if req.GetBody == nil {
+				return errors.New("request body cannot be replayed")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			attempt = req.Clone(req.Context())
+			attempt.Body = body
+		}
+		first = false
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		var err error
+		resp, err = t.base.RoundTrip(attempt)
+		if err != nil {
+			resp = nil
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return errRetryableStatus{resp.StatusCode}
+		}
+		return nil
+	})
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+func retryableHTTP(err error) bool {
+	var status errRetryableStatus
+	var netErr net.Error
+	return errors.As(err, &status) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// handleHealthz reports breaker state per upstream; any open breaker makes
+// the service "degraded".
+func handleHealthz(c *gin.Context) {
+	upstreams := gin.H{}
+	overall := "ok"
+	for name, b := range breakers() {
+		snap := b.snapshot()
+		if snap.State != breakerClosed {
+			overall = "degraded"
+		}
+		upstreams[name] = snap
+	}
+	c.JSON(http.StatusOK, gin.H{"status": overall, "upstreams": upstreams})
+}
+
+// handleMetrics exposes counters in the Prometheus text format.
+func handleMetrics(c *gin.Context) {
+	var b strings.Builder
+	names := make([]string, 0, len(breakers()))
+	for name := range breakers() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("# TYPE docuchat_upstream_breaker_open gauge\n")
+	for _, name := range names {
+		open := 0
+		if breakers()[name].snapshot().State != breakerClosed {
+			open = 1
+		}
+		fmt.Fprintf(&b, "docuchat_upstream_breaker_open{upstream=%q} %d\n", name, open)
+	}
+	fmt.Fprintf(&b, "# TYPE docuchat_llm_active_requests gauge\ndocuchat_llm_active_requests %d\n", llmQueue().active())
+	fmt.Fprintf(&b, "# TYPE docuchat_llm_queued_requests gauge\ndocuchat_llm_queued_requests %d\n", llmQueue().queued.Load())
+	fmt.Fprintf(&b, "# TYPE docuchat_llm_queue_rejected_total counter\ndocuchat_llm_queue_rejected_total %d\n", llmQueue().rejected.Load())
+	fmt.Fprintf(&b, "# TYPE docuchat_llm_queue_timeouts_total counter\ndocuchat_llm_queue_timeouts_total %d\n", llmQueue().timeouts.Load())
+	counters := []struct {
+		metric string
+		value  func(breakerSnapshot) uint64
+	}{
+		{"docuchat_upstream_calls_total", func(s breakerSnapshot) uint64 { return s.Calls }},
+		{"docuchat_upstream_errors_total", func(s breakerSnapshot) uint64 { return s.Errors }},
+		{"docuchat_upstream_retries_total", func(s breakerSnapshot) uint64 { return s.Retries }},
+		{"docuchat_upstream_rejected_total", func(s breakerSnapshot) uint64 { return s.Rejected }},
+	}
+	for _, ctr := range counters {
+		fmt.Fprintf(&b, "# TYPE %s counter\n", ctr.metric)
+		for _, name := range names {
+			fmt.Fprintf(&b, "%s{upstream=%q} %d\n", ctr.metric, name, ctr.value(breakers()[name].snapshot()))
+		}
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4", []byte(b.String()))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai"
+)
+
+// embedText returns the embedding for a single piece of text.
+func embedText(ctx context.Context, text string) ([]float32, error) {
+	model := currentEmbedding()
+	resp, err := aiClient.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input:      []string{text},
+		Model:      openai.EmbeddingModel(model.Model),
+		Dimensions: model.Dimensions,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Data[0].Embedding, nil
+}
+
+// searchChunks runs a vector search against the collection, optionally
+// narrowed by a payload filter. Disabled chunks never match.
+func searchChunks(ctx context.Context, vector []float32, limit uint64, filter *pb.Filter) ([]*pb.ScoredPoint, error) {
+	return searchCollection(ctx, collectionName, vector, limit, filter)
+}
+
+func searchCollection(ctx context.Context, collection string, vector []float32, limit uint64, filter *pb.Filter) ([]*pb.ScoredPoint, error) {
+	res, err := qdrantClient.Search(ctx, &pb.SearchPoints{
+		CollectionName: collection,
+		Vector:         vector,
+		Limit:          limit,
+		Filter:         andFilters(filter, enabledFilter),
+		WithPayload:    pb.NewWithPayload(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Result, nil
+}
+
+// enabledFilter leaves out chunks disabled with PATCH /chunks/:id.
+var enabledFilter = &pb.Filter{MustNot: []*pb.Condition{pb.NewMatchBool("disabled", true)}}
+
+// documentFilter restricts a search to the points of one document.
+func documentFilter(documentID string) *pb.Filter {
+	return &pb.Filter{Must: []*pb.Condition{pb.NewMatch("document_id", documentID)}}
+}
+
+// documentsFilter restricts a search to the given documents; nil for all.
+func documentsFilter(ids []string) *pb.Filter {
+	if len(ids) == 0 {
+		return nil
+	}
+	return &pb.Filter{Must: []*pb.Condition{pb.NewMatchKeywords("document_id", ids...)}}
+}
+
+// workspacesFilter restricts a search to documents from the given
+// workspaces; nil for all.
+func workspacesFilter(workspaces []string) *pb.Filter {
+	if len(workspaces) == 0 {
+		return nil
+	}
+	return &pb.Filter{Must: []*pb.Condition{pb.NewMatchKeywords("workspace", workspaces...)}}
+}
+
+// excludeFilter leaves out the given documents, along with files embedded in
+// them, and any chunk carrying one of the given tags; nil if there is nothing
+// to exclude.
+func excludeFilter(documentIDs, tags []string) *pb.Filter {
+	f := &pb.Filter{}
+	if len(documentIDs) > 0 {
+		f.MustNot = append(f.MustNot, pb.NewMatchKeywords("document_id", documentIDs...), pb.NewMatchKeywords("parent_id", documentIDs...))
+	}
+	if len(tags) > 0 {
+		f.MustNot = append(f.MustNot, pb.NewMatchKeywords("tags", tags...))
+	}
+	if len(f.MustNot) == 0 {
+		return nil
+	}
+	return f
+}
+
+// excluded reports whether excludeFilter would leave out a point, for points
+// fetched by ID rather than searched.
+func excluded(payload map[string]*pb.Value, documentIDs, tags []string) bool {
+	if slices.Contains(documentIDs, payloadString(payload, "document_id")) || slices.Contains(documentIDs, payloadString(payload, "parent_id")) {
+		return true
+	}
+	for _, t := range payload["tags"].GetListValue().GetValues() {
+		if slices.Contains(tags, t.GetStringValue()) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibleTo reports whether user may see the document a chunk comes from:
+// one nobody owns, or their own.
+func visibleTo(user string) func(map[string]*pb.Value) bool {
+	visible := documentVisibleTo(user)
+	return func(payload map[string]*pb.Value) bool { return visible(payloadString(payload, "document_id")) }
+}
+
+// documentVisibleTo reports whether user may see a document. Each document
+// is looked up once per returned func.
+func documentVisibleTo(user string) func(documentID string) bool {
+	var mu sync.Mutex
+	visible := map[string]bool{}
+	return func(id string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		seen, ok := visible[id]
+		if !ok {
+			var doc documentRecord
+			found, _ := metaStore.Get("documents", id, &doc)
+			seen = found && (doc.Owner == "" || doc.Owner == user)
+			visible[id] = seen
+		}
+		return seen
+	}
+}
+
+// andFilters combines filters so a point must match all of them. Nil
+// filters are skipped.
+func andFilters(filters ...*pb.Filter) *pb.Filter {
+	var out *pb.Filter
+	for _, f := range filters {
+		if f == nil {
+			continue
+		}
+		if out == nil {
+			out = &pb.Filter{}
+		}
+		out.Must = append(out.Must, f.Must...)
+		out.Should = append(out.Should, f.Should...)
+		out.MustNot = append(out.MustNot, f.MustNot...)
+	}
+	return out
+}
+
+// payloadString reads a string field from a point payload, decrypting it if it
+// was sealed at rest, or "" if absent.
+func payloadString(payload map[string]*pb.Value, key string) string {
+	if v, ok := payload[key]; ok {
+		return openText(v.GetStringValue())
+	}
+	return ""
+}
+
+// getPoints fetches points by ID along with their payloads, for retrieval:
+// disabled chunks are left out.
+func getPoints(ctx context.Context, ids []string) ([]*pb.RetrievedPoint, error) {
+	pointIDs := make([]*pb.PointId, len(ids))
+	for i, id := range ids {
+		pointIDs[i] = pb.NewIDUUID(id)
+	}
+	res, err := qdrantClient.Get(ctx, &pb.GetPoints{
+		CollectionName: collectionName,
+		Ids:            pointIDs,
+		WithPayload:    pb.NewWithPayload(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	points := res.Result[:0]
+	for _, p := range res.Result {
+		if !p.Payload["disabled"].GetBoolValue() {
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+// scrollPoints pages through every point matching filter, a page at a time.
+func scrollPoints(ctx context.Context, filter *pb.Filter, withPayload bool, fn func([]*pb.RetrievedPoint) error) error {
+	return scrollCollection(ctx, collectionName, filter, withPayload, fn)
+}
+
+func scrollCollection(ctx context.Context, collection string, filter *pb.Filter, withPayload bool, fn func([]*pb.RetrievedPoint) error) error {
+	limit := uint32(256)
+	var offset *pb.PointId
+	for {
+		res, err := qdrantClient.Scroll(ctx, &pb.ScrollPoints{
+			CollectionName: collection,
+			Filter:         filter,
+			Limit:          &limit,
+			Offset:         offset,
+			WithPayload:    pb.NewWithPayload(withPayload),
+		})
+		if err != nil {
+			return err
+		}
+		if err := fn(res.GetResult()); err != nil {
+			return err
+		}
+		if offset = res.GetNextPageOffset(); offset == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// handleRetrieve runs retrieval alone, for orchestration frameworks that
+// bring their own model: POST /v1/retrieve {"query", "top_k", "workspace",
+// "score_threshold", "filters"} returns the best chunks as LangChain
+// documents, page_content and metadata. A LangChain RemoteLangChainRetriever
+// works against it with input_key "query" and response_key "documents".
+// Chunks of other users' documents, duplicates and superseded versions are
+// left out, as in chat.
+func handleRetrieve(c *gin.Context) {
+	var body api.RetrieveRequest
+	if err := c.BindJSON(&body); err != nil || strings.TrimSpace(body.Query) == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "A query is required"))
+		return
+	}
+	body.Workspace = cmp.Or(body.Workspace, "default")
+	limit := cmp.Or(body.TopK, 4)
+	if limit < 1 || limit > 50 {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "top_k must be between 1 and 50"))
+		return
+	}
+	f := cmp.Or(body.Filters, &api.RetrieveFilter{})
+	after, err := parseDateBound(f.After, true)
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
+		return
+	}
+	before, err := parseDateBound(f.Before, false)
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
+		return
+	}
+	var tags *pb.Filter
+	if t := parseTags(strings.Join(f.Tags, ",")); len(t) > 0 {
+		tags = &pb.Filter{Must: []*pb.Condition{pb.NewMatchKeywords("tags", t...)}}
+	}
+
+	ctx := c.Request.Context()
+	vector, err := embedText(ctx, body.Query)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Embedding Error: %v", err)))
+		return
+	}
+	filter := andFilters(workspacesFilter([]string{body.Workspace}), documentsFilter(f.DocumentIDs), tags, languageFilter(f.Language),
+		dateFilter(after, before), validAtFilter(time.Now()), duplicatesFilter(f.DocumentIDs), fieldsFilter(f.Fields))
+	// room for chunks of documents the caller can't see
+	results, err := searchChunks(ctx, vector, uint64(limit*2)*languageOversample(), filter)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+
+	visible := visibleTo(requestUser(c))
+	var hits []*pb.ScoredPoint
+	for _, hit := range results {
+		if visible(hit.Payload) && hit.Score >= body.ScoreThreshold {
+			hits = append(hits, hit)
+		}
+	}
+	hits = boostLanguage(hits, detectLanguage(body.Query), limit)
+
+	docs := []api.RetrievedDocument{}
+	for _, hit := range hits {
+		p := hit.Payload
+		meta := map[string]any{
+			"chunk_id":    hit.Id.GetUuid(),
+			"document_id": payloadString(p, "document_id"),
+			"filename":    payloadString(p, "filename"),
+			"chunk_index": p["chunk_index"].GetIntegerValue(),
+			"page":        p["page"].GetIntegerValue(),
+			"workspace":   payloadString(p, "workspace"),
+			"language":    payloadString(p, "language"),
+			"score":       hit.Score,
+		}
+		for _, key := range []string{"title", "source", "url", "parent_id"} {
+			if v := payloadString(p, key); v != "" {
+				meta[key] = v
+			}
+		}
+		if t := p["tags"].GetListValue().GetValues(); len(t) > 0 {
+			names := make([]string, len(t))
+			for i, v := range t {
+				names[i] = v.GetStringValue()
+			}
+			meta["tags"] = names
+		}
+		if fields := p["fields"]; fields != nil {
+			meta["fields"] = payloadValue(fields)
+		}
+		if d := p["doc_date"]; d != nil {
+			meta["date"] = time.Unix(d.GetIntegerValue(), 0).UTC().Format(time.DateOnly)
+		}
+		docs = append(docs, api.RetrievedDocument{
+			ID:          hit.Id.GetUuid(),
+			PageContent: payloadString(p, "text"),
+			Metadata:    meta,
+			Score:       hit.Score,
+		})
+	}
+	c.JSON(http.StatusOK, api.RetrieveResponse{Status: "success", Documents: docs})
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retrieverPlugin searches a source that isn't ingested, such as an internal
+// search index or a wiki's search API, at question time. Deployers add their
+// own by implementing it in a new file and calling registerRetriever from an
+// init function, as with tools.
+type retrieverPlugin interface {
+	Name() string
+	Retrieve(ctx context.Context, workspace, question string, limit int) ([]externalHit, error)
+}
+
+// externalHit is one result from a retriever.
+type externalHit struct {
+	Title string
+	URL   string
+	Text  string
+	Score float64
+}
+
+var retrieverRegistry = map[string]retrieverPlugin{}
+
+func registerRetriever(r retrieverPlugin) {
+	if _, dup := retrieverRegistry[r.Name()]; dup {
+		panic("retriever registered twice: " + r.Name())
+	}
+	retrieverRegistry[r.Name()] = r
+}
+
+// workspaceRetrievers returns the registered retrievers enabled for a
+// workspace: RETRIEVERS_<WORKSPACE> comma-separated ("*" for all), falling
+// back to RETRIEVERS.
+func workspaceRetrievers(workspace string) []retrieverPlugin {
+	names, ok := os.LookupEnv("RETRIEVERS_" + strings.ToUpper(workspace))
+	if !ok {
+		names = os.Getenv("RETRIEVERS")
+	}
+	var retrievers []retrieverPlugin
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" {
+			retrievers = retrievers[:0]
+			for _, r := range retrieverRegistry {
+				retrievers = append(retrievers, r)
+			}
+			return retrievers
+		}
+		if r, ok := retrieverRegistry[name]; ok {
+			retrievers = append(retrievers, r)
+		}
+	}
+	return retrievers
+}
+
+// externalContext asks the workspace's retrievers in parallel, each given
+// RETRIEVER_TIMEOUT_SECONDS, and returns their hits best first per retriever.
+// A retriever that fails is logged and left out.
+func externalContext(ctx context.Context, workspace, question string, limit int) map[string][]externalHit {
+	retrievers := workspaceRetrievers(workspace)
+	out := map[string][]externalHit{}
+	if len(retrievers) == 0 {
+		return out
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(envInt("RETRIEVER_TIMEOUT_SECONDS", 5))*time.Second)
+	defer cancel()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, r := range retrievers {
+		wg.Go(func() {
+			hits, err := r.Retrieve(ctx, workspace, question, limit)
+			if err != nil {
+				recordError("retriever", err)
+				log.Printf("❌ Retriever Error (%s): %v", r.Name(), err)
+				return
+			}
+			mu.Lock()
+			out[r.Name()] = hits[:min(limit, len(hits))]
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+	return out
+}
+
+// blendedItem is a piece of context with where it came from.
+type blendedItem struct {
+	text string
+	ref  retrievedChunk
+}
+
+// blendContext merges ranked lists into one with reciprocal rank fusion, so
+// scores on different scales (cosine similarity, BM25) never have to be
+// compared: an item's weight is the sum of 1/(60+rank) over the lists it's
+// in. It keeps the best limit.
+func blendContext(lists [][]blendedItem, limit int) []blendedItem {
+	const k = 60
+	weights := map[string]float64{}
+	var order []blendedItem
+	for _, list := range lists {
+		for rank, item := range list {
+			key := item.ref.ChunkID + "\x00" + item.text
+			if _, seen := weights[key]; !seen {
+				order = append(order, item)
+			}
+			weights[key] += 1 / float64(k+rank+1)
+		}
+	}
+	// stable, so ties keep the order of the lists
+	slices.SortStableFunc(order, func(a, b blendedItem) int {
+		return cmp.Compare(weights[b.ref.ChunkID+"\x00"+b.text], weights[a.ref.ChunkID+"\x00"+a.text])
+	})
+	return order[:min(limit, len(order))]
+}
+
+// externalItems turns a retriever's hits into context, citing them by URL.
+func externalItems(name string, hits []externalHit) []blendedItem {
+	items := make([]blendedItem, len(hits))
+	for i, h := range hits {
+		text := h.Text
+		if h.Title != "" || h.URL != "" {
+			text = "Source: " + strings.TrimSpace(h.Title+" "+h.URL) + "\n" + text
+		}
+		items[i] = blendedItem{text: text, ref: retrievedChunk{
+			ChunkID: h.URL,
+			Score:   float32(h.Score),
+			Source:  "external:" + name,
+			URL:     h.URL,
+			Title:   h.Title,
+		}}
+	}
+	return items
+}
+
+var retrieverClient = &http.Client{}
+
+func retrieverCall(req *http.Request) ([]byte, error) {
+	return clientCall(retrieverClient, req)
+}
+
+// clientCall sends req with client and reads the reply, at most
+// RESPONSE_MAX_MB (default 32) of it.
+func clientCall(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	limit := int64(envInt("RESPONSE_MAX_MB", 32)) << 20
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("response is larger than %d MB", limit>>20)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body[:min(len(body), 300)])))
+	}
+	return body, nil
+}
+
+// envWorkspace reads NAME_<WORKSPACE>, falling back to NAME.
+func envWorkspace(name, workspace string) string {
+	if v, ok := os.LookupEnv(name + "_" + strings.ToUpper(workspace)); ok {
+		return v
+	}
+	return os.Getenv(name)
+}
+
+// elasticsearchRetriever runs a multi_match query against
+// ELASTICSEARCH_URL/ELASTICSEARCH_INDEX, either of which may be set per
+// workspace with a _<WORKSPACE> suffix. ELASTICSEARCH_FIELDS lists the fields
+// to search (default title,content); the hit text is the first of them
+// other than title that's present. ELASTICSEARCH_API_KEY authenticates.
+type elasticsearchRetriever struct{}
+
+func (elasticsearchRetriever) Name() string { return "elasticsearch" }
+
+func (elasticsearchRetriever) Retrieve(ctx context.Context, workspace, question string, limit int) ([]externalHit, error) {
+	base := strings.TrimRight(envWorkspace("ELASTICSEARCH_URL", workspace), "/")
+	index := envWorkspace("ELASTICSEARCH_INDEX", workspace)
+	if base == "" || index == "" {
+		return nil, fmt.Errorf("ELASTICSEARCH_URL and ELASTICSEARCH_INDEX must be set")
+	}
+	fields := strings.Split(cmp.Or(os.Getenv("ELASTICSEARCH_FIELDS"), "title,content"), ",")
+	query, _ := json.Marshal(map[string]any{
+		"size":  limit,
+		"query": map[string]any{"multi_match": map[string]any{"query": question, "fields": fields}},
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/"+url.PathEscape(index)+"/_search", bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := envWorkspace("ELASTICSEARCH_API_KEY", workspace); key != "" {
+		req.Header.Set("Authorization", "ApiKey "+key)
+	}
+	body, err := retrieverCall(req)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Hits struct {
+			Hits []struct {
+				ID     string         `json:"_id"`
+				Score  float64        `json:"_score"`
+				Source map[string]any `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	var hits []externalHit
+	for _, h := range res.Hits.Hits {
+		hit := externalHit{Score: h.Score, URL: index + "/" + h.ID}
+		hit.Title, _ = h.Source["title"].(string)
+		if u, ok := h.Source["url"].(string); ok {
+			hit.URL = u
+		}
+		for _, f := range fields {
+			// fields may carry a boost, as in content^2
+			name, _, _ := strings.Cut(strings.TrimSpace(f), "^")
+			if s, ok := h.Source[name].(string); ok && name != "title" {
+				hit.Text = s
+				break
+			}
+		}
+		if hit.Text != "" {
+			hits = append(hits, hit)
+		}
+	}
+	return hits, nil
+}
+
+// searchAPIRetriever calls a generic search endpoint, SEARCH_API_URL
+// (settable per workspace like the Elasticsearch ones), as
+// GET ?q=<question>&limit=<n> with SEARCH_API_KEY as a bearer token. It
+// expects {"results":[{"title","url","text","score"}]}, which is easy to put
+// in front of most wiki and intranet search APIs.
+type searchAPIRetriever struct{}
+
+func (searchAPIRetriever) Name() string { return "search_api" }
+
+func (searchAPIRetriever) Retrieve(ctx context.Context, workspace, question string, limit int) ([]externalHit, error) {
+	endpoint := envWorkspace("SEARCH_API_URL", workspace)
+	if endpoint == "" {
+		return nil, fmt.Errorf("SEARCH_API_URL must be set")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("q", question)
+	q.Set("limit", fmt.Sprint(limit))
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if key := envWorkspace("SEARCH_API_KEY", workspace); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	body, err := retrieverCall(req)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Results []struct {
+			Title string  `json:"title"`
+			URL   string  `json:"url"`
+			Text  string  `json:"text"`
+			Score float64 `json:"score"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	hits := make([]externalHit, 0, len(res.Results))
+	for _, r := range res.Results {
+		if r.Text != "" {
+			hits = append(hits, externalHit{Title: r.Title, URL: r.URL, Text: r.Text, Score: r.Score})
+		}
+	}
+	return hits, nil
+}
+
+func init() {
+	registerRetriever(elasticsearchRetriever{})
+	registerRetriever(searchAPIRetriever{})
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	scanMapPrompt    = "You read part of a document to help answer a question that needs the whole document, such as a request to list every item of some kind. From the numbered excerpts below, note every piece of information that helps answer the question, each as a short self-contained statement with the excerpt numbers it comes from. Leave nothing relevant out and invent nothing; return no findings if the excerpts have nothing relevant."
+	scanMergePrompt  = "You merge notes taken from different parts of a document while answering a question. Combine the numbered notes below into one list: merge notes that say the same thing, keep every distinct item, and keep the note numbers each merged note comes from."
+	scanReducePrompt = "Answer the question from the notes below, which were taken from every part of the documents in turn. The notes are complete: include every relevant item they contain, merge duplicates, and say so plainly if they don't answer the question. Cite the note numbers you use like [1]."
+)
+
+// scanFinding is a note taken from part of a document, with the chunks it
+// came from.
+type scanFinding struct {
+	Text   string           `json:"text"`
+	Chunks []retrievedChunk `json:"chunks"`
+}
+
+// findingsSchema is the response schema for a list of findings citing
+// numbered inputs.
+var findingsSchema, _ = json.Marshal(map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"findings": map[string]any{"type": "array", "items": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"text":    map[string]any{"type": "string"},
+				"sources": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+			},
+			"required":             []string{"text", "sources"},
+			"additionalProperties": false,
+		}},
+	},
+	"required":             []string{"findings"},
+	"additionalProperties": false,
+})
+
+type rawFinding struct {
+	Text    string `json:"text"`
+	Sources []int  `json:"sources"`
+}
+
+func completeFindings(ctx context.Context, prompt string) ([]rawFinding, error) {
+	raw, err := completeStructured(ctx, prompt, "scan_findings", findingsSchema)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Findings []rawFinding `json:"findings"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return nil, err
+	}
+	return out.Findings, nil
+}
+
+// runScan answers a question by reading every chunk of the given documents
+// rather than the top few matches: batches of SCAN_BATCH_CHUNKS (default 20)
+// chunks are read SCAN_CONCURRENCY (default 4) at a time for findings, which
+// are merged in groups while there are more than SCAN_REDUCE_FINDINGS
+// (default 200) of them and then turned into the answer. Documents with more
+// than SCAN_MAX_CHUNKS (default 2000) chunks in all are refused. It returns
+// the answer, whose [n] citations number the final findings, and the
+// findings.
+func runScan(ctx context.Context, question, lang string, documentIDs []string, skip func(map[string]*pb.Value) bool) (string, []scanFinding, error) {
+	var points []*pb.RetrievedPoint
+	err := scrollPoints(ctx, andFilters(documentsFilter(documentIDs), enabledFilter), true, func(batch []*pb.RetrievedPoint) error {
+		for _, p := range batch {
+			if !skip(p.Payload) {
+				points = append(points, p)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if limit := envInt("SCAN_MAX_CHUNKS", 2000); len(points) > limit {
+		return "", nil, fmt.Errorf("the documents have %d chunks, more than the %d a scan reads", len(points), limit)
+	}
+	if len(points) == 0 {
+		return "", nil, fmt.Errorf("no indexed text for these documents")
+	}
+	order := map[string]int{}
+	for i, id := range documentIDs {
+		order[id] = i
+	}
+	sort.Slice(points, func(i, j int) bool {
+		di, dj := order[payloadString(points[i].Payload, "document_id")], order[payloadString(points[j].Payload, "document_id")]
+		if di != dj {
+			return di < dj
+		}
+		return points[i].Payload["chunk_index"].GetIntegerValue() < points[j].Payload["chunk_index"].GetIntegerValue()
+	})
+
+	// MAP: findings from each batch, in document order
+	size := max(envInt("SCAN_BATCH_CHUNKS", 20), 1)
+	batches := make([][]scanFinding, (len(points)+size-1)/size)
+	errs := make([]error, len(batches))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(envInt("SCAN_CONCURRENCY", 4), 1))
+	for b := range batches {
+		wg.Go(func() {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			part := points[b*size : min((b+1)*size, len(points))]
+			var excerpts strings.Builder
+			for i, p := range part {
+				fmt.Fprintf(&excerpts, "[%d] (%s, page %d) %s\n\n", i+1, payloadString(p.Payload, "filename"), p.Payload["page"].GetIntegerValue(), payloadString(p.Payload, "text"))
+			}
+			found, err := completeFindings(ctx, scanMapPrompt+"\n\nQuestion: "+question+"\n\nExcerpts:\n"+excerpts.String())
+			if err != nil {
+				errs[b] = err
+				return
+			}
+			for _, f := range found {
+				sf := scanFinding{Text: strings.TrimSpace(f.Text)}
+				for _, n := range f.Sources {
+					if n >= 1 && n <= len(part) {
+						sf.Chunks = append(sf.Chunks, chunkRef(part[n-1].GetId(), part[n-1].Payload, 0, "scan"))
+					}
+				}
+				if sf.Text != "" {
+					batches[b] = append(batches[b], sf)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	var findings []scanFinding
+	for b, found := range batches {
+		if errs[b] != nil {
+			return "", nil, errs[b]
+		}
+		findings = append(findings, found...)
+	}
+
+	// REDUCE: merge groups of findings until they fit one prompt
+	limit := max(envInt("SCAN_REDUCE_FINDINGS", 200), 2)
+	for len(findings) > limit {
+		var merged []scanFinding
+		for start := 0; start < len(findings); start += limit {
+			group := findings[start:min(start+limit, len(findings))]
+			out, err := completeFindings(ctx, scanMergePrompt+"\n\nQuestion: "+question+"\n\nNotes:\n"+numberedFindings(group))
+			if err != nil {
+				return "", nil, err
+			}
+			for _, f := range out {
+				sf := scanFinding{Text: strings.TrimSpace(f.Text)}
+				for _, n := range f.Sources {
+					if n >= 1 && n <= len(group) {
+						sf.Chunks = append(sf.Chunks, group[n-1].Chunks...)
+					}
+				}
+				if sf.Text != "" {
+					merged = append(merged, sf)
+				}
+			}
+		}
+		if len(merged) >= len(findings) {
+			break // merging no longer shrinks them
+		}
+		findings = merged
+	}
+
+	notes := numberedFindings(findings)
+	if len(findings) == 0 {
+		notes = "(none: nothing in the documents bears on the question)\n"
+	}
+	resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: chatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: scanReducePrompt + languageInstruction(lang) + "\n\nNotes:\n" + notes + "\nQuestion: " + question},
+		},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(resp.Choices) == 0 {
+		return "", nil, errors.New("the model returned no answer")
+	}
+	return resp.Choices[0].Message.Content, findings, nil
+}
+
+// findingSources are the distinct chunks behind the findings.
+func findingSources(findings []scanFinding) []retrievedChunk {
+	var sources []retrievedChunk
+	seen := map[string]bool{}
+	for _, f := range findings {
+		for _, ch := range f.Chunks {
+			if !seen[ch.ChunkID] {
+				seen[ch.ChunkID] = true
+				sources = append(sources, ch)
+			}
+		}
+	}
+	return sources
+}
+
+func numberedFindings(findings []scanFinding) string {
+	var b strings.Builder
+	for i, f := range findings {
+		fmt.Fprintf(&b, "[%d] %s\n", i+1, f.Text)
+	}
+	return b.String()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+)
+
+// outOfScopeInstruction tells the model what to do when the context doesn't
+// answer the question. OUT_OF_SCOPE picks the behaviour, per workspace with
+// OUT_OF_SCOPE_<WORKSPACE>:
+//
+//   - refuse: say the documents don't cover it, and nothing else
+//   - general: answer from general knowledge behind a disclaimer
+//   - redirect: point the user elsewhere
+//
+// OUT_OF_SCOPE_MESSAGE (also per workspace) replaces the refusal, disclaimer
+// or redirect text. The model is told to give it in the answer language, so
+// one English setting serves every language. Unset, the persona's own line is
+// used, or a plain "not covered" for workspaces with their own persona.
+func outOfScopeInstruction(workspace string) string {
+	message := envWorkspace("OUT_OF_SCOPE_MESSAGE", workspace)
+	in := " If the answer isn't in the context, "
+	switch strings.ToLower(envWorkspace("OUT_OF_SCOPE", workspace)) {
+	case "refuse":
+		message = cmp.Or(message, "I couldn't find that in the documents.")
+		return in + fmt.Sprintf("reply only with this message, in the language you answer in: %q Don't guess or use outside knowledge.", message)
+	case "general":
+		message = cmp.Or(message, "This isn't covered by the documents, so this answer is from general knowledge:")
+		return in + fmt.Sprintf("answer from general knowledge, starting with this disclaimer in the language you answer in: %q", message)
+	case "redirect":
+		message = cmp.Or(message, "I can only help with questions about the documents. For anything else, please contact the team directly.")
+		return in + fmt.Sprintf("reply only with this message, in the language you answer in: %q", message)
+	}
+	if message != "" {
+		return in + fmt.Sprintf("say, in the language you answer in: %q", message)
+	}
+	if _, ok := loadPersona(workspace); ok {
+		return in + "say that the documents don't cover it."
+	}
+	return in + "say 'I don't have that detail handy, but George is a fast learner!'"
+}
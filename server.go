@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve runs the HTTP server, terminating TLS itself when configured:
+//
+//   - TLS_CERT_FILE and TLS_KEY_FILE serve a certificate from disk;
+//   - TLS_AUTOCERT_DOMAINS (comma-separated) gets certificates from Let's
+//     Encrypt, cached in TLS_AUTOCERT_CACHE;
+//   - TLS_CLIENT_CA_FILE additionally requires clients to present a
+//     certificate signed by that CA (mTLS), or only verifies one if sent when
+//     TLS_CLIENT_AUTH=optional.
+//
+// With none of these set it speaks plain HTTP, as before.
+func serve(r *gin.Engine, port string) error {
+	cfg, err := serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: ":" + port, Handler: r, TLSConfig: cfg}
+	if cfg == nil {
+		log.Println("🚀 Server running on port " + port)
+		return srv.ListenAndServe()
+	}
+	log.Println("🔒 Server running with TLS on port " + port)
+	return srv.ListenAndServeTLS("", "")
+}
+
+func serverTLSConfig() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	domains := os.Getenv("TLS_AUTOCERT_DOMAINS")
+
+	var cfg *tls.Config
+	switch {
+	case certFile != "" || keyFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		cfg = &tls.Config{Certificates: []tls.Certificate{cert}}
+	case domains != "":
+		cache := os.Getenv("TLS_AUTOCERT_CACHE")
+		if cache == "" {
+			cache = "certs"
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(domains, ",")...),
+			Cache:      autocert.DirCache(cache),
+		}
+		cfg = m.TLSConfig()
+	}
+
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if caFile == "" {
+		return cfg, nil
+	}
+	if cfg == nil {
+		return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE/TLS_KEY_FILE or TLS_AUTOCERT_DOMAINS")
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if os.Getenv("TLS_CLIENT_AUTH") == "optional" {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// newRouter is the gin engine for LOG_LEVEL: debug logs routes and every
+// request, info (the default) every request, and warn or error only
+// failures and panics. Every request is given an ID to report errors with.
+func newRouter() *gin.Engine {
+	level := os.Getenv("LOG_LEVEL")
+	if level != "debug" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := gin.New()
+	if level == "warn" || level == "error" {
+		r.Use(requestID, gin.Recovery())
+		return r
+	}
+	r.Use(requestID, gin.Logger(), gin.Recovery())
+	return r
+}
+
+// corsOrigins sets the allowed origins from CORS_ORIGINS, comma-separated;
+// unset or "*" allows all.
+func corsOrigins(config *cors.Config) {
+	origins := strings.TrimSpace(os.Getenv("CORS_ORIGINS"))
+	if origins == "" || origins == "*" {
+		config.AllowAllOrigins = true
+		return
+	}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			config.AllowOrigins = append(config.AllowOrigins, o)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sashabaranov/go-openai"
+)
+
+// session is a conversation: the chat turns asked in it, oldest first.
+type session struct {
+	ID        string   `json:"id"`
+	Workspace string   `json:"workspace"`
+	Owner     string   `json:"owner,omitempty"`
+	Title     string   `json:"title"`
+	Documents []string `json:"documents,omitempty"` // scope; empty for all
+	Messages  []string `json:"messages"`            // chat record IDs
+	// Summary condenses the first Summarized messages, which are no longer
+	// replayed verbatim.
+	Summary    string    `json:"summary,omitempty"`
+	Summarized int       `json:"summarized,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// answerVariant is one generated answer to a turn. Regenerating a turn adds
+// a variant rather than replacing the answer.
+type answerVariant struct {
+	Answer      string   `json:"answer"`
+	Model       string   `json:"model"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	// SystemFingerprint is the provider backend that generated the answer.
+	SystemFingerprint string    `json:"system_fingerprint,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// chatFeedback is a user's verdict on a turn, including which variant won.
+type chatFeedback struct {
+	Variant   int       `json:"variant"`
+	Rating    string    `json:"rating"` // "up" or "down"
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// sessionsMu serialises read-modify-write updates of session records.
+var sessionsMu sync.Mutex
+
+const summaryPrompt = "Update the running summary of a conversation with the new exchanges below. Keep names, figures, decisions and open questions; drop small talk. Reply with the updated summary only, in at most 200 words."
+
+const titlePrompt = "Write a title of at most six words for a conversation that starts with the exchange below. Reply with the title only, no quotes."
+
+// openSession loads the session a chat belongs to, starting a new one scoped
+// to documents when no ID is given.
+func openSession(c *gin.Context, id, workspace string, documents []string) (session, error) {
+	if id == "" {
+		now := time.Now()
+		return session{ID: uuid.New().String(), Workspace: workspace, Owner: requestUser(c), Documents: documents, Messages: []string{}, CreatedAt: now, UpdatedAt: now}, nil
+	}
+	var s session
+	found, err := metaStore.Get("sessions", id, &s)
+	if err != nil {
+		return s, err
+	}
+	if !found || s.Owner != requestUser(c) {
+		return s, fmt.Errorf("session %s not found", id)
+	}
+	return s, nil
+}
+
+// sessionHistory replays the turns of a session that haven't been
+// summarized, at most the last SESSION_HISTORY_TURNS, as chat messages. When
+// older turns have been summarized the summary goes first, as a system
+// message.
+func sessionHistory(s session) []openai.ChatCompletionMessage {
+	var msgs []openai.ChatCompletionMessage
+	if s.Summary != "" {
+		msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: "Summary of the conversation so far: " + s.Summary})
+	}
+	ids := s.Messages[min(s.Summarized, len(s.Messages)):]
+	if n := envInt("SESSION_HISTORY_TURNS", 5); len(ids) > n {
+		ids = ids[len(ids)-n:]
+	}
+	for _, rec := range sessionTurns(ids) {
+		if rec.ID == "" {
+			continue
+		}
+		msgs = append(msgs,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: rec.Question},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: rec.Answer},
+		)
+	}
+	return msgs
+}
+
+// sessionTurns loads the chat records of ids; a missing one is left zero.
+func sessionTurns(ids []string) []chatRecord {
+	turns := make([]chatRecord, len(ids))
+	for i, id := range ids {
+		metaStore.Get("chats", id, &turns[i])
+	}
+	return turns
+}
+
+// summarizeSession folds older turns into the session's rolling summary once
+// the unsummarized ones exceed SESSION_HISTORY_TURNS or
+// SESSION_HISTORY_TOKENS (default 4000), keeping the newest turns verbatim.
+func summarizeSession(id string) {
+	sessionsMu.Lock()
+	var s session
+	found, _ := metaStore.Get("sessions", id, &s)
+	sessionsMu.Unlock()
+	if !found {
+		return
+	}
+
+	pending := sessionTurns(s.Messages[min(s.Summarized, len(s.Messages)):])
+	maxTurns, maxTokens := envInt("SESSION_HISTORY_TURNS", 5), envInt("SESSION_HISTORY_TOKENS", 4000)
+	keep, tokens := 0, 0
+	for i := len(pending) - 1; i >= 0 && keep < maxTurns; i-- {
+		n := countTokens(chatModel, pending[i].Question) + countTokens(chatModel, pending[i].Answer)
+		if keep > 0 && tokens+n > maxTokens {
+			break
+		}
+		tokens += n
+		keep++
+	}
+	fold := pending[:len(pending)-keep]
+	if len(fold) == 0 {
+		return
+	}
+
+	var exchanges strings.Builder
+	for _, rec := range fold {
+		fmt.Fprintf(&exchanges, "User: %s\nAssistant: %s\n\n", rec.Question, rec.Answer)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: chatModel,
+		Messages: []openai.ChatCompletionMessage{{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf("%s\n\nRunning summary: %s\n\nNew exchanges:\n%s", summaryPrompt, s.Summary, exchanges.String()),
+		}},
+	})
+	if err != nil || len(resp.Choices) == 0 {
+		log.Printf("❌ Session Summary Error: %v", err)
+		return
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	var latest session
+	if found, _ := metaStore.Get("sessions", id, &latest); !found || latest.Summarized != s.Summarized {
+		return // deleted, or summarized meanwhile
+	}
+	latest.Summary = strings.TrimSpace(resp.Choices[0].Message.Content)
+	latest.Summarized = s.Summarized + len(fold)
+	metaStore.Put("sessions", id, latest)
+}
+
+// addToSession appends a saved chat turn to its session, creating the
+// session record on its first turn.
+func addToSession(s session, chatID string) error {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	metaStore.Get("sessions", s.ID, &s)
+	s.Messages = append(s.Messages, chatID)
+	s.UpdatedAt = time.Now()
+	return metaStore.Put("sessions", s.ID, s)
+}
+
+// titleSession names a session after its first exchange.
+func titleSession(id string, first chatRecord) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: chatModel,
+		Messages: []openai.ChatCompletionMessage{{
+			Role:    openai.ChatMessageRoleUser,
+			Content: fmt.Sprintf("%s\n\nQuestion: %s\n\nAnswer: %s", titlePrompt, first.Question, snippet(first.Answer, 1000)),
+		}},
+		MaxTokens: 20,
+	})
+	title := snippet(first.Question, 60)
+	if err == nil && len(resp.Choices) > 0 {
+		if t := strings.Trim(strings.TrimSpace(resp.Choices[0].Message.Content), `"'.`); t != "" {
+			title = t
+		}
+	}
+
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	var s session
+	if found, _ := metaStore.Get("sessions", id, &s); found {
+		s.Title = title
+		metaStore.Put("sessions", id, s)
+	}
+}
+
+// handleListSessions lists the caller's conversations, most recent first:
+// GET /sessions?workspace=.
+func handleListSessions(c *gin.Context) {
+	all, err := metaStore.List("sessions")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	user, ws := requestUser(c), c.Query("workspace")
+	type listed struct {
+		ID        string    `json:"id"`
+		Title     string    `json:"title"`
+		Workspace string    `json:"workspace"`
+		Documents []string  `json:"documents"`
+		Messages  int       `json:"messages"`
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	sessions := []listed{}
+	for _, raw := range all {
+		var s session
+		if json.Unmarshal(raw, &s) != nil || s.Owner != user || (ws != "" && s.Workspace != ws) {
+			continue
+		}
+		docs := s.Documents
+		if docs == nil {
+			docs = []string{}
+		}
+		sessions = append(sessions, listed{s.ID, s.Title, s.Workspace, docs, len(s.Messages), s.CreatedAt, s.UpdatedAt})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt) })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "sessions": sessions})
+}
+
+// sessionMessage loads a turn, checking it belongs to the session.
+func sessionMessage(c *gin.Context, sessionID, messageID string) (session, chatRecord, error) {
+	var rec chatRecord
+	s, err := openSession(c, sessionID, "", nil)
+	if err != nil {
+		return s, rec, err
+	}
+	if found, err := metaStore.Get("chats", messageID, &rec); err != nil || !found || rec.SessionID != sessionID {
+		return s, rec, fmt.Errorf("message %s not found in session", messageID)
+	}
+	return s, rec, nil
+}
+
+// handleRegenerate re-runs the last turn of a session with a different
+// model, temperature or seed: POST /sessions/:id/messages/:mid/regenerate.
+// The new answer is added as a variant and becomes the one shown.
+func handleRegenerate(c *gin.Context) {
+	var body struct {
+		Model       string   `json:"model"`
+		Temperature *float32 `json:"temperature"`
+		Seed        *int     `json:"seed"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+			return
+		}
+	}
+	s, rec, err := sessionMessage(c, c.Param("id"), c.Param("mid"))
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeNotFound, err))
+		return
+	}
+	if s.Messages[len(s.Messages)-1] != rec.ID {
+		c.JSON(http.StatusOK, errorReply(c, codeConflict, "Only the last message of a session can be regenerated"))
+		return
+	}
+	if rec.Prompt == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeConflict, "This answer was not a plain chat reply and can't be regenerated"))
+		return
+	}
+
+	prior := s
+	prior.Messages = s.Messages[:len(s.Messages)-1]
+	history := sessionHistory(prior)
+	req := openai.ChatCompletionRequest{
+		Model:     rec.Model,
+		Messages:  append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: openText(rec.Prompt)}),
+		Seed:      body.Seed,
+		MaxTokens: answerTokenCap(rec.Length),
+	}
+	if body.Model != "" {
+		req.Model = body.Model
+	}
+	if req.Model == "" {
+		req.Model = chatModel
+	}
+	if body.Temperature != nil {
+		req.Temperature = providerTemperature(*body.Temperature)
+	}
+	resp, err := aiClient.CreateChatCompletion(context.Background(), req)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, fmt.Sprintf("❌ OpenAI Chat Error: %v", err)))
+		return
+	}
+	if len(resp.Choices) == 0 {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamOpenAI, "❌ OpenAI Chat Error: the model returned no answer"))
+		return
+	}
+
+	if len(rec.Variants) == 0 {
+		rec.Variants = []answerVariant{{Answer: rec.Answer, Model: rec.Model, Temperature: rec.Temperature, Seed: rec.Seed, SystemFingerprint: rec.SystemFingerprint, CreatedAt: rec.CreatedAt}}
+	}
+	rec.Variants = append(rec.Variants, answerVariant{Answer: filterAnswer(rec.Workspace, resp.Choices[0].Message.Content), Model: req.Model, Temperature: body.Temperature, Seed: body.Seed, SystemFingerprint: resp.SystemFingerprint, CreatedAt: time.Now()})
+	rec.Selected = len(rec.Variants) - 1
+	rec.Answer = rec.Variants[rec.Selected].Answer
+	saveChat(rec)
+	c.JSON(http.StatusOK, withReplyExtras(gin.H{"status": "success", "answer": formatAnswer(rec.Answer, rec.Format), "variant": rec.Selected, "variants": rec.Variants}, rec))
+}
+
+// handleFeedback records a rating for a turn and which of its variants the
+// user preferred, which also becomes the answer carried into the session's
+// history: POST /sessions/:id/messages/:mid/feedback.
+func handleFeedback(c *gin.Context) {
+	var body chatFeedback
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	_, rec, err := sessionMessage(c, c.Param("id"), c.Param("mid"))
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeNotFound, err))
+		return
+	}
+	if body.Rating != "" && body.Rating != "up" && body.Rating != "down" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, `rating must be "up" or "down"`))
+		return
+	}
+	if len(rec.Variants) > 0 {
+		if body.Variant < 0 || body.Variant >= len(rec.Variants) {
+			c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No such variant"))
+			return
+		}
+		rec.Selected = body.Variant
+		rec.Answer = rec.Variants[body.Variant].Answer
+	} else if body.Variant != 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No such variant"))
+		return
+	}
+	if body.Rating == "down" && (rec.Feedback == nil || rec.Feedback.Rating != "down") {
+		recordNegativeFeedback(rec.Sources)
+	}
+	body.CreatedAt = time.Now()
+	rec.Feedback = &body
+	saveChat(rec)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "message": "Feedback recorded"})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Shadow mode answers a sample of live chats a second time with a candidate
+// pipeline, such as a collection rechunked or re-embedded by a reindex with
+// "activate": false, or another chat model, and records how the two
+// differ. The candidate's answer is never served. SHADOW_SAMPLE_PERCENT
+// (default 0, off) picks the share of plain document chats to shadow, and
+// SHADOW_CONFIG the candidate as a JSON shadowConfig. At most
+// SHADOW_MAX_CONCURRENT (default 2) shadow runs go at once; chats sampled
+// beyond that aren't shadowed, so live traffic never waits on them.
+
+// shadowConfig is the candidate pipeline; unset fields match the live one.
+type shadowConfig struct {
+	Collection string `json:"collection,omitempty"` // collection or alias to search
+	Model      string `json:"model,omitempty"`      // chat model
+	TopK       int    `json:"top_k,omitempty"`      // chunks put into the context, default 3
+}
+
+func loadShadowConfig() (shadowConfig, bool) {
+	raw := os.Getenv("SHADOW_CONFIG")
+	if raw == "" {
+		return shadowConfig{}, false
+	}
+	var cfg shadowConfig
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		log.Printf("❌ Shadow Config Error: %v", err)
+		return shadowConfig{}, false
+	}
+	if cfg.Collection == "" {
+		cfg.Collection = collectionName
+	}
+	if cfg.Model == "" {
+		cfg.Model = chatModel
+	}
+	if cfg.TopK <= 0 {
+		cfg.TopK = 3
+	}
+	return cfg, true
+}
+
+// shadowInput is what a live chat hands its shadow run to rebuild the prompt
+// around the candidate's context.
+type shadowInput struct {
+	vector  []float32 // the question embedded for the serving collection, if it was
+	filter  *pb.Filter
+	history []openai.ChatCompletionMessage
+	prefix  string                      // persona and instructions, for the prompt budget
+	prompt  func(context string) string // frames the context and question as the live prompt does
+}
+
+// shadowSampled reports whether this chat should be shadowed.
+func shadowSampled() bool {
+	percent := envInt("SHADOW_SAMPLE_PERCENT", 0)
+	if percent <= 0 || os.Getenv("SHADOW_CONFIG") == "" {
+		return false
+	}
+	return rand.IntN(100) < percent
+}
+
+// shadowSlots is built on first use, once loadConfig has read the env file.
+var shadowSlots = sync.OnceValue(func() chan struct{} {
+	return make(chan struct{}, max(envInt("SHADOW_MAX_CONCURRENT", 2), 1))
+})
+
+// shadowAnswer is one pipeline's side of a comparison.
+type shadowAnswer struct {
+	Collection string           `json:"collection"`
+	Model      string           `json:"model"`
+	Answer     string           `json:"answer"`
+	Sources    []retrievedChunk `json:"sources"`
+}
+
+// shadowDiff summarises how the candidate differs from the live answer.
+// Overlaps are Jaccard indexes, 1 when identical; chunk IDs only line up when
+// both pipelines search the same chunks, so DocumentOverlap is the one to
+// read for a rechunked collection.
+type shadowDiff struct {
+	AnswerSimilarity float64  `json:"answer_similarity"` // over the answers' words
+	SourceOverlap    float64  `json:"source_overlap"`    // over chunk IDs
+	DocumentOverlap  float64  `json:"document_overlap"`  // over the documents cited
+	LengthDelta      int      `json:"length_delta"`      // candidate minus live, in words
+	OnlyLive         []string `json:"only_live,omitempty"`
+	OnlyCandidate    []string `json:"only_candidate,omitempty"`
+}
+
+// shadowRun is a stored comparison, in the "shadow" bucket by chat ID.
+type shadowRun struct {
+	ChatID    string       `json:"chat_id"`
+	Workspace string       `json:"workspace"`
+	Question  string       `json:"question"`
+	Live      shadowAnswer `json:"live"`
+	Candidate shadowAnswer `json:"candidate"`
+	Diff      shadowDiff   `json:"diff"`
+	Error     string       `json:"error,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// startShadow runs a sampled chat's shadow in the background once its live
+// answer is final.
+func startShadow(rec chatRecord) {
+	cfg, ok := loadShadowConfig()
+	if !ok {
+		return
+	}
+	select {
+	case shadowSlots() <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-shadowSlots() }()
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		run := runShadow(ctx, rec, cfg)
+		if err := metaStore.Put("shadow", run.ChatID, run); err != nil {
+			log.Printf("❌ Metadata Store Error: %v", err)
+		}
+	}()
+}
+
+func runShadow(ctx context.Context, rec chatRecord, cfg shadowConfig) shadowRun {
+	in := rec.shadow
+	run := shadowRun{
+		ChatID:    rec.ID,
+		Workspace: rec.Workspace,
+		Question:  rec.Question,
+		Live:      shadowAnswer{Collection: collectionName, Model: rec.Model, Answer: rec.Answer, Sources: rec.Sources},
+		Candidate: shadowAnswer{Collection: cfg.Collection, Model: cfg.Model, Sources: []retrievedChunk{}},
+		CreatedAt: time.Now(),
+	}
+	fail := func(err error) shadowRun {
+		run.Error = err.Error()
+		log.Printf("❌ Shadow Error: chat %s: %v", rec.ID, err)
+		return run
+	}
+
+	vector := in.vector
+	if model := collectionModel(cfg.Collection); vector == nil || model != currentEmbedding() {
+		vectors, err := embedTextsWith(ctx, model, []string{rec.Question})
+		if err != nil {
+			return fail(err)
+		}
+		vector = vectors[0]
+	}
+	hits, err := searchCollection(ctx, cfg.Collection, vector, uint64(cfg.TopK), in.filter)
+	if err != nil {
+		return fail(err)
+	}
+	var texts []string
+	for _, hit := range hits {
+		texts = append(texts, payloadString(hit.Payload, "text"))
+		ref := chunkRef(hit.Id, hit.Payload, hit.Score, "vector")
+		if cfg.Collection != collectionName {
+			ref.Collection = cfg.Collection
+		}
+		run.Candidate.Sources = append(run.Candidate.Sources, ref)
+	}
+	history, texts := newPromptBudget(cfg.Model).fit(in.prefix+rec.Question, in.history, texts)
+	resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:    cfg.Model,
+		Messages: append(history, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: in.prompt(strings.Join(texts, "\n\n---\n\n"))}),
+	})
+	if err != nil {
+		return fail(err)
+	}
+	if len(resp.Choices) == 0 {
+		return fail(errors.New("the model returned no answer"))
+	}
+	run.Candidate.Answer = filterAnswer(rec.Workspace, labelAnswer(rec, resp.Choices[0].Message.Content))
+	run.Diff = diffShadow(run.Live, run.Candidate)
+	log.Printf("👥 Shadow: chat %s, answer similarity %.2f, document overlap %.2f", rec.ID, run.Diff.AnswerSimilarity, run.Diff.DocumentOverlap)
+	return run
+}
+
+func diffShadow(live, candidate shadowAnswer) shadowDiff {
+	liveWords, candidateWords := strings.Fields(strings.ToLower(live.Answer)), strings.Fields(strings.ToLower(candidate.Answer))
+	d := shadowDiff{
+		AnswerSimilarity: jaccard(liveWords, candidateWords),
+		LengthDelta:      len(candidateWords) - len(liveWords),
+	}
+	var liveChunks, candidateChunks, liveDocs, candidateDocs []string
+	for _, s := range live.Sources {
+		liveChunks, liveDocs = append(liveChunks, s.ChunkID), append(liveDocs, s.DocumentID)
+	}
+	for _, s := range candidate.Sources {
+		candidateChunks, candidateDocs = append(candidateChunks, s.ChunkID), append(candidateDocs, s.DocumentID)
+	}
+	d.SourceOverlap = jaccard(liveChunks, candidateChunks)
+	d.DocumentOverlap = jaccard(liveDocs, candidateDocs)
+	d.OnlyLive, d.OnlyCandidate = difference(liveDocs, candidateDocs), difference(candidateDocs, liveDocs)
+	return d
+}
+
+// jaccard is the share of the distinct non-empty values in a or b that are
+// in both; 1 when both are empty.
+func jaccard(a, b []string) float64 {
+	inA, union := map[string]bool{}, map[string]bool{}
+	for _, s := range a {
+		if s != "" {
+			inA[s], union[s] = true, true
+		}
+	}
+	shared := map[string]bool{}
+	for _, s := range b {
+		if s != "" {
+			union[s] = true
+			if inA[s] {
+				shared[s] = true
+			}
+		}
+	}
+	if len(union) == 0 {
+		return 1
+	}
+	return float64(len(shared)) / float64(len(union))
+}
+
+// difference is the distinct non-empty values of a missing from b.
+func difference(a, b []string) []string {
+	var out []string
+	for _, s := range a {
+		if s != "" && !slices.Contains(b, s) && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// handleShadowReport summarises the shadow runs and lists the most recent:
+// GET /admin/shadow?workspace=&limit=50.
+func handleShadowReport(c *gin.Context) {
+	all, err := metaStore.List("shadow")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	workspace := c.Query("workspace")
+	var runs []shadowRun
+	for _, raw := range all {
+		var run shadowRun
+		if json.Unmarshal(raw, &run) == nil && (workspace == "" || run.Workspace == workspace) {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+
+	var compared, failed int
+	var answers, chunks, docs float64
+	for _, run := range runs {
+		if run.Error != "" {
+			failed++
+			continue
+		}
+		compared++
+		answers += run.Diff.AnswerSimilarity
+		chunks += run.Diff.SourceOverlap
+		docs += run.Diff.DocumentOverlap
+	}
+	summary := gin.H{"runs": len(runs), "compared": compared, "failed": failed}
+	if compared > 0 {
+		n := float64(compared)
+		summary["mean_answer_similarity"] = answers / n
+		summary["mean_source_overlap"] = chunks / n
+		summary["mean_document_overlap"] = docs / n
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	cfg, _ := loadShadowConfig()
+	c.JSON(http.StatusOK, gin.H{"status": "success", "config": cfg, "sample_percent": envInt("SHADOW_SAMPLE_PERCENT", 0), "summary": summary, "runs": runs[:min(len(runs), limit)]})
+}
+
+// handleGetShadow returns one chat's comparison: GET /admin/shadow/:id.
+func handleGetShadow(c *gin.Context) {
+	var run shadowRun
+	found, err := metaStore.Get("shadow", c.Param("id"), &run)
+	if err != nil || !found {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "No shadow run for that chat"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "shadow": run})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// similarDocument is a document related to another by content.
+type similarDocument struct {
+	DocumentID string  `json:"document_id"`
+	Filename   string  `json:"filename"`
+	Similarity float64 `json:"similarity"` // of the two documents' centroids
+	Redundant  bool    `json:"redundant"`  // likely the same content uploaded again
+	Match      string  `json:"match"`      // the chunk nearest the document's centroid
+}
+
+// documentCentroid is the normalised mean of a document's chunk embeddings.
+func documentCentroid(ctx context.Context, documentID string) ([]float32, error) {
+	limit := uint32(256)
+	var sum []float32
+	var offset *pb.PointId
+	for {
+		res, err := qdrantClient.Scroll(ctx, &pb.ScrollPoints{
+			CollectionName: collectionName,
+			Filter:         andFilters(documentFilter(documentID), enabledFilter),
+			Limit:          &limit,
+			Offset:         offset,
+			WithPayload:    pb.NewWithPayload(false),
+			WithVectors:    pb.NewWithVectors(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range res.GetResult() {
+			v := p.GetVectors().GetVector()
+			data := v.GetDense().GetData()
+			if data == nil {
+				data = v.GetData() // servers before 1.13
+			}
+			if sum == nil {
+				sum = make([]float32, len(data))
+			}
+			for i, x := range normalize(data) {
+				if i < len(sum) {
+					sum[i] += x
+				}
+			}
+		}
+		if offset = res.GetNextPageOffset(); offset == nil {
+			break
+		}
+	}
+	if sum == nil {
+		return nil, nil
+	}
+	return normalize(sum), nil
+}
+
+// handleSimilarDocuments lists the documents in the same workspace closest
+// in content to one: GET /documents/:id/similar?limit=. Candidates are the
+// documents with chunks nearest the document's centroid; they're ranked by
+// how close their own centroids are, and those at least
+// SIMILAR_REDUNDANT_SCORE (default 0.97) similar are flagged as redundant.
+func handleSimilarDocuments(c *gin.Context) {
+	documentID := c.Param("id")
+	user := requestUser(c)
+	var doc documentRecord
+	if found, err := metaStore.Get("documents", documentID, &doc); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	} else if !found || (doc.Owner != "" && doc.Owner != user) {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Document not found"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+	limit = min(limit, 50)
+
+	ctx := c.Request.Context()
+	centroid, err := documentCentroid(ctx, documentID)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	similar := []similarDocument{}
+	if centroid == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "document_id": documentID, "similar": similar})
+		return
+	}
+	res, err := qdrantClient.SearchGroups(ctx, &pb.SearchPointGroups{
+		CollectionName: collectionName,
+		Vector:         centroid,
+		Filter: andFilters(
+			workspacesFilter([]string{doc.Workspace}),
+			&pb.Filter{MustNot: []*pb.Condition{pb.NewMatch("document_id", documentID)}},
+			enabledFilter,
+		),
+		// room for documents the caller can't see
+		Limit:       uint32(limit * 2),
+		GroupBy:     "document_id",
+		GroupSize:   1,
+		WithPayload: pb.NewWithPayload(true),
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+
+	redundant := envFloat("SIMILAR_REDUNDANT_SCORE", 0.97)
+	for _, g := range res.GetResult().GetGroups() {
+		id := g.GetId().GetStringValue()
+		var other documentRecord
+		if found, _ := metaStore.Get("documents", id, &other); !found || (other.Owner != "" && other.Owner != user) || len(g.GetHits()) == 0 {
+			continue
+		}
+		otherCentroid, err := documentCentroid(ctx, id)
+		if err != nil {
+			c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+			return
+		}
+		score := dot(centroid, otherCentroid)
+		similar = append(similar, similarDocument{
+			DocumentID: id,
+			Filename:   other.Filename,
+			Similarity: score,
+			Redundant:  score >= redundant,
+			Match:      g.GetHits()[0].GetId().GetUuid(),
+		})
+		if len(similar) == limit {
+			break
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool { return similar[i].Similarity > similar[j].Similarity })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "document_id": documentID, "similar": similar})
+}
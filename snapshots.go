@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+var snapshotsClient pb.SnapshotsClient
+
+// snapshotCollection is the collection to snapshot: the one named by the
+// "collection" query parameter, else the one behind the serving alias.
+func snapshotCollection(c *gin.Context) (string, error) {
+	if name := c.Query("collection"); name != "" {
+		return name, nil
+	}
+	return aliasTarget(c.Request.Context(), collectionName)
+}
+
+// handleCreateSnapshot snapshots a collection on the Qdrant server:
+// POST /admin/snapshots. The metadata store is not included; back it up
+// alongside.
+func handleCreateSnapshot(c *gin.Context) {
+	name, err := snapshotCollection(c)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	res, err := snapshotsClient.Create(c.Request.Context(), &pb.CreateSnapshotRequest{CollectionName: name})
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Snapshot Error: "+err.Error()))
+		return
+	}
+	snap := res.GetSnapshotDescription()
+	audit(auditEntry{Action: "snapshot", Detail: fmt.Sprintf("%s of %s (%d bytes)", snap.GetName(), name, snap.GetSize())})
+	c.JSON(http.StatusOK, gin.H{"status": "success", "collection": name, "snapshot": describeSnapshot(snap)})
+}
+
+// handleListSnapshots lists a collection's snapshots: GET /admin/snapshots.
+func handleListSnapshots(c *gin.Context) {
+	name, err := snapshotCollection(c)
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	res, err := snapshotsClient.List(c.Request.Context(), &pb.ListSnapshotsRequest{CollectionName: name})
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Snapshot Error: "+err.Error()))
+		return
+	}
+	snaps := []gin.H{}
+	for _, s := range res.GetSnapshotDescriptions() {
+		snaps = append(snaps, describeSnapshot(s))
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "collection": name, "snapshots": snaps})
+}
+
+func describeSnapshot(s *pb.SnapshotDescription) gin.H {
+	return gin.H{"name": s.GetName(), "size": s.GetSize(), "checksum": s.GetChecksum(), "created_at": s.GetCreationTime().AsTime()}
+}
+
+// restoreSnapshot recovers the collection from SNAPSHOT_RESTORE_PATH at
+// startup, unless it already holds points. The path is a local snapshot
+// file, which is uploaded, or a URL or file:// location the Qdrant server
+// can read itself. Qdrant only offers recovery over REST, at QDRANT_HTTP_URL
+// (default http://<QDRANT_URL host>:6333). With nothing serving yet, it is
+// recovered into a new collection that collectionName is then pointed at, as
+// ensureCollection would have created.
+func restoreSnapshot(ctx context.Context) error {
+	location := os.Getenv("SNAPSHOT_RESTORE_PATH")
+	if location == "" {
+		return nil
+	}
+	info, err := collectionsClient.Get(ctx, &pb.GetCollectionInfoRequest{CollectionName: collectionName})
+	if err == nil && info.GetResult().GetPointsCount() > 0 {
+		log.Printf("⏭️ Skipping snapshot restore: %s already has %d points", collectionName, info.GetResult().GetPointsCount())
+		return nil
+	}
+	target, err := aliasTarget(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+	fresh := false
+	if target == collectionName {
+		res, err := collectionsClient.CollectionExists(ctx, &pb.CollectionExistsRequest{CollectionName: collectionName})
+		if err != nil {
+			return err
+		}
+		if fresh = !res.GetResult().GetExists(); fresh {
+			target = newCollectionName()
+		}
+	}
+
+	endpoint := qdrantHTTPURL() + "/collections/" + target + "/snapshots"
+	var req *http.Request
+	if strings.Contains(location, "://") {
+		body, _ := json.Marshal(map[string]string{"location": location})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/recover?wait=true", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	} else {
+		req, err = snapshotUpload(ctx, endpoint+"/upload?wait=true", location)
+	}
+	if err != nil {
+		return err
+	}
+	if key := os.Getenv("QDRANT_API_KEY"); key != "" {
+		req.Header.Set("api-key", key)
+	}
+
+	start := time.Now()
+	resp, err := (&http.Client{Timeout: 30 * time.Minute}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("qdrant returned %s: %s", resp.Status, msg)
+	}
+	if fresh {
+		if err := switchAlias(ctx, collectionName, target, false); err != nil {
+			return err
+		}
+	}
+	log.Printf("♻️ Restored %s from %s in %s", target, location, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// snapshotUpload builds a multipart request streaming a local snapshot file.
+func snapshotUpload(ctx context.Context, url, path string) (*http.Request, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		defer f.Close()
+		part, err := mw.CreateFormFile("snapshot", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req, nil
+}
+
+func qdrantHTTPURL() string {
+	if u := os.Getenv("QDRANT_HTTP_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	host := os.Getenv("QDRANT_URL")
+	if host == "" {
+		host = "localhost:6334"
+	}
+	if i := strings.LastIndex(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+	scheme := "http"
+	if os.Getenv("QDRANT_API_KEY") != "" {
+		scheme = "https" // the gRPC connection uses TLS then too
+	}
+	return scheme + "://" + host + ":6333"
+}
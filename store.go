@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// metadataStore holds everything the service knows about documents that
+// doesn't belong in the vector index. Values are JSON-encoded and grouped
+// into buckets.
+type metadataStore interface {
+	Get(bucket, key string, v any) (bool, error)
+	Put(bucket, key string, v any) error
+	PutBatch(bucket string, items map[string]any) error
+	Delete(bucket, key string) error
+	List(bucket string) (map[string]json.RawMessage, error)
+}
+
+var metaStore metadataStore
+
+// openStore picks the metadata backend from STORE_BACKEND: "file" (the
+// default, METADATA_PATH) for a single instance, or "redis" (REDIS_URL) when
+// several replicas need to share state.
+func openStore() (metadataStore, error) {
+	switch backend := os.Getenv("STORE_BACKEND"); backend {
+	case "", "file":
+		path := os.Getenv("METADATA_PATH")
+		if path == "" {
+			path = "metadata.json"
+		}
+		return openFileStore(path)
+	case "redis":
+		url := os.Getenv("REDIS_URL")
+		if url == "" {
+			url = "redis://localhost:6379/0"
+		}
+		return openRedisStore(url)
+	default:
+		return nil, fmt.Errorf("unknown STORE_BACKEND %q", backend)
+	}
+}
+
+// fileStore is a metadataStore kept in memory and written through to a single
+// JSON file, which is plenty for one service instance.
+type fileStore struct {
+	mu      sync.RWMutex
+	path    string
+	buckets map[string]map[string]json.RawMessage
+}
+
+func openFileStore(path string) (*fileStore, error) {
+	s := &fileStore{path: path, buckets: map[string]map[string]json.RawMessage{}}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.buckets); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileStore) Get(bucket, key string, v any) (bool, error) {
+	s.mu.RLock()
+	raw, ok := s.buckets[bucket][key]
+	s.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+func (s *fileStore) Put(bucket, key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = map[string]json.RawMessage{}
+	}
+	s.buckets[bucket][key] = raw
+	return s.flush()
+}
+
+func (s *fileStore) PutBatch(bucket string, items map[string]any) error {
+	encoded := make(map[string]json.RawMessage, len(items))
+	for k, v := range items {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		encoded[k] = raw
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = map[string]json.RawMessage{}
+	}
+	for k, raw := range encoded {
+		s.buckets[bucket][k] = raw
+	}
+	return s.flush()
+}
+
+func (s *fileStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucket][key]; !ok {
+		return nil
+	}
+	delete(s.buckets[bucket], key)
+	return s.flush()
+}
+
+func (s *fileStore) List(bucket string) (map[string]json.RawMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]json.RawMessage, len(s.buckets[bucket]))
+	for k, v := range s.buckets[bucket] {
+		out[k] = v
+	}
+	return out, nil
+}
+
+// flush rewrites the backing file atomically. Callers hold the write lock.
+func (s *fileStore) flush() error {
+	data, err := json.Marshal(s.buckets)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".metadata-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+const structuredPrompt = "You extract information from documents. Using only the context provided, fill in the fields described by the JSON schema. Leave out optional fields the context does not cover. Respond with JSON only."
+
+// structuredFormat turns a caller-supplied JSON Schema into the response
+// format sent to OpenAI, plus the parsed definition used to validate the reply.
+func structuredFormat(schema json.RawMessage) (*openai.ChatCompletionResponseFormat, *jsonschema.Definition, error) {
+	var def jsonschema.Definition
+	if err := json.Unmarshal(schema, &def); err != nil {
+		return nil, nil, err
+	}
+	if def.Type != jsonschema.Object {
+		return nil, nil, errors.New("response_schema must describe an object")
+	}
+	return &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:   "extraction",
+			Schema: schema,
+		},
+	}, &def, nil
+}
+
+// validateStructured checks the model's JSON reply against the schema and
+// returns it decoded.
+func validateStructured(def *jsonschema.Definition, content string) (any, error) {
+	var data any
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		return nil, err
+	}
+	if !jsonschema.Validate(*def, data) {
+		return nil, errors.New("model output does not match response_schema")
+	}
+	return data, nil
+}
+
+// completeStructured sends a single prompt and constrains the reply to the
+// given JSON Schema, returning the raw JSON text.
+func completeStructured(ctx context.Context, prompt, name string, schema json.RawMessage) (string, error) {
+	resp, err := aiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: chatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:   name,
+				Schema: schema,
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", errors.New("the model returned no answer")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
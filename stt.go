@@ -0,0 +1,120 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// sttProvider transcribes a spoken question. Deployers add their own by
+// implementing it in a new file and calling registerSTT from an init
+// function; STT_PROVIDER picks one (default openai).
+type sttProvider interface {
+	Name() string
+	Transcribe(ctx context.Context, filename string, audio io.Reader) (string, error)
+}
+
+// timedSTT is an sttProvider that can also tell when each part of a
+// recording is spoken, as transcribing a video for timestamped citations
+// needs. Other providers' transcripts are timed as one cue.
+type timedSTT interface {
+	TranscribeTimed(ctx context.Context, filename string, audio io.Reader) ([]transcriptCue, error)
+}
+
+var sttRegistry = map[string]sttProvider{}
+
+func registerSTT(p sttProvider) {
+	if _, dup := sttRegistry[p.Name()]; dup {
+		panic("STT provider registered twice: " + p.Name())
+	}
+	sttRegistry[p.Name()] = p
+}
+
+func init() {
+	registerSTT(openaiSTT{})
+}
+
+// bindVoiceQuestion reads a multipart /chat request: the usual JSON body,
+// if any, in the "request" field and the question as an "audio" file of at
+// most STT_MAX_MB (default 25). It returns the transcript.
+func bindVoiceQuestion(c *gin.Context, body any) (string, error) {
+	file, err := c.FormFile("audio")
+	if err != nil {
+		return "", fmt.Errorf("an audio file is required: %v", err)
+	}
+	if limit := int64(envInt("STT_MAX_MB", 25)) << 20; file.Size > limit {
+		return "", fmt.Errorf("the audio is %d MB, more than STT_MAX_MB (%d)", file.Size>>20, limit>>20)
+	}
+	if raw := c.PostForm("request"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), body); err != nil {
+			return "", fmt.Errorf("invalid request JSON: %v", err)
+		}
+	}
+	name := cmp.Or(os.Getenv("STT_PROVIDER"), "openai")
+	provider, ok := sttRegistry[name]
+	if !ok {
+		return "", fmt.Errorf("unknown STT provider %q", name)
+	}
+	f, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	text, err := provider.Transcribe(c.Request.Context(), file.Filename, f)
+	if err != nil {
+		recordError("stt", err)
+		return "", err
+	}
+	if text = strings.TrimSpace(text); text == "" {
+		return "", fmt.Errorf("no speech found in the audio")
+	}
+	return text, nil
+}
+
+// openaiSTT uses OpenAI's transcription endpoint with STT_MODEL (default
+// whisper-1).
+type openaiSTT struct{}
+
+func (openaiSTT) Name() string { return "openai" }
+
+func (openaiSTT) Transcribe(ctx context.Context, filename string, audio io.Reader) (string, error) {
+	resp, err := aiClient.CreateTranscription(ctx, openai.AudioRequest{
+		Model: cmp.Or(os.Getenv("STT_MODEL"), openai.Whisper1),
+		// the extension tells the API the audio format
+		FilePath: filepath.Base(cmp.Or(filename, "question.webm")),
+		Reader:   audio,
+		Format:   openai.AudioResponseFormatJSON,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.Text, nil
+}
+
+func (openaiSTT) TranscribeTimed(ctx context.Context, filename string, audio io.Reader) ([]transcriptCue, error) {
+	resp, err := aiClient.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    cmp.Or(os.Getenv("STT_MODEL"), openai.Whisper1),
+		FilePath: filepath.Base(cmp.Or(filename, "audio.m4a")),
+		Reader:   audio,
+		Format:   openai.AudioResponseFormatVerboseJSON,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cues := make([]transcriptCue, 0, len(resp.Segments))
+	for _, s := range resp.Segments {
+		cues = append(cues, transcriptCue{Start: s.Start, End: s.End, Text: strings.TrimSpace(s.Text)})
+	}
+	if len(cues) == 0 && strings.TrimSpace(resp.Text) != "" {
+		cues = append(cues, transcriptCue{End: resp.Duration, Text: strings.TrimSpace(resp.Text)})
+	}
+	return cues, nil
+}
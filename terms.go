@@ -0,0 +1,244 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// termEntry is one exact-match term and the chunks it appears in.
+type termEntry struct {
+	Term   string   `json:"term"`
+	Kind   string   `json:"kind"` // reference, identifier, defined_term, acronym or entity
+	Chunks []string `json:"chunks"`
+}
+
+// termPatterns are tried in order; the first kind to claim a span wins.
+var termPatterns = []struct {
+	kind string
+	re   *regexp.Regexp
+}{
+	{"reference", regexp.MustCompile(`(?i)\b(?:section|clause|article|appendix|schedule|exhibit|annex)\s+[0-9A-Z]+(?:\.[0-9A-Za-z]+)*(?:\([0-9A-Za-z]{1,4}\))*`)},
+	{"identifier", regexp.MustCompile(`\b[A-Z][A-Z0-9]*(?:[-/][A-Z0-9]+)+\b`)},
+	{"defined_term", regexp.MustCompile(`["“]([A-Z][^"”\n]{1,60})["”]`)},
+	{"acronym", regexp.MustCompile(`\b[A-Z]{2,}[0-9]*s?\b`)},
+	{"entity", regexp.MustCompile(`\b[A-Z][a-z]+(?:\s+(?:of\s+|&\s+)?[A-Z][a-z]+)+\b`)},
+}
+
+// termsMu serialises read-modify-write updates of the term index.
+var termsMu sync.Mutex
+
+func termKey(term string) string { return strings.ToLower(strings.Join(strings.Fields(term), " ")) }
+
+// extractTerms finds exact-match terms in text, keyed by normalised form.
+func extractTerms(text string) map[string]termEntry {
+	terms := map[string]termEntry{}
+	claimed := make([]bool, len(text))
+	for _, p := range termPatterns {
+		for _, m := range p.re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := m[0], m[1]
+			if len(m) > 2 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			if slices.Contains(claimed[start:end], true) {
+				continue
+			}
+			for i := start; i < end; i++ {
+				claimed[i] = true
+			}
+			term := strings.TrimSpace(text[start:end])
+			if key := termKey(term); key != "" {
+				if _, ok := terms[key]; !ok {
+					terms[key] = termEntry{Term: term, Kind: p.kind}
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// indexTerms records the exact-match terms of each chunk in the metadata store.
+func indexTerms(points []*pb.PointStruct) error {
+	found := map[string]termEntry{}
+	for _, p := range points {
+		chunkID := p.Id.GetUuid()
+		for key, t := range extractTerms(payloadString(p.Payload, "text")) {
+			entry, ok := found[key]
+			if !ok {
+				entry = t
+			}
+			entry.Chunks = append(entry.Chunks, chunkID)
+			found[key] = entry
+		}
+	}
+
+	termsMu.Lock()
+	defer termsMu.Unlock()
+	batch := make(map[string]any, len(found))
+	for key, t := range found {
+		var existing termEntry
+		if ok, err := metaStore.Get("terms", key, &existing); err != nil {
+			return err
+		} else if ok {
+			// a retried ingest indexes the same chunks again
+			t.Chunks = append(existing.Chunks, slices.DeleteFunc(t.Chunks, func(id string) bool { return slices.Contains(existing.Chunks, id) })...)
+		}
+		batch[key] = t
+	}
+	return metaStore.PutBatch("terms", batch)
+}
+
+// exactMatchChunks returns the IDs of chunks containing terms from the
+// question that are distinctive enough to skip vector search: references,
+// identifiers and defined terms. Chunks containing more of the terms come
+// first, ties in term order, so the same question always gets the same
+// chunks.
+func exactMatchChunks(question string) ([]string, error) {
+	terms := extractTerms(question)
+	hits := map[string]int{}
+	var ids []string
+	for _, key := range slices.Sorted(maps.Keys(terms)) {
+		if t := terms[key]; t.Kind == "acronym" || t.Kind == "entity" {
+			continue
+		}
+		var entry termEntry
+		found, err := metaStore.Get("terms", key, &entry)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		for _, id := range entry.Chunks {
+			if hits[id] == 0 {
+				ids = append(ids, id)
+			}
+			hits[id]++
+		}
+	}
+	slices.SortStableFunc(ids, func(a, b string) int { return cmp.Compare(hits[b], hits[a]) })
+	return ids, nil
+}
+
+// exactMatchContext returns the text of up to limit chunks matched by
+// exactMatchChunks, or nil when the question has no indexed exact terms.
+// Only chunks matching filter, the one the vector search would use, count,
+// and skip, if set, drops more by their payload.
+func exactMatchContext(ctx context.Context, question string, limit int, filter *pb.Filter, skip func(map[string]*pb.Value) bool) ([]string, []retrievedChunk) {
+	ids, err := exactMatchChunks(question)
+	if err != nil || len(ids) == 0 {
+		return nil, nil
+	}
+	pointIDs := make([]*pb.PointId, len(ids))
+	for i, id := range ids {
+		pointIDs[i] = pb.NewIDUUID(id)
+	}
+	matched := map[string]*pb.RetrievedPoint{}
+	err = scrollPoints(ctx, andFilters(&pb.Filter{Must: []*pb.Condition{pb.NewHasID(pointIDs...)}}, filter, enabledFilter), true, func(batch []*pb.RetrievedPoint) error {
+		for _, p := range batch {
+			matched[p.Id.GetUuid()] = p
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil
+	}
+	texts := make([]string, 0, limit)
+	var sources []retrievedChunk
+	for _, id := range ids {
+		p, ok := matched[id]
+		if !ok || (skip != nil && skip(p.Payload)) {
+			continue
+		}
+		sources = append(sources, chunkRef(p.Id, p.Payload, 0, "exact"))
+		if texts = append(texts, payloadString(p.Payload, "text")); len(texts) == limit {
+			break
+		}
+	}
+	return texts, sources
+}
+
+// handleTerms lists indexed terms, optionally filtered by a "q" substring,
+// with the chunks of documents the caller may see. Terms found only in other
+// users' documents are left out.
+func handleTerms(c *gin.Context) {
+	all, err := metaStore.List("terms")
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	q := termKey(c.Query("q"))
+	terms := []termEntry{}
+	for key, raw := range all {
+		if q != "" && !strings.Contains(key, q) {
+			continue
+		}
+		var t termEntry
+		if err := json.Unmarshal(raw, &t); err == nil {
+			terms = append(terms, t)
+		}
+	}
+	var chunkIDs []string
+	for _, t := range terms {
+		chunkIDs = append(chunkIDs, t.Chunks...)
+	}
+	slices.Sort(chunkIDs)
+	points, err := getPoints(c.Request.Context(), slices.Compact(chunkIDs))
+	if err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamQdrant, "Qdrant Error: "+err.Error()))
+		return
+	}
+	visible, seen := visibleTo(requestUser(c)), map[string]bool{}
+	for _, p := range points {
+		seen[p.Id.GetUuid()] = visible(p.Payload)
+	}
+	kept := terms[:0]
+	for _, t := range terms {
+		if t.Chunks = slices.DeleteFunc(t.Chunks, func(id string) bool { return !seen[id] }); len(t.Chunks) > 0 {
+			kept = append(kept, t)
+		}
+	}
+	terms = kept
+	sort.Slice(terms, func(i, j int) bool { return terms[i].Term < terms[j].Term })
+	c.JSON(http.StatusOK, gin.H{"status": "success", "terms": terms})
+}
+
+// forgetTermChunks removes deleted chunks from the term index, dropping
+// terms no chunk mentions any more.
+func forgetTermChunks(chunkIDs map[string]bool) error {
+	termsMu.Lock()
+	defer termsMu.Unlock()
+	all, err := metaStore.List("terms")
+	if err != nil {
+		return err
+	}
+	batch := map[string]any{}
+	for key, raw := range all {
+		var t termEntry
+		if json.Unmarshal(raw, &t) != nil {
+			continue
+		}
+		kept := slices.DeleteFunc(slices.Clone(t.Chunks), func(id string) bool { return chunkIDs[id] })
+		switch {
+		case len(kept) == len(t.Chunks):
+		case len(kept) == 0:
+			if err := metaStore.Delete("terms", key); err != nil {
+				return err
+			}
+		default:
+			t.Chunks = kept
+			batch[key] = t
+		}
+	}
+	return metaStore.PutBatch("terms", batch)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// handleIngestText ingests text pushed by another system, such as a CRM
+// note or a support ticket, without it having to make up a file:
+// POST /ingest/text {"title", "text", "metadata", "workspace", "tags",
+// "source", "url", "supersedes"}. Form feeds in the text break pages; url
+// is cited as where the text came from. Metadata values, numbers and the
+// like as JSON, are kept on the document record and on its chunks as
+// fields that chat and retrieval can filter by. Bodies over
+// TEXT_INGEST_MAX_MB (default 10) are refused. Replies as POST /ingest
+// does, honouring Idempotency-Key the same way.
+func handleIngestText(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(envInt("TEXT_INGEST_MAX_MB", 10))<<20)
+	var body struct {
+		Title      string         `json:"title"`
+		Text       string         `json:"text"`
+		Metadata   map[string]any `json:"metadata"`
+		Workspace  string         `json:"workspace"`
+		Tags       []string       `json:"tags"`
+		Source     string         `json:"source"`
+		URL        string         `json:"url"`
+		Supersedes string         `json:"supersedes"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusOK, errorReply(c, codeTooLarge, fmt.Sprintf("Text is larger than %d MB", tooLarge.Limit>>20)))
+			return
+		}
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	if strings.TrimSpace(body.Text) == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "text is required"))
+		return
+	}
+	if body.Workspace == "" {
+		body.Workspace = "default"
+	}
+	metadata := map[string]string{}
+	for k, v := range body.Metadata {
+		if s, ok := v.(string); ok {
+			metadata[k] = s
+		} else if raw, err := json.Marshal(v); err == nil {
+			metadata[k] = string(raw)
+		}
+	}
+	tags := parseTags(strings.Join(body.Tags, ","))
+
+	withIdempotency(c, fingerprint([]byte(body.Text), body.Workspace, body.Title), func() gin.H {
+		tmp, err := os.CreateTemp("", "docuchat-*.txt")
+		if err != nil {
+			return errorReply(c, codeStorage, "Upload Storage Error: "+err.Error())
+		}
+		_, err = tmp.WriteString(body.Text)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		defer os.Remove(tmp.Name()) // gone already if a queued job moved it
+		if err != nil {
+			return errorReply(c, codeStorage, "Upload Storage Error: "+err.Error())
+		}
+
+		job := ingestJob{
+			ID:         uuid.New().String(),
+			DocumentID: uuid.New().String(),
+			Filename:   textFilename(body.Title),
+			Path:       tmp.Name(),
+			Workspace:  body.Workspace,
+			Owner:      requestUser(c),
+			Graph:      graphEnabled(""),
+			Tags:       tags,
+			Source:     body.Source,
+			URL:        body.URL,
+			Supersedes: body.Supersedes,
+			Title:      strings.TrimSpace(body.Title),
+			Metadata:   metadata,
+		}
+		resp, _ := submitIngest(c, job)
+		return resp
+	})
+}
+
+// textFilename makes a filename for pushed text out of its title.
+func textFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	if name == "" {
+		name = "text"
+	}
+	return name + ".txt"
+}
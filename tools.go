@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// toolPlugin is a function the chat model may call while answering. Deployers
+// add their own by implementing it in a new file and calling registerTool
+// from an init function.
+type toolPlugin interface {
+	Name() string
+	Description() string
+	Parameters() json.RawMessage // JSON Schema of the arguments object
+	Call(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+var toolRegistry = map[string]toolPlugin{}
+
+func registerTool(t toolPlugin) {
+	if _, dup := toolRegistry[t.Name()]; dup {
+		panic("tool registered twice: " + t.Name())
+	}
+	toolRegistry[t.Name()] = t
+}
+
+// workspaceTools returns the registered tools enabled for a workspace.
+// TOOLS_<WORKSPACE> lists them comma-separated ("*" for all), falling back
+// to TOOLS for workspaces without their own setting.
+func workspaceTools(workspace string) []toolPlugin {
+	names, ok := os.LookupEnv("TOOLS_" + strings.ToUpper(workspace))
+	if !ok {
+		names = os.Getenv("TOOLS")
+	}
+	var tools []toolPlugin
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "*" {
+			tools = tools[:0]
+			for _, t := range toolRegistry {
+				tools = append(tools, t)
+			}
+			return tools
+		}
+		if t, ok := toolRegistry[name]; ok {
+			tools = append(tools, t)
+		}
+	}
+	return tools
+}
+
+func toolDefinitions(tools []toolPlugin) []openai.Tool {
+	defs := make([]openai.Tool, 0, len(tools))
+	for _, t := range tools {
+		defs = append(defs, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        t.Name(),
+				Description: t.Description(),
+				Parameters:  t.Parameters(),
+			},
+		})
+	}
+	return defs
+}
+
+func init() {
+	registerTool(calculatorTool{})
+}
+
+// calculatorTool evaluates arithmetic so the model doesn't have to.
+type calculatorTool struct{}
+
+func (calculatorTool) Name() string { return "calculator" }
+
+func (calculatorTool) Description() string {
+	return "Evaluates an arithmetic expression such as (1200 * 0.15) + 40."
+}
+
+func (calculatorTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{"type":"object","properties":{"expression":{"type":"string"}},"required":["expression"]}`)
+}
+
+func (calculatorTool) Call(ctx context.Context, args json.RawMessage) (string, error) {
+	var in struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return "", err
+	}
+	expr, err := parser.ParseExpr(in.Expression)
+	if err != nil {
+		return "", err
+	}
+	v, err := evalArithmetic(expr)
+	if err != nil {
+		return "", err
+	}
+	if i := constant.ToInt(v); i.Kind() == constant.Int {
+		return i.ExactString(), nil
+	}
+	f, _ := constant.Float64Val(v)
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// evalArithmetic evaluates numbers, parentheses and + - * / %, with every
+// operand a float so that 7/2 is 3.5 rather than Go's integer 3.
+func evalArithmetic(e ast.Expr) (constant.Value, error) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return nil, fmt.Errorf("%s is not a number", e.Value)
+		}
+		return constant.ToFloat(constant.MakeFromLiteral(e.Value, e.Kind, 0)), nil
+	case *ast.ParenExpr:
+		return evalArithmetic(e.X)
+	case *ast.UnaryExpr:
+		x, err := evalArithmetic(e.X)
+		if err != nil {
+			return nil, err
+		}
+		if e.Op != token.ADD && e.Op != token.SUB {
+			return nil, fmt.Errorf("unsupported operator %s", e.Op)
+		}
+		return constant.UnaryOp(e.Op, x, 0), nil
+	case *ast.BinaryExpr:
+		x, err := evalArithmetic(e.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := evalArithmetic(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.ADD, token.SUB, token.MUL:
+			return constant.BinaryOp(x, e.Op, y), nil
+		case token.QUO, token.REM:
+			if constant.Sign(y) == 0 {
+				return nil, errors.New("division by zero")
+			}
+			if e.Op == token.QUO {
+				return constant.BinaryOp(x, token.QUO, y), nil
+			}
+			xi, yi := constant.ToInt(x), constant.ToInt(y)
+			if xi.Kind() != constant.Int || yi.Kind() != constant.Int {
+				return nil, errors.New("% needs whole numbers")
+			}
+			return constant.ToFloat(constant.BinaryOp(xi, token.REM, yi)), nil
+		}
+		return nil, fmt.Errorf("unsupported operator %s", e.Op)
+	}
+	return nil, errors.New("only numbers and + - * / % are supported")
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+const topicsPrompt = "You label the topics of a document collection. Each numbered group below holds excerpts that are close in meaning. For every group give a short label of two to five words naming what its excerpts are about, and a one-sentence description. Return the groups in the order given."
+
+// topic is one cluster of a workspace's chunks.
+type topic struct {
+	Label       string           `json:"label"`
+	Description string           `json:"description"`
+	Chunks      int              `json:"chunks"` // sampled chunks in the cluster
+	Share       float64          `json:"share"`  // of the sample
+	Documents   []topicDocument  `json:"documents"`
+	Examples    []retrievedChunk `json:"examples"` // the chunks nearest its centre
+}
+
+// topicDocument is a document contributing to a topic.
+type topicDocument struct {
+	DocumentID string `json:"document_id"`
+	Filename   string `json:"filename"`
+	Chunks     int    `json:"chunks"`
+}
+
+// topicMap is a workspace's topic breakdown, kept in the "topics" bucket.
+type topicMap struct {
+	Workspace   string    `json:"workspace"`
+	Sampled     int       `json:"sampled"`
+	Topics      []topic   `json:"topics"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// handleTopics reports what a workspace's documents cover:
+// GET /analytics/topics?workspace=&k=&refresh=true. A random sample of
+// TOPICS_SAMPLE (default 2000) chunks is clustered by k-means over their
+// embeddings into k topics (by default about √(sample/2), at most 20), which
+// the model then labels. The map is kept and served for TOPICS_CACHE_HOURS
+// (default 24) unless refresh is set or a different k is asked for. The
+// sample takes in every user's documents, so the route needs ADMIN_TOKEN.
+func handleTopics(c *gin.Context) {
+	ws := c.DefaultQuery("workspace", "default")
+	k, _ := strconv.Atoi(c.Query("k"))
+	var cached topicMap
+	if found, _ := metaStore.Get("topics", ws, &cached); found && c.Query("refresh") != "true" &&
+		time.Since(cached.GeneratedAt) < time.Duration(envInt("TOPICS_CACHE_HOURS", 24))*time.Hour &&
+		(k <= 0 || k == len(cached.Topics)) {
+		c.JSON(http.StatusOK, gin.H{"status": "success", "topics": cached, "cached": true})
+		return
+	}
+	m, err := buildTopics(c.Request.Context(), ws, k)
+	if err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeUpstream, "❌ Topics Error: "+err.Error()))
+		return
+	}
+	if err := metaStore.Put("topics", ws, m); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "topics": m})
+}
+
+func buildTopics(ctx context.Context, ws string, k int) (topicMap, error) {
+	limit := uint64(envInt("TOPICS_SAMPLE", 2000))
+	res, err := qdrantClient.Query(ctx, &pb.QueryPoints{
+		CollectionName: collectionName,
+		Query:          pb.NewQuerySample(pb.Sample_Random),
+		Filter:         andFilters(workspacesFilter([]string{ws}), enabledFilter, validAtFilter(time.Now()), notDuplicateFilter),
+		Limit:          &limit,
+		WithPayload:    pb.NewWithPayload(true),
+		WithVectors:    pb.NewWithVectors(true),
+	})
+	if err != nil {
+		return topicMap{}, err
+	}
+	var points []*pb.ScoredPoint
+	var vectors [][]float32
+	for _, p := range res.GetResult() {
+		v := p.GetVectors().GetVector()
+		data := v.GetDense().GetData()
+		if data == nil {
+			data = v.GetData() // servers before 1.13
+		}
+		if len(data) > 0 {
+			points, vectors = append(points, p), append(vectors, normalize(data))
+		}
+	}
+	m := topicMap{Workspace: ws, Sampled: len(points), Topics: []topic{}, GeneratedAt: time.Now()}
+	if len(points) == 0 {
+		return m, nil
+	}
+	if k <= 0 {
+		k = int(math.Round(math.Sqrt(float64(len(points)) / 2)))
+	}
+	k = max(min(k, 20, len(points)), 1)
+	assign, centroids := kmeans(vectors, k)
+
+	clusters := make([][]int, k)
+	for i, a := range assign {
+		clusters[a] = append(clusters[a], i)
+	}
+	var excerpts strings.Builder
+	for ci, members := range clusters {
+		if len(members) == 0 {
+			continue
+		}
+		// nearest the centre first
+		sort.Slice(members, func(i, j int) bool {
+			return dot(vectors[members[i]], centroids[ci]) > dot(vectors[members[j]], centroids[ci])
+		})
+		t := topic{Chunks: len(members), Share: float64(len(members)) / float64(len(points))}
+		docs := map[string]*topicDocument{}
+		for _, i := range members {
+			p := points[i]
+			id := payloadString(p.Payload, "document_id")
+			if docs[id] == nil {
+				docs[id] = &topicDocument{DocumentID: id, Filename: payloadString(p.Payload, "filename")}
+			}
+			docs[id].Chunks++
+		}
+		for _, d := range docs {
+			t.Documents = append(t.Documents, *d)
+		}
+		sort.Slice(t.Documents, func(i, j int) bool { return t.Documents[i].Chunks > t.Documents[j].Chunks })
+		t.Documents = t.Documents[:min(len(t.Documents), 10)]
+
+		fmt.Fprintf(&excerpts, "Group %d:\n", len(m.Topics)+1)
+		for _, i := range members[:min(len(members), 5)] {
+			p := points[i]
+			t.Examples = append(t.Examples, chunkRef(p.GetId(), p.Payload, 0, "vector"))
+			text := []rune(payloadString(p.Payload, "text"))
+			fmt.Fprintf(&excerpts, "- %s\n", string(text[:min(len(text), 400)]))
+		}
+		excerpts.WriteString("\n")
+		m.Topics = append(m.Topics, t)
+	}
+
+	schema, _ := json.Marshal(map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"topics": map[string]any{"type": "array", "items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"label":       map[string]any{"type": "string"},
+					"description": map[string]any{"type": "string"},
+				},
+				"required":             []string{"label", "description"},
+				"additionalProperties": false,
+			}},
+		},
+		"required":             []string{"topics"},
+		"additionalProperties": false,
+	})
+	raw, err := completeStructured(ctx, topicsPrompt+"\n\n"+excerpts.String(), "topic_labels", schema)
+	if err != nil {
+		return topicMap{}, err
+	}
+	var out struct {
+		Topics []struct {
+			Label       string `json:"label"`
+			Description string `json:"description"`
+		} `json:"topics"`
+	}
+	if err := json.Unmarshal([]byte(raw), &out); err != nil {
+		return topicMap{}, err
+	}
+	for i := range m.Topics {
+		m.Topics[i].Label = fmt.Sprintf("Topic %d", i+1)
+		if i < len(out.Topics) && strings.TrimSpace(out.Topics[i].Label) != "" {
+			m.Topics[i].Label = strings.TrimSpace(out.Topics[i].Label)
+			m.Topics[i].Description = strings.TrimSpace(out.Topics[i].Description)
+		}
+	}
+	sort.SliceStable(m.Topics, func(i, j int) bool { return m.Topics[i].Chunks > m.Topics[j].Chunks })
+	return m, nil
+}
+
+// kmeans clusters unit vectors into k groups by cosine similarity, seeded
+// k-means++ style with a fixed seed so the same sample gives the same map,
+// and returns each vector's cluster and the cluster centres.
+func kmeans(vectors [][]float32, k int) ([]int, [][]float32) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	centroids := [][]float32{vectors[rng.IntN(len(vectors))]}
+	dist := make([]float64, len(vectors))
+	for len(centroids) < k {
+		var total float64
+		for i, v := range vectors {
+			d := 1 - dot(v, centroids[len(centroids)-1])
+			if len(centroids) == 1 || d < dist[i] {
+				dist[i] = d
+			}
+			total += dist[i]
+		}
+		if total <= 0 {
+			break // fewer distinct vectors than k
+		}
+		r := rng.Float64() * total
+		next := len(vectors) - 1
+		for i, d := range dist {
+			if r -= d; r <= 0 {
+				next = i
+				break
+			}
+		}
+		centroids = append(centroids, vectors[next])
+	}
+
+	assign := make([]int, len(vectors))
+	for iter := 0; iter < 25; iter++ {
+		changed := iter == 0
+		for i, v := range vectors {
+			best, bestScore := 0, math.Inf(-1)
+			for ci, ctr := range centroids {
+				if s := dot(v, ctr); s > bestScore {
+					best, bestScore = ci, s
+				}
+			}
+			if assign[i] != best {
+				assign[i], changed = best, true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([][]float32, len(centroids))
+		for i, v := range vectors {
+			a := assign[i]
+			if sums[a] == nil {
+				sums[a] = make([]float32, len(v))
+			}
+			for j, x := range v {
+				sums[a][j] += x
+			}
+		}
+		for ci, s := range sums {
+			if s != nil {
+				centroids[ci] = normalize(s)
+			}
+		}
+	}
+	return assign, centroids
+}
+
+func dot(a, b []float32) float64 {
+	var s float64
+	for i := range min(len(a), len(b)) {
+		s += float64(a[i]) * float64(b[i])
+	}
+	return s
+}
+
+// normalize returns v scaled to unit length.
+func normalize(v []float32) []float32 {
+	var n float64
+	for _, x := range v {
+		n += float64(x) * float64(x)
+	}
+	out := make([]float32, len(v))
+	if n == 0 {
+		return out
+	}
+	n = math.Sqrt(n)
+	for i, x := range v {
+		out[i] = float32(float64(x) / n)
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// transcriptCue is a line of a transcript and when it is spoken, in seconds.
+type transcriptCue struct {
+	Start, End float64
+	Text       string
+}
+
+// isTranscriptFile reports whether a file is a WebVTT or SubRip transcript,
+// ingested in timestamp windows.
+func isTranscriptFile(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".vtt", ".srt":
+		return true
+	}
+	return false
+}
+
+var (
+	cueTimeRe = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s+-->\s+((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+	cueTagRe  = regexp.MustCompile(`<[^>]+>`)
+)
+
+// parseSubtitles reads the cues of a WebVTT or SubRip file. Styling tags
+// are dropped, and cues auto-captioning repeats word for word are kept once.
+func parseSubtitles(path string) ([]transcriptCue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cues []transcriptCue
+	var cur *transcriptCue
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\uFEFF"))
+		if m := cueTimeRe.FindStringSubmatch(line); m != nil {
+			cues = append(cues, transcriptCue{Start: cueSeconds(m[1]), End: cueSeconds(m[2])})
+			cur = &cues[len(cues)-1]
+			continue
+		}
+		if line == "" {
+			cur = nil
+			continue
+		}
+		if cur != nil {
+			text := strings.TrimSpace(html.UnescapeString(cueTagRe.ReplaceAllString(line, "")))
+			if text != "" {
+				cur.Text = strings.TrimSpace(cur.Text + " " + text)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	out := cues[:0]
+	for _, c := range cues {
+		if c.Text == "" || (len(out) > 0 && out[len(out)-1].Text == c.Text) {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// cueSeconds reads a cue time, "01:02:03.450", "02:03.450" or SubRip's
+// "01:02:03,450".
+func cueSeconds(s string) float64 {
+	parts := strings.Split(strings.Replace(s, ",", ".", 1), ":")
+	total := 0.0
+	for _, p := range parts {
+		v, _ := strconv.ParseFloat(p, 64)
+		total = total*60 + v
+	}
+	return total
+}
+
+// transcriptPages cuts a transcript into windows of TRANSCRIPT_WINDOW_SECONDS
+// (default 60), ending each at a cue boundary. A window is a page starting
+// at its first cue, its text led by that time so answers can quote it.
+func transcriptPages(path string) ([]pdfPage, error) {
+	cues, err := parseSubtitles(path)
+	if err != nil {
+		return nil, err
+	}
+	window := float64(max(envInt("TRANSCRIPT_WINDOW_SECONDS", 60), 1))
+	var pages []pdfPage
+	var b strings.Builder
+	start := 0.0
+	flush := func() {
+		if b.Len() > 0 {
+			pages = append(pages, pdfPage{Number: len(pages) + 1, Text: "[" + formatTimestamp(start) + "] " + b.String(), Start: start})
+			b.Reset()
+		}
+	}
+	for _, c := range cues {
+		if b.Len() > 0 && c.Start-start >= window {
+			flush()
+		}
+		if b.Len() == 0 {
+			start = c.Start
+		} else {
+			b.WriteByte(' ')
+		}
+		b.WriteString(c.Text)
+	}
+	flush()
+	return pages, nil
+}
+
+// writeVTT saves cues as a WebVTT file.
+func writeVTT(path string, cues []transcriptCue) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, c := range cues {
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", vttTime(c.Start), vttTime(max(c.End, c.Start)), strings.ReplaceAll(c.Text, "\n", " "))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+func vttTime(s float64) string {
+	ms := int64(s*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// formatTimestamp writes seconds as "m:ss", or "h:mm:ss" past an hour.
+func formatTimestamp(s float64) string {
+	t := int(s)
+	if t >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", t/3600, t/60%60, t%60)
+	}
+	return fmt.Sprintf("%d:%02d", t/60, t%60)
+}
+
+// timestampURL links to a moment of a recording: YouTube's t parameter,
+// Vimeo's #t fragment, or a media fragment for anything else.
+func timestampURL(link string, seconds float64) string {
+	u, err := url.Parse(link)
+	if err != nil || seconds < 1 {
+		return link
+	}
+	t := strconv.Itoa(int(seconds))
+	switch host := strings.TrimPrefix(u.Hostname(), "www."); {
+	case host == "youtube.com" || host == "m.youtube.com" || host == "youtu.be":
+		q := u.Query()
+		q.Set("t", t+"s")
+		u.RawQuery = q.Encode()
+	case strings.HasSuffix(host, "vimeo.com"):
+		u.Fragment = "t=" + t + "s"
+	default:
+		u.Fragment = "t=" + t
+	}
+	return u.String()
+}
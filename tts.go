@@ -0,0 +1,130 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ttsProvider turns an answer into speech. Deployers add their own by
+// implementing it in a new file and calling registerTTS from an init
+// function, as with retrievers; TTS_PROVIDER, per workspace with a
+// _<WORKSPACE> suffix, picks one (default openai).
+type ttsProvider interface {
+	Name() string
+	// Synthesize returns the audio and its content type.
+	Synthesize(ctx context.Context, workspace, text string) (io.ReadCloser, string, error)
+}
+
+var ttsRegistry = map[string]ttsProvider{}
+
+func registerTTS(p ttsProvider) {
+	if _, dup := ttsRegistry[p.Name()]; dup {
+		panic("TTS provider registered twice: " + p.Name())
+	}
+	ttsRegistry[p.Name()] = p
+}
+
+func init() {
+	registerTTS(openaiTTS{})
+}
+
+func ttsFor(workspace string) (ttsProvider, error) {
+	name := cmp.Or(envWorkspace("TTS_PROVIDER", workspace), "openai")
+	p, ok := ttsRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown TTS provider %q", name)
+	}
+	return p, nil
+}
+
+// audioURL is where a chat turn's answer can be fetched as speech.
+func audioURL(rec chatRecord) string {
+	return fmt.Sprintf("/sessions/%s/messages/%s/audio", rec.SessionID, rec.ID)
+}
+
+// speechText is the answer as it should be read out: plain text, cut at a
+// sentence end to fit TTS_MAX_CHARS (default 4096, OpenAI's limit).
+func speechText(answer string) string {
+	text := markdownToPlain(answer)
+	limit := envInt("TTS_MAX_CHARS", 4096)
+	if r := []rune(text); len(r) > limit {
+		text = string(r[:limit])
+		if i := strings.LastIndexAny(text, ".!?"); i > limit/2 {
+			text = text[:i+1]
+		}
+	}
+	return text
+}
+
+// handleChatAudio streams a chat turn's answer as speech:
+// GET /sessions/:id/messages/:mid/audio. It is synthesised on request, so the
+// selected variant is the one read out.
+func handleChatAudio(c *gin.Context) {
+	_, rec, err := sessionMessage(c, c.Param("id"), c.Param("mid"))
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeNotFound, err))
+		return
+	}
+	text := speechText(rec.Answer)
+	if strings.TrimSpace(text) == "" {
+		c.JSON(http.StatusOK, errorReply(c, codeNoText, "This message has no answer to read out"))
+		return
+	}
+	provider, err := ttsFor(rec.Workspace)
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeInternal, err))
+		return
+	}
+	audio, contentType, err := provider.Synthesize(c.Request.Context(), rec.Workspace, text)
+	if err != nil {
+		recordError("tts", err)
+		log.Printf("❌ TTS Error: %v", err)
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamTTS, "❌ TTS Error: "+err.Error()))
+		return
+	}
+	defer audio.Close()
+	c.Header("Cache-Control", "private, no-store")
+	c.DataFromReader(http.StatusOK, -1, contentType, audio, nil)
+}
+
+// openaiTTS uses OpenAI's speech endpoint with TTS_MODEL (default
+// gpt-4o-mini-tts), TTS_VOICE (default alloy) and TTS_FORMAT (default mp3),
+// the voice and format settable per workspace.
+type openaiTTS struct{}
+
+func (openaiTTS) Name() string { return "openai" }
+
+var speechTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	"pcm":  "audio/pcm",
+}
+
+func (openaiTTS) Synthesize(ctx context.Context, workspace, text string) (io.ReadCloser, string, error) {
+	format := cmp.Or(envWorkspace("TTS_FORMAT", workspace), "mp3")
+	contentType, ok := speechTypes[format]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported TTS_FORMAT %q", format)
+	}
+	resp, err := aiClient.CreateSpeech(ctx, openai.CreateSpeechRequest{
+		Model:          openai.SpeechModel(cmp.Or(envWorkspace("TTS_MODEL", workspace), "gpt-4o-mini-tts")),
+		Input:          text,
+		Voice:          openai.SpeechVoice(cmp.Or(envWorkspace("TTS_VOICE", workspace), "alloy")),
+		ResponseFormat: openai.SpeechResponseFormat(format),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, contentType, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// upload is a file being sent in pieces before it is ingested. The bytes
+// live in UPLOAD_DIR; this record tracks how many have arrived.
+type upload struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	Ingested  bool      `json:"ingested"`
+	Encrypted bool      `json:"encrypted,omitempty"`
+	Stored    int64     `json:"stored,omitempty"` // bytes on disk, when encrypted
+	Frames    uint64    `json:"frames,omitempty"` // sealed frames on disk, when encrypted
+}
+
+func (u upload) path() string { return filepath.Join(uploadDir(), u.ID) }
+
+func (u upload) complete() bool { return u.Offset == u.Size }
+
+func uploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+// uploadLocks serialises PATCHes to the same upload.
+var uploadLocks sync.Map
+
+func lockUpload(id string) func() {
+	m, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	m.(*sync.Mutex).Lock()
+	return m.(*sync.Mutex).Unlock
+}
+
+// handleCreateUpload starts a resumable upload: POST /uploads {filename, size}.
+func handleCreateUpload(c *gin.Context) {
+	var body struct {
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+	}
+	if err := c.BindJSON(&body); err != nil || body.Filename == "" || body.Size <= 0 {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Provide filename and a positive size"))
+		return
+	}
+	if limit := int64(envInt("UPLOAD_MAX_MB", 1024)) << 20; body.Size > limit {
+		c.JSON(http.StatusOK, errorReply(c, codeTooLarge, fmt.Sprintf("File exceeds the %d MB upload limit", limit>>20)))
+		return
+	}
+
+	u := upload{ID: uuid.New().String(), Filename: filepath.Base(body.Filename), Size: body.Size, CreatedAt: time.Now()}
+	if err := os.MkdirAll(uploadDir(), 0o755); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeStorage, "Upload Storage Error: "+err.Error()))
+		return
+	}
+	f, err := os.Create(u.path())
+	if err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeStorage, "Upload Storage Error: "+err.Error()))
+		return
+	}
+	if atRest != nil {
+		u.Encrypted = true
+		n, _ := f.WriteString(sealedFileMagic)
+		u.Stored = int64(n)
+	}
+	f.Close()
+	if err := metaStore.Put("uploads", u.ID, u); err != nil {
+		c.JSON(http.StatusOK, upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error()))
+		return
+	}
+	c.Header("Location", "/uploads/"+u.ID)
+	c.JSON(http.StatusOK, gin.H{"status": "success", "upload_id": u.ID, "offset": 0, "size": u.Size})
+}
+
+// handleUploadStatus (HEAD /uploads/:id) reports how much has arrived, so a
+// client can resume from the right place.
+func handleUploadStatus(c *gin.Context) {
+	var u upload
+	if found, _ := metaStore.Get("uploads", c.Param("id"), &u); !found {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(u.Size, 10))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+}
+
+// handlePatchUpload appends the request body at the client's Upload-Offset,
+// which must match what the server already has. Bytes that arrive before a
+// dropped connection are kept.
+func handlePatchUpload(c *gin.Context) {
+	id := c.Param("id")
+	defer lockUpload(id)()
+
+	var u upload
+	if found, _ := metaStore.Get("uploads", id, &u); !found || u.Ingested {
+		c.JSON(http.StatusOK, errorReply(c, codeNotFound, "Upload not found"))
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset != u.Offset {
+		resp := errorReply(c, codeConflict, "Upload-Offset does not match the server")
+		resp["offset"] = u.Offset
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	f, err := os.OpenFile(u.path(), os.O_WRONLY, 0)
+	if err != nil {
+		resp := errorReply(c, codeStorage, "Upload Storage Error: "+err.Error())
+		resp["offset"] = u.Offset
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	defer f.Close()
+	// Drop anything written after the last recorded offset, e.g. by a PATCH
+	// whose metadata update failed.
+	pos := u.Offset
+	if u.Encrypted {
+		pos = u.Stored
+	}
+	if err := f.Truncate(pos); err == nil {
+		_, err = f.Seek(pos, io.SeekStart)
+	}
+	if err != nil {
+		resp := errorReply(c, codeStorage, "Upload Storage Error: "+err.Error())
+		resp["offset"] = u.Offset
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	body := io.LimitReader(c.Request.Body, u.Size-u.Offset)
+	var n int64
+	var copyErr error
+	if u.Encrypted {
+		if atRest == nil {
+			resp := errorReply(c, codeStorage, "Upload is encrypted but encryption at rest is not configured")
+			resp["offset"] = u.Offset
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		sw := newSealWriter(f, u.Frames)
+		n, copyErr = io.Copy(sw, body)
+		end := sw.Close
+		if u.Offset+n == u.Size {
+			end = sw.Finish
+		}
+		if err := end(); err != nil {
+			// nothing of this request counts; the next one truncates it away
+			copyErr, n = cmp.Or(copyErr, err), 0
+		} else {
+			u.Stored, _ = f.Seek(0, io.SeekCurrent)
+			u.Frames = sw.Frames()
+		}
+	} else {
+		n, copyErr = io.Copy(f, body)
+	}
+	u.Offset += n
+	if err := metaStore.Put("uploads", id, u); err != nil {
+		resp := upstreamReply(c, upstreamMetadata, "Metadata Store Error: "+err.Error())
+		resp["offset"] = u.Offset - n
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if copyErr != nil {
+		resp := errorReply(c, codeInterrupted, "Upload interrupted: "+copyErr.Error())
+		resp["offset"] = u.Offset
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "upload_id": id, "offset": u.Offset, "size": u.Size, "complete": u.complete()})
+}
+
+// completedUpload looks up an upload that is ready to ingest.
+func completedUpload(id string) (upload, error) {
+	var u upload
+	found, err := metaStore.Get("uploads", id, &u)
+	if err != nil {
+		return u, err
+	}
+	if !found {
+		return u, codedError{codeNotFound, fmt.Sprintf("upload %s not found", id)}
+	}
+	if !u.complete() {
+		return u, fmt.Errorf("upload %s is incomplete (%d of %d bytes)", id, u.Offset, u.Size)
+	}
+	return u, nil
+}
+
+// finishUpload removes an ingested upload's bytes, keeping its record.
+func finishUpload(u upload) {
+	os.Remove(u.path())
+	u.Ingested = true
+	metaStore.Put("uploads", u.ID, u)
+}
+
+// sweepUploads deletes uploads that were never finished within
+// UPLOAD_EXPIRE_HOURS (default 24), bytes and record both.
+func sweepUploads(ctx context.Context) {
+	all, err := metaStore.List("uploads")
+	if err != nil {
+		log.Printf("❌ Upload Sweep Error: %v", err)
+		return
+	}
+	expiry := time.Duration(envInt("UPLOAD_EXPIRE_HOURS", 24)) * time.Hour
+	for id, raw := range all {
+		var u upload
+		if json.Unmarshal(raw, &u) != nil || u.Ingested || time.Since(u.CreatedAt) < expiry {
+			continue
+		}
+		os.Remove(u.path())
+		metaStore.Delete("uploads", id)
+	}
+}
+
+// ingestFile is the file an /ingest request refers to.
+type ingestFile struct {
+	Filename    string
+	Path        string // "" once an upload's bytes have been ingested and removed
+	UploadID    string // set when the file is a resumable upload
+	Fingerprint string
+	Done        func(success bool)
+}
+
+// ingestSource finds the file an /ingest request refers to: either a
+// multipart "file" field, saved to a temp file, or the "upload_id" of a
+// completed resumable upload. An upload's bytes are removed once it has been
+// ingested, but its record stays so a retried request can be replayed. The
+// fingerprint covers the file, workspace and every ingest option, so a retry
+// that changes any of them is not taken for the same request.
+func ingestSource(c *gin.Context, workspace string) (ingestFile, error) {
+	fields := []string{workspace, c.PostForm("graph"), c.PostForm("tags"), c.PostForm("source"), c.PostForm("supersedes"), c.PostForm("mapping")}
+	if id := c.PostForm("upload_id"); id != "" {
+		u, err := completedUpload(id)
+		if err != nil {
+			return ingestFile{}, err
+		}
+		src := ingestFile{
+			Filename:    u.Filename,
+			UploadID:    u.ID,
+			Fingerprint: fingerprint([]byte("upload:"+id), fields...),
+			Done: func(success bool) {
+				if success {
+					finishUpload(u)
+				}
+			},
+		}
+		if !u.Ingested {
+			src.Path = u.path()
+		}
+		return src, nil
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return ingestFile{}, errors.New("No file uploaded")
+	}
+	tmp, err := os.CreateTemp("", "docuchat-*"+filepath.Ext(file.Filename))
+	if err != nil {
+		return ingestFile{}, codedError{codeStorage, "Upload Storage Error: " + err.Error()}
+	}
+	tmp.Close()
+	remove := func(bool) { os.Remove(tmp.Name()) }
+	if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+		remove(false)
+		return ingestFile{}, errors.New("Upload Read Error")
+	}
+	sum, err := fileFingerprint(tmp.Name(), fields...)
+	if err != nil {
+		remove(false)
+		return ingestFile{}, errors.New("Upload Read Error")
+	}
+	return ingestFile{Filename: filepath.Base(file.Filename), Path: tmp.Name(), Fingerprint: sum, Done: remove}, nil
+}
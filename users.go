@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestUser is the end user a request acts for, as identified by the
+// X-User-ID header set by whatever sits in front of the service. It is
+// recorded as the owner of what the request creates.
+func requestUser(c *gin.Context) string {
+	return c.GetHeader("X-User-ID")
+}
+
+// deletionReport says what deleteUserData removed.
+type deletionReport struct {
+	User      string   `json:"user"`
+	Documents []string `json:"documents"`
+	Chunks    int      `json:"chunks"`
+	Relations int      `json:"graph_relations"`
+	Jobs      int      `json:"jobs"`
+	Sessions  int      `json:"sessions"`
+	Chats     int      `json:"chats"`
+	FAQs      int      `json:"faq_entries"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// deleteUserData removes everything owned by user from the metadata store
+// and the vector index. It carries on past individual failures so one bad
+// document doesn't block the rest, reporting them instead.
+func deleteUserData(ctx context.Context, user string) deletionReport {
+	report := deletionReport{User: user, Documents: []string{}}
+	fail := func(what string, err error) {
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", what, err))
+	}
+
+	docs, err := metaStore.List("documents")
+	if err != nil {
+		fail("documents", err)
+	}
+	for _, raw := range docs {
+		var doc documentRecord
+		if json.Unmarshal(raw, &doc) != nil || doc.Owner != user {
+			continue
+		}
+		deleted, err := deleteDocument(ctx, doc)
+		report.Chunks += deleted.Chunks
+		report.Relations += deleted.Relations
+		if err != nil {
+			fail("document "+doc.ID, err)
+			continue
+		}
+		report.Documents = append(report.Documents, doc.ID)
+	}
+
+	jobRecords, err := metaStore.List("jobs")
+	if err != nil {
+		fail("jobs", err)
+	}
+	for id, raw := range jobRecords {
+		var job ingestJob
+		if json.Unmarshal(raw, &job) != nil || job.Owner != user {
+			continue
+		}
+		if err := metaStore.Delete("jobs", id); err != nil {
+			fail("job "+id, err)
+			continue
+		}
+		report.Jobs++
+	}
+	sessions, err := metaStore.List("sessions")
+	if err != nil {
+		fail("sessions", err)
+	}
+	for id, raw := range sessions {
+		var s session
+		if json.Unmarshal(raw, &s) != nil || s.Owner != user {
+			continue
+		}
+		if err := metaStore.Delete("sessions", id); err != nil {
+			fail("session "+id, err)
+			continue
+		}
+		report.Sessions++
+	}
+
+	chats, err := metaStore.List("chats")
+	if err != nil {
+		fail("chats", err)
+	}
+	for id, raw := range chats {
+		var rec chatRecord
+		if json.Unmarshal(raw, &rec) != nil || rec.Owner != user {
+			continue
+		}
+		if err := metaStore.Delete("chats", id); err != nil {
+			fail("chat "+id, err)
+			continue
+		}
+		metaStore.Delete("chat_debug", id)
+		metaStore.Delete("shadow", id)
+		report.Chats++
+	}
+
+	// FAQ entries stand for questions the user asked
+	report.FAQs, err = forgetFAQ(func(e faqEntry) bool { return slices.Contains(e.AskedBy, user) })
+	if err != nil {
+		fail("faq", err)
+	}
+	return report
+}
+
+// handleDeleteUserData is the right-to-be-forgotten endpoint:
+// DELETE /users/:id/data.
+func handleDeleteUserData(c *gin.Context) {
+	user := c.Param("id")
+	report := deleteUserData(c.Request.Context(), user)
+	audit(auditEntry{
+		Action: "user_deletion",
+		Detail: fmt.Sprintf("deleted data of user %q: %d documents, %d chunks, %d graph relations, %d jobs, %d sessions, %d chats, %d FAQ entries, %d errors", user, len(report.Documents), report.Chunks, report.Relations, report.Jobs, report.Sessions, report.Chats, report.FAQs, len(report.Errors)),
+	})
+	if len(report.Errors) > 0 {
+		// deleting again picks up what was missed
+		resp := errorReply(c, codeUpstream, fmt.Sprintf("%d deletions failed; retry to finish", len(report.Errors)))
+		resp["report"] = report
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "report": report})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	pb "github.com/qdrant/go-client/qdrant"
+)
+
+// Versioning: a document ingested with supersedes=<document_id> becomes the
+// next version of that document. Each version's chunks carry the period it
+// was in force as valid_from/valid_to payload fields (unix seconds; absent
+// means open-ended), and searches only see the version valid at the
+// request's as_of date, or now. Deleting the newest version puts the previous
+// one back in force.
+
+// documentVersion is a document's place in its version history.
+type documentVersion struct {
+	VersionOf    string    `json:"version_of,omitempty"` // first version's ID
+	Version      int       `json:"version,omitempty"`    // 1 for the first version, 0 if never versioned
+	Supersedes   string    `json:"supersedes,omitempty"`
+	SupersededBy string    `json:"superseded_by,omitempty"`
+	ValidFrom    time.Time `json:"valid_from,omitzero"`
+	ValidTo      time.Time `json:"valid_to,omitzero"`
+}
+
+// supersedable checks that a new version can be ingested over documentID on
+// behalf of user.
+func supersedable(documentID, user string) error {
+	var prev documentRecord
+	found, err := metaStore.Get("documents", documentID, &prev)
+	switch {
+	case err != nil:
+		return err
+	case !found || (prev.Owner != "" && prev.Owner != user):
+		return errors.New("Document to supersede not found")
+	case prev.SupersededBy != "":
+		return errors.New("Document already has a newer version: " + prev.SupersededBy)
+	}
+	return nil
+}
+
+// versionStart is when a new version comes into force: its effective date if
+// the text states one, otherwise now.
+func versionStart(meta documentMeta) time.Time {
+	if meta.DateSource == "content_effective" {
+		return meta.Date
+	}
+	return time.Now()
+}
+
+// validityPayload is the payload that marks chunks with their version's
+// period in force.
+func validityPayload(v documentVersion) map[string]*pb.Value {
+	payload := map[string]*pb.Value{}
+	if !v.ValidFrom.IsZero() {
+		payload["valid_from"] = pb.NewValueInt(v.ValidFrom.Unix())
+	}
+	if !v.ValidTo.IsZero() {
+		payload["valid_to"] = pb.NewValueInt(v.ValidTo.Unix())
+	}
+	return payload
+}
+
+// supersede records doc as the next version of the document it supersedes,
+// closing the previous version's period at doc's start.
+func supersede(ctx context.Context, doc *documentRecord) error {
+	var prev documentRecord
+	if found, err := metaStore.Get("documents", doc.Supersedes, &prev); err != nil || !found {
+		return errors.New("superseded document is gone")
+	}
+	prev.VersionOf = cmp.Or(prev.VersionOf, prev.ID)
+	prev.Version = max(prev.Version, 1)
+	prev.SupersededBy, prev.ValidTo = doc.ID, doc.ValidFrom
+	_, err := qdrantClient.SetPayload(ctx, &pb.SetPayloadPoints{
+		CollectionName: collectionName,
+		Payload:        map[string]*pb.Value{"valid_to": pb.NewValueInt(prev.ValidTo.Unix())},
+		PointsSelector: pb.NewPointsSelectorFilter(versionFilter(prev.ID)),
+	})
+	if err != nil {
+		return err
+	}
+	doc.VersionOf, doc.Version = prev.VersionOf, prev.Version+1
+	if err := metaStore.Put("documents", prev.ID, prev); err != nil {
+		return err
+	}
+	return metaStore.Put("documents", doc.ID, *doc)
+}
+
+// reopenPrevious puts the version doc superseded back in force, for when doc
+// is deleted.
+func reopenPrevious(ctx context.Context, doc documentRecord) {
+	var prev documentRecord
+	if found, _ := metaStore.Get("documents", doc.Supersedes, &prev); !found || prev.SupersededBy != doc.ID {
+		return
+	}
+	prev.SupersededBy, prev.ValidTo = "", time.Time{}
+	_, err := qdrantClient.DeletePayload(ctx, &pb.DeletePayloadPoints{
+		CollectionName: collectionName,
+		Keys:           []string{"valid_to"},
+		PointsSelector: pb.NewPointsSelectorFilter(versionFilter(prev.ID)),
+	})
+	if err == nil {
+		err = metaStore.Put("documents", prev.ID, prev)
+	}
+	if err != nil {
+		log.Printf("❌ Version Error (%s): %v", prev.ID, err)
+	}
+}
+
+// versionFilter matches a version's points and those of files embedded in it.
+func versionFilter(documentID string) *pb.Filter {
+	return &pb.Filter{Should: []*pb.Condition{
+		pb.NewMatch("document_id", documentID),
+		pb.NewMatch("parent_id", documentID),
+	}}
+}
+
+// validAtFilter restricts a search to chunks of versions in force at t:
+// started no later than t and not yet superseded by then.
+func validAtFilter(t time.Time) *pb.Filter {
+	at := float64(t.Unix())
+	return &pb.Filter{MustNot: []*pb.Condition{
+		pb.NewRange("valid_from", &pb.Range{Gt: &at}),
+		pb.NewRange("valid_to", &pb.Range{Lte: &at}),
+	}}
+}
+
+// validAt reports whether a chunk fetched by ID is in force at t, as
+// validAtFilter would.
+func validAt(payload map[string]*pb.Value, t time.Time) bool {
+	if v, ok := payload["valid_from"]; ok && v.GetIntegerValue() > t.Unix() {
+		return false
+	}
+	if v, ok := payload["valid_to"]; ok && v.GetIntegerValue() <= t.Unix() {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gebt2000/go-docuchat/api"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	youtubeIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+	vimeoIDRe   = regexp.MustCompile(`^\d+$`)
+)
+
+// parseVideoURL finds the provider and ID of a YouTube (watch, youtu.be,
+// shorts, embed or live) or Vimeo link.
+func parseVideoURL(s string) (provider, id string, err error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Host == "" {
+		return "", "", errors.New("url must be a YouTube or Vimeo link")
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch host {
+	case "youtu.be":
+		id = segments[0]
+	case "youtube.com", "m.youtube.com", "youtube-nocookie.com":
+		if segments[0] == "watch" {
+			id = u.Query().Get("v")
+		} else if len(segments) == 2 && (segments[0] == "shorts" || segments[0] == "embed" || segments[0] == "live") {
+			id = segments[1]
+		}
+	case "vimeo.com", "player.vimeo.com":
+		for _, seg := range segments {
+			if vimeoIDRe.MatchString(seg) {
+				return "vimeo", seg, nil
+			}
+		}
+	}
+	if youtubeIDRe.MatchString(id) {
+		return "youtube", id, nil
+	}
+	return "", "", errors.New("url must be a YouTube or Vimeo link")
+}
+
+// handleIngestVideo ingests a video's transcript: POST /ingest/video
+// {"url", "language", "workspace", "tags"}. Captions are used when the
+// video has them, those in the given language first and ones written by
+// people over automatic ones; Vimeo's need VIMEO_ACCESS_TOKEN. Otherwise
+// the audio is downloaded with yt-dlp (VIDEO_AUDIO_COMMAND) and transcribed
+// by the STT provider, within STT_MAX_MB. The transcript is chunked in
+// TRANSCRIPT_WINDOW_SECONDS windows and each passage is cited by a link to
+// the moment it starts. Replies as POST /ingest does, with the video's
+// details.
+func handleIngestVideo(c *gin.Context) {
+	var body api.IngestVideoRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusOK, errorReply(c, codeInvalidRequest, "Invalid JSON format"))
+		return
+	}
+	provider, id, err := parseVideoURL(body.URL)
+	if err != nil {
+		c.JSON(http.StatusOK, errReply(c, codeInvalidRequest, err))
+		return
+	}
+	workspace := cmp.Or(body.Workspace, "default")
+
+	withIdempotency(c, fingerprint([]byte(provider+":"+id), workspace, body.Language), func() gin.H {
+		ctx := c.Request.Context()
+		var video api.Video
+		var cues []transcriptCue
+		if provider == "youtube" {
+			video, cues, err = youtubeTranscript(ctx, id, body.Language)
+		} else {
+			video, cues, err = vimeoTranscript(ctx, id, body.Language)
+		}
+		if err != nil {
+			return upstreamReply(c, upstreamVideos, "Video Lookup Error: "+err.Error())
+		}
+		if len(cues) == 0 {
+			if cues, err = transcribeVideo(ctx, video.URL); err != nil {
+				resp := upstreamReply(c, upstreamSTT, "Transcription Error: "+err.Error())
+				resp["video"] = video
+				return resp
+			}
+			video.Transcript, video.Language = "transcribed", ""
+		}
+
+		dir, err := os.MkdirTemp("", "docuchat-video-*")
+		if err != nil {
+			return errorReply(c, codeStorage, "Upload Storage Error: "+err.Error())
+		}
+		defer os.RemoveAll(dir) // the file is gone already if a queued job moved it
+		filename := strings.TrimSuffix(textFilename(video.Title), ".txt") + ".vtt"
+		path := filepath.Join(dir, filename)
+		if err := writeVTT(path, cues); err != nil {
+			return errorReply(c, codeStorage, "Upload Storage Error: "+err.Error())
+		}
+
+		meta := map[string]string{"video_id": id, "transcript": video.Transcript}
+		if video.Channel != "" {
+			meta["channel"] = video.Channel
+		}
+		if video.Language != "" {
+			meta["caption_language"] = video.Language
+		}
+		job := ingestJob{
+			ID:         uuid.New().String(),
+			DocumentID: uuid.New().String(),
+			Filename:   filename,
+			Path:       path,
+			Workspace:  workspace,
+			Owner:      requestUser(c),
+			Graph:      graphEnabled(""),
+			Tags:       parseTags(strings.Join(body.Tags, ",")),
+			Source:     provider,
+			Title:      video.Title,
+			URL:        video.URL,
+			Metadata:   meta,
+		}
+		resp, _ := submitIngest(c, job)
+		resp["video"] = video
+		return resp
+	})
+}
+
+// captionTrack is a set of captions a video offers.
+type captionTrack struct {
+	Language string
+	Auto     bool // generated by speech recognition
+	URL      string
+}
+
+// pickCaptions chooses captions: the preferred language first, then
+// English, then any, and within each people's over automatic ones.
+func pickCaptions(tracks []captionTrack, language string) (captionTrack, bool) {
+	best, bestRank := captionTrack{}, -1
+	for _, t := range tracks {
+		rank := 0
+		lang := strings.ToLower(t.Language)
+		switch {
+		case language != "" && (lang == strings.ToLower(language) || strings.HasPrefix(lang, strings.ToLower(language)+"-")):
+			rank = 4
+		case lang == "en" || strings.HasPrefix(lang, "en-"):
+			rank = 2
+		}
+		if !t.Auto {
+			rank++
+		}
+		if rank > bestRank {
+			best, bestRank = t, rank
+		}
+	}
+	return best, bestRank >= 0
+}
+
+// youtubeTranscript reads a YouTube video's details and captions from its
+// watch page; cues are nil when it has no captions that can be fetched.
+func youtubeTranscript(ctx context.Context, id, language string) (api.Video, []transcriptCue, error) {
+	video := api.Video{Provider: "youtube", ID: id, URL: "https://www.youtube.com/watch?v=" + id}
+	page, err := connectorGet(ctx, video.URL, func(req *http.Request) {
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Accept-Language", cmp.Or(language, "en"))
+		req.Header.Set("Cookie", "CONSENT=YES+1") // skips the EU consent interstitial
+	})
+	if err != nil {
+		return video, nil, err
+	}
+	const marker = "ytInitialPlayerResponse = "
+	i := strings.Index(string(page), marker)
+	if i < 0 {
+		return video, nil, errors.New("the video page has no player data")
+	}
+	var player struct {
+		PlayabilityStatus struct {
+			Status string `json:"status"`
+			Reason string `json:"reason"`
+		} `json:"playabilityStatus"`
+		VideoDetails struct {
+			Title         string `json:"title"`
+			Author        string `json:"author"`
+			LengthSeconds string `json:"lengthSeconds"`
+		} `json:"videoDetails"`
+		Captions struct {
+			Renderer struct {
+				Tracks []struct {
+					BaseURL      string `json:"baseUrl"`
+					LanguageCode string `json:"languageCode"`
+					Kind         string `json:"kind"`
+				} `json:"captionTracks"`
+			} `json:"playerCaptionsTracklistRenderer"`
+		} `json:"captions"`
+	}
+	// the object is followed by more script, which the decoder leaves unread
+	if err := json.NewDecoder(strings.NewReader(string(page[i+len(marker):]))).Decode(&player); err != nil {
+		return video, nil, fmt.Errorf("reading the player data: %v", err)
+	}
+	if s := player.PlayabilityStatus; s.Status != "" && s.Status != "OK" {
+		return video, nil, fmt.Errorf("the video can't be played: %s", cmp.Or(s.Reason, s.Status))
+	}
+	video.Title = cmp.Or(player.VideoDetails.Title, id)
+	video.Channel = player.VideoDetails.Author
+	video.Duration, _ = strconv.ParseFloat(player.VideoDetails.LengthSeconds, 64)
+
+	var tracks []captionTrack
+	for _, t := range player.Captions.Renderer.Tracks {
+		tracks = append(tracks, captionTrack{Language: t.LanguageCode, Auto: t.Kind == "asr", URL: t.BaseURL})
+	}
+	track, ok := pickCaptions(tracks, language)
+	if !ok {
+		return video, nil, nil
+	}
+	body, err := connectorGet(ctx, track.URL+"&fmt=json3", nil)
+	if err != nil {
+		return video, nil, err
+	}
+	var captions struct {
+		Events []struct {
+			Start    int64 `json:"tStartMs"`
+			Duration int64 `json:"dDurationMs"`
+			Segs     []struct {
+				Text string `json:"utf8"`
+			} `json:"segs"`
+		} `json:"events"`
+	}
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return video, nil, nil // captions YouTube won't serve this client
+	}
+	if err := json.Unmarshal(body, &captions); err != nil {
+		return video, nil, fmt.Errorf("reading the captions: %v", err)
+	}
+	var cues []transcriptCue
+	for _, e := range captions.Events {
+		var b strings.Builder
+		for _, s := range e.Segs {
+			b.WriteString(s.Text)
+		}
+		if text := strings.Join(strings.Fields(b.String()), " "); text != "" {
+			cues = append(cues, transcriptCue{Start: float64(e.Start) / 1000, End: float64(e.Start+e.Duration) / 1000, Text: text})
+		}
+	}
+	video.Transcript, video.Language = "captions", track.Language
+	if track.Auto {
+		video.Transcript = "auto_captions"
+	}
+	return video, cues, nil
+}
+
+// vimeoTranscript reads a Vimeo video's details by oEmbed and, with
+// VIMEO_ACCESS_TOKEN, its text tracks; cues are nil when it has none.
+func vimeoTranscript(ctx context.Context, id, language string) (api.Video, []transcriptCue, error) {
+	video := api.Video{Provider: "vimeo", ID: id, URL: "https://vimeo.com/" + id}
+	body, err := connectorGet(ctx, "https://vimeo.com/api/oembed.json?url="+url.QueryEscape(video.URL), nil)
+	if err != nil {
+		return video, nil, err
+	}
+	var embed struct {
+		Title    string  `json:"title"`
+		Author   string  `json:"author_name"`
+		Duration float64 `json:"duration"`
+	}
+	if err := json.Unmarshal(body, &embed); err != nil {
+		return video, nil, err
+	}
+	video.Title, video.Channel, video.Duration = cmp.Or(embed.Title, id), embed.Author, embed.Duration
+
+	token := os.Getenv("VIMEO_ACCESS_TOKEN")
+	if token == "" {
+		return video, nil, nil
+	}
+	body, err = connectorGet(ctx, "https://api.vimeo.com/videos/"+id+"/texttracks", func(req *http.Request) {
+		req.Header.Set("Authorization", "bearer "+token)
+		req.Header.Set("Accept", "application/vnd.vimeo.*+json;version=3.4")
+	})
+	if err != nil {
+		return video, nil, err
+	}
+	var res struct {
+		Data []struct {
+			Active   bool   `json:"active"`
+			Language string `json:"language"`
+			Type     string `json:"type"` // captions, subtitles...
+			Link     string `json:"link"` // WebVTT
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return video, nil, err
+	}
+	var tracks []captionTrack
+	for _, t := range res.Data {
+		if t.Active && t.Link != "" {
+			tracks = append(tracks, captionTrack{Language: t.Language, Auto: strings.Contains(t.Language, "autogen"), URL: t.Link})
+		}
+	}
+	track, ok := pickCaptions(tracks, language)
+	if !ok {
+		return video, nil, nil
+	}
+	vtt, err := connectorGet(ctx, track.URL, func(req *http.Request) { req.Header.Set("Accept", "text/vtt") })
+	if err != nil {
+		return video, nil, err
+	}
+	tmp, err := os.CreateTemp("", "docuchat-captions-*.vtt")
+	if err != nil {
+		return video, nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(vtt)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return video, nil, err
+	}
+	cues, err := parseSubtitles(tmp.Name())
+	if err != nil {
+		return video, nil, err
+	}
+	video.Transcript, video.Language = "captions", track.Language
+	if track.Auto {
+		video.Transcript = "auto_captions"
+	}
+	return video, cues, nil
+}
+
+// transcribeVideo downloads a video's audio with VIDEO_AUDIO_COMMAND
+// (default yt-dlp) and transcribes it, timed when the STT provider can.
+func transcribeVideo(ctx context.Context, link string) ([]transcriptCue, error) {
+	name := cmp.Or(os.Getenv("STT_PROVIDER"), "openai")
+	provider, ok := sttRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown STT provider %q", name)
+	}
+	command := cmp.Or(os.Getenv("VIDEO_AUDIO_COMMAND"), "yt-dlp")
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, fmt.Errorf("the video has no captions, and downloading its audio needs %s: %v", command, err)
+	}
+	dir, err := os.MkdirTemp("", "docuchat-audio-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	// the download stops at the size the STT provider would turn away anyway
+	limit := int64(envInt("STT_MAX_MB", 25)) << 20
+	cmd := exec.CommandContext(ctx, command, "--no-playlist", "-f", "bestaudio[ext=m4a]/bestaudio", "--max-filesize", strconv.FormatInt(limit, 10), "-o", filepath.Join(dir, "audio.%(ext)s"), link)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", command, err, strings.TrimSpace(string(out[max(len(out)-300, 0):])))
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "audio.*"))
+	files = slices.DeleteFunc(files, func(f string) bool { return strings.HasSuffix(f, ".part") }) // cut off at the limit
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s saved no audio; it may be more than STT_MAX_MB (%d)", command, limit>>20)
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > limit {
+		return nil, fmt.Errorf("the audio is %d MB, more than STT_MAX_MB (%d)", info.Size()>>20, limit>>20)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cues []transcriptCue
+	if timed, ok := provider.(timedSTT); ok {
+		cues, err = timed.TranscribeTimed(ctx, filepath.Base(files[0]), f)
+	} else {
+		var text string
+		if text, err = provider.Transcribe(ctx, filepath.Base(files[0]), f); strings.TrimSpace(text) != "" {
+			cues = []transcriptCue{{Text: strings.TrimSpace(text)}}
+		}
+	}
+	if err != nil {
+		recordError("stt", err)
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, errors.New("no speech found in the audio")
+	}
+	return cues, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// webSearchProvider is the WEB_SEARCH fallback: "serper" or "bing", with
+// WEB_SEARCH_API_KEY. When set, a chat whose document retrieval finds
+// nothing confident (see lowConfidence) answers from web results instead,
+// labelled as such. Unset by default.
+func webSearchProvider() string {
+	switch p := os.Getenv("WEB_SEARCH"); p {
+	case "serper", "bing":
+		return p
+	}
+	return ""
+}
+
+// webAnswerLabel heads answers drawn from the web: WEB_ANSWER_LABEL.
+func webAnswerLabel() string {
+	return cmp.Or(os.Getenv("WEB_ANSWER_LABEL"), "🌐 From the web, not your documents:")
+}
+
+// webInstruction tells the model where its context came from.
+const webInstruction = "\n\nThe context below comes from a web search, not from the user's documents. Answer from it and mention the URLs you relied on."
+
+// labelAnswer heads an answer with webAnswerLabel if it came from the web.
+func labelAnswer(rec chatRecord, answer string) string {
+	if rec.AnswerSource != "web" {
+		return answer
+	}
+	return webAnswerLabel() + "\n\n" + answer
+}
+
+// webSearch returns up to limit web results for query.
+func webSearch(ctx context.Context, query string, limit int) ([]externalHit, error) {
+	key := os.Getenv("WEB_SEARCH_API_KEY")
+	var req *http.Request
+	var err error
+	switch webSearchProvider() {
+	case "serper":
+		body, _ := json.Marshal(map[string]any{"q": query, "num": limit})
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, "https://google.serper.dev/search", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-API-KEY", key)
+		}
+	case "bing":
+		u := "https://api.bing.microsoft.com/v7.0/search?" + url.Values{"q": {query}, "count": {fmt.Sprint(limit)}}.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err == nil {
+			req.Header.Set("Ocp-Apim-Subscription-Key", key)
+		}
+	default:
+		return nil, fmt.Errorf("web search is not configured")
+	}
+	if err != nil {
+		return nil, err
+	}
+	body, err := retrieverCall(req)
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Organic []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"organic"` // serper
+		WebPages struct {
+			Value []struct {
+				Name    string `json:"name"`
+				URL     string `json:"url"`
+				Snippet string `json:"snippet"`
+			} `json:"value"`
+		} `json:"webPages"` // bing
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	var hits []externalHit
+	for _, r := range res.Organic {
+		hits = append(hits, externalHit{Title: r.Title, URL: r.Link, Text: r.Snippet})
+	}
+	for _, r := range res.WebPages.Value {
+		hits = append(hits, externalHit{Title: r.Name, URL: r.URL, Text: r.Snippet})
+	}
+	return hits[:min(limit, len(hits))], nil
+}